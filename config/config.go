@@ -16,8 +16,10 @@ package config
 
 import (
 	"bytes"
+	"net"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -99,6 +101,18 @@ type (
 		Host string `validate:"ip"`
 		Port int    `validate:"number"`
 	}
+
+	// OIDCProviderConfig oidc provider config
+	OIDCProviderConfig struct {
+		Name         string `validate:"min=1,max=20"`
+		Issuer       string `validate:"url"`
+		ClientID     string `validate:"min=1"`
+		ClientSecret string
+		RedirectURL  string `validate:"url"`
+		Scopes       []string
+		// 信任provider已校验的邮箱，用于关联已有的同邮箱账户
+		TrustEmail bool
+	}
 )
 
 const (
@@ -117,6 +131,9 @@ var (
 	defaultViper = viper.New()
 
 	applicationStatus = ApplicationStatusStopped
+
+	// 可信代理，在加载配置时解析
+	trustedProxies []*net.IPNet
 )
 
 func init() {
@@ -153,6 +170,39 @@ func init() {
 		panic(err)
 	}
 	appName = GetString("app")
+	trustedProxies, err = parseIPNets(GetStringSlice("trustedProxies"))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// parseIPNets parse the ip or cidr list to ip nets
+func parseIPNets(values []string) (ipNets []*net.IPNet, err error) {
+	ipNets = make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				err = &net.ParseError{
+					Type: "IP address",
+					Text: value,
+				}
+				return
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			value += "/" + strconv.Itoa(bits)
+		}
+		_, ipNet, e := net.ParseCIDR(value)
+		if e != nil {
+			err = e
+			return
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return
 }
 
 func validatePanic(v interface{}) {
@@ -300,6 +350,12 @@ func GetListen() string {
 	return GetStringDefault("listen", defaultListen)
 }
 
+// GetTrustedProxies get the trusted proxies(parsed from ip or cidr), the client ip
+// is only got from X-Forwarded-For when the request is from them
+func GetTrustedProxies() []*net.IPNet {
+	return trustedProxies
+}
+
 // GetTrackKey get the track cookie key
 func GetTrackKey() string {
 	return GetStringDefault("track", defaultTrackKey)
@@ -470,3 +526,29 @@ func GetTinyConfig() TinyConfig {
 	validatePanic(&tinyConfig)
 	return tinyConfig
 }
+
+// GetOIDCProviderConfigs get the configs of oidc providers
+func GetOIDCProviderConfigs() []OIDCProviderConfig {
+	prefix := "oidc.providers."
+	names := make([]string, 0)
+	for name := range GetStringMap("oidc.providers") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	configs := make([]OIDCProviderConfig, 0, len(names))
+	for _, name := range names {
+		key := prefix + name + "."
+		providerConfig := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       GetString(key + "issuer"),
+			ClientID:     GetString(key + "clientID"),
+			ClientSecret: GetStringFromENV(key + "clientSecret"),
+			RedirectURL:  GetString(key + "redirectURL"),
+			Scopes:       GetStringSlice(key + "scopes"),
+			TrustEmail:   GetBool(key + "trustEmail"),
+		}
+		validatePanic(&providerConfig)
+		configs = append(configs, providerConfig)
+	}
+	return configs
+}
@@ -70,3 +70,50 @@ func TestConfigGet(t *testing.T) {
 		"c": 1,
 	}, GetStringMap(key))
 }
+
+func TestGetOIDCProviderConfigs(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, len(GetOIDCProviderConfigs()))
+
+	defaultViper.Set("oidc.providers", map[string]interface{}{
+		"corp": map[string]interface{}{
+			"issuer":      "https://sso.example.com",
+			"clientID":    "origin",
+			"redirectURL": "http://127.0.0.1:7001/users/v1/oidc/corp/callback",
+			"trustEmail":  true,
+		},
+	})
+	defer defaultViper.Set("oidc.providers", nil)
+	configs := GetOIDCProviderConfigs()
+	assert.Equal(1, len(configs))
+	assert.Equal("corp", configs[0].Name)
+	assert.Equal("https://sso.example.com", configs[0].Issuer)
+	assert.Equal("origin", configs[0].ClientID)
+	assert.True(configs[0].TrustEmail)
+}
+
+func TestParseIPNets(t *testing.T) {
+	assert := assert.New(t)
+	ipNets, err := parseIPNets([]string{
+		"127.0.0.1",
+		"::1",
+		"10.0.0.0/8",
+	})
+	assert.Nil(err)
+	assert.Equal(3, len(ipNets))
+	assert.Equal("127.0.0.1/32", ipNets[0].String())
+	assert.Equal("::1/128", ipNets[1].String())
+	assert.Equal("10.0.0.0/8", ipNets[2].String())
+
+	_, err = parseIPNets([]string{
+		"abc",
+	})
+	assert.NotNil(err)
+
+	_, err = parseIPNets([]string{
+		"10.0.0.0/33",
+	})
+	assert.NotNil(err)
+
+	assert.Equal(2, len(GetTrustedProxies()))
+}
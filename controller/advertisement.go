@@ -80,14 +80,14 @@ func init() {
 		"/v1",
 		loadUserSession,
 		newTracker(cs.ActionAdvertisementAdd),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionAdvertisementUpdate),
 		ctrl.add,
 	)
 	g.PATCH(
 		"/v1/{id}",
 		loadUserSession,
 		newTracker(cs.ActionAdvertisementUpdate),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionAdvertisementUpdate),
 		ctrl.updateByID,
 	)
 }
@@ -0,0 +1,490 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/middleware"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/util"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	afterSaleCtrl struct{}
+
+	addAfterSaleParams struct {
+		SubOrder uint     `json:"subOrder,omitempty" validate:"xAfterSaleSubOrder"`
+		Category string   `json:"category,omitempty" validate:"xAfterSaleCategory"`
+		Reason   string   `json:"reason,omitempty" validate:"xAfterSaleReason"`
+		Pics     []string `json:"pics,omitempty" validate:"omitempty,dive,xFile"`
+	}
+	// 审核参数
+	reviewAfterSaleParams struct {
+		Remark string `json:"remark,omitempty" validate:"omitempty,xAfterSaleRemark"`
+	}
+	// 售后物流参数
+	afterSaleDeliveryParams struct {
+		DeliverySN      string `json:"deliverySN,omitempty" validate:"xOrderDeliverySN"`
+		DeliveryCompany string `json:"deliveryCompany,omitempty" validate:"xOrderDeliveryCompnay"`
+	}
+	// 退款参数，未指定金额则退子订单的支付金额
+	refundAfterSaleParams struct {
+		RefundAmount float64 `json:"refundAmount,omitempty" validate:"omitempty,xAfterSaleRefundAmount"`
+	}
+
+	listAfterSaleParams struct {
+		listParams
+
+		SN       string    `json:"sn,omitempty" validate:"omitempty,xAfterSaleSN"`
+		OrderSN  string    `json:"orderSN,omitempty" validate:"omitempty,xOrderSN"`
+		Status   string    `json:"status,omitempty" validate:"omitempty,xAfterSaleStatus"`
+		Category string    `json:"category,omitempty" validate:"omitempty,xAfterSaleCategory"`
+		User     string    `json:"user,omitempty" validate:"omitempty,xOrderUser"`
+		Begin    time.Time `json:"begin,omitempty"`
+		End      time.Time `json:"end,omitempty"`
+	}
+	listAfterSaleResp struct {
+		AfterSales service.AfterSales `json:"afterSales,omitempty"`
+		Count      int64              `json:"count,omitempty"`
+	}
+)
+
+func init() {
+	ctrl := afterSaleCtrl{}
+	g := router.NewGroup("/after-sales")
+
+	afterSaleUpdateLimit := middleware.NewConcurrentLimitWithDone([]string{
+		"p:sn",
+	}, time.Minute, "")
+
+	// 申请售后
+	g.POST(
+		"/v1",
+		loadUserSession,
+		shouldBeLogined,
+		newTracker(cs.ActionAfterSaleAdd),
+		ctrl.add,
+	)
+	// 查询售后列表
+	g.GET(
+		"/v1",
+		loadUserSession,
+		requirePermission(cs.PermissionAfterSaleRead),
+		ctrl.list,
+	)
+	// 查询我的售后
+	g.GET(
+		"/v1/mine",
+		loadUserSession,
+		shouldBeLogined,
+		ctrl.listMine,
+	)
+	g.GET(
+		"/v1/statuses",
+		ctrl.listStatus,
+	)
+	g.GET(
+		"/v1/categories",
+		ctrl.listCategory,
+	)
+	// 售后详情
+	g.GET(
+		"/v1/{sn}",
+		loadUserSession,
+		shouldBeLogined,
+		ctrl.detail,
+	)
+
+	// 客户取消售后
+	g.PATCH(
+		"/v1/{sn}/cancel",
+		loadUserSession,
+		shouldBeLogined,
+		newTracker(cs.ActionAfterSaleCancel),
+		afterSaleUpdateLimit,
+		ctrl.cancel,
+	)
+	// 客户寄回商品
+	g.PATCH(
+		"/v1/{sn}/return",
+		loadUserSession,
+		shouldBeLogined,
+		newTracker(cs.ActionAfterSaleReturn),
+		afterSaleUpdateLimit,
+		ctrl.returnGoods,
+	)
+	// 客户确认收到换货
+	g.PATCH(
+		"/v1/{sn}/finish",
+		loadUserSession,
+		shouldBeLogined,
+		newTracker(cs.ActionAfterSaleFinish),
+		afterSaleUpdateLimit,
+		ctrl.finish,
+	)
+
+	// 同意售后
+	g.PATCH(
+		"/v1/{sn}/approve",
+		loadUserSession,
+		newTracker(cs.ActionAfterSaleApprove),
+		requirePermission(cs.PermissionAfterSaleHandle),
+		afterSaleUpdateLimit,
+		ctrl.approve,
+	)
+	// 拒绝售后
+	g.PATCH(
+		"/v1/{sn}/reject",
+		loadUserSession,
+		newTracker(cs.ActionAfterSaleReject),
+		requirePermission(cs.PermissionAfterSaleHandle),
+		afterSaleUpdateLimit,
+		ctrl.reject,
+	)
+	// 关闭售后（如已同意但退货一直未寄回）
+	g.PATCH(
+		"/v1/{sn}/close",
+		loadUserSession,
+		newTracker(cs.ActionAfterSaleClose),
+		requirePermission(cs.PermissionAfterSaleHandle),
+		afterSaleUpdateLimit,
+		ctrl.close,
+	)
+	// 确认收到退货
+	g.PATCH(
+		"/v1/{sn}/receive",
+		loadUserSession,
+		newTracker(cs.ActionAfterSaleReceive),
+		requirePermission(cs.PermissionAfterSaleHandle),
+		afterSaleUpdateLimit,
+		ctrl.receive,
+	)
+	// 换货发出
+	g.PATCH(
+		"/v1/{sn}/ship-exchange",
+		loadUserSession,
+		newTracker(cs.ActionAfterSaleShipExchange),
+		requirePermission(cs.PermissionAfterSaleHandle),
+		afterSaleUpdateLimit,
+		ctrl.shipExchange,
+	)
+	// 退款
+	g.PATCH(
+		"/v1/{sn}/refund",
+		loadUserSession,
+		newTracker(cs.ActionAfterSaleRefund),
+		requirePermission(cs.PermissionAfterSaleRefund),
+		afterSaleUpdateLimit,
+		ctrl.refund,
+	)
+}
+
+func (params listAfterSaleParams) toConditions() (conditions []interface{}) {
+	conds := queryConditions{}
+	if params.SN != "" {
+		conds.add("sn = ?", params.SN)
+	}
+	if params.OrderSN != "" {
+		conds.add("order_sn = ?", params.OrderSN)
+	}
+	if params.Status != "" {
+		conds.add("status = ?", params.Status)
+	}
+	if params.Category != "" {
+		conds.add("category = ?", params.Category)
+	}
+	if params.User != "" {
+		id, _ := strconv.Atoi(params.User)
+		conds.add("user_id = ?", id)
+	}
+	if !params.Begin.IsZero() {
+		conds.add("created_at >= ?", util.FormatTime(params.Begin))
+	}
+	if !params.End.IsZero() {
+		conds.add("created_at <= ?", util.FormatTime(params.End))
+	}
+	return conds.toArray()
+}
+
+func (afterSaleCtrl) listAfterSale(params listAfterSaleParams) (resp *listAfterSaleResp, err error) {
+	count := int64(-1)
+	args := params.toConditions()
+	queryParams := params.toPGQueryParams()
+	if queryParams.Offset == 0 {
+		count, err = afterSaleSrv.Count(args...)
+		if err != nil {
+			return
+		}
+	}
+	afterSales, err := afterSaleSrv.List(queryParams, args...)
+	if err != nil {
+		return
+	}
+	resp = &listAfterSaleResp{
+		AfterSales: afterSales,
+		Count:      count,
+	}
+	return
+}
+
+// add add after sale
+func (afterSaleCtrl) add(c *elton.Context) (err error) {
+	params := addAfterSaleParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	afterSale, err := afterSaleSrv.Add(service.CreateAfterSaleParams{
+		UserID:   us.GetID(),
+		SubOrder: params.SubOrder,
+		Category: params.Category,
+		Reason:   params.Reason,
+		Pics:     params.Pics,
+	})
+	if err != nil {
+		return
+	}
+	c.Created(afterSale)
+	return
+}
+
+// list list after sale
+func (ctrl afterSaleCtrl) list(c *elton.Context) (err error) {
+	params := listAfterSaleParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	resp, err := ctrl.listAfterSale(params)
+	if err != nil {
+		return
+	}
+	c.Body = resp
+	return
+}
+
+// listMine list my after sale
+func (ctrl afterSaleCtrl) listMine(c *elton.Context) (err error) {
+	params := listAfterSaleParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	params.User = strconv.Itoa(int(us.GetID()))
+	resp, err := ctrl.listAfterSale(params)
+	if err != nil {
+		return
+	}
+	c.Body = resp
+	return
+}
+
+// detail get the detail of after sale
+func (afterSaleCtrl) detail(c *elton.Context) (err error) {
+	afterSale, err := afterSaleSrv.FindBySN(c.Param("sn"))
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	// 非本人的售后需要有查询所有售后的权限
+	if afterSale.UserID != us.GetID() {
+		valid, e := us.HasPermission(cs.PermissionAfterSaleRead)
+		if e != nil {
+			err = e
+			return
+		}
+		if !valid {
+			err = errForbidden
+			return
+		}
+	}
+	subOrder, err := orderSrv.FindSubOrderByID(afterSale.SubOrder)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		AfterSale *service.AfterSale `json:"afterSale,omitempty"`
+		SubOrder  *service.SubOrder  `json:"subOrder,omitempty"`
+	}{
+		afterSale,
+		subOrder,
+	}
+	return
+}
+
+// listStatus list after sale status
+func (afterSaleCtrl) listStatus(c *elton.Context) (err error) {
+	c.CacheMaxAge("5m")
+	c.Body = &struct {
+		Statuses service.AfterSaleStatusInfoList `json:"statuses,omitempty"`
+	}{
+		afterSaleSrv.ListStatus(),
+	}
+	return
+}
+
+// listCategory list after sale category
+func (afterSaleCtrl) listCategory(c *elton.Context) (err error) {
+	c.CacheMaxAge("5m")
+	c.Body = &struct {
+		Categories []*service.AfterSaleCategoryInfo `json:"categories,omitempty"`
+	}{
+		afterSaleSrv.ListCategory(),
+	}
+	return
+}
+
+// cancel cancel the after sale
+func (afterSaleCtrl) cancel(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	err = afterSaleSrv.Cancel(c.Param("sn"), us.GetID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// returnGoods the customer ships the goods back
+func (afterSaleCtrl) returnGoods(c *elton.Context) (err error) {
+	params := afterSaleDeliveryParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = afterSaleSrv.Return(c.Param("sn"), us.GetID(), service.AfterSaleDeliveryParams{
+		SN:      params.DeliverySN,
+		Company: params.DeliveryCompany,
+	})
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// finish the customer receives the exchange goods
+func (afterSaleCtrl) finish(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	err = afterSaleSrv.Finish(c.Param("sn"), us.GetID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// approve approve the after sale
+func (afterSaleCtrl) approve(c *elton.Context) (err error) {
+	params := reviewAfterSaleParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = afterSaleSrv.Approve(c.Param("sn"), us.GetID(), params.Remark)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// reject reject the after sale
+func (afterSaleCtrl) reject(c *elton.Context) (err error) {
+	params := reviewAfterSaleParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = afterSaleSrv.Reject(c.Param("sn"), us.GetID(), params.Remark)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// close close the after sale
+func (afterSaleCtrl) close(c *elton.Context) (err error) {
+	params := reviewAfterSaleParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = afterSaleSrv.Close(c.Param("sn"), us.GetID(), params.Remark)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// receive receive the returned goods
+func (afterSaleCtrl) receive(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	err = afterSaleSrv.Receive(c.Param("sn"), us.GetID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// shipExchange ship the exchange goods
+func (afterSaleCtrl) shipExchange(c *elton.Context) (err error) {
+	params := afterSaleDeliveryParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = afterSaleSrv.ShipExchange(c.Param("sn"), us.GetID(), service.AfterSaleDeliveryParams{
+		SN:      params.DeliverySN,
+		Company: params.DeliveryCompany,
+	})
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// refund refund the after sale
+func (afterSaleCtrl) refund(c *elton.Context) (err error) {
+	params := refundAfterSaleParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	afterSale, err := afterSaleSrv.Refund(c.Param("sn"), us.GetID(), params.RefundAmount)
+	if err != nil {
+		return
+	}
+	c.Body = afterSale
+	return
+}
@@ -53,7 +53,7 @@ func init() {
 		"/v1",
 		loadUserSession,
 		newTracker(cs.ActionBrandAdd),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionBrandUpdate),
 		ctrl.add,
 	)
 	// 品牌列表
@@ -73,9 +73,10 @@ func init() {
 		"/v1/{id}",
 		loadUserSession,
 		newTracker(cs.ActionBrandUpdate),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionBrandUpdate),
 		ctrl.updateByID,
 	)
+	brandHistoryCtrl.addRouters(g, "/v1")
 }
 
 func (params listBrandParams) toConditions() []interface{} {
@@ -107,6 +108,10 @@ func (ctrl brandCtrl) add(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
+	err = brandHistoryCtrl.recordCreate(c, brand.ID)
+	if err != nil {
+		return
+	}
 	c.Created(brand)
 
 	return
@@ -171,11 +176,13 @@ func (ctrl brandCtrl) updateByID(c *elton.Context) (err error) {
 		return
 	}
 
-	err = brandSrv.UpdateByID(id, service.Brand{
-		Name:    params.Name,
-		Status:  params.Status,
-		Logo:    params.Logo,
-		Catalog: params.Catalog,
+	err = brandHistoryCtrl.track(c, id, func() error {
+		return brandSrv.UpdateByID(id, service.Brand{
+			Name:    params.Name,
+			Status:  params.Status,
+			Logo:    params.Logo,
+			Catalog: params.Catalog,
+		})
 	})
 
 	if err != nil {
@@ -0,0 +1,175 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	// catalogHistoryCtrl 商品目录（产品、分类、品牌、供应商）的删除、恢复与变更历史
+	catalogHistoryCtrl struct {
+		category string
+		// 查询历史与编辑（删除、恢复）所需权限
+		readPermission   string
+		updatePermission string
+	}
+
+	getCatalogAsOfParams struct {
+		Time time.Time `json:"time,omitempty" validate:"required"`
+	}
+)
+
+var (
+	productHistoryCtrl = catalogHistoryCtrl{
+		category:         service.CatalogProduct,
+		readPermission:   cs.PermissionProductRead,
+		updatePermission: cs.PermissionProductUpdate,
+	}
+	productSKUHistoryCtrl = catalogHistoryCtrl{
+		category:         service.CatalogProductSKU,
+		readPermission:   cs.PermissionProductRead,
+		updatePermission: cs.PermissionProductUpdate,
+	}
+	productCategoryHistoryCtrl = catalogHistoryCtrl{
+		category:         service.CatalogProductCategory,
+		readPermission:   cs.PermissionProductRead,
+		updatePermission: cs.PermissionProductUpdate,
+	}
+	brandHistoryCtrl = catalogHistoryCtrl{
+		category:         service.CatalogBrand,
+		readPermission:   cs.PermissionBrandRead,
+		updatePermission: cs.PermissionBrandUpdate,
+	}
+	supplierHistoryCtrl = catalogHistoryCtrl{
+		category:         service.CatalogSupplier,
+		readPermission:   cs.PermissionSupplierRead,
+		updatePermission: cs.PermissionSupplierUpdate,
+	}
+)
+
+// addRouters add the delete, restore and history routers of catalog
+func (ctrl catalogHistoryCtrl) addRouters(g *elton.Group, prefix string) {
+	// 删除（软删除）
+	g.DELETE(
+		prefix+"/{id}",
+		loadUserSession,
+		newTracker(cs.ActionCatalogDelete),
+		requirePermission(ctrl.updatePermission),
+		ctrl.delete,
+	)
+	// 恢复已删除的记录
+	g.PATCH(
+		prefix+"/{id}/restore",
+		loadUserSession,
+		newTracker(cs.ActionCatalogRestore),
+		requirePermission(ctrl.updatePermission),
+		ctrl.restore,
+	)
+	// 变更历史
+	g.GET(
+		prefix+"/{id}/histories",
+		loadUserSession,
+		requirePermission(ctrl.readPermission),
+		ctrl.listHistory,
+	)
+	// 获取指定时间点的数据
+	g.GET(
+		prefix+"/{id}/as-of",
+		loadUserSession,
+		requirePermission(ctrl.readPermission),
+		ctrl.getAsOf,
+	)
+}
+
+// track run the fn and record the snapshot of changes
+func (ctrl catalogHistoryCtrl) track(c *elton.Context, id uint, fn func() error) error {
+	return catalogHistorySrv.Track(ctrl.category, id, getUserSession(c).GetID(), service.CatalogActionUpdate, fn)
+}
+
+// recordCreate record the snapshot of created record
+func (ctrl catalogHistoryCtrl) recordCreate(c *elton.Context, id uint) error {
+	return catalogHistorySrv.RecordCreate(ctrl.category, id, getUserSession(c).GetID())
+}
+
+// delete soft delete the record
+func (ctrl catalogHistoryCtrl) delete(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	err = catalogHistorySrv.Delete(ctrl.category, id, getUserSession(c).GetID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// restore restore the deleted record
+func (ctrl catalogHistoryCtrl) restore(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	err = catalogHistorySrv.Restore(ctrl.category, id, getUserSession(c).GetID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// listHistory list the snapshots of record
+func (ctrl catalogHistoryCtrl) listHistory(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	result, err := catalogHistorySrv.List(ctrl.category, id)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Histories service.CatalogSnapshots `json:"histories"`
+	}{
+		result,
+	}
+	return
+}
+
+// getAsOf get the data of record at the time
+func (ctrl catalogHistoryCtrl) getAsOf(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := getCatalogAsOfParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	data, err := catalogHistorySrv.GetAsOf(ctrl.category, id, params.Time)
+	if err != nil {
+		return
+	}
+	c.Body = data
+	return
+}
@@ -0,0 +1,299 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	commissionCtrl struct{}
+
+	listCommissionLedgerParams struct {
+		listParams
+
+		Category string `json:"category,omitempty" validate:"omitempty,xCommissionLedgerCategory"`
+	}
+	listCommissionLedgerResp struct {
+		Count   int64                     `json:"count,omitempty"`
+		Ledgers service.CommissionLedgers `json:"ledgers,omitempty"`
+	}
+
+	// 提现参数
+	withdrawCommissionParams struct {
+		Amount  float64 `json:"amount,omitempty" validate:"xCommissionWithdrawAmount"`
+		Account string  `json:"account,omitempty" validate:"xCommissionWithdrawAccount"`
+	}
+	// 提现审核参数
+	reviewCommissionWithdrawalParams struct {
+		Remark string `json:"remark,omitempty" validate:"omitempty,xCommissionRemark"`
+	}
+
+	listCommissionWithdrawalParams struct {
+		listParams
+
+		User   uint   `json:"user,omitempty" validate:"omitempty,xUserID"`
+		Status string `json:"status,omitempty" validate:"omitempty,xCommissionWithdrawalStatus"`
+	}
+	listCommissionWithdrawalResp struct {
+		Count       int64                         `json:"count,omitempty"`
+		Withdrawals service.CommissionWithdrawals `json:"withdrawals,omitempty"`
+	}
+)
+
+func init() {
+	ctrl := commissionCtrl{}
+	g := router.NewGroup("/commissions", loadUserSession)
+
+	// 我的佣金账户
+	g.GET(
+		"/v1/account",
+		shouldBeLogined,
+		ctrl.getAccount,
+	)
+	// 我的佣金流水
+	g.GET(
+		"/v1/ledgers",
+		shouldBeLogined,
+		ctrl.listLedger,
+	)
+
+	// 申请提现
+	g.POST(
+		"/v1/withdrawals",
+		shouldBeLogined,
+		newTracker(cs.ActionCommissionWithdraw),
+		ctrl.withdraw,
+	)
+	// 我的提现申请
+	g.GET(
+		"/v1/withdrawals/mine",
+		shouldBeLogined,
+		ctrl.listMyWithdrawal,
+	)
+	g.GET(
+		"/v1/withdrawals/statuses",
+		ctrl.listWithdrawalStatus,
+	)
+	// 查询提现申请
+	g.GET(
+		"/v1/withdrawals",
+		requirePermission(cs.PermissionCommissionRead),
+		ctrl.listWithdrawal,
+	)
+	// 同意提现（打款）
+	g.PATCH(
+		"/v1/withdrawals/{id}/approve",
+		newTracker(cs.ActionCommissionWithdrawalApprove),
+		requirePermission(cs.PermissionCommissionApprove),
+		shouldHave2FA,
+		ctrl.approveWithdrawal,
+	)
+	// 拒绝提现
+	g.PATCH(
+		"/v1/withdrawals/{id}/reject",
+		newTracker(cs.ActionCommissionWithdrawalReject),
+		requirePermission(cs.PermissionCommissionApprove),
+		shouldHave2FA,
+		ctrl.rejectWithdrawal,
+	)
+}
+
+func (params listCommissionLedgerParams) toConditions(userID uint) []interface{} {
+	conds := queryConditions{}
+	conds.add("user_id = ?", userID)
+	if params.Category != "" {
+		conds.add("category = ?", params.Category)
+	}
+	return conds.toArray()
+}
+
+func (params listCommissionWithdrawalParams) toConditions() []interface{} {
+	conds := queryConditions{}
+	if params.User != 0 {
+		conds.add("user_id = ?", params.User)
+	}
+	if params.Status != "" {
+		conds.add("status = ?", params.Status)
+	}
+	return conds.toArray()
+}
+
+func (commissionCtrl) queryWithdrawal(params listCommissionWithdrawalParams) (resp *listCommissionWithdrawalResp, err error) {
+	args := params.toConditions()
+	queryParams := params.toPGQueryParams()
+	count := int64(-1)
+	if queryParams.Offset == 0 {
+		count, err = commissionLedgerSrv.CountWithdrawal(args...)
+		if err != nil {
+			return
+		}
+	}
+	withdrawals, err := commissionLedgerSrv.ListWithdrawal(queryParams, args...)
+	if err != nil {
+		return
+	}
+	resp = &listCommissionWithdrawalResp{
+		Count:       count,
+		Withdrawals: withdrawals,
+	}
+	return
+}
+
+// getAccount get my commission account
+func (commissionCtrl) getAccount(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	account, err := commissionLedgerSrv.FindAccount(us.GetID())
+	if err != nil {
+		return
+	}
+	c.Body = account
+	return
+}
+
+// listLedger list my commission ledger
+func (commissionCtrl) listLedger(c *elton.Context) (err error) {
+	params := listCommissionLedgerParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	args := params.toConditions(us.GetID())
+	queryParams := params.toPGQueryParams()
+	count := int64(-1)
+	if queryParams.Offset == 0 {
+		count, err = commissionLedgerSrv.Count(args...)
+		if err != nil {
+			return
+		}
+	}
+	ledgers, err := commissionLedgerSrv.List(queryParams, args...)
+	if err != nil {
+		return
+	}
+	c.Body = &listCommissionLedgerResp{
+		Count:   count,
+		Ledgers: ledgers,
+	}
+	return
+}
+
+// withdraw apply for withdrawal
+func (commissionCtrl) withdraw(c *elton.Context) (err error) {
+	params := withdrawCommissionParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	withdrawal, err := commissionLedgerSrv.Withdraw(service.CommissionWithdrawParams{
+		UserID:  us.GetID(),
+		Amount:  params.Amount,
+		Account: params.Account,
+	})
+	if err != nil {
+		return
+	}
+	c.Created(withdrawal)
+	return
+}
+
+// listMyWithdrawal list my withdrawal
+func (ctrl commissionCtrl) listMyWithdrawal(c *elton.Context) (err error) {
+	params := listCommissionWithdrawalParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	params.User = us.GetID()
+	resp, err := ctrl.queryWithdrawal(params)
+	if err != nil {
+		return
+	}
+	c.Body = resp
+	return
+}
+
+// listWithdrawal list withdrawal
+func (ctrl commissionCtrl) listWithdrawal(c *elton.Context) (err error) {
+	params := listCommissionWithdrawalParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	resp, err := ctrl.queryWithdrawal(params)
+	if err != nil {
+		return
+	}
+	c.Body = resp
+	return
+}
+
+// listWithdrawalStatus list the status of withdrawal
+func (commissionCtrl) listWithdrawalStatus(c *elton.Context) (err error) {
+	c.CacheMaxAge("5m")
+	c.Body = &struct {
+		Statuses service.CommissionWithdrawalStatusInfoList `json:"statuses,omitempty"`
+	}{
+		commissionLedgerSrv.ListWithdrawalStatus(),
+	}
+	return
+}
+
+// approveWithdrawal approve the withdrawal
+func (commissionCtrl) approveWithdrawal(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := reviewCommissionWithdrawalParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	withdrawal, err := commissionLedgerSrv.ApproveWithdrawal(id, us.GetID(), params.Remark)
+	if err != nil {
+		return
+	}
+	c.Body = withdrawal
+	return
+}
+
+// rejectWithdrawal reject the withdrawal
+func (commissionCtrl) rejectWithdrawal(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := reviewCommissionWithdrawalParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	withdrawal, err := commissionLedgerSrv.RejectWithdrawal(id, us.GetID(), params.Remark)
+	if err != nil {
+		return
+	}
+	c.Body = withdrawal
+	return
+}
@@ -105,7 +105,7 @@ type locationResponse struct {
 // Responses:
 // 	200: locationResponse
 func (ctrl commonCtrl) location(c *elton.Context) (err error) {
-	info, err := service.GetLocationByIP(c.RealIP(), c)
+	info, err := service.GetLocationByIP(util.GetClientIP(c.Request), c)
 	if err != nil {
 		return
 	}
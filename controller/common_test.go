@@ -48,6 +48,8 @@ func TestCommonCtrl(t *testing.T) {
 
 		assert := assert.New(t)
 		req := httptest.NewRequest("GET", "/", nil)
+		// 来自可信代理的请求才使用X-Forwarded-For
+		req.RemoteAddr = "127.0.0.1:1234"
 		req.Header.Set(elton.HeaderXForwardedFor, "1.1.1.1")
 		c := elton.NewContext(nil, req)
 		err := ctrl.location(c)
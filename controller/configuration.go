@@ -51,31 +51,39 @@ type (
 )
 
 func init() {
-	g := router.NewGroup("/configurations", loadUserSession, shouldBeSu)
+	g := router.NewGroup("/configurations", loadUserSession)
 	ctrl := configurationCtrl{}
 
 	g.GET(
 		"/v1",
+		requirePermission(cs.PermissionConfigurationRead),
 		ctrl.list,
 	)
 
 	g.POST(
 		"/v1",
 		newTracker(cs.ActionConfigurationAdd),
+		requirePermission(cs.PermissionConfigurationUpdate),
+		shouldHave2FA,
 		ctrl.add,
 	)
 	g.GET(
 		"/v1/{id}",
+		requirePermission(cs.PermissionConfigurationRead),
 		ctrl.findByID,
 	)
 	g.PATCH(
 		"/v1/{id}",
 		newTracker(cs.ActionConfigurationUpdate),
+		requirePermission(cs.PermissionConfigurationUpdate),
+		shouldHave2FA,
 		ctrl.updateByID,
 	)
 	g.DELETE(
 		"/v1/{id}",
 		newTracker(cs.ActionConfigurationDelete),
+		requirePermission(cs.PermissionConfigurationUpdate),
+		shouldHave2FA,
 		ctrl.delete,
 	)
 }
@@ -73,6 +73,20 @@ var (
 	advertisementSrv = new(service.AdvertisementSrv)
 	// 图片服务
 	imageSrv = new(service.ImageSrv)
+	// 售后服务
+	afterSaleSrv        = new(service.AfterSaleSrv)
+	invoiceSrv          = new(service.InvoiceSrv)
+	commissionLedgerSrv = new(service.CommissionLedgerSrv)
+	// 产品搜索关键字服务
+	productSearchKeywordSrv = new(service.ProductSearchKeywordSrv)
+	// 产品收藏与到货通知服务
+	productFavoriteSrv = new(service.ProductFavoriteSrv)
+	// 用户通知服务
+	notificationSrv = new(service.NotificationSrv)
+	// 商品目录变更历史服务
+	catalogHistorySrv = new(service.CatalogHistorySrv)
+	// 角色权限服务
+	roleSrv = new(service.RoleSrv)
 
 	// 创建新的并发控制中间件
 	newConcurrentLimit = middleware.NewConcurrentLimit
@@ -82,33 +96,19 @@ var (
 	newErrorLimit = middleware.NewErrorLimit
 
 	getUserSession = service.NewUserSession
-	// 加载用户session
-	loadUserSession = middleware.NewSession()
-	// 判断用户是否登录
+	// 加载用户session（支持通过API token加载）
+	loadUserSession = elton.Compose(loadSession, middleware.NewSession())
+	// 判断用户是否登录（不允许通过API token访问）
 	shouldBeLogined = checkLogin
+	// 判断用户是否登录，允许通过API token访问，需配合requirePermission使用
+	shouldBeLoginedAllowAPIToken = checkLoginAllowAPIToken
 	// 判断用户是否未登录
 	shouldBeAnonymous = checkAnonymous
-	// 判断用户是否admin权限
-	shouldBeAdmin = newCheckRolesMiddleware([]string{
-		cs.UserRoleSu,
-		cs.UserRoleAdmin,
-	})
-	// shouldBeSu 判断用户是否su权限
-	shouldBeSu = newCheckRolesMiddleware([]string{
-		cs.UserRoleSu,
-	})
+	// shouldHave2FA 要求启用两步验证的角色或分组，需要通过两步验证
+	shouldHave2FA = checkTwoFactor
 	// noCacheIfSetNoCache 如果query指定了no cache，则设置不缓存
 	noCacheIfSetNoCache = middleware.NewNoCacheWithCondition("cacheControl", "no-cache")
 
-	// checkMarketingGroup 判断用户组是否marketing
-	checkMarketingGroup = newCheckGroupsMiddleware([]string{
-		cs.UserGroupMarketing,
-	})
-	// checkLogisticsGroup 判断用户组是否logistics，物流部门
-	checkLogisticsGroup = newCheckGroupsMiddleware([]string{
-		cs.UserGroupLogistics,
-	})
-
 	// 图形验证码校验
 	captchaValidate elton.Handler
 
@@ -183,7 +183,7 @@ func newTracker(action string) elton.Handler {
 			if us != nil && us.IsLogined() {
 				account = us.GetAccount()
 			}
-			ip := c.RealIP()
+			ip := util.GetClientIP(c.Request)
 			sid := util.GetSessionID(c)
 			fields := make([]zap.Field, 0, 10)
 			fields = append(
@@ -227,6 +227,19 @@ func isLogined(c *elton.Context) bool {
 }
 
 func checkLogin(c *elton.Context) (err error) {
+	if !isLogined(c) {
+		err = errShouldLogin
+		return
+	}
+	// API token仅可访问明确允许的功能
+	if getUserSession(c).IsAPIToken() {
+		err = errForbidden
+		return
+	}
+	return c.Next()
+}
+
+func checkLoginAllowAPIToken(c *elton.Context) (err error) {
 	if !isLogined(c) {
 		err = errShouldLogin
 		return
@@ -242,39 +255,23 @@ func checkAnonymous(c *elton.Context) (err error) {
 	return c.Next()
 }
 
-// newCheckRolesMiddleware create a new check roles middleware
-func newCheckRolesMiddleware(validRoles []string) elton.Handler {
+// requirePermission create a new middleware which checks the user has the permission,
+// the permissions are granted by the roles and groups of user
+func requirePermission(permission string) elton.Handler {
 	return func(c *elton.Context) (err error) {
 		if !isLogined(c) {
 			err = errShouldLogin
 			return
 		}
-		us := service.NewUserSession(c)
-		roles := us.GetRoles()
-		valid := util.UserRoleIsValid(validRoles, roles)
-		if valid {
-			return c.Next()
-		}
-		err = errForbidden
-		return
-	}
-}
-
-// newCheckGroupsMiddleware create a new check groups middleware
-func newCheckGroupsMiddleware(validGroups []string) elton.Handler {
-	return func(c *elton.Context) (err error) {
-		if !isLogined(c) {
-			err = errShouldLogin
+		valid, err := getUserSession(c).HasPermission(permission)
+		if err != nil {
 			return
 		}
-		us := service.NewUserSession(c)
-		groups := us.GetGroups()
-		valid := util.UserGroupIsValid(validGroups, groups)
-		if valid {
-			return c.Next()
+		if !valid {
+			err = errForbidden
+			return
 		}
-		err = errForbidden
-		return
+		return c.Next()
 	}
 }
 
@@ -65,7 +65,7 @@ func init() {
 	g.POST(
 		"/v1/images",
 		loadUserSession,
-		checkMarketingGroup,
+		requirePermission(cs.PermissionFileUpload),
 		newTracker(cs.ActionFileUpload),
 		ctrl.uploadImage,
 	)
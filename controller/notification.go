@@ -0,0 +1,104 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	notificationCtrl struct{}
+
+	listNotificationParams struct {
+		listParams
+
+		// 仅未读
+		Unread string `json:"unread,omitempty"`
+	}
+)
+
+func init() {
+	ctrl := notificationCtrl{}
+	g := router.NewGroup("/notifications", loadUserSession, shouldBeLogined)
+
+	// 我的通知
+	g.GET(
+		"/v1",
+		ctrl.list,
+	)
+	// 标记已读
+	g.PATCH(
+		"/v1/{id}/read",
+		ctrl.markRead,
+	)
+}
+
+func (params listNotificationParams) toConditions(userID uint) []interface{} {
+	conds := queryConditions{}
+	conds.add("user_id = ?", userID)
+	if params.Unread != "" {
+		conds.addQuery("read_at IS NULL")
+	}
+	return conds.toArray()
+}
+
+// list list my notifications
+func (notificationCtrl) list(c *elton.Context) (err error) {
+	params := listNotificationParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	args := params.toConditions(us.GetID())
+	queryParams := params.toPGQueryParams()
+	count := int64(-1)
+	if queryParams.Offset == 0 {
+		count, err = notificationSrv.Count(args...)
+		if err != nil {
+			return
+		}
+	}
+	notifications, err := notificationSrv.List(queryParams, args...)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Notifications service.Notifications `json:"notifications"`
+		Count         int64                 `json:"count"`
+	}{
+		notifications,
+		count,
+	}
+	return
+}
+
+// markRead mark the notification as read
+func (notificationCtrl) markRead(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = notificationSrv.MarkRead(us.GetID(), id)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
@@ -15,6 +15,8 @@
 package controller
 
 import (
+	"bytes"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -37,9 +39,11 @@ type (
 
 	addOrderParams struct {
 		Products []struct {
-			ProductID uint    `json:"productID,omitempty" validate:"xOrderProductID"`
-			Count     uint    `json:"count,omitempty" validate:"xOrderProductCount"`
-			Price     float64 `json:"price,omitempty" validate:"xProductPrice"`
+			ProductID uint `json:"productID,omitempty" validate:"xOrderProductID"`
+			// 产品规格，有规格的产品必须指定
+			SKU   uint    `json:"sku,omitempty"`
+			Count uint    `json:"count,omitempty" validate:"xOrderProductCount"`
+			Price float64 `json:"price,omitempty" validate:"xProductPrice"`
 		} `json:"products,omitempty"`
 		Amount              float64 `json:"amount,omitempty" validate:"required"`
 		ReceiverName        string  `json:"receiverName,omitempty"`
@@ -145,8 +149,8 @@ func init() {
 	g.GET(
 		"/v1",
 		loadUserSession,
-		shouldBeLogined,
-		checkMarketingGroup,
+		shouldBeLoginedAllowAPIToken,
+		requirePermission(cs.PermissionOrderRead),
 		ctrl.list,
 	)
 	// 查看我的订单
@@ -167,16 +171,16 @@ func init() {
 	g.GET(
 		"/v1/my-deliveries",
 		loadUserSession,
-		shouldBeLogined,
-		checkLogisticsGroup,
+		shouldBeLoginedAllowAPIToken,
+		requirePermission(cs.PermissionOrderDeliver),
 		ctrl.listDeliveryOrder,
 	)
 	// 查询未分派订单
 	g.GET(
 		"/v1/no-delivery",
 		loadUserSession,
-		shouldBeLogined,
-		checkLogisticsGroup,
+		shouldBeLoginedAllowAPIToken,
+		requirePermission(cs.PermissionOrderDeliver),
 		ctrl.listNoDelivery,
 	)
 
@@ -187,6 +191,13 @@ func init() {
 		shouldBeLogined,
 		ctrl.detail,
 	)
+	// 下载订单收据
+	g.GET(
+		"/v1/{sn}/invoice",
+		loadUserSession,
+		shouldBeLogined,
+		ctrl.invoice,
+	)
 
 	// 支付订单
 	g.PATCH(
@@ -220,9 +231,9 @@ func init() {
 	g.PATCH(
 		"/v1/{sn}/assign-deliverer",
 		loadUserSession,
-		shouldBeLogined,
+		shouldBeLoginedAllowAPIToken,
 		newTracker(cs.ActionOrderChangeDeliverer),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionOrderAssign),
 		orderUpdateLimit,
 		ctrl.changeDeliverer,
 	)
@@ -230,9 +241,9 @@ func init() {
 	g.PATCH(
 		"/v1/{sn}/assign-deliverer-to-me",
 		loadUserSession,
-		shouldBeLogined,
+		shouldBeLoginedAllowAPIToken,
 		newTracker(cs.ActionOrderChangeDelivererToMe),
-		checkLogisticsGroup,
+		requirePermission(cs.PermissionOrderDeliver),
 		orderUpdateLimit,
 		ctrl.changeDelivererToMe,
 	)
@@ -240,9 +251,9 @@ func init() {
 	g.PATCH(
 		"/v1/{sn}/to-be-shipped",
 		loadUserSession,
-		shouldBeLogined,
+		shouldBeLoginedAllowAPIToken,
 		newTracker(cs.ActionOrderToBeShipped),
-		checkLogisticsGroup,
+		requirePermission(cs.PermissionOrderDeliver),
 		orderUpdateLimit,
 		ctrl.toBeShipped,
 	)
@@ -250,9 +261,9 @@ func init() {
 	g.PATCH(
 		"/v1/{sn}/ship",
 		loadUserSession,
-		shouldBeLogined,
+		shouldBeLoginedAllowAPIToken,
 		newTracker(cs.ActionOrderShipped),
-		checkLogisticsGroup,
+		requirePermission(cs.PermissionOrderDeliver),
 		orderUpdateLimit,
 		ctrl.shipped,
 	)
@@ -260,9 +271,9 @@ func init() {
 	g.PATCH(
 		"/v1/delivering/loaction",
 		loadUserSession,
-		shouldBeLogined,
+		shouldBeLoginedAllowAPIToken,
 		newTracker(cs.ActionOrderUpdateDeliveryLocation),
-		checkLogisticsGroup,
+		requirePermission(cs.PermissionOrderDeliver),
 		ctrl.updateDeliveringLocation,
 	)
 
@@ -329,6 +340,7 @@ func (orderCtrl) add(c *elton.Context) (err error) {
 	for index, prod := range params.Products {
 		subOrders[index] = service.SubOrder{
 			Product:      prod.ProductID,
+			ProductSKU:   prod.SKU,
 			ProductCount: prod.Count,
 			ProductPrice: prod.Price,
 		}
@@ -472,6 +484,32 @@ func (orderCtrl) detail(c *elton.Context) (err error) {
 	return
 }
 
+// invoice download the invoice of order
+func (orderCtrl) invoice(c *elton.Context) (err error) {
+	order, err := orderSrv.FindBySN(c.Param("sn"))
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = order.ValidateOwner(us.GetID())
+	if err != nil {
+		return
+	}
+	data, header, err := invoiceSrv.GetData(order)
+	if err != nil {
+		return
+	}
+	c.NoCache()
+	for k, values := range header {
+		for _, v := range values {
+			c.AddHeader(k, v)
+		}
+	}
+	c.SetHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.png"`, order.SN))
+	c.BodyBuffer = bytes.NewBuffer(data)
+	return
+}
+
 // pay pay order
 func (orderCtrl) pay(c *elton.Context) (err error) {
 	params := payOrderParams{}
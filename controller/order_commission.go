@@ -15,9 +15,17 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
 	"github.com/vicanso/origin/router"
 	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/util"
 	"github.com/vicanso/origin/validate"
 )
 
@@ -30,6 +38,19 @@ type (
 		Recommender uint `json:"recommender,omitempty" validate:"omitempty,xUserID"`
 	}
 
+	getCommissionStatementParams struct {
+		Month string `json:"month,omitempty" validate:"omitempty,xOrderCommissionMonth"`
+	}
+	commissionSummaryParams struct {
+		Begin time.Time `json:"begin,omitempty"`
+		End   time.Time `json:"end,omitempty"`
+		Group string    `json:"group,omitempty" validate:"omitempty,xUserMarketingGroup"`
+	}
+
+	getOrderCommissionDownlineParams struct {
+		Depth string `json:"depth,omitempty" validate:"omitempty,xOrderCommissionDepth"`
+	}
+
 	listOrderCommissionsResp struct {
 		Count            int64                    `json:"count,omitempty"`
 		OrderCommissions service.OrderCommissions `json:"orderCommissions,omitempty"`
@@ -44,6 +65,37 @@ func init() {
 		"/v1",
 		ctrl.list,
 	)
+	// 我的月度结算单
+	g.GET(
+		"/v1/statements",
+		ctrl.getStatement,
+	)
+	// 导出月度佣金明细
+	g.GET(
+		"/v1/statements/export",
+		ctrl.exportStatement,
+	)
+	// 我负责的销售分组的汇总
+	g.GET(
+		"/v1/marketing-groups/summary",
+		ctrl.getMarketingGroupSummary,
+	)
+	// 财务按分组汇总
+	g.GET(
+		"/v1/summary",
+		requirePermission(cs.PermissionCommissionRead),
+		ctrl.getSummary,
+	)
+	g.GET(
+		"/v1/summary/export",
+		requirePermission(cs.PermissionCommissionRead),
+		ctrl.exportSummary,
+	)
+	// 我的下线及各层级佣金
+	g.GET(
+		"/v1/downline",
+		ctrl.getDownline,
+	)
 }
 
 func (params listOrderCommissionParams) toConditions() []interface{} {
@@ -81,3 +133,208 @@ func (ctrl orderCommissionCtrl) list(c *elton.Context) (err error) {
 	}
 	return
 }
+
+// getDownline get my downline tree
+func (ctrl orderCommissionCtrl) getDownline(c *elton.Context) (err error) {
+	params := getOrderCommissionDownlineParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	depth, _ := strconv.Atoi(params.Depth)
+	us := getUserSession(c)
+	downline, err := orderCommissionSrv.GetDownline(us.GetID(), depth)
+	if err != nil {
+		return
+	}
+	c.Body = downline
+	return
+}
+
+// setCSVBody set csv data as response body
+func setCSVBody(c *elton.Context, filename string, records [][]string) (err error) {
+	buffer := new(bytes.Buffer)
+	// 添加BOM，避免excel打开时中文乱码
+	buffer.WriteString("\xEF\xBB\xBF")
+	w := csv.NewWriter(buffer)
+	err = w.WriteAll(records)
+	if err != nil {
+		return
+	}
+	c.NoCache()
+	c.SetHeader(elton.HeaderContentType, "text/csv; charset=utf-8")
+	c.SetHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+	c.BodyBuffer = buffer
+	return
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func (params commissionSummaryParams) toServiceParams() service.CommissionSummaryParams {
+	serviceParams := service.CommissionSummaryParams{
+		Begin: params.Begin,
+		End:   params.End,
+	}
+	if params.Group != "" {
+		serviceParams.Groups = []string{
+			params.Group,
+		}
+	}
+	return serviceParams
+}
+
+// getStatement get my monthly statement
+func (orderCommissionCtrl) getStatement(c *elton.Context) (err error) {
+	params := getCommissionStatementParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	statement, err := orderCommissionSrv.GetStatement(us.GetID(), params.Month)
+	if err != nil {
+		return
+	}
+	c.Body = statement
+	return
+}
+
+// exportStatement export my commissions of the month as csv
+func (orderCommissionCtrl) exportStatement(c *elton.Context) (err error) {
+	params := getCommissionStatementParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	begin, end, err := util.ChinaMonth(params.Month)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	orderCommissions, err := orderCommissionSrv.ListAll(PGQueryParams{
+		Limit: 100,
+	}, "recommender = ? AND created_at >= ? AND created_at < ?",
+		us.GetID(),
+		util.FormatTime(begin),
+		util.FormatTime(end),
+	)
+	if err != nil {
+		return
+	}
+	records := [][]string{
+		{
+			"订单编号",
+			"佣金分组",
+			"层级",
+			"支付金额",
+			"佣金比例",
+			"佣金",
+			"冲正佣金",
+			"生成时间",
+		},
+	}
+	for _, item := range orderCommissions {
+		records = append(records, []string{
+			item.OrderSN,
+			item.CommissionGroup,
+			strconv.Itoa(item.Level),
+			formatAmount(item.PayAmount),
+			strconv.FormatFloat(item.Ratio, 'f', -1, 64),
+			formatAmount(item.CommissionAmount),
+			formatAmount(item.ReversedAmount),
+			util.FormatTime(*item.CreatedAt),
+		})
+	}
+	return setCSVBody(c, "commission-"+begin.Format("2006-01"), records)
+}
+
+// getMarketingGroupSummary get the summary of my marketing groups
+func (orderCommissionCtrl) getMarketingGroupSummary(c *elton.Context) (err error) {
+	params := commissionSummaryParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	groups := service.ListMarketingGroupByOwner(us.GetID())
+	if params.Group != "" {
+		if !util.ContainsString(groups, params.Group) {
+			err = errForbidden
+			return
+		}
+		groups = []string{
+			params.Group,
+		}
+	}
+	summaries := make(service.CommissionGroupSummaries, 0)
+	if len(groups) != 0 {
+		serviceParams := params.toServiceParams()
+		serviceParams.Groups = groups
+		// 按成员所在的销售分组汇总
+		summaries, err = orderCommissionSrv.SummaryByMemberGroup(serviceParams)
+		if err != nil {
+			return
+		}
+	}
+	c.Body = &struct {
+		Summaries service.CommissionGroupSummaries `json:"summaries,omitempty"`
+	}{
+		summaries,
+	}
+	return
+}
+
+// getSummary get the summary of all groups
+func (orderCommissionCtrl) getSummary(c *elton.Context) (err error) {
+	params := commissionSummaryParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	summaries, err := orderCommissionSrv.SummaryByGroup(params.toServiceParams())
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Summaries service.CommissionGroupSummaries `json:"summaries,omitempty"`
+	}{
+		summaries,
+	}
+	return
+}
+
+// exportSummary export the summary of all groups as csv
+func (orderCommissionCtrl) exportSummary(c *elton.Context) (err error) {
+	params := commissionSummaryParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	summaries, err := orderCommissionSrv.SummaryByGroup(params.toServiceParams())
+	if err != nil {
+		return
+	}
+	records := [][]string{
+		{
+			"佣金分组",
+			"订单数",
+			"推荐人数",
+			"支付金额",
+			"佣金",
+			"冲正佣金",
+		},
+	}
+	for _, item := range summaries {
+		records = append(records, []string{
+			item.CommissionGroup,
+			strconv.FormatInt(item.OrderCount, 10),
+			strconv.FormatInt(item.RecommenderCount, 10),
+			formatAmount(item.PayAmount),
+			formatAmount(item.CommissionAmount),
+			formatAmount(item.ReversedAmount),
+		})
+	}
+	return setCSVBody(c, "commission-summary", records)
+}
@@ -16,18 +16,19 @@ package controller
 
 import (
 	"bytes"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v7"
+	"github.com/lib/pq"
 	"github.com/vicanso/elton"
 	"github.com/vicanso/hes"
 	"github.com/vicanso/origin/cs"
-	"github.com/vicanso/origin/helper"
 	"github.com/vicanso/origin/router"
 	"github.com/vicanso/origin/service"
 	"github.com/vicanso/origin/validate"
+	"go.uber.org/zap"
 )
 
 type (
@@ -87,6 +88,21 @@ type (
 		Status      string `json:"status,omitempty" validate:"omitempty,xStatus"`
 		Purchasable string `json:"purchasable,omitempty"`
 		Keyword     string `json:"keyword,omitempty" validate:"omitempty,xKeyword"`
+		// 是否包括子分类的产品
+		Descendants string `json:"descendants,omitempty"`
+		// 排序方式：热度、近一周销量、近一月销量
+		Sort string `json:"sort,omitempty" validate:"omitempty,xProductSort"`
+
+		// 分类及其子分类（由Category转换）
+		categories []int64
+	}
+	listRelatedProductParams struct {
+		Category string `json:"category,omitempty" validate:"xProductRelationCategory"`
+		Limit    string `json:"limit,omitempty" validate:"omitempty,xLimit"`
+	}
+	listProductSearchSuggestionParams struct {
+		Keyword string `json:"keyword,omitempty" validate:"xKeyword"`
+		Limit   string `json:"limit,omitempty" validate:"omitempty,xLimit"`
 	}
 	addProductCategoryParams struct {
 		Name    string  `json:"name,omitempty" validate:"xProductCategoryName"`
@@ -104,6 +120,64 @@ type (
 		Rank    int     `json:"rank,omitempty" validate:"omitempty,xRank"`
 		Icon    string  `json:"icon,omitempty" validate:"omitempty,xFile"`
 	}
+	addProductSKUParams struct {
+		Name  string            `json:"name,omitempty" validate:"xProductSKUName"`
+		SN    string            `json:"sn,omitempty" validate:"omitempty,xProductSN"`
+		Attrs map[string]string `json:"attrs,omitempty" validate:"omitempty,xProductSKUAttrs"`
+		Price float64           `json:"price,omitempty" validate:"xProductPrice"`
+		Specs uint              `json:"specs,omitempty" validate:"xProductSpecs"`
+		Unit  string            `json:"unit,omitempty" validate:"xProductUnit"`
+		Stock int               `json:"stock,omitempty" validate:"omitempty,xProductSKUStock"`
+		Pic   string            `json:"pic,omitempty" validate:"omitempty,xFile"`
+		Rank  int               `json:"rank,omitempty" validate:"omitempty,xRank"`
+		// 默认为启用
+		Status int `json:"status,omitempty" validate:"omitempty,xStatus"`
+	}
+	updateProductSKUParams struct {
+		Name  string            `json:"name,omitempty" validate:"omitempty,xProductSKUName"`
+		SN    string            `json:"sn,omitempty" validate:"omitempty,xProductSN"`
+		Attrs map[string]string `json:"attrs,omitempty" validate:"omitempty,xProductSKUAttrs"`
+		Price float64           `json:"price,omitempty" validate:"omitempty,xProductPrice"`
+		Specs uint              `json:"specs,omitempty" validate:"omitempty,xProductSpecs"`
+		Unit  string            `json:"unit,omitempty" validate:"omitempty,xProductUnit"`
+		// 库存可设置为0，因此使用指针
+		Stock  *int   `json:"stock,omitempty" validate:"omitempty,xProductSKUStock"`
+		Pic    string `json:"pic,omitempty" validate:"omitempty,xFile"`
+		Rank   int    `json:"rank,omitempty" validate:"omitempty,xRank"`
+		Status int    `json:"status,omitempty" validate:"omitempty,xStatus"`
+	}
+	importProductParams struct {
+		// 仅校验数据，不导入
+		DryRun string `json:"dryRun,omitempty"`
+	}
+	// 导入数据的单行出错信息，row为表格中的行号
+	importProductRowError struct {
+		Row     int    `json:"row"`
+		Message string `json:"message"`
+	}
+	importProductResp struct {
+		DryRun   bool                     `json:"dryRun"`
+		Imported bool                     `json:"imported"`
+		Count    int                      `json:"count"`
+		Errors   []*importProductRowError `json:"errors"`
+	}
+	exportProductParams struct {
+		Format   string `json:"format,omitempty" validate:"xProductSheetFormat"`
+		Category string `json:"category,omitempty" validate:"omitempty,xProductCategory"`
+		Status   string `json:"status,omitempty" validate:"omitempty,xStatus"`
+	}
+	addProductPriceParams struct {
+		// 产品规格，为空则调整产品的价格
+		ProductSKU uint    `json:"productSKU,omitempty"`
+		Price      float64 `json:"price,omitempty" validate:"xProductPrice"`
+		// 生效时间，为空则立即生效
+		EffectiveAt *time.Time `json:"effectiveAt,omitempty"`
+		Remark      string     `json:"remark,omitempty" validate:"omitempty,xProductPriceRemark"`
+	}
+	moveProductCategoryParams struct {
+		// 为空则移动至顶级
+		Belongs []int64 `json:"belongs"`
+	}
 	listProductCategoryParams struct {
 		listParams
 
@@ -113,6 +187,21 @@ type (
 	}
 )
 
+var (
+	// 产品列表的排序方式
+	productSortOrders = map[string]string{
+		"popularity": "-popularity,-rank",
+		"weekSales":  "-weekSales,-rank",
+		"monthSales": "-monthSales,-rank",
+	}
+)
+
+const (
+	// 单次导入、导出的产品数量限制
+	maxImportProductCount = 1000
+	maxExportProductCount = 10000
+)
+
 func init() {
 	ctrl := productCtrl{}
 	g := router.NewGroup("/products")
@@ -128,6 +217,18 @@ func init() {
 		"/v1/search-hot-keywords",
 		ctrl.listSearchHotKeywords,
 	)
+	// 搜索联想
+	g.GET(
+		"/v1/search-suggestions",
+		ctrl.listSearchSuggestion,
+	)
+	// 无搜索结果的关键字
+	g.GET(
+		"/v1/search-zero-result-keywords",
+		loadUserSession,
+		requirePermission(cs.PermissionProductRead),
+		ctrl.listSearchZeroResultKeywords,
+	)
 
 	// 获取产品分类
 	g.GET(
@@ -140,7 +241,7 @@ func init() {
 		"/v1/categories",
 		loadUserSession,
 		newTracker(cs.ActionProductCategoryAdd),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionProductUpdate),
 		ctrl.addCategory,
 	)
 	// 更新产品分类
@@ -148,30 +249,66 @@ func init() {
 		"/v1/categories/{id}",
 		loadUserSession,
 		newTracker(cs.ActionProductCategoryUpdate),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionProductUpdate),
 		ctrl.updateCategoryByID,
 	)
+	// 获取产品分类树
+	g.GET(
+		"/v1/categories/tree",
+		noCacheIfSetNoCache,
+		ctrl.getCategoryTree,
+	)
+	// 移动产品分类
+	g.PATCH(
+		"/v1/categories/{id}/move",
+		loadUserSession,
+		newTracker(cs.ActionProductCategoryMove),
+		requirePermission(cs.PermissionProductUpdate),
+		ctrl.moveCategory,
+	)
 	// 获取产品分类详情
 	g.GET(
 		"/v1/categories/{id}",
 		noCacheIfSetNoCache,
 		ctrl.findCategoryByID,
 	)
+	productCategoryHistoryCtrl.addRouters(g, "/v1/categories")
 
 	// 添加产品
 	g.POST(
 		"/v1",
 		loadUserSession,
 		newTracker(cs.ActionProductAdd),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionProductUpdate),
 		ctrl.add,
 	)
+	// 批量导入产品
+	g.POST(
+		"/v1/import",
+		loadUserSession,
+		newTracker(cs.ActionProductImport),
+		requirePermission(cs.PermissionProductImport),
+		ctrl.importFromFile,
+	)
+	// 导出产品
+	g.GET(
+		"/v1/export",
+		loadUserSession,
+		requirePermission(cs.PermissionProductExport),
+		ctrl.export,
+	)
 	// 查询产品详情
 	g.GET(
 		"/v1/{id}",
 		noCacheIfSetNoCache,
 		ctrl.findByID,
 	)
+	// 获取关联产品（经常一起购买、看了又看）
+	g.GET(
+		"/v1/{id}/related",
+		noCacheIfSetNoCache,
+		ctrl.listRelated,
+	)
 	// 获取产品主图
 	g.GET(
 		"/v1/{id}/cover/{quality}-{width}-{height}.{ext}",
@@ -182,9 +319,58 @@ func init() {
 		"/v1/{id}",
 		loadUserSession,
 		newTracker(cs.ActionProductUpdate),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionProductUpdate),
 		ctrl.updateByID,
 	)
+	productHistoryCtrl.addRouters(g, "/v1")
+	productSKUHistoryCtrl.addRouters(g, "/v1/skus")
+
+	// 获取产品规格
+	g.GET(
+		"/v1/{id}/skus",
+		noCacheIfSetNoCache,
+		ctrl.listSKU,
+	)
+	// 添加产品规格
+	g.POST(
+		"/v1/{id}/skus",
+		loadUserSession,
+		newTracker(cs.ActionProductSKUAdd),
+		requirePermission(cs.PermissionProductUpdate),
+		ctrl.addSKU,
+	)
+	// 更新产品规格
+	g.PATCH(
+		"/v1/skus/{id}",
+		loadUserSession,
+		newTracker(cs.ActionProductSKUUpdate),
+		requirePermission(cs.PermissionProductUpdate),
+		ctrl.updateSKUByID,
+	)
+
+	// 获取产品价格历史（包括待生效的调价）
+	g.GET(
+		"/v1/{id}/prices",
+		loadUserSession,
+		requirePermission(cs.PermissionProductRead),
+		ctrl.listPrice,
+	)
+	// 调整产品价格（可指定生效时间）
+	g.POST(
+		"/v1/{id}/prices",
+		loadUserSession,
+		newTracker(cs.ActionProductPriceAdd),
+		requirePermission(cs.PermissionProductUpdate),
+		ctrl.addPrice,
+	)
+	// 取消待生效的调价
+	g.PATCH(
+		"/v1/prices/{id}/cancel",
+		loadUserSession,
+		newTracker(cs.ActionProductPriceCancel),
+		requirePermission(cs.PermissionProductUpdate),
+		ctrl.cancelPrice,
+	)
 }
 
 func (params listProductParams) toConditions() (conditions []interface{}) {
@@ -192,7 +378,9 @@ func (params listProductParams) toConditions() (conditions []interface{}) {
 	if params.IDS != "" {
 		conds.add("id IN (?)", strings.Split(params.IDS, ","))
 	}
-	if params.Category != "" {
+	if len(params.categories) != 0 {
+		conds.add("categories && ?", pq.Int64Array(params.categories))
+	} else if params.Category != "" {
 		conds.add("? = ANY(categories)", params.Category)
 	}
 	if params.Status != "" {
@@ -206,12 +394,50 @@ func (params listProductParams) toConditions() (conditions []interface{}) {
 	}
 
 	if params.Keyword != "" {
-		conds.add("name ILIKE ?", "%"+params.Keyword+"%")
+		query := service.GetProductSearchQuery(params.Keyword)
+		if query != "" {
+			conds.add(service.ProductSearchCondition, query)
+		} else {
+			conds.add("name ILIKE ?", "%"+params.Keyword+"%")
+		}
 	}
 
 	return conds.toArray()
 }
 
+func (params addProductParams) toProduct() service.Product {
+	return service.Product{
+		Name:       params.Name,
+		Price:      params.Price,
+		Specs:      params.Specs,
+		Unit:       params.Unit,
+		Catalog:    params.Catalog,
+		Pics:       params.Pics,
+		MainPic:    params.MainPic,
+		SN:         params.SN,
+		Status:     params.Status,
+		Keywords:   params.Keywords,
+		Categories: params.Categories,
+		StartedAt:  params.StartedAt,
+		EndedAt:    params.EndedAt,
+		Origin:     params.Origin,
+		Brand:      params.Brand,
+		Supplier:   params.Supplier,
+		Rank:       params.Rank,
+	}
+}
+
+func (params exportProductParams) toConditions() (conditions []interface{}) {
+	conds := queryConditions{}
+	if params.Category != "" {
+		conds.add("? = ANY(categories)", params.Category)
+	}
+	if params.Status != "" {
+		conds.add("status = ?", params.Status)
+	}
+	return conds.toArray()
+}
+
 func (params listProductCategoryParams) toConditions() (conditions []interface{}) {
 	conds := queryConditions{}
 	if params.Keyword != "" {
@@ -233,25 +459,11 @@ func (ctrl productCtrl) add(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
-	product, err := productSrv.Add(service.Product{
-		Name:       params.Name,
-		Price:      params.Price,
-		Specs:      params.Specs,
-		Unit:       params.Unit,
-		Catalog:    params.Catalog,
-		Pics:       params.Pics,
-		MainPic:    params.MainPic,
-		SN:         params.SN,
-		Status:     params.Status,
-		Keywords:   params.Keywords,
-		Categories: params.Categories,
-		StartedAt:  params.StartedAt,
-		EndedAt:    params.EndedAt,
-		Origin:     params.Origin,
-		Brand:      params.Brand,
-		Supplier:   params.Supplier,
-		Rank:       params.Rank,
-	})
+	product, err := productSrv.Add(params.toProduct())
+	if err != nil {
+		return
+	}
+	err = productHistoryCtrl.recordCreate(c, product.ID)
 	if err != nil {
 		return
 	}
@@ -266,21 +478,42 @@ func (ctrl productCtrl) list(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
-	if params.Keyword != "" {
-		// 添加关键字搜索数量
-		_, _ = helper.RedisGetClient().ZIncrBy(cs.ProductSearchHotKeywords, 1, params.Keyword).Result()
+	if params.Category != "" && params.Descendants != "" {
+		id, _ := strconv.Atoi(params.Category)
+		ids, e := productSrv.GetCategoryDescendants(uint(id))
+		if e != nil {
+			err = e
+			return
+		}
+		for _, item := range ids {
+			params.categories = append(params.categories, int64(item))
+		}
 	}
 	count := int64(-1)
 	args := params.toConditions()
 	queryParams := params.toPGQueryParams()
+	if params.Sort != "" {
+		queryParams.Order = productSortOrders[params.Sort]
+	}
 	if queryParams.Offset == 0 {
 		count, err = productSrv.Count(args...)
 		if err != nil {
 			return
 		}
+		// 首页查询时记录搜索关键字（包括无结果的关键字）
+		if params.Keyword != "" {
+			_ = productSearchKeywordSrv.Record(params.Keyword, count)
+		}
 	}
 
-	result, err := productSrv.List(queryParams, args...)
+	var result service.Products
+	query := service.GetProductSearchQuery(params.Keyword)
+	if query != "" {
+		// 关键字搜索按相关度排序
+		result, err = productSrv.Search(queryParams, query, args...)
+	} else {
+		result, err = productSrv.List(queryParams, args...)
+	}
 	if err != nil {
 		return
 	}
@@ -305,8 +538,133 @@ func (ctrl productCtrl) findByID(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
+	skuMatrix, err := productSrv.GetSKUMatrix(id)
+	if err != nil {
+		return
+	}
+	// 记录浏览，用于计算看了又看（同一用户一段时间内仅记录一次）
+	err = productSrv.AddView(getTrackID(c), id)
+	if err != nil {
+		logger.Error("add product view fail",
+			zap.Uint("product", id),
+			zap.Error(err),
+		)
+		err = nil
+	}
 	c.CacheMaxAge("1m")
-	c.Body = data
+	c.Body = &struct {
+		*service.Product
+		SKUMatrix *service.ProductSKUMatrix `json:"skuMatrix,omitempty"`
+	}{
+		data,
+		skuMatrix,
+	}
+	return
+}
+
+// listRelated list the related products
+func (ctrl productCtrl) listRelated(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := listRelatedProductParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(params.Limit)
+	if limit == 0 {
+		limit = 10
+	}
+	products, err := productSrv.ListRelated(id, params.Category, limit)
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("5m")
+	c.Body = &struct {
+		Products service.Products `json:"products"`
+	}{
+		products,
+	}
+	return
+}
+
+// listSKU list the skus of product
+func (ctrl productCtrl) listSKU(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	skuMatrix, err := productSrv.GetSKUMatrix(id)
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("1m")
+	c.Body = skuMatrix
+	return
+}
+
+// addSKU add sku to product
+func (ctrl productCtrl) addSKU(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := addProductSKUParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	if params.Status == 0 {
+		params.Status = cs.StatusEnabled
+	}
+	sku, err := productSrv.AddSKU(service.ProductSKU{
+		Product: id,
+		Name:    params.Name,
+		SN:      params.SN,
+		Attrs:   params.Attrs,
+		Price:   params.Price,
+		Specs:   params.Specs,
+		Unit:    params.Unit,
+		Stock:   params.Stock,
+		Pic:     params.Pic,
+		Rank:    params.Rank,
+		Status:  params.Status,
+	}, getUserSession(c).GetID())
+	if err != nil {
+		return
+	}
+	c.Created(sku)
+	return
+}
+
+// updateSKUByID update sku by id
+func (ctrl productCtrl) updateSKUByID(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := updateProductSKUParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = productSrv.UpdateSKU(id, service.ProductSKU{
+		Name:   params.Name,
+		SN:     params.SN,
+		Attrs:  params.Attrs,
+		Price:  params.Price,
+		Specs:  params.Specs,
+		Unit:   params.Unit,
+		Pic:    params.Pic,
+		Rank:   params.Rank,
+		Status: params.Status,
+	}, params.Stock, getUserSession(c).GetID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
 	return
 }
 
@@ -340,7 +698,7 @@ func (ctrl productCtrl) updateByID(c *elton.Context) (err error) {
 		Supplier:   params.Supplier,
 		Rank:       params.Rank,
 	}
-	err = productSrv.UpdateByID(id, product)
+	err = productSrv.UpdateByID(id, product, getUserSession(c).GetID())
 	if err != nil {
 		return
 	}
@@ -366,6 +724,10 @@ func (ctrl productCtrl) addCategory(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
+	err = productCategoryHistoryCtrl.recordCreate(c, cat.ID)
+	if err != nil {
+		return
+	}
 	c.Created(cat)
 	return
 }
@@ -413,6 +775,12 @@ func (ctrl productCtrl) updateCategoryByID(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
+	if params.Level != 0 || params.Belongs != nil {
+		err = productSrv.ValidateCategory(id, params.Level, params.Belongs)
+		if err != nil {
+			return
+		}
+	}
 	cat := &service.ProductCategory{
 		Name:    params.Name,
 		Level:   params.Level,
@@ -421,7 +789,45 @@ func (ctrl productCtrl) updateCategoryByID(c *elton.Context) (err error) {
 		Rank:    params.Rank,
 		Icon:    params.Icon,
 	}
-	err = productSrv.UpdateCategoryByID(id, cat)
+	err = productCategoryHistoryCtrl.track(c, id, func() error {
+		return productSrv.UpdateCategoryByID(id, cat)
+	})
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// getCategoryTree get the category tree
+func (ctrl productCtrl) getCategoryTree(c *elton.Context) (err error) {
+	tree, err := productSrv.GetCategoryTree()
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("1m")
+	c.Body = &struct {
+		Categories []*service.ProductCategoryTreeNode `json:"categories"`
+	}{
+		tree,
+	}
+	return
+}
+
+// moveCategory move the category to new parents
+func (ctrl productCtrl) moveCategory(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := moveProductCategoryParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = productCategoryHistoryCtrl.track(c, id, func() error {
+		return productSrv.MoveCategory(id, params.Belongs)
+	})
 	if err != nil {
 		return
 	}
@@ -482,6 +888,171 @@ func (ctrl productCtrl) getMainImage(c *elton.Context) (err error) {
 	return
 }
 
+// importFromFile import products from csv or xlsx
+func (ctrl productCtrl) importFromFile(c *elton.Context) (err error) {
+	params := importProductParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	format, err := service.GetProductSheetFormat(header.Filename)
+	if err != nil {
+		return
+	}
+	rows, err := service.ReadProductSheet(format, file)
+	if err != nil {
+		return
+	}
+	if len(rows) > maxImportProductCount {
+		err = hes.New(fmt.Sprintf("每次最多导入%d个产品", maxImportProductCount))
+		return
+	}
+	resolver := service.NewProductSheetResolver()
+	products := make([]*service.Product, 0, len(rows))
+	rowErrors := make([]*importProductRowError, 0)
+	for index, row := range rows {
+		// 表头为第一行
+		rowNumber := index + 2
+		data, e := resolver.Resolve(row)
+		if e == nil {
+			p := addProductParams{}
+			e = validate.Do(&p, data)
+			if e == nil {
+				product := p.toProduct()
+				products = append(products, &product)
+			}
+		}
+		if e != nil {
+			rowErrors = append(rowErrors, &importProductRowError{
+				Row:     rowNumber,
+				Message: hes.Wrap(e).Message,
+			})
+		}
+	}
+	resp := &importProductResp{
+		DryRun: params.DryRun != "",
+		Count:  len(rows),
+		Errors: rowErrors,
+	}
+	// 有出错或仅校验，不导入
+	if resp.DryRun || len(rowErrors) != 0 {
+		c.Body = resp
+		return
+	}
+	err = productSrv.BatchAdd(products, getUserSession(c).GetID())
+	if err != nil {
+		return
+	}
+	resp.Imported = true
+	c.Created(resp)
+	return
+}
+
+// export export products to csv or xlsx
+func (ctrl productCtrl) export(c *elton.Context) (err error) {
+	params := exportProductParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	args := params.toConditions()
+	products := make(service.Products, 0)
+	limit := 100
+	for offset := 0; offset < maxExportProductCount; offset += limit {
+		result, e := productSrv.List(service.PGQueryParams{
+			Limit:  limit,
+			Offset: offset,
+			Order:  "id",
+		}, args...)
+		if e != nil {
+			err = e
+			return
+		}
+		products = append(products, result...)
+		if len(result) < limit {
+			break
+		}
+	}
+	buf, err := service.WriteProductSheet(params.Format, products)
+	if err != nil {
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	if params.Format == service.ProductSheetXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	c.NoCache()
+	c.SetHeader(elton.HeaderContentType, contentType)
+	c.SetHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, params.Format))
+	c.BodyBuffer = bytes.NewBuffer(buf)
+	return
+}
+
+// listPrice list the price timeline of product
+func (ctrl productCtrl) listPrice(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	prices, err := productSrv.ListPrice(id)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Prices service.ProductPrices `json:"prices"`
+	}{
+		prices,
+	}
+	return
+}
+
+// addPrice add price change of product
+func (ctrl productCtrl) addPrice(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := addProductPriceParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	price, err := productSrv.SchedulePrice(service.ProductPriceParams{
+		Product:     id,
+		ProductSKU:  params.ProductSKU,
+		Price:       params.Price,
+		EffectiveAt: params.EffectiveAt,
+		Creator:     us.GetID(),
+		Remark:      params.Remark,
+	})
+	if err != nil {
+		return
+	}
+	c.Created(price)
+	return
+}
+
+// cancelPrice cancel the scheduled price
+func (ctrl productCtrl) cancelPrice(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = productSrv.CancelPrice(id, us.GetID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
 // listSearchHotKeywords 热门搜索关键字
 func (productCtrl) listSearchHotKeywords(c *elton.Context) (err error) {
 	limit, _ := strconv.Atoi(c.QueryParam("limit"))
@@ -492,17 +1063,61 @@ func (productCtrl) listSearchHotKeywords(c *elton.Context) (err error) {
 		err = hes.New("热门关键字数量查询不能大于10")
 		return
 	}
-	result, err := helper.RedisGetClient().ZRevRangeByScore(cs.ProductSearchHotKeywords, &redis.ZRangeBy{
-		Min:   "-inf",
-		Max:   "+inf",
-		Count: int64(limit),
-	}).Result()
+	hotKeywords, err := productSearchKeywordSrv.ListHot(limit)
 	if err != nil {
 		return
 	}
+	result := make([]string, len(hotKeywords))
+	for index, item := range hotKeywords {
+		result[index] = item.Keyword
+	}
 	c.CacheMaxAge("5m")
 	c.Body = map[string][]string{
 		"keywords": result,
 	}
 	return
 }
+
+// listSearchSuggestion 搜索联想
+func (productCtrl) listSearchSuggestion(c *elton.Context) (err error) {
+	params := listProductSearchSuggestionParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(params.Limit)
+	if limit == 0 {
+		limit = 10
+	}
+	suggestions, err := productSearchKeywordSrv.Suggest(params.Keyword, limit)
+	if err != nil {
+		return
+	}
+	c.CacheMaxAge("1m")
+	c.Body = map[string][]string{
+		"suggestions": suggestions,
+	}
+	return
+}
+
+// listSearchZeroResultKeywords 无搜索结果的关键字
+func (productCtrl) listSearchZeroResultKeywords(c *elton.Context) (err error) {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit == 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		err = hes.New("关键字数量查询不能大于100")
+		return
+	}
+	keywords, err := productSearchKeywordSrv.ListZeroResult(limit)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Keywords service.ProductSearchKeywords `json:"keywords"`
+	}{
+		keywords,
+	}
+	return
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	productFavoriteCtrl struct{}
+
+	listProductFavoriteParams struct {
+		listParams
+	}
+)
+
+func init() {
+	ctrl := productFavoriteCtrl{}
+	g := router.NewGroup("/products", loadUserSession, shouldBeLogined)
+
+	// 我收藏的产品
+	g.GET(
+		"/v1/favorites",
+		ctrl.listFavorite,
+	)
+	// 收藏产品
+	g.POST(
+		"/v1/{id}/favorite",
+		newTracker(cs.ActionProductFavoriteAdd),
+		ctrl.addFavorite,
+	)
+	// 取消收藏
+	g.DELETE(
+		"/v1/{id}/favorite",
+		newTracker(cs.ActionProductFavoriteRemove),
+		ctrl.removeFavorite,
+	)
+
+	// 我订阅的到货通知
+	g.GET(
+		"/v1/restock-subscriptions",
+		ctrl.listRestockSubscription,
+	)
+	// 订阅到货通知
+	g.POST(
+		"/v1/{id}/restock-subscription",
+		newTracker(cs.ActionProductRestockSubscribe),
+		ctrl.subscribeRestock,
+	)
+	// 取消到货通知
+	g.DELETE(
+		"/v1/{id}/restock-subscription",
+		newTracker(cs.ActionProductRestockUnsubscribe),
+		ctrl.unsubscribeRestock,
+	)
+}
+
+// listFavorite list my favorite products
+func (productFavoriteCtrl) listFavorite(c *elton.Context) (err error) {
+	params := listProductFavoriteParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	queryParams := params.toPGQueryParams()
+	if queryParams.Order == "" {
+		queryParams.Order = "-id"
+	}
+	count := int64(-1)
+	if queryParams.Offset == 0 {
+		count, err = productFavoriteSrv.Count("user_id = ?", us.GetID())
+		if err != nil {
+			return
+		}
+	}
+	products, err := productFavoriteSrv.ListProducts(us.GetID(), queryParams)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Products service.Products `json:"products"`
+		Count    int64            `json:"count"`
+	}{
+		products,
+		count,
+	}
+	return
+}
+
+// addFavorite add product to my favorites
+func (productFavoriteCtrl) addFavorite(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	favorite, err := productFavoriteSrv.Add(us.GetID(), id)
+	if err != nil {
+		return
+	}
+	c.Created(favorite)
+	return
+}
+
+// removeFavorite remove product from my favorites
+func (productFavoriteCtrl) removeFavorite(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = productFavoriteSrv.Remove(us.GetID(), id)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// listRestockSubscription list my restock subscriptions which are not notified
+func (productFavoriteCtrl) listRestockSubscription(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	subs, err := productFavoriteSrv.ListSubscription(service.PGQueryParams{
+		Order: "-id",
+	}, "user_id = ? AND notified_at IS NULL", us.GetID())
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Subscriptions service.ProductRestockSubscriptions `json:"subscriptions"`
+	}{
+		subs,
+	}
+	return
+}
+
+// subscribeRestock subscribe the restock notification
+func (productFavoriteCtrl) subscribeRestock(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	sub, err := productFavoriteSrv.Subscribe(us.GetID(), id)
+	if err != nil {
+		return
+	}
+	c.Created(sub)
+	return
+}
+
+// unsubscribeRestock cancel the restock notification
+func (productFavoriteCtrl) unsubscribeRestock(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = productFavoriteSrv.Unsubscribe(us.GetID(), id)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
@@ -58,7 +58,7 @@ func init() {
 	g.POST(
 		"/v1/import/{category}",
 		loadUserSession,
-		shouldBeSu,
+		requirePermission(cs.PermissionRegionImport),
 		ctrl.importFromFile,
 	)
 
@@ -74,7 +74,7 @@ func init() {
 		"/v1/{id}",
 		loadUserSession,
 		newTracker(cs.ActionRegionUpdate),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionRegionUpdate),
 		ctrl.updateByID,
 	)
 }
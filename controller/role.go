@@ -0,0 +1,204 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	roleCtrl struct{}
+
+	addRoleParams struct {
+		Name        string   `json:"name,omitempty" validate:"xRoleName"`
+		Description string   `json:"description,omitempty" validate:"xRoleDescription"`
+		Category    string   `json:"category,omitempty" validate:"xRoleCategory"`
+		Status      int      `json:"status,omitempty" validate:"xStatus"`
+		Permissions []string `json:"permissions,omitempty" validate:"xRolePermissions"`
+	}
+	updateRoleParams struct {
+		Description string   `json:"description,omitempty" validate:"omitempty,xRoleDescription"`
+		Status      int      `json:"status,omitempty" validate:"omitempty,xStatus"`
+		Permissions []string `json:"permissions" validate:"omitempty,xRolePermissions"`
+	}
+	listRoleParams struct {
+		listParams
+
+		Status string `json:"status,omitempty" validate:"omitempty,xStatus"`
+	}
+)
+
+func init() {
+	ctrl := roleCtrl{}
+	g := router.NewGroup("/roles", loadUserSession)
+
+	// 角色列表
+	g.GET(
+		"/v1",
+		requirePermission(cs.PermissionRoleRead),
+		ctrl.list,
+	)
+	// 权限列表
+	g.GET(
+		"/v1/permissions",
+		requirePermission(cs.PermissionRoleRead),
+		ctrl.listPermission,
+	)
+	// 获取角色信息
+	g.GET(
+		"/v1/{id}",
+		requirePermission(cs.PermissionRoleRead),
+		ctrl.findByID,
+	)
+	// 添加角色
+	g.POST(
+		"/v1",
+		newTracker(cs.ActionRoleAdd),
+		requirePermission(cs.PermissionRoleUpdate),
+		shouldHave2FA,
+		ctrl.add,
+	)
+	// 更新角色权限
+	g.PATCH(
+		"/v1/{id}",
+		newTracker(cs.ActionRoleUpdate),
+		requirePermission(cs.PermissionRoleUpdate),
+		shouldHave2FA,
+		ctrl.updateByID,
+	)
+
+	ug := router.NewGroup("/users", loadUserSession)
+	// 我的权限列表
+	ug.GET(
+		"/v1/me/permissions",
+		shouldBeLoginedAllowAPIToken,
+		ctrl.listMyPermission,
+	)
+}
+
+func (params listRoleParams) toConditions() []interface{} {
+	conds := queryConditions{}
+	if params.Status != "" {
+		conds.add("status = ?", params.Status)
+	}
+	return conds.toArray()
+}
+
+// list list role
+func (ctrl roleCtrl) list(c *elton.Context) (err error) {
+	params := listRoleParams{}
+	err = validate.Do(&params, c.Query())
+	if err != nil {
+		return
+	}
+	result, err := roleSrv.List(params.toPGQueryParams(), params.toConditions()...)
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Roles service.Roles `json:"roles"`
+	}{
+		result,
+	}
+	return
+}
+
+// listPermission list all permissions
+func (ctrl roleCtrl) listPermission(c *elton.Context) (err error) {
+	c.CacheMaxAge("5m")
+	c.Body = &struct {
+		Permissions []*service.Permission `json:"permissions"`
+	}{
+		roleSrv.ListPermission(),
+	}
+	return
+}
+
+// findByID find role by id
+func (ctrl roleCtrl) findByID(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	data, err := roleSrv.FindByID(id)
+	if err != nil {
+		return
+	}
+	c.Body = data
+	return
+}
+
+// add add role
+func (ctrl roleCtrl) add(c *elton.Context) (err error) {
+	params := addRoleParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	role, err := roleSrv.Add(service.Role{
+		Name:        params.Name,
+		Description: params.Description,
+		Category:    params.Category,
+		Status:      params.Status,
+		Permissions: params.Permissions,
+	})
+	if err != nil {
+		return
+	}
+	c.Created(role)
+	return
+}
+
+// updateByID update role by id
+func (ctrl roleCtrl) updateByID(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	params := updateRoleParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = roleSrv.UpdateByID(id, service.Role{
+		Description: params.Description,
+		Status:      params.Status,
+		Permissions: params.Permissions,
+	})
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// listMyPermission list the permissions of current user
+func (ctrl roleCtrl) listMyPermission(c *elton.Context) (err error) {
+	permissions, err := getUserSession(c).GetPermissions()
+	if err != nil {
+		return
+	}
+	c.NoCache()
+	c.Body = &struct {
+		Permissions []string `json:"permissions"`
+	}{
+		permissions,
+	}
+	return
+}
@@ -56,14 +56,14 @@ func init() {
 	g.GET(
 		"/v1",
 		loadUserSession,
-		checkMarketingGroup,
+		requirePermission(cs.PermissionSupplierRead),
 		ctrl.list,
 	)
 	g.POST(
 		"/v1",
 		loadUserSession,
 		newTracker(cs.ActionSupplierAdd),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionSupplierUpdate),
 		ctrl.add,
 	)
 
@@ -71,15 +71,16 @@ func init() {
 		"/v1/{id}",
 		loadUserSession,
 		newTracker(cs.ActionSupplierUpdate),
-		checkMarketingGroup,
+		requirePermission(cs.PermissionSupplierUpdate),
 		ctrl.updateByID,
 	)
 	g.GET(
 		"/v1/{id}",
 		loadUserSession,
-		checkMarketingGroup,
+		requirePermission(cs.PermissionSupplierRead),
 		ctrl.findByID,
 	)
+	supplierHistoryCtrl.addRouters(g, "/v1")
 }
 
 func (params listSupplierParams) toConditions() []interface{} {
@@ -112,6 +113,10 @@ func (supplierCtrl) add(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
+	err = supplierHistoryCtrl.recordCreate(c, supplier.ID)
+	if err != nil {
+		return
+	}
 	c.Created(supplier)
 	return
 }
@@ -127,13 +132,15 @@ func (supplierCtrl) updateByID(c *elton.Context) (err error) {
 	if err != nil {
 		return
 	}
-	err = supplierSrv.UpdateByID(id, service.Supplier{
-		Name:        params.Name,
-		BaseAddress: params.BaseAddress,
-		Address:     params.Address,
-		Mobile:      params.Mobile,
-		Contact:     params.Contact,
-		Status:      params.Status,
+	err = supplierHistoryCtrl.track(c, id, func() error {
+		return supplierSrv.UpdateByID(id, service.Supplier{
+			Name:        params.Name,
+			BaseAddress: params.BaseAddress,
+			Address:     params.Address,
+			Mobile:      params.Mobile,
+			Contact:     params.Contact,
+			Status:      params.Status,
+		})
 	})
 	if err != nil {
 		return
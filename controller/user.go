@@ -15,6 +15,7 @@
 package controller
 
 import (
+	"encoding/json"
 	"net/http"
 	"strings"
 	"time"
@@ -126,6 +127,16 @@ type (
 		Password    string `json:"password,omitempty" validate:"omitempty,xUserPassword"`
 		NewPassword string `json:"newPassword,omitempty" validate:"omitempty,xUserPassword"`
 	}
+	sendPasswordResetCodeParams struct {
+		Account string `json:"account,omitempty" validate:"xUserAccount"`
+		// 验证码发送方式：email或sms
+		Channel string `json:"channel,omitempty" validate:"xUserPasswordResetChannel"`
+	}
+	resetPasswordParams struct {
+		Account  string `json:"account,omitempty" validate:"xUserAccount"`
+		Code     string `json:"code,omitempty" validate:"xUserPasswordResetCode"`
+		Password string `json:"password,omitempty" validate:"xUserPassword"`
+	}
 	listUserLoginRecordParams struct {
 		listParams
 
@@ -171,21 +182,22 @@ func init() {
 	// 获取用户列表
 	g.GET(
 		"/v1",
-		shouldBeAdmin,
+		requirePermission(cs.PermissionUserRead),
 		ctrl.list,
 	)
 
 	// 获取用户信息
 	g.GET(
 		"/v1/{id}",
-		shouldBeAdmin,
+		requirePermission(cs.PermissionUserRead),
 		ctrl.findByID,
 	)
 	// 更新用户信息
 	g.PATCH(
 		"/v1/{id}",
 		newTracker(cs.ActionUserInfoUpdate),
-		shouldBeAdmin,
+		requirePermission(cs.PermissionUserUpdate),
+		shouldHave2FA,
 		ctrl.updateByID,
 	)
 
@@ -206,6 +218,7 @@ func init() {
 	g.PATCH(
 		"/v1/me",
 		newTracker(cs.ActionUserMeUpdate),
+		shouldNotUseAPIToken,
 		ctrl.updateMe,
 	)
 
@@ -236,11 +249,29 @@ func init() {
 		}),
 		ctrl.login,
 	)
+	// 发送重置密码验证码
+	g.POST(
+		"/v1/password-reset-codes",
+		newTracker(cs.ActionPasswordResetCodeSend),
+		captchaValidate,
+		// 限制相同IP在60秒之内只能调用5次
+		newIPLimit(5, 60*time.Second, cs.ActionPasswordResetCodeSend),
+		ctrl.sendPasswordResetCode,
+	)
+	// 通过验证码重置密码
+	g.POST(
+		"/v1/password-reset",
+		newTracker(cs.ActionPasswordReset),
+		// 限制相同IP在60秒之内只能调用10次
+		newIPLimit(10, 60*time.Second, cs.ActionPasswordReset),
+		ctrl.resetPassword,
+	)
 	// 用户退出登录
 	g.DELETE(
 		"/v1/me",
 		newTracker(cs.ActionLogout),
 		shouldBeLogined,
+		shouldNotUseAPIToken,
 		ctrl.logout,
 	)
 
@@ -254,7 +285,7 @@ func init() {
 	// 获取客户登录记录
 	g.GET(
 		"/v1/login-records",
-		shouldBeAdmin,
+		requirePermission(cs.PermissionUserRead),
 		ctrl.listLoginRecord,
 	)
 
@@ -327,7 +358,7 @@ func pickUserInfo(c *elton.Context) (userInfo *userInfoResp, err error) {
 	userInfo = &userInfoResp{
 		Anonymous: true,
 		Date:      now(),
-		IP:        c.RealIP(),
+		IP:        util.GetClientIP(c.Request),
 		TrackID:   getTrackID(c),
 	}
 	if us.IsLogined() {
@@ -372,7 +403,7 @@ func (ctrl userCtrl) me(c *elton.Context) (err error) {
 		})
 		trackRecord := &service.UserTrackRecord{
 			UserAgent: c.GetRequestHeader("User-Agent"),
-			IP:        c.RealIP(),
+			IP:        util.GetClientIP(c.Request),
 			TrackID:   util.GetTrackID(c),
 		}
 		_ = userSrv.AddTrackRecord(trackRecord, c)
@@ -538,23 +569,94 @@ func (ctrl userCtrl) login(c *elton.Context) (err error) {
 		err = errLoginTokenNil
 		return
 	}
+	ip := util.GetClientIP(c.Request)
 	u, err := userSrv.Login(params.Account, params.Password, token)
 	if err != nil {
+		// 记录失败次数，过多则锁定账户或屏蔽IP
+		userSrv.AddLoginFailure(params.Account, ip, err)
 		return
 	}
 	if u.Status != cs.StatusEnabled {
 		err = errUserStatusInvalid
 		return
 	}
-	deviceInfo := params.Device
-	ip := c.RealIP()
+	err = userSrv.ClearLoginFailure(u.Account)
+	if err != nil {
+		return
+	}
+	resp, err := ctrl.guardLogin(c, u, params.Device)
+	if err != nil {
+		return
+	}
+	if resp != nil {
+		c.Body = resp
+		return
+	}
+	return ctrl.finishLogin(c, u, params.Device, false)
+}
+
+// guardLogin assess the login risk of user, the pending login is created
+// if two factor or step-up verification is required, and the response is returned
+func (ctrl userCtrl) guardLogin(c *elton.Context, u *service.User, deviceInfo deviceInfoParams) (resp *loginTwoFactorResp, err error) {
+	ip := util.GetClientIP(c.Request)
+	userAgent := c.GetRequestHeader("User-Agent")
+	risk, err := userSrv.AssessLogin(u.Account, ip, deviceInfo.UUID, userAgent, c)
+	if err != nil {
+		return
+	}
+	// 启用两步验证的账户，需要再校验验证码才完成登录
+	enabled, err := userSrv.IsTOTPEnabled(u.ID)
+	if err != nil {
+		return
+	}
+	// 未启用两步验证的账户异地或新设备登录，需要校验邮箱验证码
+	stepUp := !enabled &&
+		risk.IsSuspicious() &&
+		u.Email != "" &&
+		service.GetLoginGuardConfig().StepUp
+	if !stepUp {
+		userSrv.NotifySuspiciousLogin(u, ip, userAgent, risk)
+	}
+	if enabled || stepUp {
+		extra, e := json.Marshal(&deviceInfo)
+		if e != nil {
+			err = e
+			return
+		}
+		err = getUserSession(c).SetPendingLogin(service.UserPendingLogin{
+			ID:      u.ID,
+			Account: u.Account,
+			StepUp:  stepUp,
+			Extra:   extra,
+		})
+		if err != nil {
+			return
+		}
+		if stepUp {
+			err = userSrv.SendLoginStepUpCode(u, ip, risk)
+			if err != nil {
+				return
+			}
+		}
+		resp = &loginTwoFactorResp{
+			TwoFactorRequired: enabled,
+			StepUpRequired:    stepUp,
+		}
+	}
+	return
+}
+
+// finishLogin add login record and set user session info
+func (ctrl userCtrl) finishLogin(c *elton.Context, u *service.User, deviceInfo deviceInfoParams, twoFactorVerified bool) (err error) {
+	us := getUserSession(c)
+	ip := util.GetClientIP(c.Request)
 	trackID := util.GetTrackID(c)
 	sessionID := util.GetSessionID(c)
 	userAgent := c.GetRequestHeader("User-Agent")
 	loginRecord := &service.UserLoginRecord{
-		Account:       params.Account,
+		Account:       u.Account,
 		UserAgent:     userAgent,
-		IP:            c.RealIP(),
+		IP:            ip,
 		TrackID:       trackID,
 		SessionID:     sessionID,
 		XForwardedFor: c.GetRequestHeader("X-Forwarded-For"),
@@ -571,7 +673,7 @@ func (ctrl userCtrl) login(c *elton.Context) (err error) {
 	}
 	// 记录用户登录行为
 	getInfluxSrv().Write(cs.MeasurementUserLogin, map[string]interface{}{
-		"account":    params.Account,
+		"account":    u.Account,
 		"userAgent":  userAgent,
 		"ip":         ip,
 		"trackID":    trackID,
@@ -590,6 +692,9 @@ func (ctrl userCtrl) login(c *elton.Context) (err error) {
 	_ = userSrv.AddLoginRecord(loginRecord, c)
 	omitUserInfo(u)
 	_ = us.SetInfo(*u)
+	if twoFactorVerified {
+		_ = us.SetTwoFactorVerified()
+	}
 	c.Body = u
 	return
 }
@@ -656,27 +761,26 @@ func (ctrl userCtrl) updateMe(c *elton.Context) (err error) {
 	isUpdatedPassword := params.NewPassword != ""
 	// 如果要更新密码，先校验旧密码是否一致
 	if isUpdatedPassword {
-		user, e := userSrv.FindOneByAccount(account)
-		if e != nil {
-			err = e
-			return
-		}
-		// 如果密码不一致
-		if user.Password != params.Password {
-			err = hes.New("password is incorrect")
+		err = userSrv.CheckPassword(account, params.Password)
+		if err != nil {
 			return
 		}
 	}
 
 	err = userSrv.UpdateByAccount(account, &service.User{
-		Name:     params.Name,
-		Email:    params.Email,
-		Mobile:   params.Mobile,
-		Password: params.NewPassword,
+		Name:   params.Name,
+		Email:  params.Email,
+		Mobile: params.Mobile,
 	})
 	if err != nil {
 		return
 	}
+	if isUpdatedPassword {
+		err = userSrv.UpdatePassword(account, params.NewPassword)
+		if err != nil {
+			return
+		}
+	}
 	c.NoContent()
 	return
 }
@@ -744,6 +848,43 @@ func (ctrl userCtrl) updateByID(c *elton.Context) (err error) {
 			return
 		}
 	}
+	// 角色与分组需为已启用的角色权限
+	err = roleSrv.ValidateNames(cs.RoleCategoryRole, params.Roles)
+	if err != nil {
+		return
+	}
+	err = roleSrv.ValidateNames(cs.RoleCategoryGroup, params.Groups)
+	if err != nil {
+		return
+	}
+	// 新增的角色与分组，其权限不可超出操作者的权限
+	if len(params.Roles) != 0 || len(params.Groups) != 0 {
+		current, e := userSrv.FindByID(id)
+		if e != nil {
+			err = e
+			return
+		}
+		added := make([]string, 0)
+		for _, role := range params.Roles {
+			if !util.ContainsString(current.Roles, role) {
+				added = append(added, role)
+			}
+		}
+		for _, group := range params.Groups {
+			if !util.ContainsString(current.Groups, group) {
+				added = append(added, group)
+			}
+		}
+		permissions, e := getUserSession(c).GetPermissions()
+		if e != nil {
+			err = e
+			return
+		}
+		err = roleSrv.ValidateGrant(permissions, added...)
+		if err != nil {
+			return
+		}
+	}
 	user := service.User{}
 	if params.Status != 0 {
 		user.Status = params.Status
@@ -796,18 +937,26 @@ func (ctrl userCtrl) listLoginRecord(c *elton.Context) (err error) {
 
 // listRoles list user roles
 func (ctrl userCtrl) listRole(c *elton.Context) (err error) {
+	roles, err := userSrv.ListRole()
+	if err != nil {
+		return
+	}
 	c.CacheMaxAge("5m")
 	c.Body = map[string][]*service.UserRole{
-		"roles": userSrv.ListRole(),
+		"roles": roles,
 	}
 	return
 }
 
 // listGroups list user group
 func (ctrl userCtrl) listGroup(c *elton.Context) (err error) {
+	groups, err := userSrv.ListGroup()
+	if err != nil {
+		return
+	}
 	c.CacheMaxAge("5m")
 	c.Body = map[string][]*service.UserGroup{
-		"groups": userSrv.ListGroup(),
+		"groups": groups,
 	}
 	return
 }
@@ -830,3 +979,41 @@ func (userCtrl) listMarketingGroup(c *elton.Context) (err error) {
 	}
 	return
 }
+
+// sendPasswordResetCode send password reset code to email or mobile
+func (ctrl userCtrl) sendPasswordResetCode(c *elton.Context) (err error) {
+	params := sendPasswordResetCodeParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = userSrv.SendPasswordResetCode(params.Account, params.Channel)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// resetPassword reset password by code
+func (ctrl userCtrl) resetPassword(c *elton.Context) (err error) {
+	params := resetPasswordParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	err = userSrv.ResetPassword(params.Account, params.Code, params.Password)
+	if err != nil {
+		return
+	}
+	// 当前session也需要重新登录
+	us := getUserSession(c)
+	if us != nil && us.IsLogined() {
+		err = us.Destroy()
+		if err != nil {
+			return
+		}
+	}
+	c.NoContent()
+	return
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"strings"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/util"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	userAPITokenCtrl struct{}
+
+	addUserAPITokenParams struct {
+		Name        string     `json:"name,omitempty" validate:"xUserAPITokenName"`
+		Permissions []string   `json:"permissions,omitempty" validate:"required,xRolePermissions"`
+		ExpiredAt   *time.Time `json:"expiredAt,omitempty" validate:"required"`
+	}
+)
+
+const (
+	bearerPrefix = "Bearer "
+)
+
+var (
+	// loadAPITokenSession 使用Authorization: Bearer token时，校验token并生成用户session，
+	// 每次使用均记录tracker
+	loadAPITokenSession = elton.Compose(
+		newTracker(cs.ActionAPITokenUse),
+		verifyAPIToken,
+	)
+)
+
+func init() {
+	g := router.NewGroup("/users", loadUserSession)
+	ctrl := userAPITokenCtrl{}
+
+	// 我的API token列表
+	g.GET(
+		"/v1/me/api-tokens",
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.list,
+	)
+	// 添加API token
+	g.POST(
+		"/v1/me/api-tokens",
+		newTracker(cs.ActionAPITokenAdd),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.add,
+	)
+	// 删除API token
+	g.DELETE(
+		"/v1/me/api-tokens/{id}",
+		newTracker(cs.ActionAPITokenDelete),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.delete,
+	)
+}
+
+// getBearerToken get the bearer token from authorization header
+func getBearerToken(c *elton.Context) string {
+	value := c.GetRequestHeader("Authorization")
+	if !strings.HasPrefix(value, bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(value[len(bearerPrefix):])
+}
+
+// loadSession load the user session from api token or cookie
+func loadSession(c *elton.Context) (err error) {
+	if getBearerToken(c) == "" {
+		return c.Next()
+	}
+	return loadAPITokenSession(c)
+}
+
+// verifyAPIToken verify the api token and create the user session of it
+func verifyAPIToken(c *elton.Context) (err error) {
+	t, u, err := userSrv.VerifyAPIToken(getBearerToken(c), util.GetClientIP(c.Request))
+	if err != nil {
+		return
+	}
+	_, err = service.NewAPITokenUserSession(c, t, u)
+	if err != nil {
+		return
+	}
+	return c.Next()
+}
+
+// shouldNotUseAPIToken 不允许通过API token使用的功能（如管理API token）
+func shouldNotUseAPIToken(c *elton.Context) (err error) {
+	if getUserSession(c).IsAPIToken() {
+		err = errForbidden
+		return
+	}
+	return c.Next()
+}
+
+// list list my api tokens
+func (ctrl userAPITokenCtrl) list(c *elton.Context) (err error) {
+	tokens, err := userSrv.ListAPIToken(getUserSession(c).GetID())
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		APITokens service.UserAPITokens `json:"apiTokens"`
+	}{
+		tokens,
+	}
+	return
+}
+
+// add add api token, the token is only returned once
+func (ctrl userAPITokenCtrl) add(c *elton.Context) (err error) {
+	params := addUserAPITokenParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	permissions, err := us.GetPermissions()
+	if err != nil {
+		return
+	}
+	token, result, err := userSrv.AddAPIToken(us.GetID(), service.UserAPIToken{
+		Name:        params.Name,
+		Permissions: params.Permissions,
+		ExpiredAt:   params.ExpiredAt,
+	}, permissions)
+	if err != nil {
+		return
+	}
+	c.NoCache()
+	c.Created(&struct {
+		*service.UserAPIToken
+		Token string `json:"token"`
+	}{
+		result,
+		token,
+	})
+	return
+}
+
+// delete delete my api token
+func (ctrl userAPITokenCtrl) delete(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	err = userSrv.DeleteAPIToken(getUserSession(c).GetID(), id)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	userLoginGuardCtrl struct{}
+
+	userLoginStepUpParams struct {
+		Code string `json:"code,omitempty" validate:"xUserLoginStepUpCode"`
+	}
+)
+
+var (
+	errLoginStepUpPendingNil = &hes.Error{
+		Message:    "登录验证已超时，请重新登录",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+func init() {
+	g := router.NewGroup("/users", loadUserSession)
+	ctrl := userLoginGuardCtrl{}
+
+	// 异地或新设备登录时的邮箱验证码校验
+	g.POST(
+		"/v1/me/login/step-up",
+		newTracker(cs.ActionLoginStepUp),
+		shouldBeAnonymous,
+		// 限制10分钟内，相同的账号只允许出错5次
+		newErrorLimit(5, 10*time.Minute, func(c *elton.Context) string {
+			pending := getUserSession(c).GetPendingLogin()
+			if pending == nil {
+				return "step-up"
+			}
+			return "step-up-" + pending.Account
+		}),
+		ctrl.login,
+	)
+
+	// 解锁因登录失败过多被锁定的用户
+	g.DELETE(
+		"/v1/{id}/lock",
+		newTracker(cs.ActionUserUnlock),
+		requirePermission(cs.PermissionUserUpdate),
+		shouldHave2FA,
+		ctrl.unlock,
+	)
+}
+
+// login verify the step up code and finish the login
+func (ctrl userLoginGuardCtrl) login(c *elton.Context) (err error) {
+	params := userLoginStepUpParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	pending := us.GetPendingLogin()
+	if pending == nil || !pending.StepUp {
+		err = errLoginStepUpPendingNil
+		return
+	}
+	err = userSrv.VerifyLoginStepUpCode(pending.ID, params.Code)
+	if err != nil {
+		return
+	}
+	err = us.ClearPendingLogin()
+	if err != nil {
+		return
+	}
+	u, err := userSrv.FindByID(pending.ID)
+	if err != nil {
+		return
+	}
+	if u.Status != cs.StatusEnabled {
+		err = errUserStatusInvalid
+		return
+	}
+	deviceInfo := deviceInfoParams{}
+	if len(pending.Extra) != 0 {
+		_ = json.Unmarshal(pending.Extra, &deviceInfo)
+	}
+	return userCtrl{}.finishLogin(c, u, deviceInfo, false)
+}
+
+// unlock unlock the user
+func (ctrl userLoginGuardCtrl) unlock(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	err = userSrv.Unlock(id)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	userOIDCCtrl struct{}
+
+	oidcAuthorizationParams struct {
+		Provider string `json:"provider,omitempty" validate:"xOIDCProvider"`
+		// 推荐人，自动注册时使用
+		Recommender string `json:"recommender,omitempty" validate:"omitempty,xUserAccount"`
+	}
+	oidcCallbackParams struct {
+		Provider string `json:"provider,omitempty" validate:"xOIDCProvider"`
+		Code     string `json:"code,omitempty" validate:"omitempty,xOIDCCode"`
+		State    string `json:"state,omitempty" validate:"xOIDCState"`
+		// provider返回的出错信息
+		Error            string `json:"error,omitempty"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+)
+
+func init() {
+	g := router.NewGroup("/users", loadUserSession)
+	ctrl := userOIDCCtrl{}
+
+	// 第三方登录列表
+	g.GET(
+		"/v1/oidc/providers",
+		ctrl.listProvider,
+	)
+	// 跳转至第三方授权（已登录则为关联第三方账户）
+	g.GET(
+		"/v1/oidc/{provider}/authorization",
+		ctrl.authorize,
+	)
+	// 第三方授权回调
+	g.GET(
+		"/v1/oidc/{provider}/callback",
+		newTracker(cs.ActionOIDCLogin),
+		ctrl.callback,
+	)
+
+	// 我关联的第三方账户
+	g.GET(
+		"/v1/me/identities",
+		shouldBeLogined,
+		ctrl.listMyIdentity,
+	)
+	// 取消关联第三方账户
+	g.DELETE(
+		"/v1/me/identities/{id}",
+		newTracker(cs.ActionUserIdentityDelete),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.deleteMyIdentity,
+	)
+}
+
+// redirect redirect to the url without committing the response,
+// so the session cookie can be set
+func redirect(c *elton.Context, url string) {
+	c.NoContent()
+	c.NoCache()
+	c.StatusCode = http.StatusFound
+	c.SetHeader(elton.HeaderLocation, url)
+}
+
+// listProvider list the oidc providers
+func (ctrl userOIDCCtrl) listProvider(c *elton.Context) (err error) {
+	c.CacheMaxAge("5m")
+	c.Body = &struct {
+		Providers []string `json:"providers"`
+	}{
+		userSrv.ListOIDCProvider(),
+	}
+	return
+}
+
+// authorize redirect to the authorization endpoint of provider
+func (ctrl userOIDCCtrl) authorize(c *elton.Context) (err error) {
+	params := oidcAuthorizationParams{}
+	query := c.Query()
+	query["provider"] = c.Param("provider")
+	err = validate.Do(&params, query)
+	if err != nil {
+		return
+	}
+	state, err := userSrv.NewOIDCAuthState(params.Provider)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	if us.IsLogined() {
+		if us.IsAPIToken() {
+			err = errForbidden
+			return
+		}
+		state.UserID = us.GetID()
+	} else if params.Recommender != "" {
+		rcmder, _ := userSrv.FindOneByAccount(params.Recommender)
+		if rcmder.ID == 0 {
+			err = errUserRcmderNotExists
+			return
+		}
+		state.Recommender = rcmder.ID
+	}
+	authURL, err := userSrv.GetOIDCAuthURL(state)
+	if err != nil {
+		return
+	}
+	err = us.SetOIDCAuthState(state)
+	if err != nil {
+		return
+	}
+	redirect(c, authURL)
+	return
+}
+
+// callback verify the code of provider and login
+func (ctrl userOIDCCtrl) callback(c *elton.Context) (err error) {
+	params := oidcCallbackParams{}
+	query := c.Query()
+	query["provider"] = c.Param("provider")
+	err = validate.Do(&params, query)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	state, err := us.TakeOIDCAuthState(params.Provider, params.State)
+	if err != nil {
+		return
+	}
+	if params.Error != "" || params.Code == "" {
+		err = &hes.Error{
+			Message:    strings.TrimSpace("第三方授权失败：" + params.Error + " " + params.ErrorDescription),
+			StatusCode: http.StatusBadRequest,
+			Category:   errUserCategory,
+		}
+		return
+	}
+	u, err := userSrv.OIDCLogin(state, params.Code)
+	if err != nil {
+		return
+	}
+	redirectURL := service.GetOIDCRedirectURL()
+	// 关联第三方账户
+	if state.UserID != 0 {
+		redirect(c, redirectURL)
+		return
+	}
+	if u.Status != cs.StatusEnabled {
+		err = errUserStatusInvalid
+		return
+	}
+	// 与账户密码登录一致，校验登录风险，需要两步验证或邮箱验证码时再完成登录
+	resp, err := userCtrl{}.guardLogin(c, u, deviceInfoParams{})
+	if err != nil {
+		return
+	}
+	if resp != nil {
+		if resp.TwoFactorRequired {
+			redirectURL = appendQuery(redirectURL, "twoFactorRequired", "true")
+		}
+		if resp.StepUpRequired {
+			redirectURL = appendQuery(redirectURL, "stepUpRequired", "true")
+		}
+		redirect(c, redirectURL)
+		return
+	}
+	err = userCtrl{}.finishLogin(c, u, deviceInfoParams{}, false)
+	if err != nil {
+		return
+	}
+	redirect(c, redirectURL)
+	return
+}
+
+// appendQuery append the query to url
+func appendQuery(rawURL, key, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// listMyIdentity list my identities of oidc providers
+func (ctrl userOIDCCtrl) listMyIdentity(c *elton.Context) (err error) {
+	identities, err := userSrv.ListIdentity(getUserSession(c).GetID())
+	if err != nil {
+		return
+	}
+	c.Body = &struct {
+		Identities service.UserIdentities `json:"identities"`
+	}{
+		identities,
+	}
+	return
+}
+
+// deleteMyIdentity delete my identity of oidc provider
+func (ctrl userOIDCCtrl) deleteMyIdentity(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	err = userSrv.DeleteIdentity(getUserSession(c).GetID(), id)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
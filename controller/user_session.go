@@ -0,0 +1,147 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/vicanso/elton"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+)
+
+type (
+	userSessionCtrl struct{}
+
+	listUserSessionResp struct {
+		Sessions service.UserSessionDetails `json:"sessions"`
+	}
+)
+
+func init() {
+	g := router.NewGroup("/users", loadUserSession)
+	ctrl := userSessionCtrl{}
+
+	// 我的登录session列表
+	g.GET(
+		"/v1/me/sessions",
+		shouldBeLogined,
+		ctrl.listMine,
+	)
+	// 注销我的其它session
+	g.DELETE(
+		"/v1/me/sessions",
+		newTracker(cs.ActionUserSessionRevoke),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.revokeMyOthers,
+	)
+	// 注销我的指定session
+	g.DELETE(
+		"/v1/me/sessions/{sessionID}",
+		newTracker(cs.ActionUserSessionRevoke),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.revokeMine,
+	)
+
+	// 用户的登录session列表
+	g.GET(
+		"/v1/{id}/sessions",
+		requirePermission(cs.PermissionUserRead),
+		ctrl.list,
+	)
+	// 注销用户的所有session
+	g.DELETE(
+		"/v1/{id}/sessions",
+		newTracker(cs.ActionUserSessionRevoke),
+		requirePermission(cs.PermissionUserUpdate),
+		shouldHave2FA,
+		ctrl.revokeAll,
+	)
+}
+
+// listMine list my sessions
+func (ctrl userSessionCtrl) listMine(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	sessions, err := userSrv.ListSessions(us.GetID())
+	if err != nil {
+		return
+	}
+	current := us.GetSessionID()
+	for _, item := range sessions {
+		item.Current = item.ID == current
+	}
+	c.Body = &listUserSessionResp{
+		Sessions: sessions,
+	}
+	return
+}
+
+// revokeMyOthers revoke my sessions except current session
+func (ctrl userSessionCtrl) revokeMyOthers(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	err = userSrv.RevokeOtherSessions(us.GetID(), us.GetSessionID())
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// revokeMine revoke my session
+func (ctrl userSessionCtrl) revokeMine(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	sessionID := c.Param("sessionID")
+	if sessionID == us.GetSessionID() {
+		err = us.Destroy()
+	} else {
+		err = userSrv.RevokeSession(us.GetID(), sessionID)
+	}
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
+
+// list list the sessions of user
+func (ctrl userSessionCtrl) list(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	sessions, err := userSrv.ListSessions(id)
+	if err != nil {
+		return
+	}
+	c.Body = &listUserSessionResp{
+		Sessions: sessions,
+	}
+	return
+}
+
+// revokeAll revoke all sessions of user
+func (ctrl userSessionCtrl) revokeAll(c *elton.Context) (err error) {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return
+	}
+	err = userSrv.RevokeSessions(id)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
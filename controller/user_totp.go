@@ -0,0 +1,251 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/router"
+	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/validate"
+)
+
+type (
+	userTOTPCtrl struct{}
+
+	loginTwoFactorResp struct {
+		// 需要两步验证
+		TwoFactorRequired bool `json:"twoFactorRequired,omitempty"`
+		// 异地或新设备登录，需要邮箱验证码校验
+		StepUpRequired bool `json:"stepUpRequired,omitempty"`
+	}
+	userTOTPStatusResp struct {
+		Enabled bool `json:"enabled"`
+		// 所属角色或分组是否要求启用两步验证
+		Required bool `json:"required"`
+		// 当前session是否已通过两步验证
+		Verified bool `json:"verified"`
+	}
+
+	userTOTPCodeParams struct {
+		// 验证码或恢复码
+		Code string `json:"code,omitempty" validate:"xUserTOTPCode"`
+	}
+)
+
+var (
+	errLoginTwoFactorPendingNil = &hes.Error{
+		Message:    "两步验证已超时，请重新登录",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errTwoFactorNotEnabled = &hes.Error{
+		Message:    "该操作需要先启用两步验证",
+		StatusCode: http.StatusForbidden,
+		Category:   errUserCategory,
+	}
+	errTwoFactorNotVerified = &hes.Error{
+		Message:    "该操作需要通过两步验证，请重新登录",
+		StatusCode: http.StatusForbidden,
+		Category:   errUserCategory,
+	}
+)
+
+func init() {
+	g := router.NewGroup("/users", loadUserSession)
+	ctrl := userTOTPCtrl{}
+
+	// 登录时的两步验证
+	g.POST(
+		"/v1/me/login/2fa",
+		newTracker(cs.ActionLoginTwoFactor),
+		shouldBeAnonymous,
+		// 限制10分钟内，相同的账号只允许出错5次
+		newErrorLimit(5, 10*time.Minute, func(c *elton.Context) string {
+			pending := getUserSession(c).GetPendingLogin()
+			if pending == nil {
+				return "2fa"
+			}
+			return "2fa-" + pending.Account
+		}),
+		ctrl.login,
+	)
+
+	// 两步验证状态
+	g.GET(
+		"/v1/me/2fa",
+		shouldBeLogined,
+		ctrl.getStatus,
+	)
+	// 生成两步验证的密钥（二维码）
+	g.POST(
+		"/v1/me/2fa/enrollment",
+		newTracker(cs.ActionUserTOTPEnroll),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.enroll,
+	)
+	// 启用两步验证
+	g.POST(
+		"/v1/me/2fa",
+		newTracker(cs.ActionUserTOTPEnable),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.enable,
+	)
+	// 关闭两步验证
+	g.PATCH(
+		"/v1/me/2fa/disable",
+		newTracker(cs.ActionUserTOTPDisable),
+		shouldBeLogined,
+		shouldNotUseAPIToken,
+		ctrl.disable,
+	)
+}
+
+// checkTwoFactor check the session is verified by two factor
+// if the roles or groups of user are forced to use two factor
+func checkTwoFactor(c *elton.Context) (err error) {
+	if !isLogined(c) {
+		err = errShouldLogin
+		return
+	}
+	us := getUserSession(c)
+	info := us.MustGetInfo()
+	if info.TwoFactorVerified || !service.IsTwoFactorRequired(info.Roles, info.Groups) {
+		return c.Next()
+	}
+	enabled, err := userSrv.IsTOTPEnabled(info.ID)
+	if err != nil {
+		return
+	}
+	if !enabled {
+		err = errTwoFactorNotEnabled
+		return
+	}
+	err = errTwoFactorNotVerified
+	return
+}
+
+// login verify the code and finish the login
+func (ctrl userTOTPCtrl) login(c *elton.Context) (err error) {
+	params := userTOTPCodeParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	pending := us.GetPendingLogin()
+	if pending == nil {
+		err = errLoginTwoFactorPendingNil
+		return
+	}
+	err = userSrv.VerifyTOTP(pending.ID, params.Code)
+	if err != nil {
+		return
+	}
+	err = us.ClearPendingLogin()
+	if err != nil {
+		return
+	}
+	u, err := userSrv.FindByID(pending.ID)
+	if err != nil {
+		return
+	}
+	if u.Status != cs.StatusEnabled {
+		err = errUserStatusInvalid
+		return
+	}
+	deviceInfo := deviceInfoParams{}
+	if len(pending.Extra) != 0 {
+		_ = json.Unmarshal(pending.Extra, &deviceInfo)
+	}
+	return userCtrl{}.finishLogin(c, u, deviceInfo, true)
+}
+
+// getStatus get the two factor status of user
+func (ctrl userTOTPCtrl) getStatus(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	info := us.MustGetInfo()
+	enabled, err := userSrv.IsTOTPEnabled(info.ID)
+	if err != nil {
+		return
+	}
+	c.Body = &userTOTPStatusResp{
+		Enabled:  enabled,
+		Required: service.IsTwoFactorRequired(info.Roles, info.Groups),
+		Verified: info.TwoFactorVerified,
+	}
+	return
+}
+
+// enroll generate the totp secret
+func (ctrl userTOTPCtrl) enroll(c *elton.Context) (err error) {
+	us := getUserSession(c)
+	enrollment, err := userSrv.EnrollTOTP(us.GetID(), us.GetAccount())
+	if err != nil {
+		return
+	}
+	c.NoCache()
+	c.Created(enrollment)
+	return
+}
+
+// enable enable the totp, the recovery codes are only returned once
+func (ctrl userTOTPCtrl) enable(c *elton.Context) (err error) {
+	params := userTOTPCodeParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	recoveryCodes, err := userSrv.EnableTOTP(us.GetID(), params.Code)
+	if err != nil {
+		return
+	}
+	// 已校验验证码，当前session视为通过两步验证
+	err = us.SetTwoFactorVerified()
+	if err != nil {
+		return
+	}
+	c.NoCache()
+	c.Created(&struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}{
+		recoveryCodes,
+	})
+	return
+}
+
+// disable disable the totp
+func (ctrl userTOTPCtrl) disable(c *elton.Context) (err error) {
+	params := userTOTPCodeParams{}
+	err = validate.Do(&params, c.RequestBody)
+	if err != nil {
+		return
+	}
+	us := getUserSession(c)
+	err = userSrv.DisableTOTP(us.GetID(), params.Code)
+	if err != nil {
+		return
+	}
+	c.NoContent()
+	return
+}
@@ -28,6 +28,20 @@ const (
 	ActionUserMeUpdate = "update-user-me"
 	// ActionUserTrackAdd add user track
 	ActionUserTrackAdd = "add-user-track"
+	// ActionPasswordResetCodeSend send password reset code
+	ActionPasswordResetCodeSend = "send-password-reset-code"
+	// ActionPasswordReset reset password
+	ActionPasswordReset = "reset-password"
+	// ActionLoginTwoFactor verify two factor code of login
+	ActionLoginTwoFactor = "login-two-factor"
+	// ActionUserTOTPEnroll enroll totp
+	ActionUserTOTPEnroll = "enroll-user-totp"
+	// ActionUserTOTPEnable enable totp
+	ActionUserTOTPEnable = "enable-user-totp"
+	// ActionUserTOTPDisable disable totp
+	ActionUserTOTPDisable = "disable-user-totp"
+	// ActionUserSessionRevoke revoke user session
+	ActionUserSessionRevoke = "revoke-user-session"
 
 	// ActionConfigurationAdd add configuration
 	ActionConfigurationAdd = "add-configuration"
@@ -55,6 +69,30 @@ const (
 	ActionProductCategoryAdd = "add-product-category"
 	// ActionProductCategoryUpdate update product category
 	ActionProductCategoryUpdate = "update-product-category"
+	// ActionProductCategoryMove move product category
+	ActionProductCategoryMove = "move-product-category"
+	// ActionCatalogDelete soft delete catalog(product, category, brand, supplier)
+	ActionCatalogDelete = "delete-catalog"
+	// ActionCatalogRestore restore deleted catalog
+	ActionCatalogRestore = "restore-catalog"
+	// ActionProductSKUAdd add product sku
+	ActionProductSKUAdd = "add-product-sku"
+	// ActionProductSKUUpdate update product sku
+	ActionProductSKUUpdate = "update-product-sku"
+	// ActionProductImport import products
+	ActionProductImport = "import-product"
+	// ActionProductFavoriteAdd add product to favorites
+	ActionProductFavoriteAdd = "add-product-favorite"
+	// ActionProductFavoriteRemove remove product from favorites
+	ActionProductFavoriteRemove = "remove-product-favorite"
+	// ActionProductRestockSubscribe subscribe product restock
+	ActionProductRestockSubscribe = "subscribe-product-restock"
+	// ActionProductRestockUnsubscribe unsubscribe product restock
+	ActionProductRestockUnsubscribe = "unsubscribe-product-restock"
+	// ActionProductPriceAdd add product price
+	ActionProductPriceAdd = "add-product-price"
+	// ActionProductPriceCancel cancel product price
+	ActionProductPriceCancel = "cancel-product-price"
 
 	// ActionOrderAdd add order
 	ActionOrderAdd = "add-order"
@@ -91,4 +129,54 @@ const (
 	ActionAdvertisementAdd = "add-advertisement"
 	// ActionAdvertisementUpdate update advertisement
 	ActionAdvertisementUpdate = "update-advertisement"
+
+	// ActionAfterSaleAdd add after sale
+	ActionAfterSaleAdd = "add-after-sale"
+	// ActionAfterSaleCancel cancel after sale
+	ActionAfterSaleCancel = "cancel-after-sale"
+	// ActionAfterSaleApprove approve after sale
+	ActionAfterSaleApprove = "approve-after-sale"
+	// ActionAfterSaleReject reject after sale
+	ActionAfterSaleReject = "reject-after-sale"
+	// ActionAfterSaleReturn return goods of after sale
+	ActionAfterSaleReturn = "return-after-sale"
+	// ActionAfterSaleReceive receive returned goods of after sale
+	ActionAfterSaleReceive = "receive-after-sale"
+	// ActionAfterSaleShipExchange ship exchange goods of after sale
+	ActionAfterSaleShipExchange = "ship-exchange-after-sale"
+	// ActionAfterSaleRefund refund after sale
+	ActionAfterSaleRefund = "refund-after-sale"
+	// ActionAfterSaleFinish finish after sale
+	ActionAfterSaleFinish = "finish-after-sale"
+	// ActionAfterSaleClose close after sale
+	ActionAfterSaleClose = "close-after-sale"
+
+	// ActionCommissionWithdraw apply for commission withdrawal
+	ActionCommissionWithdraw = "withdraw-commission"
+	// ActionCommissionWithdrawalApprove approve commission withdrawal
+	ActionCommissionWithdrawalApprove = "approve-commission-withdrawal"
+	// ActionCommissionWithdrawalReject reject commission withdrawal
+	ActionCommissionWithdrawalReject = "reject-commission-withdrawal"
+
+	// ActionRoleAdd add role
+	ActionRoleAdd = "add-role"
+	// ActionRoleUpdate update role
+	ActionRoleUpdate = "update-role"
+
+	// ActionAPITokenAdd add api token
+	ActionAPITokenAdd = "add-api-token"
+	// ActionAPITokenDelete delete api token
+	ActionAPITokenDelete = "delete-api-token"
+	// ActionAPITokenUse use api token
+	ActionAPITokenUse = "use-api-token"
+
+	// ActionOIDCLogin login by oidc provider
+	ActionOIDCLogin = "oidc-login"
+	// ActionUserIdentityDelete delete the identity of user
+	ActionUserIdentityDelete = "delete-user-identity"
+
+	// ActionLoginStepUp verify the code of login from new device or location
+	ActionLoginStepUp = "login-step-up"
+	// ActionUserUnlock unlock the locked user
+	ActionUserUnlock = "unlock-user"
 )
@@ -86,6 +86,12 @@ var (
 	}
 )
 
+// 角色分类，角色权限对应用户的角色或分组
+const (
+	RoleCategoryRole  = "role"
+	RoleCategoryGroup = "group"
+)
+
 // 地区分类
 const (
 	RegionCountry  = "country"
@@ -122,4 +128,24 @@ var (
 const (
 	// 热门搜索关键字
 	ProductSearchHotKeywords = "product-search-hot-keywords"
+	// 无结果的搜索关键字
+	ProductSearchZeroResultKeywords = "product-search-zero-result-keywords"
+)
+
+// 售后类型
+const (
+	// 退货退款
+	AfterSaleReturn = "return"
+	// 换货
+	AfterSaleExchange = "exchange"
+	// 仅退款
+	AfterSaleRefundOnly = "refund-only"
+)
+
+var (
+	AfterSaleCategories = []string{
+		AfterSaleReturn,
+		AfterSaleExchange,
+		AfterSaleRefundOnly,
+	}
 )
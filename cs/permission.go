@@ -0,0 +1,117 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cs
+
+// 权限，格式为 resource:action
+const (
+	// PermissionAll 所有权限
+	PermissionAll = "*"
+
+	// PermissionUserRead 查询用户信息
+	PermissionUserRead = "user:read"
+	// PermissionUserUpdate 更新用户信息（角色、状态等）
+	PermissionUserUpdate = "user:update"
+
+	// PermissionRoleRead 查询角色权限
+	PermissionRoleRead = "role:read"
+	// PermissionRoleUpdate 编辑角色权限
+	PermissionRoleUpdate = "role:update"
+
+	// PermissionConfigurationRead 查询系统配置
+	PermissionConfigurationRead = "configuration:read"
+	// PermissionConfigurationUpdate 编辑系统配置
+	PermissionConfigurationUpdate = "configuration:update"
+
+	// PermissionProductRead 查询产品后台数据（价格历史、变更历史等）
+	PermissionProductRead = "product:read"
+	// PermissionProductUpdate 编辑产品、分类、SKU与价格
+	PermissionProductUpdate = "product:update"
+	// PermissionProductImport 导入产品
+	PermissionProductImport = "product:import"
+	// PermissionProductExport 导出产品
+	PermissionProductExport = "product:export"
+
+	// PermissionBrandRead 查询品牌后台数据（变更历史等）
+	PermissionBrandRead = "brand:read"
+	// PermissionBrandUpdate 编辑品牌
+	PermissionBrandUpdate = "brand:update"
+
+	// PermissionSupplierRead 查询供应商
+	PermissionSupplierRead = "supplier:read"
+	// PermissionSupplierUpdate 编辑供应商
+	PermissionSupplierUpdate = "supplier:update"
+
+	// PermissionAdvertisementUpdate 编辑广告
+	PermissionAdvertisementUpdate = "advertisement:update"
+
+	// PermissionFileUpload 上传文件
+	PermissionFileUpload = "file:upload"
+
+	// PermissionRegionUpdate 编辑地区
+	PermissionRegionUpdate = "region:update"
+	// PermissionRegionImport 导入地区
+	PermissionRegionImport = "region:import"
+
+	// PermissionOrderRead 查询所有订单
+	PermissionOrderRead = "order:read"
+	// PermissionOrderAssign 指派订单配送员
+	PermissionOrderAssign = "order:assign"
+	// PermissionOrderDeliver 订单配送
+	PermissionOrderDeliver = "order:deliver"
+
+	// PermissionAfterSaleRead 查询所有售后
+	PermissionAfterSaleRead = "afterSale:read"
+	// PermissionAfterSaleHandle 处理售后（审核、收货、换货发货）
+	PermissionAfterSaleHandle = "afterSale:handle"
+	// PermissionAfterSaleRefund 售后退款
+	PermissionAfterSaleRefund = "afterSale:refund"
+
+	// PermissionCommissionRead 查询佣金与提现
+	PermissionCommissionRead = "commission:read"
+	// PermissionCommissionApprove 审核佣金提现
+	PermissionCommissionApprove = "commission:approve"
+)
+
+var (
+	// Permissions 权限列表
+	Permissions = []string{
+		PermissionUserRead,
+		PermissionUserUpdate,
+		PermissionRoleRead,
+		PermissionRoleUpdate,
+		PermissionConfigurationRead,
+		PermissionConfigurationUpdate,
+		PermissionProductRead,
+		PermissionProductUpdate,
+		PermissionProductImport,
+		PermissionProductExport,
+		PermissionBrandRead,
+		PermissionBrandUpdate,
+		PermissionSupplierRead,
+		PermissionSupplierUpdate,
+		PermissionAdvertisementUpdate,
+		PermissionFileUpload,
+		PermissionRegionUpdate,
+		PermissionRegionImport,
+		PermissionOrderRead,
+		PermissionOrderAssign,
+		PermissionOrderDeliver,
+		PermissionAfterSaleRead,
+		PermissionAfterSaleHandle,
+		PermissionAfterSaleRefund,
+		PermissionCommissionRead,
+		PermissionCommissionApprove,
+	}
+)
@@ -15,6 +15,7 @@
 package helper
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -34,6 +35,11 @@ import (
 	gormLogger "gorm.io/gorm/logger"
 )
 
+const (
+	// 唯一约束冲突的错误码
+	pgUniqueViolationCode = "23505"
+)
+
 var (
 	pgClient    *gorm.DB
 	pgStatsHook *pgStats
@@ -369,6 +375,17 @@ func PGCount(model interface{}, args ...interface{}) (count int64, err error) {
 	return
 }
 
+// PGIsUniqueViolation check the error is unique violation of postgres
+func PGIsUniqueViolation(err error) bool {
+	var pgErr interface {
+		SQLState() string
+	}
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.SQLState() == pgUniqueViolationCode
+}
+
 // PGAutoMigrate pg auto migrate
 func PGAutoMigrate(dst ...interface{}) error {
 	if pgConfig.DisableAutoMigrate {
@@ -15,6 +15,8 @@
 package helper
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,3 +47,22 @@ func TestPGQuery(t *testing.T) {
 	db = PGQuery(PGQueryParams{}, "id = ?", 1)
 	assert.NotNil(db)
 }
+
+type pgStateError string
+
+func (e pgStateError) Error() string {
+	return "pg error " + string(e)
+}
+
+func (e pgStateError) SQLState() string {
+	return string(e)
+}
+
+func TestPGIsUniqueViolation(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(PGIsUniqueViolation(pgStateError("23505")))
+	assert.True(PGIsUniqueViolation(fmt.Errorf("create fail: %w", pgStateError("23505"))))
+	assert.False(PGIsUniqueViolation(pgStateError("23503")))
+	assert.False(PGIsUniqueViolation(errors.New("23505")))
+	assert.False(PGIsUniqueViolation(nil))
+}
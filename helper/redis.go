@@ -68,6 +68,9 @@ type (
 	}
 )
 
+// RedisSessionKeyPrefix the key prefix of session
+const RedisSessionKeyPrefix = "ss-"
+
 // 对于慢或出错请求输出日志并写入influxdb
 func (rh *redisHook) logSlowOrError(ctx context.Context, cmd, err string) {
 	t := ctx.Value(startedAtKey).(*time.Time)
@@ -90,6 +90,34 @@ func dependServiceCheck() (err error) {
 	if err != nil {
 		return
 	}
+	invoiceSrv := new(service.InvoiceSrv)
+	err = invoiceSrv.InitBucket()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// 历史数据迁移，可重复执行（已迁移的数据会忽略）
+func dataMigrate() (err error) {
+	// 配置版本上线前的佣金配置生成首个版本
+	configSrv := new(service.ConfigurationSrv)
+	err = configSrv.BackfillVersions()
+	if err != nil {
+		return
+	}
+	// 未生成搜索向量的产品（如新上线时）无法被搜索，启动时生成
+	productSrv := new(service.ProductSrv)
+	err = productSrv.RefreshMissingSearchVector()
+	if err != nil {
+		return
+	}
+	// 佣金账户与流水上线前的佣金记录入账
+	commissionLedgerSrv := new(service.CommissionLedgerSrv)
+	err = commissionLedgerSrv.Backfill()
+	if err != nil {
+		return
+	}
 	return
 }
 
@@ -161,7 +189,7 @@ func main() {
 			}
 			he.Extra["stack"] = util.GetStack(5)
 		}
-		ip := c.RealIP()
+		ip := util.GetClientIP(c.Request)
 		uri := c.Request.RequestURI
 
 		helper.GetInfluxSrv().Write(cs.MeasurementException, map[string]interface{}{
@@ -252,6 +280,15 @@ func main() {
 		panic(err)
 	}
 
+	err = dataMigrate()
+	if err != nil {
+		service.AlarmError("migrate data fail, " + err.Error())
+		logger.DPanic("exception",
+			zap.Error(err),
+		)
+		panic(err)
+	}
+
 	listen := config.GetListen()
 	// http1与http2均支持
 	e.Server = &http.Server{
@@ -24,6 +24,7 @@ import (
 	"github.com/vicanso/hes"
 	"github.com/vicanso/origin/helper"
 	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/util"
 	"github.com/vicanso/tiny/log"
 	"go.uber.org/zap"
 )
@@ -150,7 +151,7 @@ func NewNotFoundHandler() http.HandlerFunc {
 	}
 	notFoundErrBytes := notFoundErr.ToJSON()
 	return func(resp http.ResponseWriter, req *http.Request) {
-		ip := elton.GetClientIP(req)
+		ip := util.GetClientIP(req)
 		logger.Info("404",
 			zap.String("ip", ip),
 			zap.String("method", req.Method),
@@ -180,7 +181,7 @@ func NewMethodNotAllowedHandler() http.HandlerFunc {
 	}
 	methodNotAllowedErrBytes := methodNotAllowedErr.ToJSON()
 	return func(resp http.ResponseWriter, req *http.Request) {
-		ip := elton.GetClientIP(req)
+		ip := util.GetClientIP(req)
 		logger.Info("method not allowed",
 			zap.String("ip", ip),
 			zap.String("method", req.Method),
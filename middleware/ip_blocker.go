@@ -20,6 +20,7 @@ import (
 	"github.com/vicanso/elton"
 	"github.com/vicanso/hes"
 	"github.com/vicanso/origin/service"
+	"github.com/vicanso/origin/util"
 )
 
 var (
@@ -33,7 +34,7 @@ var (
 // NewIPBlocker create a new block ip middleware
 func NewIPBlocker() elton.Handler {
 	return func(c *elton.Context) (err error) {
-		if service.IsBlockIP(c.RealIP()) {
+		if service.IsBlockIP(util.GetClientIP(c.Request)) {
 			err = errIPNotAllow
 			return
 		}
@@ -29,6 +29,8 @@ func TestNewIPBlocker(t *testing.T) {
 
 	fn := NewIPBlocker()
 	req := httptest.NewRequest("GET", "/", nil)
+	// 来自可信代理的请求才使用X-Forwarded-For
+	req.RemoteAddr = "127.0.0.1:1234"
 	req.Header.Set(elton.HeaderXForwardedFor, "1.1.1.1")
 	resp := httptest.NewRecorder()
 	c := elton.NewContext(resp, req)
@@ -23,6 +23,7 @@ import (
 	"github.com/vicanso/hes"
 	"github.com/vicanso/origin/helper"
 	"github.com/vicanso/origin/log"
+	"github.com/vicanso/origin/util"
 	"go.uber.org/zap"
 
 	"github.com/vicanso/elton/middleware"
@@ -93,7 +94,7 @@ func NewConcurrentLimitWithDone(keys []string, ttl time.Duration, prefix string)
 // NewIPLimit create a limit middleware by ip address
 func NewIPLimit(maxCount int64, ttl time.Duration, prefix string) elton.Handler {
 	return func(c *elton.Context) (err error) {
-		key := ipLimitKeyPrefix + "-" + prefix + "-" + c.RealIP()
+		key := ipLimitKeyPrefix + "-" + prefix + "-" + util.GetClientIP(c.Request)
 		count, err := redisSrv.IncWithTTL(key, ttl)
 		if err != nil {
 			return
@@ -29,7 +29,7 @@ func NewSession() elton.Handler {
 		panic("session store need redis client")
 	}
 	store := &helper.RedisSessionStore{
-		Prefix: "ss-",
+		Prefix: helper.RedisSessionKeyPrefix,
 	}
 	scf := config.GetSessionConfig()
 	return session.NewByCookie(session.CookieConfig{
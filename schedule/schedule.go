@@ -33,9 +33,16 @@ func init() {
 	_, _ = c.AddFunc("@every 1m", configRefresh)
 	_, _ = c.AddFunc("@every 5m", redisStats)
 	_, _ = c.AddFunc("@every 1m", pgStats)
-	_, _ = c.AddFunc("00 00 * * *", resetProductSearchHotKeywords)
+	_, _ = c.AddFunc("@every 1h", decayProductSearchKeywords)
+	_, _ = c.AddFunc("@every 1m", applyProductPrice)
+	_, _ = c.AddFunc("@every 5m", notifyProductRestock)
+	_, _ = c.AddFunc("15 * * * *", refreshProductRanking)
 	// 测试暂时每5分钟自动生成
 	_, _ = c.AddFunc("@every 5m", generateOrderCommission)
+	_, _ = c.AddFunc("@every 10m", unfreezeCommission)
+	_, _ = c.AddFunc("@every 5m", retryCommissionReversal)
+	// 品牌、分类名称可能调整，每天重建产品搜索
+	_, _ = c.AddFunc("30 03 * * *", refreshProductSearchVector)
 	go func() {
 		time.Sleep(time.Second)
 		generateOrderCommission()
@@ -74,10 +81,11 @@ func pgStats() {
 	helper.GetInfluxSrv().Write(cs.MeasurementPGStats, stats, nil)
 }
 
-func resetProductSearchHotKeywords() {
-	_, err := helper.RedisGetClient().ZRemRangeByRank(cs.ProductSearchHotKeywords, 0, -1).Result()
+func decayProductSearchKeywords() {
+	productSearchKeywordSrv := new(service.ProductSearchKeywordSrv)
+	err := productSearchKeywordSrv.Decay(time.Hour)
 	if err != nil {
-		log.Default().Error("reset product search hot key words fail",
+		log.Default().Error("decay product search keywords fail",
 			zap.Error(err),
 		)
 	}
@@ -93,3 +101,69 @@ func generateOrderCommission() {
 		service.AlarmError("order commission geerate fail, " + err.Error())
 	}
 }
+
+func unfreezeCommission() {
+	commissionLedgerSrv := new(service.CommissionLedgerSrv)
+	err := commissionLedgerSrv.Unfreeze()
+	if err != nil {
+		log.Default().Error("commission unfreeze fail",
+			zap.Error(err),
+		)
+		service.AlarmError("commission unfreeze fail, " + err.Error())
+	}
+}
+
+func retryCommissionReversal() {
+	orderCommissionSrv := new(service.OrderCommissionSrv)
+	err := orderCommissionSrv.RetryReversal()
+	if err != nil {
+		log.Default().Error("retry commission reversal fail",
+			zap.Error(err),
+		)
+		service.AlarmError("retry commission reversal fail, " + err.Error())
+	}
+}
+
+func refreshProductSearchVector() {
+	productSrv := new(service.ProductSrv)
+	err := productSrv.RefreshAllSearchVector()
+	if err != nil {
+		log.Default().Error("refresh product search vector fail",
+			zap.Error(err),
+		)
+		service.AlarmError("refresh product search vector fail, " + err.Error())
+	}
+}
+
+func applyProductPrice() {
+	productSrv := new(service.ProductSrv)
+	err := productSrv.ApplyScheduledPrices()
+	if err != nil {
+		log.Default().Error("apply product price fail",
+			zap.Error(err),
+		)
+		service.AlarmError("apply product price fail, " + err.Error())
+	}
+}
+
+func notifyProductRestock() {
+	productFavoriteSrv := new(service.ProductFavoriteSrv)
+	err := productFavoriteSrv.NotifyRestock()
+	if err != nil {
+		log.Default().Error("notify product restock fail",
+			zap.Error(err),
+		)
+		service.AlarmError("notify product restock fail, " + err.Error())
+	}
+}
+
+func refreshProductRanking() {
+	productSrv := new(service.ProductSrv)
+	err := productSrv.RefreshRanking()
+	if err != nil {
+		log.Default().Error("refresh product ranking fail",
+			zap.Error(err),
+		)
+		service.AlarmError("refresh product ranking fail, " + err.Error())
+	}
+}
@@ -0,0 +1,769 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	// 售后状态
+	AfterSaleStatus int
+	// 售后状态信息
+	AfterSaleStatusInfo struct {
+		Name  string          `json:"name,omitempty"`
+		Value AfterSaleStatus `json:"value,omitempty"`
+	}
+	AfterSaleStatusInfoList []*AfterSaleStatusInfo
+	// 售后类型信息
+	AfterSaleCategoryInfo struct {
+		Name  string `json:"name,omitempty"`
+		Value string `json:"value,omitempty"`
+	}
+	// 售后状态时间线
+	AfterSaleStatusTimelineItem struct {
+		CreatedAt  *time.Time      `json:"createdAt,omitempty"`
+		Status     AfterSaleStatus `json:"status,omitempty"`
+		StatusDesc string          `json:"statusDesc,omitempty"`
+		// 操作人
+		Operator uint `json:"operator,omitempty"`
+	}
+	AfterSaleStatusTimeline []AfterSaleStatusTimelineItem
+
+	AfterSales []*AfterSale
+	// 售后记录
+	AfterSale struct {
+		helper.Model
+
+		Tx *gorm.DB `json:"-" gorm:"-"`
+
+		// 编号
+		SN string `json:"sn,omitempty" gorm:"not null;uniqueIndex:idx_after_sale_sn"`
+		// 用户ID
+		UserID uint `json:"userID,omitempty" gorm:"index:idx_after_sale_user;not null"`
+		// 订单
+		MainOrder uint   `json:"mainOrder,omitempty" gorm:"not null"`
+		OrderSN   string `json:"orderSN,omitempty" gorm:"index:idx_after_sale_order_sn;not null"`
+		// 子订单
+		SubOrder uint `json:"subOrder,omitempty" gorm:"index:idx_after_sale_sub_order;not null"`
+		// 类型：退货、换货、仅退款
+		Category     string `json:"category,omitempty" gorm:"not null"`
+		CategoryDesc string `json:"categoryDesc,omitempty" gorm:"-"`
+		// 申请原因
+		Reason string `json:"reason,omitempty"`
+		// 凭证图片
+		Pics pq.StringArray `json:"pics,omitempty" gorm:"type:text[]"`
+
+		// 状态
+		Status     AfterSaleStatus `json:"status,omitempty" gorm:"index:idx_after_sale_status"`
+		StatusDesc string          `json:"statusDesc,omitempty" gorm:"-"`
+		// 审核意见
+		Remark string `json:"remark,omitempty"`
+
+		// 退货物流
+		ReturnDeliverySN      string `json:"returnDeliverySN,omitempty"`
+		ReturnDeliveryCompany string `json:"returnDeliveryCompany,omitempty"`
+		// 换货物流
+		ExchangeDeliverySN      string `json:"exchangeDeliverySN,omitempty"`
+		ExchangeDeliveryCompany string `json:"exchangeDeliveryCompany,omitempty"`
+
+		// 同意退款前子订单的状态，取消或关闭售后时恢复
+		PrevSubOrderStatus SubOrderStatus `json:"prevSubOrderStatus,omitempty"`
+
+		// 退款金额
+		RefundAmount float64    `json:"refundAmount,omitempty"`
+		RefundedAt   *time.Time `json:"refundedAt,omitempty"`
+
+		// 状态时间线
+		StatusTimeline AfterSaleStatusTimeline `json:"statusTimeline,omitempty"`
+	}
+	// 创建售后参数
+	CreateAfterSaleParams struct {
+		UserID   uint
+		SubOrder uint
+		Category string
+		Reason   string
+		Pics     []string
+	}
+	// 售后物流参数
+	AfterSaleDeliveryParams struct {
+		SN      string
+		Company string
+	}
+	AfterSaleSrv struct{}
+)
+
+const (
+	errAfterSaleCategory = "after-sale"
+)
+
+const (
+	AfterSaleStatusUnknown AfterSaleStatus = iota
+	// 申请中
+	AfterSaleStatusApplied
+	// 已拒绝
+	AfterSaleStatusRejected
+	// 已同意
+	AfterSaleStatusApproved
+	// 已取消
+	AfterSaleStatusCanceled
+	// 退货中（客户已寄回）
+	AfterSaleStatusReturning
+	// 已收到退货
+	AfterSaleStatusReceived
+	// 换货已发出
+	AfterSaleStatusExchangeShipped
+	// 已退款
+	AfterSaleStatusRefunded
+	// 已完成
+	AfterSaleStatusDone
+	// 已关闭（如退货一直未寄回）
+	AfterSaleStatusClosed
+)
+
+var (
+	afterSaleStatusDict = map[AfterSaleStatus]string{
+		AfterSaleStatusApplied:         "申请中",
+		AfterSaleStatusRejected:        "已拒绝",
+		AfterSaleStatusApproved:        "已同意",
+		AfterSaleStatusCanceled:        "已取消",
+		AfterSaleStatusReturning:       "退货中",
+		AfterSaleStatusReceived:        "已收到退货",
+		AfterSaleStatusExchangeShipped: "换货已发出",
+		AfterSaleStatusRefunded:        "已退款",
+		AfterSaleStatusDone:            "已完成",
+		AfterSaleStatusClosed:          "已关闭",
+	}
+	afterSaleStatusList AfterSaleStatusInfoList
+
+	afterSaleCategoriesMap map[string]string
+)
+
+var (
+	errAfterSaleSubOrderInvalid = &hes.Error{
+		Message:    "该子订单未发货或未完成，不可申请售后",
+		StatusCode: http.StatusBadRequest,
+		Category:   errAfterSaleCategory,
+	}
+	errAfterSaleExists = &hes.Error{
+		Message:    "该子订单已有处理中的售后申请",
+		StatusCode: http.StatusBadRequest,
+		Category:   errAfterSaleCategory,
+	}
+	errAfterSaleOwnerInvalid = &hes.Error{
+		Message:    "售后申请用户异常",
+		StatusCode: http.StatusBadRequest,
+		Category:   errAfterSaleCategory,
+	}
+	errAfterSaleRefundAmountInvalid = &hes.Error{
+		Message:    "退款金额需大于0且不能大于子订单可退金额",
+		StatusCode: http.StatusBadRequest,
+		Category:   errAfterSaleCategory,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&AfterSale{})
+	if err != nil {
+		panic(err)
+	}
+	afterSaleCategoriesMap = map[string]string{
+		cs.AfterSaleReturn:     "退货退款",
+		cs.AfterSaleExchange:   "换货",
+		cs.AfterSaleRefundOnly: "仅退款",
+	}
+
+	afterSaleStatusList = make(AfterSaleStatusInfoList, 0)
+	for k, v := range afterSaleStatusDict {
+		afterSaleStatusList = append(afterSaleStatusList, &AfterSaleStatusInfo{
+			Name:  v,
+			Value: k,
+		})
+	}
+	sort.Slice(afterSaleStatusList, func(i, j int) bool {
+		return afterSaleStatusList[i].Value < afterSaleStatusList[j].Value
+	})
+}
+
+func (timeline AfterSaleStatusTimeline) Value() (driver.Value, error) {
+	buf, err := json.Marshal(timeline)
+	return string(buf), err
+}
+
+func (timeline *AfterSaleStatusTimeline) Scan(input interface{}) error {
+	switch value := input.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), timeline)
+	case []byte:
+		return json.Unmarshal(value, timeline)
+	default:
+		return &hes.Error{
+			Message:    "不支持的时间轴类型",
+			Category:   errAfterSaleCategory,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+}
+
+// Add add status to timeline
+func (timeline AfterSaleStatusTimeline) Add(status AfterSaleStatus, operator uint) AfterSaleStatusTimeline {
+	now := time.Now()
+	timeline = append(timeline, AfterSaleStatusTimelineItem{
+		CreatedAt:  &now,
+		Status:     status,
+		StatusDesc: status.String(),
+		Operator:   operator,
+	})
+	return timeline
+}
+
+func (status AfterSaleStatus) String() string {
+	value, ok := afterSaleStatusDict[status]
+	if !ok {
+		return ""
+	}
+	return value
+}
+
+// IsFinished 是否已结束（结束的售后不可再变更）
+func (status AfterSaleStatus) IsFinished() bool {
+	switch status {
+	case AfterSaleStatusRejected,
+		AfterSaleStatusCanceled,
+		AfterSaleStatusRefunded,
+		AfterSaleStatusDone,
+		AfterSaleStatusClosed:
+		return true
+	}
+	return false
+}
+
+// ValidateNext validate the status to next status
+func (status AfterSaleStatus) ValidateNext(category string, nextStatus AfterSaleStatus) (err error) {
+	if status == nextStatus {
+		err = &hes.Error{
+			Message:    fmt.Sprintf("当前售后状态已是%s", status.String()),
+			StatusCode: http.StatusBadRequest,
+			Category:   errAfterSaleCategory,
+		}
+		return
+	}
+	var allowStatuses []AfterSaleStatus
+	switch status {
+	// 申请中 --> 已同意|已拒绝|已取消
+	case AfterSaleStatusApplied:
+		allowStatuses = []AfterSaleStatus{
+			AfterSaleStatusApproved,
+			AfterSaleStatusRejected,
+			AfterSaleStatusCanceled,
+		}
+		// 已同意 --> 已退款（仅退款）|退货中（退货、换货）|已取消|已关闭
+	case AfterSaleStatusApproved:
+		allowStatuses = []AfterSaleStatus{
+			AfterSaleStatusCanceled,
+			AfterSaleStatusClosed,
+		}
+		if category == cs.AfterSaleRefundOnly {
+			allowStatuses = append(allowStatuses, AfterSaleStatusRefunded)
+		} else {
+			allowStatuses = append(allowStatuses, AfterSaleStatusReturning)
+		}
+		// 退货中 --> 已收到退货|已取消|已关闭
+	case AfterSaleStatusReturning:
+		allowStatuses = []AfterSaleStatus{
+			AfterSaleStatusReceived,
+			AfterSaleStatusCanceled,
+			AfterSaleStatusClosed,
+		}
+		// 已收到退货 --> 已退款（退货）|换货已发出（换货）
+	case AfterSaleStatusReceived:
+		if category == cs.AfterSaleExchange {
+			allowStatuses = []AfterSaleStatus{
+				AfterSaleStatusExchangeShipped,
+			}
+		} else {
+			allowStatuses = []AfterSaleStatus{
+				AfterSaleStatusRefunded,
+			}
+		}
+		// 换货已发出 --> 已完成
+	case AfterSaleStatusExchangeShipped:
+		allowStatuses = []AfterSaleStatus{
+			AfterSaleStatusDone,
+		}
+		// 已结束的售后不可再变更
+	case AfterSaleStatusRejected,
+		AfterSaleStatusCanceled,
+		AfterSaleStatusRefunded,
+		AfterSaleStatusDone,
+		AfterSaleStatusClosed:
+		allowStatuses = []AfterSaleStatus{}
+	default:
+		err = &hes.Error{
+			Message:    fmt.Sprintf("异常状态[%d]", status),
+			Category:   errAfterSaleCategory,
+			StatusCode: http.StatusBadRequest,
+		}
+		return
+	}
+	for _, item := range allowStatuses {
+		if item == nextStatus {
+			return
+		}
+	}
+	err = &hes.Error{
+		Message:    fmt.Sprintf("售后状态不能由%s至%s", status.String(), nextStatus.String()),
+		Category:   errAfterSaleCategory,
+		StatusCode: http.StatusBadRequest,
+	}
+	return
+}
+
+func (afterSales AfterSales) AfterFind(tx *gorm.DB) (err error) {
+	for _, afterSale := range afterSales {
+		err = afterSale.AfterFind(tx)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (afterSale *AfterSale) AfterFind(_ *gorm.DB) (err error) {
+	afterSale.StatusDesc = afterSale.Status.String()
+	afterSale.CategoryDesc = afterSaleCategoriesMap[afterSale.Category]
+	return
+}
+
+func (afterSale *AfterSale) BeforeCreate(_ *gorm.DB) (err error) {
+	afterSale.Status = AfterSaleStatusApplied
+	timeline := make(AfterSaleStatusTimeline, 0)
+	afterSale.StatusTimeline = timeline.Add(AfterSaleStatusApplied, afterSale.UserID)
+	return
+}
+
+// ValidateOwner validate owner
+func (afterSale *AfterSale) ValidateOwner(userID uint) error {
+	if afterSale.UserID != userID {
+		return errAfterSaleOwnerInvalid
+	}
+	return nil
+}
+
+// IsRefundCategory 是否需要退款的售后类型
+func (afterSale *AfterSale) IsRefundCategory() bool {
+	return afterSale.Category == cs.AfterSaleReturn ||
+		afterSale.Category == cs.AfterSaleRefundOnly
+}
+
+// UpdateStatus update after sale status
+func (afterSale *AfterSale) UpdateStatus(status AfterSaleStatus, operator uint, updateDatas ...AfterSale) (err error) {
+	err = afterSale.Status.ValidateNext(afterSale.Category, status)
+	if err != nil {
+		return
+	}
+	db := afterSale.Tx
+	if db == nil {
+		db = pgGetClient()
+	}
+	timeline := afterSale.StatusTimeline.Add(status, operator)
+	updateData := AfterSale{}
+	if len(updateDatas) != 0 {
+		updateData = updateDatas[0]
+	}
+	updateData.Status = status
+	updateData.StatusTimeline = timeline
+
+	// 保证当前的状态一致
+	db = db.Model(afterSale).Where("status = ?", afterSale.Status).Updates(updateData)
+	err = db.Error
+	if err != nil {
+		return
+	}
+	if db.RowsAffected != 1 {
+		err = hes.New("更新售后状态失败，该售后当前状态已变化")
+		return
+	}
+	afterSale.StatusTimeline = timeline
+	afterSale.Status = status
+	afterSale.StatusDesc = status.String()
+	return
+}
+
+func (srv *AfterSaleSrv) genSN() string {
+	return util.GenUlid()
+}
+
+// ListStatus list the status of after sale
+func (srv *AfterSaleSrv) ListStatus() AfterSaleStatusInfoList {
+	return afterSaleStatusList
+}
+
+// ListCategory list the category of after sale
+func (srv *AfterSaleSrv) ListCategory() []*AfterSaleCategoryInfo {
+	categories := make([]*AfterSaleCategoryInfo, 0)
+	for key, value := range afterSaleCategoriesMap {
+		categories = append(categories, &AfterSaleCategoryInfo{
+			Name:  value,
+			Value: key,
+		})
+	}
+	return categories
+}
+
+// Add add after sale for the sub order
+func (srv *AfterSaleSrv) Add(params CreateAfterSaleParams) (afterSale *AfterSale, err error) {
+	subOrder, err := orderSrv.FindSubOrderByID(params.SubOrder)
+	if err != nil {
+		return
+	}
+	order, err := orderSrv.FindByID(subOrder.MainOrder)
+	if err != nil {
+		return
+	}
+	err = order.ValidateOwner(params.UserID)
+	if err != nil {
+		return
+	}
+	// 只有已发货或已完成的子订单才可申请售后
+	if !containsSubOrderStatus([]SubOrderStatus{
+		SubOrderStatusShipped,
+		SubOrderStatusDone,
+	}, subOrder.Status) {
+		err = errAfterSaleSubOrderInvalid
+		return
+	}
+	// 同一子订单只允许有一个处理中的售后
+	count, err := srv.Count("sub_order = ? AND status NOT IN (?)", subOrder.ID, []AfterSaleStatus{
+		AfterSaleStatusRejected,
+		AfterSaleStatusCanceled,
+		AfterSaleStatusRefunded,
+		AfterSaleStatusDone,
+		AfterSaleStatusClosed,
+	})
+	if err != nil {
+		return
+	}
+	if count != 0 {
+		err = errAfterSaleExists
+		return
+	}
+	afterSale = &AfterSale{
+		SN:        srv.genSN(),
+		UserID:    params.UserID,
+		MainOrder: order.ID,
+		OrderSN:   order.SN,
+		SubOrder:  subOrder.ID,
+		Category:  params.Category,
+		Reason:    params.Reason,
+		Pics:      params.Pics,
+	}
+	err = pgCreate(afterSale)
+	if err != nil {
+		return
+	}
+	afterSale.StatusDesc = afterSale.Status.String()
+	afterSale.CategoryDesc = afterSaleCategoriesMap[afterSale.Category]
+	return
+}
+
+// FindBySN find after sale by sn
+func (srv *AfterSaleSrv) FindBySN(sn string) (afterSale *AfterSale, err error) {
+	afterSale = new(AfterSale)
+	err = pgGetClient().First(afterSale, "sn = ?", sn).Error
+	return
+}
+
+// List list after sale
+func (srv *AfterSaleSrv) List(params PGQueryParams, args ...interface{}) (result AfterSales, err error) {
+	result = make(AfterSales, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// Count count after sale
+func (srv *AfterSaleSrv) Count(args ...interface{}) (count int64, err error) {
+	return pgCount(&AfterSale{}, args...)
+}
+
+// changeStatus change the status of after sale
+func (srv *AfterSaleSrv) changeStatus(sn string, operator uint, status AfterSaleStatus, updateDatas ...AfterSale) (afterSale *AfterSale, err error) {
+	afterSale, err = srv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	err = afterSale.UpdateStatus(status, operator, updateDatas...)
+	return
+}
+
+// Cancel cancel the after sale by the owner
+func (srv *AfterSaleSrv) Cancel(sn string, userID uint) (err error) {
+	afterSale, err := srv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	err = afterSale.ValidateOwner(userID)
+	if err != nil {
+		return
+	}
+	err = srv.terminate(afterSale, AfterSaleStatusCanceled, userID, "")
+	return
+}
+
+// Close close the after sale which is approved but not finished,
+// such as the returned goods never arrive
+func (srv *AfterSaleSrv) Close(sn string, operator uint, remark string) (err error) {
+	afterSale, err := srv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	err = srv.terminate(afterSale, AfterSaleStatusClosed, operator, remark)
+	return
+}
+
+// terminate cancel or close the after sale, the status of sub order
+// will be restored if it has been changed to apply refunds
+func (srv *AfterSaleSrv) terminate(afterSale *AfterSale, status AfterSaleStatus, operator uint, remark string) (err error) {
+	err = afterSale.Status.ValidateNext(afterSale.Category, status)
+	if err != nil {
+		return
+	}
+	updateData := AfterSale{
+		Remark: remark,
+	}
+	// 未同意或非退款类售后，子订单状态未调整
+	if afterSale.Status == AfterSaleStatusApplied || !afterSale.IsRefundCategory() {
+		err = afterSale.UpdateStatus(status, operator, updateData)
+		return
+	}
+	subOrder, err := orderSrv.FindSubOrderByID(afterSale.SubOrder)
+	if err != nil {
+		return
+	}
+	prevStatus := afterSale.getPrevSubOrderStatus()
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		if subOrder.Status == SubOrderStatusApplyRefunds {
+			subOrder.Tx = tx
+			err = subOrder.UpdateStatus(prevStatus)
+			if err != nil {
+				return
+			}
+		}
+		afterSale.Tx = tx
+		err = afterSale.UpdateStatus(status, operator, updateData)
+		return
+	})
+	return
+}
+
+// Approve approve the after sale
+func (srv *AfterSaleSrv) Approve(sn string, operator uint, remark string) (err error) {
+	afterSale, err := srv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	err = afterSale.Status.ValidateNext(afterSale.Category, AfterSaleStatusApproved)
+	if err != nil {
+		return
+	}
+	// 换货不涉及退款，子订单状态无需调整
+	if !afterSale.IsRefundCategory() {
+		err = afterSale.UpdateStatus(AfterSaleStatusApproved, operator, AfterSale{
+			Remark: remark,
+		})
+		return
+	}
+	subOrder, err := orderSrv.FindSubOrderByID(afterSale.SubOrder)
+	if err != nil {
+		return
+	}
+	prevSubOrderStatus := subOrder.Status
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		subOrder.Tx = tx
+		err = subOrder.UpdateStatus(SubOrderStatusApplyRefunds)
+		if err != nil {
+			return
+		}
+		afterSale.Tx = tx
+		err = afterSale.UpdateStatus(AfterSaleStatusApproved, operator, AfterSale{
+			Remark:             remark,
+			PrevSubOrderStatus: prevSubOrderStatus,
+		})
+		if err != nil {
+			return
+		}
+		return
+	})
+	return
+}
+
+// Reject reject the after sale
+func (srv *AfterSaleSrv) Reject(sn string, operator uint, remark string) (err error) {
+	_, err = srv.changeStatus(sn, operator, AfterSaleStatusRejected, AfterSale{
+		Remark: remark,
+	})
+	return
+}
+
+// Return the customer has shipped the goods back
+func (srv *AfterSaleSrv) Return(sn string, userID uint, params AfterSaleDeliveryParams) (err error) {
+	afterSale, err := srv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	err = afterSale.ValidateOwner(userID)
+	if err != nil {
+		return
+	}
+	err = afterSale.UpdateStatus(AfterSaleStatusReturning, userID, AfterSale{
+		ReturnDeliverySN:      params.SN,
+		ReturnDeliveryCompany: params.Company,
+	})
+	return
+}
+
+// Receive the returned goods have been received
+func (srv *AfterSaleSrv) Receive(sn string, operator uint) (err error) {
+	_, err = srv.changeStatus(sn, operator, AfterSaleStatusReceived)
+	return
+}
+
+// ShipExchange ship the exchange goods to customer
+func (srv *AfterSaleSrv) ShipExchange(sn string, operator uint, params AfterSaleDeliveryParams) (err error) {
+	_, err = srv.changeStatus(sn, operator, AfterSaleStatusExchangeShipped, AfterSale{
+		ExchangeDeliverySN:      params.SN,
+		ExchangeDeliveryCompany: params.Company,
+	})
+	return
+}
+
+// Finish the customer has received the exchange goods
+func (srv *AfterSaleSrv) Finish(sn string, userID uint) (err error) {
+	afterSale, err := srv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	err = afterSale.ValidateOwner(userID)
+	if err != nil {
+		return
+	}
+	err = afterSale.UpdateStatus(AfterSaleStatusDone, userID)
+	return
+}
+
+// getPrevSubOrderStatus get the status of sub order before the after sale is approved
+func (afterSale *AfterSale) getPrevSubOrderStatus() SubOrderStatus {
+	// 历史数据未记录原状态，售后仅允许已发货或已完成的子订单申请
+	if afterSale.PrevSubOrderStatus == SubOrderStatusUnknown {
+		return SubOrderStatusShipped
+	}
+	return afterSale.PrevSubOrderStatus
+}
+
+// Refund refund the after sale, if the amount is 0, refund all the refundable amount of sub order,
+// the sub order is refunded only if all pay amount is refunded, otherwise it will be restored
+// to the previous status with the refunded amount
+func (srv *AfterSaleSrv) Refund(sn string, operator uint, amount float64) (afterSale *AfterSale, err error) {
+	afterSale, err = srv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	err = afterSale.Status.ValidateNext(afterSale.Category, AfterSaleStatusRefunded)
+	if err != nil {
+		return
+	}
+	subOrder, err := orderSrv.FindSubOrderByID(afterSale.SubOrder)
+	if err != nil {
+		return
+	}
+	// 可退金额需扣除已部分退款的金额（以分比较，避免浮点误差）
+	refundableAmount := subOrder.ProductPayAmount - subOrder.RefundedAmount
+	if amount == 0 {
+		amount = refundableAmount
+	}
+	remainCents := math.Round((refundableAmount - amount) * 100)
+	if amount <= 0 || remainCents < 0 {
+		err = errAfterSaleRefundAmountInvalid
+		return
+	}
+	// TODO 调用支付渠道退款，暂时mock为退款成功
+	now := time.Now()
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		subOrder.Tx = tx
+		err = tx.Model(subOrder).Update("refunded_amount", subOrder.RefundedAmount+amount).Error
+		if err != nil {
+			return
+		}
+		if remainCents == 0 {
+			// 全部退款，已退款的子订单会申请冲正订单佣金
+			err = subOrder.UpdateStatus(SubOrderStatusRefunding)
+			if err != nil {
+				return
+			}
+			err = subOrder.UpdateStatus(SubOrderStatusRefunded)
+		} else {
+			// 部分退款，子订单恢复原状态，可再次申请售后
+			err = subOrder.UpdateStatus(afterSale.getPrevSubOrderStatus())
+			if err != nil {
+				return
+			}
+			err = orderCommissionSrv.RequestReversal(tx, afterSale.OrderSN)
+		}
+		if err != nil {
+			return
+		}
+		// 退货退款的商品已退回，恢复库存（仅退款未退回商品，换货则一退一发）
+		if afterSale.Category == cs.AfterSaleReturn {
+			err = productSrv.IncreaseSKUStock(tx, subOrder.ProductSKU, subOrder.ProductCount)
+			if err != nil {
+				return
+			}
+		}
+		afterSale.Tx = tx
+		err = afterSale.UpdateStatus(AfterSaleStatusRefunded, operator, AfterSale{
+			RefundAmount: amount,
+			RefundedAt:   &now,
+		})
+		return
+	})
+	if err != nil {
+		return
+	}
+	afterSale.RefundAmount = amount
+	afterSale.RefundedAt = &now
+	// 冲正该订单的佣金，失败时仅记录日志（冲正申请已记录，由定时任务重试）
+	e := orderCommissionSrv.Reverse(afterSale.OrderSN)
+	if e != nil {
+		logger.Error("reverse order commission fail",
+			zap.String("sn", afterSale.OrderSN),
+			zap.Error(e),
+		)
+	}
+	return
+}
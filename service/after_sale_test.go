@@ -0,0 +1,131 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/origin/cs"
+)
+
+func TestAfterSaleStatusValidateNext(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		category string
+		status   AfterSaleStatus
+		next     AfterSaleStatus
+		valid    bool
+	}{
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusApplied,
+			next:     AfterSaleStatusApproved,
+			valid:    true,
+		},
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusApplied,
+			next:     AfterSaleStatusApplied,
+			valid:    false,
+		},
+		// 仅退款同意后直接退款
+		{
+			category: cs.AfterSaleRefundOnly,
+			status:   AfterSaleStatusApproved,
+			next:     AfterSaleStatusRefunded,
+			valid:    true,
+		},
+		{
+			category: cs.AfterSaleRefundOnly,
+			status:   AfterSaleStatusApproved,
+			next:     AfterSaleStatusReturning,
+			valid:    false,
+		},
+		// 退货、换货同意后需寄回
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusApproved,
+			next:     AfterSaleStatusReturning,
+			valid:    true,
+		},
+		{
+			category: cs.AfterSaleExchange,
+			status:   AfterSaleStatusApproved,
+			next:     AfterSaleStatusRefunded,
+			valid:    false,
+		},
+		// 收到退货后：退货退款，换货发出
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusReceived,
+			next:     AfterSaleStatusRefunded,
+			valid:    true,
+		},
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusReceived,
+			next:     AfterSaleStatusExchangeShipped,
+			valid:    false,
+		},
+		{
+			category: cs.AfterSaleExchange,
+			status:   AfterSaleStatusReceived,
+			next:     AfterSaleStatusExchangeShipped,
+			valid:    true,
+		},
+		{
+			category: cs.AfterSaleExchange,
+			status:   AfterSaleStatusReceived,
+			next:     AfterSaleStatusRefunded,
+			valid:    false,
+		},
+		{
+			category: cs.AfterSaleExchange,
+			status:   AfterSaleStatusExchangeShipped,
+			next:     AfterSaleStatusDone,
+			valid:    true,
+		},
+		// 已结束的售后不可再变更
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusRefunded,
+			next:     AfterSaleStatusClosed,
+			valid:    false,
+		},
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusCanceled,
+			next:     AfterSaleStatusApplied,
+			valid:    false,
+		},
+		// 异常状态
+		{
+			category: cs.AfterSaleReturn,
+			status:   AfterSaleStatusUnknown,
+			next:     AfterSaleStatusApplied,
+			valid:    false,
+		},
+	}
+	for _, tt := range tests {
+		err := tt.status.ValidateNext(tt.category, tt.next)
+		if tt.valid {
+			assert.Nil(err, "%s: %s --> %s", tt.category, tt.status.String(), tt.next.String())
+		} else {
+			assert.NotNil(err, "%s: %s --> %s", tt.category, tt.status.String(), tt.next.String())
+		}
+	}
+}
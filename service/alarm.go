@@ -39,30 +39,35 @@ func init() {
 	}
 }
 
+// SendMail send mail to receivers, it is sent in goroutine
+func SendMail(receivers []string, subject, body string) {
+	if mailDialer == nil || len(receivers) == 0 {
+		return
+	}
+	m := gomail.NewMessage()
+	m.SetHeader("From", mailSender)
+	m.SetHeader("To", receivers...)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/plain", body)
+	// 避免发送邮件时太慢影响现有流程
+	go func() {
+		// 一次只允许一个email发送（由于使用的邮件服务有限制）
+		sendingMailMutex.Lock()
+		defer sendingMailMutex.Unlock()
+		err := mailDialer.DialAndSend(m)
+		if err != nil {
+			logger.Error("send mail fail",
+				zap.Error(err),
+			)
+		}
+	}()
+}
+
 // AlarmError alarm error message
 func AlarmError(message string) {
 	logger.Error(message,
 		zap.String("app", config.GetAppName()),
 		zap.String("category", "alarm-error"),
 	)
-	if mailDialer != nil {
-		m := gomail.NewMessage()
-		receivers := config.GetStringSlice("alarm.receiver")
-		m.SetHeader("From", mailSender)
-		m.SetHeader("To", receivers...)
-		m.SetHeader("Subject", "Alarm-"+config.GetAppName())
-		m.SetBody("text/plain", message)
-		// 避免发送邮件时太慢影响现有流程
-		go func() {
-			// 一次只允许一个email发送（由于使用的邮件服务有限制）
-			sendingMailMutex.Lock()
-			defer sendingMailMutex.Unlock()
-			err := mailDialer.DialAndSend(m)
-			if err != nil {
-				logger.Error("send mail fail",
-					zap.Error(err),
-				)
-			}
-		}()
-	}
+	SendMail(config.GetStringSlice("alarm.receiver"), "Alarm-"+config.GetAppName(), message)
 }
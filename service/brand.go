@@ -139,7 +139,9 @@ func (srv *BrandSrv) GetNameFromCache(id uint) (name string, err error) {
 	if ok {
 		return value.(string), nil
 	}
-	brand, err := srv.FindByID(id)
+	// 已删除的品牌仍展示其名称
+	brand := new(Brand)
+	err = pgGetClient().Unscoped().First(brand, "id = ?", id).Error
 	if err != nil {
 		return
 	}
@@ -0,0 +1,401 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+type (
+	// CatalogSnapshotData 快照数据（json）
+	CatalogSnapshotData map[string]interface{}
+	// CatalogFieldChange 字段的变更
+	CatalogFieldChange struct {
+		Old interface{} `json:"old"`
+		New interface{} `json:"new"`
+	}
+	// CatalogSnapshotDiff 快照与上一版本的差异
+	CatalogSnapshotDiff map[string]*CatalogFieldChange
+
+	CatalogSnapshots []*CatalogSnapshot
+	// CatalogSnapshot 商品目录（产品、分类、品牌、供应商）的变更快照
+	CatalogSnapshot struct {
+		helper.Model
+
+		// 类型：产品、分类、品牌、供应商
+		Category string `json:"category,omitempty" gorm:"not null;index:idx_catalog_snapshot_target"`
+		TargetID uint   `json:"targetID,omitempty" gorm:"not null;index:idx_catalog_snapshot_target"`
+		// 版本号，从1开始
+		Version int `json:"version,omitempty" gorm:"not null"`
+		// 操作：创建、更新、删除、恢复
+		Action   string `json:"action,omitempty" gorm:"not null"`
+		Operator uint   `json:"operator,omitempty"`
+		// 变更后的完整数据
+		Data CatalogSnapshotData `json:"data,omitempty"`
+		Diff CatalogSnapshotDiff `json:"diff,omitempty"`
+	}
+
+	CatalogHistorySrv struct{}
+)
+
+const (
+	errCatalogHistoryCategory = "catalog-history"
+
+	CatalogProduct         = "product"
+	CatalogProductSKU      = "productSKU"
+	CatalogProductCategory = "productCategory"
+	CatalogBrand           = "brand"
+	CatalogSupplier        = "supplier"
+
+	CatalogActionCreate  = "create"
+	CatalogActionUpdate  = "update"
+	CatalogActionDelete  = "delete"
+	CatalogActionRestore = "restore"
+)
+
+var (
+	// 各类型对应的数据
+	catalogModels = map[string]func() interface{}{
+		CatalogProduct: func() interface{} {
+			return &Product{}
+		},
+		CatalogProductSKU: func() interface{} {
+			return &ProductSKU{}
+		},
+		CatalogProductCategory: func() interface{} {
+			return &ProductCategory{}
+		},
+		CatalogBrand: func() interface{} {
+			return &Brand{}
+		},
+		CatalogSupplier: func() interface{} {
+			return &Supplier{}
+		},
+	}
+	// 不记录差异的字段
+	catalogDiffIgnoreFields = []string{
+		"createdAt",
+		"updatedAt",
+		"available",
+	}
+)
+
+var (
+	errCatalogCategoryInvalid = &hes.Error{
+		Message:    "不支持该类型",
+		StatusCode: http.StatusBadRequest,
+		Category:   errCatalogHistoryCategory,
+	}
+	errCatalogNotDeleted = &hes.Error{
+		Message:    "该记录未被删除",
+		StatusCode: http.StatusBadRequest,
+		Category:   errCatalogHistoryCategory,
+	}
+	errCatalogNotExistsAt = &hes.Error{
+		Message:    "该时间点记录不存在",
+		StatusCode: http.StatusBadRequest,
+		Category:   errCatalogHistoryCategory,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&CatalogSnapshot{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (data CatalogSnapshotData) Value() (driver.Value, error) {
+	buf, err := json.Marshal(data)
+	return string(buf), err
+}
+
+func (data *CatalogSnapshotData) Scan(input interface{}) error {
+	switch value := input.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), data)
+	case []byte:
+		return json.Unmarshal(value, data)
+	case nil:
+		return nil
+	default:
+		return hes.New("不支持的快照数据类型")
+	}
+}
+
+func (diff CatalogSnapshotDiff) Value() (driver.Value, error) {
+	buf, err := json.Marshal(diff)
+	return string(buf), err
+}
+
+func (diff *CatalogSnapshotDiff) Scan(input interface{}) error {
+	switch value := input.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), diff)
+	case []byte:
+		return json.Unmarshal(value, diff)
+	case nil:
+		return nil
+	default:
+		return hes.New("不支持的快照差异类型")
+	}
+}
+
+// toCatalogSnapshotData convert the model to snapshot data
+func toCatalogSnapshotData(model interface{}) (data CatalogSnapshotData, err error) {
+	if model == nil {
+		return
+	}
+	buf, err := json.Marshal(model)
+	if err != nil {
+		return
+	}
+	data = make(CatalogSnapshotData)
+	err = json.Unmarshal(buf, &data)
+	return
+}
+
+// diffCatalogSnapshotData get the diff of snapshot data,
+// the description fields(generated after find) are ignored
+func diffCatalogSnapshotData(prev, current CatalogSnapshotData) CatalogSnapshotDiff {
+	diff := make(CatalogSnapshotDiff)
+	keys := make(map[string]bool)
+	for key := range prev {
+		keys[key] = true
+	}
+	for key := range current {
+		keys[key] = true
+	}
+	for key := range keys {
+		if strings.HasSuffix(key, "Desc") || util.ContainsString(catalogDiffIgnoreFields, key) {
+			continue
+		}
+		if reflect.DeepEqual(prev[key], current[key]) {
+			continue
+		}
+		diff[key] = &CatalogFieldChange{
+			Old: prev[key],
+			New: current[key],
+		}
+	}
+	return diff
+}
+
+func (srv *CatalogHistorySrv) newModel(category string) (model interface{}, err error) {
+	fn, ok := catalogModels[category]
+	if !ok {
+		err = errCatalogCategoryInvalid
+		return
+	}
+	model = fn()
+	return
+}
+
+// find find the record (include deleted)
+func (srv *CatalogHistorySrv) find(category string, id uint) (model interface{}, err error) {
+	model, err = srv.newModel(category)
+	if err != nil {
+		return
+	}
+	err = pgGetClient().Unscoped().First(model, "id = ?", id).Error
+	return
+}
+
+func (srv *CatalogHistorySrv) add(category string, id, operator uint, action string, prev, current interface{}) (err error) {
+	prevData, err := toCatalogSnapshotData(prev)
+	if err != nil {
+		return
+	}
+	currentData, err := toCatalogSnapshotData(current)
+	if err != nil {
+		return
+	}
+	latest := new(CatalogSnapshot)
+	err = pgGetClient().
+		Select("version").
+		Where("category = ? AND target_id = ?", category, id).
+		Order("version DESC").
+		Limit(1).
+		Find(latest).Error
+	if err != nil {
+		return
+	}
+	err = pgCreate(&CatalogSnapshot{
+		Category: category,
+		TargetID: id,
+		Version:  latest.Version + 1,
+		Action:   action,
+		Operator: operator,
+		Data:     currentData,
+		Diff:     diffCatalogSnapshotData(prevData, currentData),
+	})
+	return
+}
+
+// RecordCreate record the snapshot of created record
+func (srv *CatalogHistorySrv) RecordCreate(category string, id, operator uint) (err error) {
+	current, err := srv.find(category, id)
+	if err != nil {
+		return
+	}
+	return srv.add(category, id, operator, CatalogActionCreate, nil, current)
+}
+
+// Track run the fn and record the snapshot of changes
+func (srv *CatalogHistorySrv) Track(category string, id, operator uint, action string, fn func() error) (err error) {
+	prev, err := srv.find(category, id)
+	if err != nil {
+		return
+	}
+	err = fn()
+	if err != nil {
+		return
+	}
+	if category == CatalogProductCategory {
+		productSrv.clearCategoryTreeCache()
+	}
+	current, err := srv.find(category, id)
+	if err != nil {
+		return
+	}
+	return srv.add(category, id, operator, action, prev, current)
+}
+
+// Delete soft delete the record
+func (srv *CatalogHistorySrv) Delete(category string, id, operator uint) (err error) {
+	return srv.Track(category, id, operator, CatalogActionDelete, func() error {
+		model, err := srv.newModel(category)
+		if err != nil {
+			return err
+		}
+		db := pgGetClient().Where("id = ?", id).Delete(model)
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// Restore restore the deleted record
+func (srv *CatalogHistorySrv) Restore(category string, id, operator uint) (err error) {
+	return srv.Track(category, id, operator, CatalogActionRestore, func() error {
+		model, err := srv.newModel(category)
+		if err != nil {
+			return err
+		}
+		db := pgGetClient().Unscoped().Model(model).
+			Where("id = ? AND deleted_at IS NOT NULL", id).
+			Update("deleted_at", nil)
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected != 1 {
+			return errCatalogNotDeleted
+		}
+		return nil
+	})
+}
+
+// List list the snapshots of record
+func (srv *CatalogHistorySrv) List(category string, id uint) (result CatalogSnapshots, err error) {
+	result = make(CatalogSnapshots, 0)
+	err = pgGetClient().
+		Where("category = ? AND target_id = ?", category, id).
+		Order("version DESC").
+		Find(&result).Error
+	return
+}
+
+// GetAsOf get the data of record at the time
+func (srv *CatalogHistorySrv) GetAsOf(category string, id uint, t time.Time) (data CatalogSnapshotData, err error) {
+	snapshot := new(CatalogSnapshot)
+	db := pgGetClient().
+		Where("category = ? AND target_id = ? AND created_at <= ?", category, id, util.FormatTime(t)).
+		Order("version DESC").
+		Limit(1).
+		Find(snapshot)
+	if db.Error != nil {
+		err = db.Error
+		return
+	}
+	if db.RowsAffected == 1 {
+		if snapshot.Action == CatalogActionDelete {
+			err = errCatalogNotExistsAt
+			return
+		}
+		data = snapshot.Data
+		return
+	}
+
+	// 该时间点之前无快照，则根据之后最早快照的差异还原
+	snapshot = new(CatalogSnapshot)
+	db = pgGetClient().
+		Where("category = ? AND target_id = ?", category, id).
+		Order("version").
+		Limit(1).
+		Find(snapshot)
+	if db.Error != nil {
+		err = db.Error
+		return
+	}
+	if db.RowsAffected == 1 {
+		if snapshot.Action == CatalogActionCreate || snapshot.Action == CatalogActionRestore {
+			err = errCatalogNotExistsAt
+			return
+		}
+		data = make(CatalogSnapshotData)
+		for key, value := range snapshot.Data {
+			data[key] = value
+		}
+		for key, change := range snapshot.Diff {
+			if change.Old == nil {
+				delete(data, key)
+			} else {
+				data[key] = change.Old
+			}
+		}
+		return
+	}
+
+	// 无任何快照，则当前数据未修改过
+	model, err := srv.find(category, id)
+	if err != nil {
+		return
+	}
+	data, err = toCatalogSnapshotData(model)
+	if err != nil {
+		return
+	}
+	value, _ := data["createdAt"].(string)
+	createdAt, _ := time.Parse(time.RFC3339, value)
+	if createdAt.After(t) {
+		data = nil
+		err = errCatalogNotExistsAt
+	}
+	return
+}
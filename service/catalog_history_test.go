@@ -0,0 +1,91 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToCatalogSnapshotData(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := toCatalogSnapshotData(nil)
+	assert.Nil(err)
+	assert.Nil(data)
+
+	data, err = toCatalogSnapshotData(&struct {
+		Name  string  `json:"name,omitempty"`
+		Price float64 `json:"price,omitempty"`
+		Stock int     `json:"stock,omitempty"`
+	}{
+		Name:  "开心果",
+		Price: 12.5,
+	})
+	assert.Nil(err)
+	assert.Equal(CatalogSnapshotData{
+		"name":  "开心果",
+		"price": 12.5,
+	}, data)
+}
+
+func TestDiffCatalogSnapshotData(t *testing.T) {
+	assert := assert.New(t)
+	prev := CatalogSnapshotData{
+		"name":       "开心果",
+		"price":      12.5,
+		"status":     1.0,
+		"statusDesc": "启用",
+		"updatedAt":  "2020-01-01T00:00:00+08:00",
+		"categories": []interface{}{1.0, 2.0},
+	}
+	current := CatalogSnapshotData{
+		"name":       "开心果",
+		"price":      10.0,
+		"status":     2.0,
+		"statusDesc": "禁用",
+		"updatedAt":  "2020-01-02T00:00:00+08:00",
+		"categories": []interface{}{1.0, 2.0},
+		"brand":      3.0,
+	}
+
+	// 描述字段与更新时间不记录差异
+	assert.Equal(CatalogSnapshotDiff{
+		"price": {
+			Old: 12.5,
+			New: 10.0,
+		},
+		"status": {
+			Old: 1.0,
+			New: 2.0,
+		},
+		"brand": {
+			New: 3.0,
+		},
+	}, diffCatalogSnapshotData(prev, current))
+
+	// 新建时无上一版本
+	diff := diffCatalogSnapshotData(nil, CatalogSnapshotData{
+		"name": "开心果",
+	})
+	assert.Equal(CatalogSnapshotDiff{
+		"name": {
+			New: "开心果",
+		},
+	}, diff)
+
+	assert.Empty(diffCatalogSnapshotData(prev, prev))
+}
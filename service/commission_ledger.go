@@ -0,0 +1,646 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	// CommissionAccount 佣金账户，余额的变化均在事务中与流水一起更新
+	CommissionAccount struct {
+		helper.Model
+
+		UserID uint `json:"userID,omitempty" gorm:"uniqueIndex:idx_commission_account_user;not null"`
+		// 总余额（可提现+冻结中+提现中）
+		Amount float64 `json:"amount,omitempty" gorm:"not null;default:0"`
+		// 可提现金额
+		EnabledAmount float64 `json:"enabledAmount,omitempty" gorm:"not null;default:0"`
+		// 冻结中金额
+		FrozenAmount float64 `json:"frozenAmount,omitempty" gorm:"not null;default:0"`
+		// 提现中金额
+		WithdrawingAmount float64 `json:"withdrawingAmount,omitempty" gorm:"not null;default:0"`
+	}
+
+	CommissionLedgers []*CommissionLedger
+	// CommissionLedger 佣金流水
+	CommissionLedger struct {
+		helper.Model
+
+		UserID uint `json:"userID,omitempty" gorm:"index:idx_commission_ledger_user;not null"`
+		// 类型：入账、出账、冲正
+		Category     string `json:"category,omitempty" gorm:"not null"`
+		CategoryDesc string `json:"categoryDesc,omitempty" gorm:"-"`
+		// 金额（入账为正，出账为负）
+		Amount float64 `json:"amount,omitempty" gorm:"not null"`
+		// 流水产生后的总余额
+		Balance float64 `json:"balance,omitempty" gorm:"not null"`
+
+		// 对应的佣金记录
+		OrderCommission uint   `json:"orderCommission,omitempty" gorm:"index:idx_commission_ledger_order_commission"`
+		OrderSN         string `json:"orderSN,omitempty"`
+		// 对应的提现记录
+		Withdrawal uint `json:"withdrawal,omitempty" gorm:"index:idx_commission_ledger_withdrawal"`
+
+		// 入账的冻结截止时间
+		FrozenUntil *time.Time `json:"frozenUntil,omitempty" gorm:"index:idx_commission_ledger_frozen_until"`
+		// 是否已解冻
+		Unfrozen bool `json:"unfrozen,omitempty"`
+		// 冻结期内已冲正的金额，解冻时不再转入可提现
+		ReversedAmount float64 `json:"reversedAmount,omitempty" gorm:"not null;default:0"`
+
+		Remark string `json:"remark,omitempty"`
+	}
+
+	// CommissionWithdrawalStatus 提现状态
+	CommissionWithdrawalStatus int
+	// CommissionWithdrawalStatusInfo 提现状态信息
+	CommissionWithdrawalStatusInfo struct {
+		Name  string                     `json:"name,omitempty"`
+		Value CommissionWithdrawalStatus `json:"value,omitempty"`
+	}
+	CommissionWithdrawalStatusInfoList []*CommissionWithdrawalStatusInfo
+
+	CommissionWithdrawals []*CommissionWithdrawal
+	// CommissionWithdrawal 提现申请
+	CommissionWithdrawal struct {
+		helper.Model
+
+		UserID uint    `json:"userID,omitempty" gorm:"index:idx_commission_withdrawal_user;not null"`
+		Amount float64 `json:"amount,omitempty" gorm:"not null"`
+		// 收款账户
+		Account string `json:"account,omitempty" gorm:"not null"`
+
+		Status     CommissionWithdrawalStatus `json:"status,omitempty" gorm:"index:idx_commission_withdrawal_status"`
+		StatusDesc string                     `json:"statusDesc,omitempty" gorm:"-"`
+
+		// 审核人
+		Operator uint       `json:"operator,omitempty"`
+		Remark   string     `json:"remark,omitempty"`
+		PaidAt   *time.Time `json:"paidAt,omitempty"`
+	}
+
+	// CommissionWithdrawParams 提现参数
+	CommissionWithdrawParams struct {
+		UserID  uint
+		Amount  float64
+		Account string
+	}
+
+	CommissionLedgerSrv struct{}
+)
+
+const (
+	errCommissionLedgerCategory = "commission-ledger"
+
+	// 入账
+	CommissionLedgerCredit = "credit"
+	// 出账
+	CommissionLedgerDebit = "debit"
+	// 冲正（订单退款扣回佣金）
+	CommissionLedgerReversal = "reversal"
+)
+
+const (
+	CommissionWithdrawalStatusUnknown CommissionWithdrawalStatus = iota
+	// 申请中
+	CommissionWithdrawalStatusApplied
+	// 已打款
+	CommissionWithdrawalStatusPaid
+	// 已拒绝
+	CommissionWithdrawalStatusRejected
+)
+
+var (
+	commissionWithdrawalStatusDict = map[CommissionWithdrawalStatus]string{
+		CommissionWithdrawalStatusApplied:  "申请中",
+		CommissionWithdrawalStatusPaid:     "已打款",
+		CommissionWithdrawalStatusRejected: "已拒绝",
+	}
+	commissionWithdrawalStatusList CommissionWithdrawalStatusInfoList
+
+	commissionLedgerCategoryDict = map[string]string{
+		CommissionLedgerCredit:   "入账",
+		CommissionLedgerDebit:    "出账",
+		CommissionLedgerReversal: "冲正",
+	}
+)
+
+var (
+	errCommissionBalanceNotEnough = &hes.Error{
+		Message:    "可提现金额不足",
+		StatusCode: http.StatusBadRequest,
+		Category:   errCommissionLedgerCategory,
+	}
+	errCommissionWithdrawalStatusInvalid = &hes.Error{
+		Message:    "该提现申请已处理",
+		StatusCode: http.StatusBadRequest,
+		Category:   errCommissionLedgerCategory,
+	}
+	errCommissionAccountUpdateFail = &hes.Error{
+		Message:    "佣金账户更新失败，余额已变化",
+		StatusCode: http.StatusBadRequest,
+		Category:   errCommissionLedgerCategory,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(
+		&CommissionAccount{},
+		&CommissionLedger{},
+		&CommissionWithdrawal{},
+	)
+	if err != nil {
+		panic(err)
+	}
+	commissionWithdrawalStatusList = make(CommissionWithdrawalStatusInfoList, 0)
+	for k, v := range commissionWithdrawalStatusDict {
+		commissionWithdrawalStatusList = append(commissionWithdrawalStatusList, &CommissionWithdrawalStatusInfo{
+			Name:  v,
+			Value: k,
+		})
+	}
+	sort.Slice(commissionWithdrawalStatusList, func(i, j int) bool {
+		return commissionWithdrawalStatusList[i].Value < commissionWithdrawalStatusList[j].Value
+	})
+}
+
+func (status CommissionWithdrawalStatus) String() string {
+	value, ok := commissionWithdrawalStatusDict[status]
+	if !ok {
+		return ""
+	}
+	return value
+}
+
+func (ledger *CommissionLedger) AfterFind(_ *gorm.DB) (err error) {
+	ledger.CategoryDesc = commissionLedgerCategoryDict[ledger.Category]
+	return
+}
+
+func (ledgers CommissionLedgers) AfterFind(tx *gorm.DB) (err error) {
+	for _, ledger := range ledgers {
+		err = ledger.AfterFind(tx)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (withdrawal *CommissionWithdrawal) BeforeCreate(_ *gorm.DB) (err error) {
+	withdrawal.Status = CommissionWithdrawalStatusApplied
+	return
+}
+
+func (withdrawal *CommissionWithdrawal) AfterFind(_ *gorm.DB) (err error) {
+	withdrawal.StatusDesc = withdrawal.Status.String()
+	return
+}
+
+func (withdrawals CommissionWithdrawals) AfterFind(tx *gorm.DB) (err error) {
+	for _, withdrawal := range withdrawals {
+		err = withdrawal.AfterFind(tx)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// getCommissionFreezePeriod 佣金入账后的冻结时长（默认7天）
+func getCommissionFreezePeriod() time.Duration {
+	return config.GetDurationDefault("commission.freezePeriod", 7*24*time.Hour)
+}
+
+// ListWithdrawalStatus list the status of withdrawal
+func (srv *CommissionLedgerSrv) ListWithdrawalStatus() CommissionWithdrawalStatusInfoList {
+	return commissionWithdrawalStatusList
+}
+
+// updateAccount update the account's amount, the conditions make sure the amount is enough
+func (srv *CommissionLedgerSrv) updateAccount(tx *gorm.DB, userID uint, values map[string]interface{}, conditions ...interface{}) (account *CommissionAccount, err error) {
+	account = &CommissionAccount{}
+	err = tx.FirstOrCreate(account, CommissionAccount{
+		UserID: userID,
+	}).Error
+	if err != nil {
+		return
+	}
+	db := tx.Model(account).Where("user_id = ?", userID)
+	if len(conditions) != 0 {
+		db = db.Where(conditions[0], conditions[1:]...)
+	}
+	db = db.Updates(values)
+	err = db.Error
+	if err != nil {
+		return
+	}
+	if db.RowsAffected != 1 {
+		err = errCommissionAccountUpdateFail
+		return
+	}
+	err = tx.First(account, "user_id = ?", userID).Error
+	return
+}
+
+// Credit add the commission to ledger, it will be frozen for a period
+func (srv *CommissionLedgerSrv) Credit(tx *gorm.DB, orderCommission *OrderCommission) (err error) {
+	return srv.credit(tx, orderCommission, time.Now())
+}
+
+// credit add the commission to ledger, the freeze period begins at the time,
+// it is unfrozen directly if the freeze period is over
+func (srv *CommissionLedgerSrv) credit(tx *gorm.DB, orderCommission *OrderCommission, creditedAt time.Time) (err error) {
+	if orderCommission.CommissionAmount <= 0 {
+		return
+	}
+	amount := orderCommission.CommissionAmount
+	frozenUntil := creditedAt.Add(getCommissionFreezePeriod())
+	unfrozen := !frozenUntil.After(time.Now())
+	values := map[string]interface{}{
+		"amount":        gorm.Expr("amount + ?", amount),
+		"frozen_amount": gorm.Expr("frozen_amount + ?", amount),
+	}
+	if unfrozen {
+		values = map[string]interface{}{
+			"amount":         gorm.Expr("amount + ?", amount),
+			"enabled_amount": gorm.Expr("enabled_amount + ?", amount),
+		}
+	}
+	account, err := srv.updateAccount(tx, orderCommission.Recommender, values)
+	if err != nil {
+		return
+	}
+	err = tx.Create(&CommissionLedger{
+		UserID:          orderCommission.Recommender,
+		Category:        CommissionLedgerCredit,
+		Amount:          amount,
+		Balance:         account.Amount,
+		OrderCommission: orderCommission.ID,
+		OrderSN:         orderCommission.OrderSN,
+		FrozenUntil:     &frozenUntil,
+		Unfrozen:        unfrozen,
+	}).Error
+	return
+}
+
+// Backfill credit the order commissions created before the ledger to accounts,
+// the commissions which have been credited are ignored, so it can run repeatedly
+func (srv *CommissionLedgerSrv) Backfill() (err error) {
+	// 避免多实例同时处理
+	success, done, err := redisSrv.LockWithDone("commission-ledger-backfill", 30*time.Minute)
+	if err != nil || !success {
+		return
+	}
+	defer func() {
+		_ = done()
+	}()
+	limit := 100
+	lastID := uint(0)
+	for {
+		orderCommissions, err := orderCommissionSrv.List(PGQueryParams{
+			Limit: limit,
+			Order: "id",
+		}, "id > ? AND NOT EXISTS (SELECT 1 FROM commission_ledgers WHERE commission_ledgers.order_commission = order_commissions.id AND commission_ledgers.category = ?)",
+			lastID,
+			CommissionLedgerCredit,
+		)
+		if err != nil {
+			return err
+		}
+		for _, orderCommission := range orderCommissions {
+			lastID = orderCommission.ID
+			err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+				creditedAt := time.Now()
+				if orderCommission.CreatedAt != nil {
+					creditedAt = *orderCommission.CreatedAt
+				}
+				err = srv.credit(tx, orderCommission, creditedAt)
+				if err != nil {
+					return
+				}
+				return srv.Reverse(tx, orderCommission, orderCommission.ReversedAmount)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(orderCommissions) < limit {
+			break
+		}
+	}
+	return
+}
+
+// Reverse reverse the commission, the amount is deducted from the frozen amount of
+// the credit ledger first, and then from enabled amount
+// (if the enabled amount is not enough, it will be negative until new commission is credited)
+func (srv *CommissionLedgerSrv) Reverse(tx *gorm.DB, orderCommission *OrderCommission, amount float64) (err error) {
+	if amount <= 0 {
+		return
+	}
+	frozenAmount, err := srv.reverseFrozen(tx, orderCommission, amount)
+	if err != nil {
+		return
+	}
+	account, err := srv.updateAccount(tx, orderCommission.Recommender, map[string]interface{}{
+		"amount":         gorm.Expr("amount - ?", amount),
+		"frozen_amount":  gorm.Expr("frozen_amount - ?", frozenAmount),
+		"enabled_amount": gorm.Expr("enabled_amount - ?", amount-frozenAmount),
+	})
+	if err != nil {
+		return
+	}
+	err = tx.Create(&CommissionLedger{
+		UserID:          orderCommission.Recommender,
+		Category:        CommissionLedgerReversal,
+		Amount:          -amount,
+		Balance:         account.Amount,
+		OrderCommission: orderCommission.ID,
+		OrderSN:         orderCommission.OrderSN,
+	}).Error
+	return
+}
+
+// calcFrozenReversal get the part of reversal amount which should be deducted
+// from the frozen credit ledger, the frozen amount is deducted first and
+// the rest is deducted from the enabled amount
+func calcFrozenReversal(ledger *CommissionLedger, amount float64) float64 {
+	if ledger.Unfrozen || amount <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(amount, ledger.Amount-ledger.ReversedAmount))
+}
+
+// reverseFrozen deduct the amount from the credit ledger which is still frozen,
+// it returns the amount deducted
+func (srv *CommissionLedgerSrv) reverseFrozen(tx *gorm.DB, orderCommission *OrderCommission, amount float64) (frozenAmount float64, err error) {
+	ledger := &CommissionLedger{}
+	err = tx.First(ledger, "order_commission = ? AND category = ? AND unfrozen = ?",
+		orderCommission.ID,
+		CommissionLedgerCredit,
+		false,
+	).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			err = nil
+		}
+		return
+	}
+	frozenAmount = calcFrozenReversal(ledger, amount)
+	if frozenAmount <= 0 {
+		return
+	}
+	db := tx.Model(ledger).
+		Where("unfrozen = ?", false).
+		Update("reversed_amount", gorm.Expr("reversed_amount + ?", frozenAmount))
+	err = db.Error
+	if err != nil {
+		return
+	}
+	// 已解冻，则从可提现金额扣除
+	if db.RowsAffected != 1 {
+		frozenAmount = 0
+	}
+	return
+}
+
+// unfreeze move the credit amount(exclude the reversed amount) from frozen to enabled
+func (srv *CommissionLedgerSrv) unfreeze(ledger *CommissionLedger) (err error) {
+	return pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		db := tx.Model(ledger).Where("unfrozen = ?", false).Update("unfrozen", true)
+		err = db.Error
+		if err != nil {
+			return
+		}
+		// 已被其它实例处理
+		if db.RowsAffected != 1 {
+			return
+		}
+		// 重新获取冻结期内的冲正金额
+		err = tx.First(ledger, "id = ?", ledger.ID).Error
+		if err != nil {
+			return
+		}
+		amount := ledger.Amount - ledger.ReversedAmount
+		if amount <= 0 {
+			return
+		}
+		_, err = srv.updateAccount(tx, ledger.UserID, map[string]interface{}{
+			"frozen_amount":  gorm.Expr("frozen_amount - ?", amount),
+			"enabled_amount": gorm.Expr("enabled_amount + ?", amount),
+		}, "frozen_amount >= ?", amount)
+		return
+	})
+}
+
+// Unfreeze unfreeze all credit ledgers whose freeze period is over
+func (srv *CommissionLedgerSrv) Unfreeze() (err error) {
+	limit := 100
+	lastID := uint(0)
+	for {
+		ledgers, err := srv.List(PGQueryParams{
+			Limit: limit,
+			Order: "id",
+		}, "id > ? AND category = ? AND unfrozen = ? AND frozen_until <= ?",
+			lastID,
+			CommissionLedgerCredit,
+			false,
+			util.FormatTime(time.Now()),
+		)
+		if err != nil {
+			return err
+		}
+		for _, ledger := range ledgers {
+			lastID = ledger.ID
+			// 单条流水失败（如冻结金额已被冲正扣减）不影响其它流水解冻
+			e := srv.unfreeze(ledger)
+			if e != nil {
+				logger.Error("unfreeze commission ledger fail",
+					zap.Uint("ledger", ledger.ID),
+					zap.Uint("user", ledger.UserID),
+					zap.Error(e),
+				)
+			}
+		}
+		if len(ledgers) < limit {
+			break
+		}
+	}
+	return
+}
+
+// FindAccount find the account of user, it returns an empty account if not exists
+func (srv *CommissionLedgerSrv) FindAccount(userID uint) (account *CommissionAccount, err error) {
+	account = &CommissionAccount{}
+	err = pgGetClient().First(account, "user_id = ?", userID).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+		account.UserID = userID
+	}
+	return
+}
+
+// List list ledger
+func (srv *CommissionLedgerSrv) List(params PGQueryParams, args ...interface{}) (result CommissionLedgers, err error) {
+	result = make(CommissionLedgers, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// Count count ledger
+func (srv *CommissionLedgerSrv) Count(args ...interface{}) (count int64, err error) {
+	return pgCount(&CommissionLedger{}, args...)
+}
+
+// Withdraw apply for withdrawal
+func (srv *CommissionLedgerSrv) Withdraw(params CommissionWithdrawParams) (withdrawal *CommissionWithdrawal, err error) {
+	withdrawal = &CommissionWithdrawal{
+		UserID:  params.UserID,
+		Amount:  params.Amount,
+		Account: params.Account,
+	}
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		_, err = srv.updateAccount(tx, params.UserID, map[string]interface{}{
+			"enabled_amount":     gorm.Expr("enabled_amount - ?", params.Amount),
+			"withdrawing_amount": gorm.Expr("withdrawing_amount + ?", params.Amount),
+		}, "enabled_amount >= ?", params.Amount)
+		if err == errCommissionAccountUpdateFail {
+			err = errCommissionBalanceNotEnough
+		}
+		if err != nil {
+			return
+		}
+		err = tx.Create(withdrawal).Error
+		return
+	})
+	if err != nil {
+		return
+	}
+	withdrawal.StatusDesc = withdrawal.Status.String()
+	return
+}
+
+// updateWithdrawalStatus update the status of withdrawal(only applied withdrawal can be updated)
+func (srv *CommissionLedgerSrv) updateWithdrawalStatus(tx *gorm.DB, withdrawal *CommissionWithdrawal, data CommissionWithdrawal) (err error) {
+	db := tx.Model(withdrawal).Where("status = ?", CommissionWithdrawalStatusApplied).Updates(data)
+	err = db.Error
+	if err != nil {
+		return
+	}
+	if db.RowsAffected != 1 {
+		err = errCommissionWithdrawalStatusInvalid
+		return
+	}
+	return
+}
+
+// ApproveWithdrawal approve the withdrawal and debit the account
+func (srv *CommissionLedgerSrv) ApproveWithdrawal(id, operator uint, remark string) (withdrawal *CommissionWithdrawal, err error) {
+	withdrawal, err = srv.FindWithdrawalByID(id)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = srv.updateWithdrawalStatus(tx, withdrawal, CommissionWithdrawal{
+			Status:   CommissionWithdrawalStatusPaid,
+			Operator: operator,
+			Remark:   remark,
+			PaidAt:   &now,
+		})
+		if err != nil {
+			return
+		}
+		// TODO 对接打款接口，暂时mock为打款成功
+		account, err := srv.updateAccount(tx, withdrawal.UserID, map[string]interface{}{
+			"amount":             gorm.Expr("amount - ?", withdrawal.Amount),
+			"withdrawing_amount": gorm.Expr("withdrawing_amount - ?", withdrawal.Amount),
+		}, "withdrawing_amount >= ?", withdrawal.Amount)
+		if err != nil {
+			return
+		}
+		err = tx.Create(&CommissionLedger{
+			UserID:     withdrawal.UserID,
+			Category:   CommissionLedgerDebit,
+			Amount:     -withdrawal.Amount,
+			Balance:    account.Amount,
+			Withdrawal: withdrawal.ID,
+			Remark:     remark,
+		}).Error
+		return
+	})
+	if err != nil {
+		return
+	}
+	return srv.FindWithdrawalByID(id)
+}
+
+// RejectWithdrawal reject the withdrawal and return the amount to enabled amount
+func (srv *CommissionLedgerSrv) RejectWithdrawal(id, operator uint, remark string) (withdrawal *CommissionWithdrawal, err error) {
+	withdrawal, err = srv.FindWithdrawalByID(id)
+	if err != nil {
+		return
+	}
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = srv.updateWithdrawalStatus(tx, withdrawal, CommissionWithdrawal{
+			Status:   CommissionWithdrawalStatusRejected,
+			Operator: operator,
+			Remark:   remark,
+		})
+		if err != nil {
+			return
+		}
+		_, err = srv.updateAccount(tx, withdrawal.UserID, map[string]interface{}{
+			"enabled_amount":     gorm.Expr("enabled_amount + ?", withdrawal.Amount),
+			"withdrawing_amount": gorm.Expr("withdrawing_amount - ?", withdrawal.Amount),
+		}, "withdrawing_amount >= ?", withdrawal.Amount)
+		return
+	})
+	if err != nil {
+		return
+	}
+	return srv.FindWithdrawalByID(id)
+}
+
+// FindWithdrawalByID find withdrawal by id
+func (srv *CommissionLedgerSrv) FindWithdrawalByID(id uint) (withdrawal *CommissionWithdrawal, err error) {
+	withdrawal = new(CommissionWithdrawal)
+	err = pgGetClient().First(withdrawal, "id = ?", id).Error
+	return
+}
+
+// ListWithdrawal list withdrawal
+func (srv *CommissionLedgerSrv) ListWithdrawal(params PGQueryParams, args ...interface{}) (result CommissionWithdrawals, err error) {
+	result = make(CommissionWithdrawals, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// CountWithdrawal count withdrawal
+func (srv *CommissionLedgerSrv) CountWithdrawal(args ...interface{}) (count int64, err error) {
+	return pgCount(&CommissionWithdrawal{}, args...)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalcFrozenReversal(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		ledger   *CommissionLedger
+		amount   float64
+		expected float64
+	}{
+		// 冻结金额足够，全部从冻结金额扣除
+		{
+			ledger: &CommissionLedger{
+				Amount: 10,
+			},
+			amount:   4,
+			expected: 4,
+		},
+		// 冻结金额不足，剩余部分从可提现金额扣除
+		{
+			ledger: &CommissionLedger{
+				Amount:         10,
+				ReversedAmount: 8,
+			},
+			amount:   4,
+			expected: 2,
+		},
+		// 冻结金额已全部冲正
+		{
+			ledger: &CommissionLedger{
+				Amount:         10,
+				ReversedAmount: 10,
+			},
+			amount:   4,
+			expected: 0,
+		},
+		// 已解冻则全部从可提现金额扣除
+		{
+			ledger: &CommissionLedger{
+				Amount:   10,
+				Unfrozen: true,
+			},
+			amount:   4,
+			expected: 0,
+		},
+		{
+			ledger: &CommissionLedger{
+				Amount: 10,
+			},
+			amount:   0,
+			expected: 0,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, calcFrozenReversal(tt.ledger, tt.amount))
+	}
+}
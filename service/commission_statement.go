@@ -0,0 +1,212 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"time"
+
+	"github.com/vicanso/origin/util"
+)
+
+type (
+	// CommissionGroupSummary 佣金分组汇总
+	CommissionGroupSummary struct {
+		CommissionGroup string `json:"commissionGroup,omitempty"`
+		// 订单数
+		OrderCount int64 `json:"orderCount,omitempty"`
+		// 推荐人数
+		RecommenderCount int64   `json:"recommenderCount,omitempty"`
+		PayAmount        float64 `json:"payAmount,omitempty"`
+		CommissionAmount float64 `json:"commissionAmount,omitempty"`
+		ReversedAmount   float64 `json:"reversedAmount,omitempty"`
+	}
+	CommissionGroupSummaries []*CommissionGroupSummary
+
+	// CommissionStatement 推荐人的月度佣金结算单
+	CommissionStatement struct {
+		UserID uint      `json:"userID,omitempty"`
+		Month  string    `json:"month,omitempty"`
+		Begin  time.Time `json:"begin,omitempty"`
+		End    time.Time `json:"end,omitempty"`
+
+		OrderCount       int64   `json:"orderCount,omitempty"`
+		PayAmount        float64 `json:"payAmount,omitempty"`
+		CommissionAmount float64 `json:"commissionAmount,omitempty"`
+		// 各分组的佣金
+		Groups CommissionGroupSummaries `json:"groups,omitempty"`
+		// 当月冲正的佣金（可能对应之前月份的订单）
+		AdjustmentAmount float64 `json:"adjustmentAmount,omitempty"`
+		// 当月已打款的提现
+		WithdrawalCount  int64   `json:"withdrawalCount,omitempty"`
+		WithdrawalAmount float64 `json:"withdrawalAmount,omitempty"`
+	}
+
+	// CommissionSummaryParams 佣金汇总查询参数
+	CommissionSummaryParams struct {
+		Begin time.Time
+		End   time.Time
+		// 指定分组，为空则为所有分组
+		Groups []string
+		// 指定推荐人
+		Recommender uint
+	}
+)
+
+// SummaryByGroup summary the commission by group
+func (srv *OrderCommissionSrv) SummaryByGroup(params CommissionSummaryParams) (result CommissionGroupSummaries, err error) {
+	db := pgGetClient().Model(&OrderCommission{}).
+		Select(`commission_group,
+			COUNT(DISTINCT order_sn) AS order_count,
+			COUNT(DISTINCT recommender) AS recommender_count,
+			SUM(pay_amount) AS pay_amount,
+			SUM(commission_amount) AS commission_amount,
+			SUM(reversed_amount) AS reversed_amount`)
+	if !params.Begin.IsZero() {
+		db = db.Where("created_at >= ?", util.FormatTime(params.Begin))
+	}
+	if !params.End.IsZero() {
+		db = db.Where("created_at < ?", util.FormatTime(params.End))
+	}
+	if len(params.Groups) != 0 {
+		db = db.Where("commission_group IN ?", params.Groups)
+	}
+	if params.Recommender != 0 {
+		db = db.Where("recommender = ?", params.Recommender)
+	}
+	result = make(CommissionGroupSummaries, 0)
+	err = db.Group("commission_group").
+		Order("commission_group").
+		Scan(&result).Error
+	return
+}
+
+// SummaryByMemberGroup summary the commission of the members by their marketing group,
+// the commissions of members in every group are included
+func (srv *OrderCommissionSrv) SummaryByMemberGroup(params CommissionSummaryParams) (result CommissionGroupSummaries, err error) {
+	result = make(CommissionGroupSummaries, 0)
+	if len(params.Groups) == 0 {
+		return
+	}
+	db := pgGetClient().Model(&OrderCommission{}).
+		Select(`users.marketing_group AS commission_group,
+			COUNT(DISTINCT order_commissions.order_sn) AS order_count,
+			COUNT(DISTINCT order_commissions.recommender) AS recommender_count,
+			SUM(order_commissions.pay_amount) AS pay_amount,
+			SUM(order_commissions.commission_amount) AS commission_amount,
+			SUM(order_commissions.reversed_amount) AS reversed_amount`).
+		Joins("JOIN users ON users.id = order_commissions.recommender").
+		Where("users.marketing_group IN ?", params.Groups)
+	if !params.Begin.IsZero() {
+		db = db.Where("order_commissions.created_at >= ?", util.FormatTime(params.Begin))
+	}
+	if !params.End.IsZero() {
+		db = db.Where("order_commissions.created_at < ?", util.FormatTime(params.End))
+	}
+	err = db.Group("users.marketing_group").
+		Order("users.marketing_group").
+		Scan(&result).Error
+	return
+}
+
+// GetStatement get the monthly statement of recommender
+func (srv *OrderCommissionSrv) GetStatement(userID uint, month string) (statement *CommissionStatement, err error) {
+	begin, end, err := util.ChinaMonth(month)
+	if err != nil {
+		return
+	}
+	groups, err := srv.SummaryByGroup(CommissionSummaryParams{
+		Begin:       begin,
+		End:         end,
+		Recommender: userID,
+	})
+	if err != nil {
+		return
+	}
+	statement = &CommissionStatement{
+		UserID: userID,
+		Month:  begin.Format("2006-01"),
+		Begin:  begin,
+		End:    end,
+		Groups: groups,
+	}
+	// 同一订单可能在多个分组中产生佣金，订单数与金额单独统计
+	orderSummary := struct {
+		OrderCount int64
+		PayAmount  float64
+	}{}
+	err = pgGetClient().Raw(`SELECT COUNT(*) AS order_count, COALESCE(SUM(pay_amount), 0) AS pay_amount
+		FROM (SELECT DISTINCT order_sn, pay_amount FROM order_commissions
+		WHERE recommender = ? AND created_at >= ? AND created_at < ? AND deleted_at IS NULL) AS t`,
+		userID,
+		util.FormatTime(begin),
+		util.FormatTime(end),
+	).Scan(&orderSummary).Error
+	if err != nil {
+		return
+	}
+	statement.OrderCount = orderSummary.OrderCount
+	statement.PayAmount = orderSummary.PayAmount
+	for _, item := range groups {
+		statement.CommissionAmount += item.CommissionAmount
+	}
+
+	adjustment := struct {
+		Amount float64
+	}{}
+	err = pgGetClient().Model(&CommissionLedger{}).
+		Select("COALESCE(SUM(amount), 0) AS amount").
+		Where("user_id = ? AND category = ? AND created_at >= ? AND created_at < ?",
+			userID,
+			CommissionLedgerReversal,
+			util.FormatTime(begin),
+			util.FormatTime(end),
+		).
+		Scan(&adjustment).Error
+	if err != nil {
+		return
+	}
+	statement.AdjustmentAmount = adjustment.Amount
+
+	withdrawal := struct {
+		Count  int64
+		Amount float64
+	}{}
+	err = pgGetClient().Model(&CommissionWithdrawal{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(amount), 0) AS amount").
+		Where("user_id = ? AND status = ? AND paid_at >= ? AND paid_at < ?",
+			userID,
+			CommissionWithdrawalStatusPaid,
+			util.FormatTime(begin),
+			util.FormatTime(end),
+		).
+		Scan(&withdrawal).Error
+	if err != nil {
+		return
+	}
+	statement.WithdrawalCount = withdrawal.Count
+	statement.WithdrawalAmount = withdrawal.Amount
+	return
+}
+
+// ListMarketingGroupByOwner list the marketing groups of owner
+func ListMarketingGroupByOwner(owner uint) (groups []string) {
+	groups = make([]string, 0)
+	for _, item := range defaultMarketingGroups.List() {
+		if item.Owner == owner {
+			groups = append(groups, item.Name)
+		}
+	}
+	return
+}
@@ -38,6 +38,10 @@ const (
 	orderCommissionCategory = "orderCommission"
 	// 营销分组
 	marketingGroupCategory = "marketingGroup"
+	// 屏蔽的搜索关键字
+	searchBlockKeywordCategory = "searchBlockKeyword"
+	// 强制两步验证的角色与分组
+	twoFactorPolicyCategory = "twoFactorPolicy"
 )
 
 var (
@@ -167,13 +171,36 @@ func (srv *ConfigurationSrv) createByID(id uint) *Configuration {
 // Add add configuration
 func (srv *ConfigurationSrv) Add(data Configuration) (conf *Configuration, err error) {
 	conf = &data
-	err = pgCreate(conf)
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = tx.Create(conf).Error
+		if err != nil {
+			return
+		}
+		return srv.addVersion(tx, conf, "")
+	})
 	return
 }
 
 // UpdateByID update configuration by id
 func (srv *ConfigurationSrv) UpdateByID(id uint, conf Configuration) (err error) {
-	err = pgGetClient().Model(srv.createByID(id)).Updates(conf).Error
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		current := new(Configuration)
+		err = tx.First(current, "id = ?", id).Error
+		if err != nil {
+			return
+		}
+		prevCategory := current.Category
+		err = tx.Model(current).Updates(conf).Error
+		if err != nil {
+			return
+		}
+		// 重新读取更新后的完整配置生成版本
+		err = tx.First(current, "id = ?", id).Error
+		if err != nil {
+			return
+		}
+		return srv.addVersion(tx, current, prevCategory)
+	})
 	return
 }
 
@@ -209,8 +236,9 @@ func (srv *ConfigurationSrv) Refresh() (err error) {
 	var signedKeysConfig *Configuration
 	blockIPList := make([]string, 0)
 	routerConcurrencyConfigs := make([]string, 0)
-	orderCommissionConfigs := make([]string, 0)
 	groupConfigs := make([]string, 0)
+	searchBlockKeywordConfigs := make([]string, 0)
+	twoFactorPolicyConfigs := make([]string, 0)
 
 	for _, item := range configs {
 		if item.Name == mockTimeKey {
@@ -229,10 +257,12 @@ func (srv *ConfigurationSrv) Refresh() (err error) {
 			blockIPList = append(blockIPList, item.Data)
 		case routerConcurrencyCategory:
 			routerConcurrencyConfigs = append(routerConcurrencyConfigs, item.Data)
-		case orderCommissionCategory:
-			orderCommissionConfigs = append(orderCommissionConfigs, item.Data)
 		case marketingGroupCategory:
 			groupConfigs = append(groupConfigs, item.Data)
+		case searchBlockKeywordCategory:
+			searchBlockKeywordConfigs = append(searchBlockKeywordConfigs, item.Data)
+		case twoFactorPolicyCategory:
+			twoFactorPolicyConfigs = append(twoFactorPolicyConfigs, item.Data)
 		}
 	}
 
@@ -251,8 +281,6 @@ func (srv *ConfigurationSrv) Refresh() (err error) {
 		signedKeys.SetKeys(keys)
 	}
 
-	defaultOrderCommissionConfigs.Set(orderCommissionConfigs)
-
 	defaultMarketingGroups.Set(groupConfigs)
 
 	// 更新router configs
@@ -260,6 +288,15 @@ func (srv *ConfigurationSrv) Refresh() (err error) {
 
 	ResetIPBlocker(blockIPList)
 	ResetRouterConcurrency(routerConcurrencyConfigs)
+	ResetSearchBlockKeywords(searchBlockKeywordConfigs)
+	ResetTwoFactorPolicies(twoFactorPolicyConfigs)
+
+	// 佣金配置需要保留所有历史版本（历史订单按支付时的配置计算）
+	orderCommissionVersions, err := srv.ListVersions(orderCommissionCategory)
+	if err != nil {
+		return
+	}
+	defaultOrderCommissionConfigs.Set(orderCommissionVersions)
 	return
 }
 
@@ -277,6 +314,19 @@ func (srv *ConfigurationSrv) List(params PGQueryParams, args ...interface{}) (re
 
 // DeleteByID delete configuration
 func (srv *ConfigurationSrv) DeleteByID(id uint) (err error) {
-	err = pgGetClient().Unscoped().Delete(srv.createByID(id)).Error
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		current := new(Configuration)
+		err = tx.First(current, "id = ?", id).Error
+		if err != nil {
+			return
+		}
+		err = tx.Unscoped().Delete(srv.createByID(id)).Error
+		if err != nil {
+			return
+		}
+		// 删除的配置以禁用版本记录，历史版本仍保留
+		current.Status = cs.StatusDisabled
+		return srv.addVersion(tx, current, "")
+	})
 	return
 }
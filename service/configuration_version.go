@@ -0,0 +1,115 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"time"
+
+	"github.com/vicanso/origin/helper"
+	"gorm.io/gorm"
+)
+
+type (
+	// ConfigurationVersion the immutable version of configuration,
+	// a new version is created whenever the configuration is changed
+	ConfigurationVersion struct {
+		helper.Model
+
+		ConfigurationID uint   `json:"configurationID,omitempty" gorm:"index;not null"`
+		Category        string `json:"category,omitempty" gorm:"type:varchar(20);index"`
+		Status          int    `json:"status,omitempty"`
+		Data            string `json:"data,omitempty"`
+		// 启用开始时间
+		BeginDate *time.Time `json:"beginDate,omitempty"`
+		// 启用结束时间
+		EndDate *time.Time `json:"endDate,omitempty"`
+	}
+	ConfigurationVersions []*ConfigurationVersion
+)
+
+var (
+	// 需要保留历史版本的配置分类
+	versionedConfigurationCategories = map[string]bool{
+		orderCommissionCategory: true,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&ConfigurationVersion{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// addVersion add a version of the configuration if its category is versioned
+func (srv *ConfigurationSrv) addVersion(tx *gorm.DB, conf *Configuration, prevCategory string) (err error) {
+	if !versionedConfigurationCategories[conf.Category] &&
+		!versionedConfigurationCategories[prevCategory] {
+		return
+	}
+	err = tx.Create(&ConfigurationVersion{
+		ConfigurationID: conf.ID,
+		Category:        conf.Category,
+		Status:          conf.Status,
+		Data:            conf.Data,
+		BeginDate:       conf.BeginDate,
+		EndDate:         conf.EndDate,
+	}).Error
+	return
+}
+
+// ListVersions list all versions of the configurations which have been in the category,
+// sorted by configuration id and created time
+func (srv *ConfigurationSrv) ListVersions(category string) (versions ConfigurationVersions, err error) {
+	versions = make(ConfigurationVersions, 0)
+	err = pgGetClient().
+		Where("configuration_id IN (SELECT configuration_id FROM configuration_versions WHERE category = ?)", category).
+		Order("configuration_id, created_at, id").
+		Find(&versions).Error
+	return
+}
+
+// BackfillVersions create the first version of the configurations created before versioning
+func (srv *ConfigurationSrv) BackfillVersions() (err error) {
+	categories := make([]string, 0, len(versionedConfigurationCategories))
+	for category := range versionedConfigurationCategories {
+		categories = append(categories, category)
+	}
+	configs := make(Configurations, 0)
+	err = pgGetClient().
+		Where("category IN ? AND NOT EXISTS (SELECT 1 FROM configuration_versions WHERE configuration_versions.configuration_id = configurations.id)", categories).
+		Find(&configs).Error
+	if err != nil || len(configs) == 0 {
+		return
+	}
+	for _, conf := range configs {
+		// 以配置的创建时间作为首个版本的生效时间
+		err = pgCreate(&ConfigurationVersion{
+			Model: helper.Model{
+				CreatedAt: conf.CreatedAt,
+			},
+			ConfigurationID: conf.ID,
+			Category:        conf.Category,
+			Status:          conf.Status,
+			Data:            conf.Data,
+			BeginDate:       conf.BeginDate,
+			EndDate:         conf.EndDate,
+		})
+		if err != nil {
+			return
+		}
+	}
+	return srv.Refresh()
+}
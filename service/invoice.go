@@ -0,0 +1,310 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/minio/minio-go/v6"
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+type (
+	InvoiceSrv struct{}
+)
+
+const (
+	errInvoiceCategory = "invoice"
+
+	invoiceBucket      = "origin-invoices"
+	invoiceContentType = "image/png"
+	invoiceWidth       = 800
+	invoiceQRCodeSize  = 160
+)
+
+var (
+	errInvoiceOrderNotPaid = &hes.Error{
+		Message:    "订单未支付，无法生成收据",
+		StatusCode: http.StatusBadRequest,
+		Category:   errInvoiceCategory,
+	}
+	errInvoiceFontInvalid = &hes.Error{
+		Message:    "收据字体未配置或不支持中文，无法生成收据",
+		StatusCode: http.StatusInternalServerError,
+		Category:   errInvoiceCategory,
+	}
+)
+
+var (
+	// invoiceFont 收据使用的中文字体，由invoice.fontFile指定（支持ttf、otf及ttc）
+	invoiceFont *sfnt.Font
+)
+
+func init() {
+	f, err := loadInvoiceFont(config.GetString("invoice.fontFile"), config.GetInt("invoice.fontIndex"))
+	// 字体加载失败不影响启动，生成收据时出错
+	if err != nil {
+		logger.Error("load invoice font fail",
+			zap.Error(err),
+		)
+		return
+	}
+	invoiceFont = f
+}
+
+// loadInvoiceFont load the font from file, the font should support chinese
+func loadInvoiceFont(file string, index int) (f *sfnt.Font, err error) {
+	if file == "" {
+		err = errInvoiceFontInvalid
+		return
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	collection, err := sfnt.ParseCollection(buf)
+	if err != nil {
+		return
+	}
+	f, err = collection.Font(index)
+	if err != nil {
+		return
+	}
+	// 收据内容为中文，字体需包含中文字形
+	glyph, err := f.GlyphIndex(nil, '收')
+	if err != nil {
+		return
+	}
+	if glyph == 0 {
+		err = errInvoiceFontInvalid
+		return
+	}
+	return
+}
+
+func newInvoiceFace(size float64) (font.Face, error) {
+	return opentype.NewFace(invoiceFont, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+}
+
+// getInvoiceName get the filename of order's invoice, the latest updated time
+// of order, sub orders and refunds is used as version, so the invoice will
+// be regenerated after refunds or after sales
+func getInvoiceName(order *Order, subOrders SubOrders, refunds AfterSales) string {
+	var version int64
+	updateVersion := func(t *time.Time) {
+		if t != nil && t.Unix() > version {
+			version = t.Unix()
+		}
+	}
+	updateVersion(order.UpdatedAt)
+	for _, item := range subOrders {
+		updateVersion(item.UpdatedAt)
+	}
+	for _, item := range refunds {
+		updateVersion(item.UpdatedAt)
+	}
+	return fmt.Sprintf("%s-%d.png", order.SN, version)
+}
+
+// getInvoiceOrderURL get the url of order for qrcode
+func getInvoiceOrderURL(sn string) string {
+	return fmt.Sprintf(config.GetStringDefault("invoice.orderURL", "/orders/%s"), sn)
+}
+
+// createInvoice create invoice image of order
+func createInvoice(order *Order, subOrders SubOrders, refunds AfterSales) (img image.Image, err error) {
+	if invoiceFont == nil {
+		err = errInvoiceFontInvalid
+		return
+	}
+	titleFace, err := newInvoiceFace(28)
+	if err != nil {
+		return
+	}
+	textFace, err := newInvoiceFace(16)
+	if err != nil {
+		return
+	}
+	refundAmount := 0.0
+	for _, item := range refunds {
+		refundAmount += item.RefundAmount
+	}
+	qrCode, err := GetQRCode(getInvoiceOrderURL(order.SN), invoiceQRCodeSize)
+	if err != nil {
+		return
+	}
+	qrCodeImg, err := png.Decode(bytes.NewReader(qrCode.Data))
+	if err != nil {
+		return
+	}
+
+	padding := 40.0
+	lineHeight := 32.0
+	// 头部信息、产品列表以及汇总信息的高度
+	height := int(padding*2 + 9*lineHeight + float64(len(subOrders)+1)*lineHeight + invoiceQRCodeSize)
+	dc := gg.NewContext(invoiceWidth, height)
+	dc.SetColor(color.White)
+	dc.Clear()
+	dc.SetColor(color.Black)
+
+	y := padding
+	dc.SetFontFace(titleFace)
+	dc.DrawStringAnchored("收据", invoiceWidth/2, y, 0.5, 0.5)
+	y += lineHeight * 1.5
+
+	dc.SetFontFace(textFace)
+	paidAt := ""
+	if order.PaidAt != nil {
+		paidAt = util.FormatTime(*order.PaidAt)
+	}
+	headers := []string{
+		"订单编号：" + order.SN,
+		"支付时间：" + paidAt,
+		"收货人：" + order.ReceiverName + " " + order.ReceiverMobile,
+		"收货地址：" + order.ReceiverBaseAddressDesc + order.ReceiverAddress,
+	}
+	for _, text := range headers {
+		dc.DrawString(text, padding, y)
+		y += lineHeight
+	}
+	dc.DrawLine(padding, y-lineHeight/2, invoiceWidth-padding, y-lineHeight/2)
+	dc.Stroke()
+
+	// 产品列表：名称、单价、数量、金额、状态
+	columns := []float64{
+		padding,
+		padding + 300,
+		padding + 400,
+		padding + 480,
+		padding + 600,
+	}
+	drawRow := func(values ...string) {
+		for index, value := range values {
+			dc.DrawString(value, columns[index], y)
+		}
+		y += lineHeight
+	}
+	drawRow("商品", "单价", "数量", "金额", "状态")
+	for _, subOrder := range subOrders {
+		drawRow(
+			subOrder.ProductName,
+			fmt.Sprintf("%.2f", subOrder.ProductPrice),
+			fmt.Sprintf("%d", subOrder.ProductCount),
+			fmt.Sprintf("%.2f", subOrder.ProductPayAmount),
+			subOrder.StatusDesc,
+		)
+	}
+	dc.DrawLine(padding, y-lineHeight/2, invoiceWidth-padding, y-lineHeight/2)
+	dc.Stroke()
+
+	drawRow("", "", "合计", fmt.Sprintf("%.2f", order.Amount))
+	drawRow("", "", "实付", fmt.Sprintf("%.2f", order.PayAmount))
+	drawRow("", "", "已退款", fmt.Sprintf("%.2f", refundAmount))
+
+	// 二维码放置于右下角，扫码可查看订单
+	dc.DrawImage(qrCodeImg, invoiceWidth-int(padding)-invoiceQRCodeSize, int(y))
+	img = dc.Image()
+	return
+}
+
+// InitBucket create the bucket of invoices if not exists
+func (srv *InvoiceSrv) InitBucket() (err error) {
+	exists, err := minioClient.BucketExists(invoiceBucket)
+	if err != nil || exists {
+		return
+	}
+	return minioClient.MakeBucket(invoiceBucket, "")
+}
+
+// listRefunds list the refunded after sales of order
+func (srv *InvoiceSrv) listRefunds(order *Order) (AfterSales, error) {
+	return afterSaleSrv.List(PGQueryParams{}, "order_sn = ? AND status = ?", order.SN, AfterSaleStatusRefunded)
+}
+
+func (srv *InvoiceSrv) generate(order *Order, subOrders SubOrders, refunds AfterSales) (data []byte, err error) {
+	img, err := createInvoice(order, subOrders, refunds)
+	if err != nil {
+		return
+	}
+	buffer := new(bytes.Buffer)
+	err = png.Encode(buffer, img)
+	if err != nil {
+		return
+	}
+	data = buffer.Bytes()
+	_, err = fileSrv.Upload(UploadParams{
+		Bucket: invoiceBucket,
+		Name:   getInvoiceName(order, subOrders, refunds),
+		Reader: bytes.NewReader(data),
+		Size:   int64(len(data)),
+		Opts: minio.PutObjectOptions{
+			ContentType: invoiceContentType,
+		},
+	})
+	if err != nil {
+		return
+	}
+	return
+}
+
+// GetData get the invoice of order, it will be generated if not exists
+// or the order has been changed
+func (srv *InvoiceSrv) GetData(order *Order) (data []byte, header http.Header, err error) {
+	if order.PaidAt == nil {
+		err = errInvoiceOrderNotPaid
+		return
+	}
+	subOrders, err := orderSrv.FindSubOrdersByOrderID(order.ID)
+	if err != nil {
+		return
+	}
+	refunds, err := srv.listRefunds(order)
+	if err != nil {
+		return
+	}
+	data, header, err = fileSrv.GetData(invoiceBucket, getInvoiceName(order, subOrders, refunds))
+	if err == nil {
+		return
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return
+	}
+	data, err = srv.generate(order, subOrders, refunds)
+	if err != nil {
+		return
+	}
+	header = make(http.Header)
+	header.Set(elton.HeaderContentType, invoiceContentType)
+	return
+}
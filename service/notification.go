@@ -0,0 +1,101 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"time"
+
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+)
+
+type (
+	Notifications []*Notification
+	// Notification 用户站内通知
+	Notification struct {
+		helper.Model
+
+		UserID   uint   `json:"userID,omitempty" gorm:"index:idx_notification_user;not null"`
+		Category string `json:"category,omitempty" gorm:"not null"`
+		Title    string `json:"title,omitempty" gorm:"not null"`
+		Content  string `json:"content,omitempty"`
+		// 已读时间
+		ReadAt *time.Time `json:"readAt,omitempty"`
+	}
+
+	NotificationSrv struct{}
+)
+
+const (
+	// 到货通知
+	NotificationRestock = "restock"
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&Notification{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Send send notification to user, it will send mail too if the user has email
+func (srv *NotificationSrv) Send(userID uint, category, title, content string) (notification *Notification, err error) {
+	notification = &Notification{
+		UserID:   userID,
+		Category: category,
+		Title:    title,
+		Content:  content,
+	}
+	err = pgCreate(notification)
+	if err != nil {
+		return
+	}
+	user, e := userSrv.FindByID(userID)
+	// 邮件发送失败不影响站内通知
+	if e != nil {
+		logger.Error("get user fail",
+			zap.Uint("user", userID),
+			zap.Error(e),
+		)
+		return
+	}
+	if user.Email != "" {
+		SendMail([]string{
+			user.Email,
+		}, title, content)
+	}
+	return
+}
+
+// MarkRead mark the notification of user as read
+func (srv *NotificationSrv) MarkRead(userID, id uint) (err error) {
+	err = pgGetClient().Model(&Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", id, userID).
+		Update("read_at", util.Now()).Error
+	return
+}
+
+// List list notification
+func (srv *NotificationSrv) List(params PGQueryParams, args ...interface{}) (result Notifications, err error) {
+	result = make(Notifications, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// Count count notification
+func (srv *NotificationSrv) Count(args ...interface{}) (count int64, err error) {
+	return pgCount(&Notification{}, args...)
+}
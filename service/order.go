@@ -139,6 +139,9 @@ type (
 		Product      uint    `json:"product,omitempty" gorm:"not null"`
 		ProductName  string  `json:"productName,omitempty" gorm:"not null"`
 		ProductPrice float64 `json:"productPrice,omitempty" grom:"not null"`
+		// 产品规格（sku）
+		ProductSKU     uint   `json:"productSKU,omitempty"`
+		ProductSKUName string `json:"productSKUName,omitempty"`
 		// 规格汇总
 		ProductSpecsCount uint   `json:"productSpecsCount,omitempty" gorm:"not null"`
 		ProductUnit       string `json:"productUnit,omitempty" gorm:"not null"`
@@ -148,6 +151,8 @@ type (
 		ProductAmount float64 `json:"productAmount,omitempty" gorm:"not null"`
 		// 支付金额
 		ProductPayAmount float64 `json:"productPayAmount,omitempty" gorm:"not null"`
+		// 已退款金额（部分退款时子订单仍为已发货或完成）
+		RefundedAmount float64 `json:"refundedAmount,omitempty"`
 		// TODO 子订单状态
 		// 状态
 		Status SubOrderStatus `json:"status,omitempty" gorm:"index:idx_sub_order_status"`
@@ -236,6 +241,8 @@ const (
 	SubOrderStatusDone
 	// 已关闭
 	SubOrderStatusClosed
+	// 已退款
+	SubOrderStatusRefunded
 )
 
 const (
@@ -270,6 +277,7 @@ var (
 		SubOrderStatusRefunding:     "退款中",
 		SubOrderStatusDone:          "完成",
 		SubOrderStatusClosed:        "已关闭",
+		SubOrderStatusRefunded:      "已退款",
 	}
 	subOrderStatusList SubOrderStatusInfoList
 )
@@ -581,10 +589,11 @@ func (status SubOrderStatus) ValidateNext(nextStatus SubOrderStatus) (err error)
 		allowStatuses = []SubOrderStatus{
 			SubOrderStatusShipped,
 		}
-		// 已发货 --> 完成
+		// 已发货 --> 完成|申请退款
 	case SubOrderStatusShipped:
 		allowStatuses = []SubOrderStatus{
 			SubOrderStatusDone,
+			SubOrderStatusApplyRefunds,
 		}
 		// 申请取消 --> 已取消
 	case SubOrderStatusApplyCanceled:
@@ -596,20 +605,24 @@ func (status SubOrderStatus) ValidateNext(nextStatus SubOrderStatus) (err error)
 		allowStatuses = []SubOrderStatus{
 			SubOrderStatusDone,
 		}
-		// 申请退款 --> 退款中
+		// 申请退款 --> 退款中|已发货|完成（售后取消或关闭时恢复）
 	case SubOrderStatusApplyRefunds:
 		allowStatuses = []SubOrderStatus{
 			SubOrderStatusRefunding,
+			SubOrderStatusShipped,
+			SubOrderStatusDone,
 		}
-		// 退款中 --> 完成
+		// 退款中 --> 完成|已退款
 	case SubOrderStatusRefunding:
 		allowStatuses = []SubOrderStatus{
 			SubOrderStatusDone,
+			SubOrderStatusRefunded,
 		}
-		// 完成 --> 已关闭
+		// 完成 --> 已关闭|申请退款
 	case SubOrderStatusDone:
 		allowStatuses = []SubOrderStatus{
 			SubOrderStatusClosed,
+			SubOrderStatusApplyRefunds,
 		}
 
 	default:
@@ -663,17 +676,31 @@ func (subOrder *SubOrder) UpdateStatus(status SubOrderStatus) (err error) {
 	}
 
 	// 保证当前的状态一致
-	db = db.Model(subOrder).Where("status = ?", subOrder.Status).Updates(SubOrder{
+	result := db.Model(subOrder).Where("status = ?", subOrder.Status).Updates(SubOrder{
 		Status: status,
 	})
-	err = db.Error
+	err = result.Error
 	if err != nil {
 		return
 	}
-	if db.RowsAffected != 1 {
+	if result.RowsAffected != 1 {
 		err = hes.New("更新子订单状态失败，该子订单当前状态已变化")
 		return
 	}
+	// 取消的子订单恢复库存（退货的库存在售后退款时恢复）
+	if status == SubOrderStatusCanceled {
+		err = productSrv.IncreaseSKUStock(db, subOrder.ProductSKU, subOrder.ProductCount)
+		if err != nil {
+			return
+		}
+	}
+	// 取消或退款的子订单，申请冲正订单佣金（与状态更新在同一事务）
+	if status == SubOrderStatusCanceled || status == SubOrderStatusRefunded {
+		err = orderCommissionSrv.RequestReversalByOrderID(db, subOrder.MainOrder)
+		if err != nil {
+			return
+		}
+	}
 	subOrder.Status = status
 	subOrder.StatusDesc = status.String()
 	return
@@ -775,15 +802,22 @@ func (order *Order) UpdateStatus(status OrderStatus, updateDatas ...Order) (err
 	}
 
 	// 保证当前的状态一致
-	db = db.Model(order).Where("status = ?", order.Status).Updates(updateData)
-	err = db.Error
+	result := db.Model(order).Where("status = ?", order.Status).Updates(updateData)
+	err = result.Error
 	if err != nil {
 		return
 	}
-	if db.RowsAffected != 1 {
+	if result.RowsAffected != 1 {
 		err = hes.New("更新订单状态失败，该订单当前状态已变化")
 		return
 	}
+	// 未支付关闭的订单恢复库存（已完成的订单关闭不影响库存）
+	if status == OrderStatusClosed && order.Status != OrderStatusDone {
+		err = productSrv.IncreaseSubOrdersSKUStock(db, order.ID)
+		if err != nil {
+			return
+		}
+	}
 	order.StatusTimeline = timeline
 	order.Status = status
 	order.StatusDesc = status.String()
@@ -855,6 +889,35 @@ func (srv *OrderSrv) ListSubOrderStatus() SubOrderStatusInfoList {
 	return subOrderStatusList
 }
 
+// getSubOrderSKU get the sku of sub order, it returns nil if the product has no sku
+func (srv *OrderSrv) getSubOrderSKU(product *Product, subOrder *SubOrder, skus ProductSKUs) (sku *ProductSKU, err error) {
+	hasSKU := false
+	for _, item := range skus {
+		if item.Product != product.ID {
+			continue
+		}
+		hasSKU = true
+		if item.ID == subOrder.ProductSKU {
+			sku = item
+			break
+		}
+	}
+	// 产品无规格且未指定规格
+	if !hasSKU && subOrder.ProductSKU == 0 {
+		return
+	}
+	if subOrder.ProductSKU == 0 {
+		err = errProductSKURequired.CloneWithMessage(fmt.Sprintf(errProductSKURequired.Message, product.Name))
+		return
+	}
+	if sku == nil {
+		err = errProductSKUInvalid
+		return
+	}
+	err = sku.CheckAvailable(product.Name)
+	return
+}
+
 // CreateWithSubOrders create order with sub orders
 func (srv *OrderSrv) CreateWithSubOrders(user uint, params CreateOrderParams) (order *Order, err error) {
 	order = &Order{
@@ -891,6 +954,20 @@ func (srv *OrderSrv) CreateWithSubOrders(user uint, params CreateOrderParams) (o
 			return
 		}
 	}
+	// 使用下单时生效的价格（定时调价可能还未被任务应用）
+	prices, err := productSrv.GetPricesAt(products, util.Now())
+	if err != nil {
+		return
+	}
+	// 产品的所有规格，有规格的产品必须选择规格下单
+	skus, err := productSrv.ListSKU(PGQueryParams{}, "product IN (?)", ids)
+	if err != nil {
+		return
+	}
+	skuPrices, err := productSrv.GetSKUPricesAt(skus, util.Now())
+	if err != nil {
+		return
+	}
 
 	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
 		err = tx.Create(order).Error
@@ -905,14 +982,32 @@ func (srv *OrderSrv) CreateWithSubOrders(user uint, params CreateOrderParams) (o
 				if subOrder.Product == p.ID {
 					found = true
 					subOrder.ProductName = p.Name
-					if subOrder.ProductPrice != p.Price {
+					price := prices[p.ID]
+					specs := p.Specs
+					unit := p.Unit
+					sku, e := srv.getSubOrderSKU(p, &subOrder, skus)
+					if e != nil {
+						err = e
+						return
+					}
+					if sku != nil {
+						price = skuPrices[sku.ID]
+						specs = sku.Specs
+						unit = sku.Unit
+						subOrder.ProductSKUName = sku.Name
+						err = productSrv.DecreaseSKUStock(tx, sku, subOrder.ProductCount, p.Name)
+						if err != nil {
+							return
+						}
+					}
+					if subOrder.ProductPrice != price {
 						he := hes.New(p.Name + "价格异常，请重新刷新订单后提交")
 						he.Category = errOrderCategory
 						err = he
 						return
 					}
-					subOrder.ProductSpecsCount = p.Specs * subOrder.ProductCount
-					subOrder.ProductUnit = p.Unit
+					subOrder.ProductSpecsCount = specs * subOrder.ProductCount
+					subOrder.ProductUnit = unit
 					subOrder.MainOrder = order.ID
 					err = tx.Create(&subOrder).Error
 					if err != nil {
@@ -970,6 +1065,13 @@ func (srv *OrderSrv) FindBySN(sn string) (order *Order, err error) {
 	return
 }
 
+// FindByID find order by id
+func (srv *OrderSrv) FindByID(id uint) (order *Order, err error) {
+	order = new(Order)
+	err = pgGetClient().First(order, "id = ?", id).Error
+	return
+}
+
 // UpdateByID update order by id
 func (srv *OrderSrv) UpdateByID(id uint, order Order) (err error) {
 	err = pgGetClient().Model(srv.createByID(id)).Updates(order).Error
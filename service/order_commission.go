@@ -15,12 +15,18 @@
 package service
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/cs"
 	"github.com/vicanso/origin/helper"
 	"github.com/vicanso/origin/util"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type (
@@ -29,23 +35,90 @@ type (
 		helper.Model
 
 		UserID  uint   `json:"userID,omitempty" gorm:"index:idx_order_commission_user_id;not null"`
-		OrderSN string `json:"orderSN,omitempty" gorm:"unique_index:idx_order_commission_order_sn_recommender;not null"`
+		OrderSN string `json:"orderSN,omitempty" gorm:"uniqueIndex:idx_order_commission_sn_recommender_group;not null"`
 		// 推荐人
-		Recommender      uint    `json:"recommender,omitempty" gorm:"unique_index:idx_order_commission_order_sn_recommender;not null"`
+		Recommender      uint    `json:"recommender,omitempty" gorm:"uniqueIndex:idx_order_commission_sn_recommender_group;not null"`
 		PayAmount        float64 `json:"payAmount,omitempty" gorm:"not null"`
 		CommissionAmount float64 `json:"commissionAmount,omitempty" gorm:"not null"`
-		// 该佣金对应的分组
-		CommissionGroup string `json:"commissionGroup,omitempty" gorm:"not null"`
+		// 该佣金对应的分组（推荐人同时为销售分组负责人时，两份佣金分别记录）
+		CommissionGroup string `json:"commissionGroup,omitempty" gorm:"uniqueIndex:idx_order_commission_sn_recommender_group;not null"`
+		// 佣金比例（按子订单计算时为整体的平均比例）
+		Ratio float64 `json:"ratio,omitempty"`
+		// 各子订单的佣金明细
+		Details OrderCommissionDetails `json:"details,omitempty"`
+		// 订单已退款（或取消）的金额
+		RefundedAmount float64 `json:"refundedAmount,omitempty"`
+		// 已冲正的佣金
+		ReversedAmount float64 `json:"reversedAmount,omitempty"`
+		// 推荐层级，1表示直接推荐人（销售分组的佣金为0）
+		Level int `json:"level,omitempty"`
+		// 计算佣金时使用的配置版本
+		ConfigVersion string `json:"configVersion,omitempty"`
+		// 申请冲正的时间（退款或取消时记录，冲正成功后清除，失败则由定时任务重试）
+		ReversalRequestedAt *time.Time `json:"-" gorm:"index:idx_order_commission_reversal_requested_at"`
 	}
 
 	OrderCommissions []*OrderCommission
 
+	// OrderCommissionDetail 子订单佣金明细
+	OrderCommissionDetail struct {
+		SubOrder         uint    `json:"subOrder,omitempty"`
+		Product          uint    `json:"product,omitempty"`
+		ProductName      string  `json:"productName,omitempty"`
+		ProductPayAmount float64 `json:"productPayAmount,omitempty"`
+		Ratio            float64 `json:"ratio,omitempty"`
+		CommissionAmount float64 `json:"commissionAmount,omitempty"`
+	}
+	OrderCommissionDetails []*OrderCommissionDetail
+
 	// OrderCommissionSrv 订单佣金
 	OrderCommissionSrv struct{}
 
+	// OrderCommissionDownlineNode 下线用户
+	OrderCommissionDownlineNode struct {
+		ID      uint   `json:"id,omitempty"`
+		Account string `json:"account,omitempty"`
+		Name    string `json:"name,omitempty"`
+		Level   int    `json:"level,omitempty"`
+		// 该用户订单产生的佣金
+		CommissionAmount float64                        `json:"commissionAmount,omitempty"`
+		Children         []*OrderCommissionDownlineNode `json:"children,omitempty"`
+	}
+	// OrderCommissionDownlineLevel 各层级下线汇总
+	OrderCommissionDownlineLevel struct {
+		Level            int     `json:"level,omitempty"`
+		Count            int     `json:"count,omitempty"`
+		CommissionAmount float64 `json:"commissionAmount,omitempty"`
+	}
+	// OrderCommissionDownline 下线树
+	OrderCommissionDownline struct {
+		Levels []*OrderCommissionDownlineLevel `json:"levels,omitempty"`
+		Nodes  []*OrderCommissionDownlineNode  `json:"nodes,omitempty"`
+	}
+
 	OrderCommissionConfig struct {
 		Group string  `json:"group,omitempty"`
 		Ratio float64 `json:"ratio,omitempty"`
+		// 退款时佣金的冲正方式：full(全额扣回)、proportional(按比例扣回)，默认为全额
+		Reversal string `json:"reversal,omitempty"`
+		// 多级推荐的佣金比例（从第二级开始），仅对全局分组有效
+		LevelRatios []float64 `json:"levelRatios,omitempty"`
+		// 按产品、产品分类、品牌覆盖的佣金比例（优先级：产品 > 分类 > 品牌）
+		ProductRatios  map[uint]float64 `json:"productRatios,omitempty"`
+		CategoryRatios map[uint]float64 `json:"categoryRatios,omitempty"`
+		BrandRatios    map[uint]float64 `json:"brandRatios,omitempty"`
+
+		// 配置版本（配置版本记录的ID，版本不可修改）
+		Version string `json:"version,omitempty"`
+		// 生效时间
+		BeginDate *time.Time `json:"beginDate,omitempty"`
+		EndDate   *time.Time `json:"endDate,omitempty"`
+
+		// 版本是否启用（禁用的版本仍保留，用于按版本获取配置）
+		enabled bool
+		// 版本的有效时间段，由下一版本的创建时间结束
+		versionFrom *time.Time
+		versionTo   *time.Time
 	}
 	// OrderCommissionConfigs 订单佣金配置
 	OrderCommissionConfigs struct {
@@ -60,6 +133,14 @@ var (
 
 const (
 	orderCommissionAllGroup = "*"
+
+	// 全额扣回佣金
+	OrderCommissionReversalFull = "full"
+	// 按退款金额比例扣回佣金
+	OrderCommissionReversalProportional = "proportional"
+
+	// 推荐链的最大层级
+	OrderCommissionMaxLevel = 5
 )
 
 func init() {
@@ -71,26 +152,62 @@ func init() {
 	}
 }
 
-func (orderCommissionConfigs *OrderCommissionConfigs) Set(items []string) {
+// Set set the order commission configs from all versions(sorted by configuration and created time),
+// including the disabled ones and the ones not in effect
+func (orderCommissionConfigs *OrderCommissionConfigs) Set(versions ConfigurationVersions) {
 	confs := make([]*OrderCommissionConfig, 0)
-	for _, item := range items {
+	for index, item := range versions {
 		conf := &OrderCommissionConfig{}
 		// 忽略出错
-		_ = json.Unmarshal([]byte(item), conf)
-		if conf.Group != "" && conf.Ratio >= 0 {
-			confs = append(confs, conf)
+		_ = json.Unmarshal([]byte(item.Data), conf)
+		if conf.Group == "" || conf.Ratio < 0 {
+			continue
+		}
+		conf.Version = strconv.Itoa(int(item.ID))
+		conf.BeginDate = item.BeginDate
+		conf.EndDate = item.EndDate
+		conf.enabled = item.Category == orderCommissionCategory && item.Status == cs.StatusEnabled
+		conf.versionFrom = item.CreatedAt
+		// 同一配置的下一版本创建后，此版本失效
+		if index+1 < len(versions) && versions[index+1].ConfigurationID == item.ConfigurationID {
+			conf.versionTo = versions[index+1].CreatedAt
 		}
+		confs = append(confs, conf)
 	}
 	orderCommissionConfigs.Lock()
 	defer orderCommissionConfigs.Unlock()
 	orderCommissionConfigs.configs = confs
 }
 
+// GetAt get the config of group which is in effect at the time,
+// if there are more than one, the one begins latest is used
+func (orderCommissionConfigs *OrderCommissionConfigs) GetAt(group string, t time.Time) (conf *OrderCommissionConfig) {
+	orderCommissionConfigs.RLock()
+	defer orderCommissionConfigs.RUnlock()
+	for _, item := range orderCommissionConfigs.configs {
+		if item.Group != group || !item.isCurrentAt(t) || !item.IsEffective(t) {
+			continue
+		}
+		// 未设置生效时间的配置视为最早开始
+		if conf == nil ||
+			(item.BeginDate != nil && (conf.BeginDate == nil || item.BeginDate.After(*conf.BeginDate))) {
+			conf = item
+		}
+	}
+	return
+}
+
+// Get get the config of group which is in effect now
 func (orderCommissionConfigs *OrderCommissionConfigs) Get(group string) (conf *OrderCommissionConfig) {
+	return orderCommissionConfigs.GetAt(group, time.Now())
+}
+
+// GetByVersion get the config by version
+func (orderCommissionConfigs *OrderCommissionConfigs) GetByVersion(version string) (conf *OrderCommissionConfig) {
 	orderCommissionConfigs.RLock()
 	defer orderCommissionConfigs.RUnlock()
 	for _, item := range orderCommissionConfigs.configs {
-		if item.Group == group {
+		if item.Version == version {
 			conf = item
 			break
 		}
@@ -98,38 +215,426 @@ func (orderCommissionConfigs *OrderCommissionConfigs) Get(group string) (conf *O
 	return
 }
 
-func (orderCommissionConfigs *OrderCommissionConfigs) GetRatio(group string) (ratio float64) {
-	conf := orderCommissionConfigs.Get(group)
-	if conf == nil {
+// isCurrentAt check the version is enabled and is the current version at the time
+func (conf *OrderCommissionConfig) isCurrentAt(t time.Time) bool {
+	if !conf.enabled {
+		return false
+	}
+	if conf.versionFrom != nil && t.Before(*conf.versionFrom) {
+		return false
+	}
+	if conf.versionTo != nil && !t.Before(*conf.versionTo) {
+		return false
+	}
+	return true
+}
+
+// IsEffective check the config is in effect at the time
+func (conf *OrderCommissionConfig) IsEffective(t time.Time) bool {
+	if conf.BeginDate != nil && t.Before(*conf.BeginDate) {
+		return false
+	}
+	if conf.EndDate != nil && !t.Before(*conf.EndDate) {
+		return false
+	}
+	return true
+}
+
+// GetLevelRatios get the ratios of every level, the first one is the direct recommender
+func (conf *OrderCommissionConfig) GetLevelRatios() (ratios []float64) {
+	ratios = append([]float64{conf.Ratio}, conf.LevelRatios...)
+	if len(ratios) > OrderCommissionMaxLevel {
+		ratios = ratios[:OrderCommissionMaxLevel]
+	}
+	return
+}
+
+// GetProductRatio get the ratio of product, the overridden ratio is for the direct recommender,
+// other levels are scaled by the same proportion
+func (conf *OrderCommissionConfig) GetProductRatio(product *Product, ratio float64) float64 {
+	if product == nil || conf.Ratio == 0 {
+		return ratio
+	}
+	override, ok := conf.ProductRatios[product.ID]
+	if !ok {
+		// 产品属于多个分类时，使用最低的比例
+		for _, id := range product.Categories {
+			value, exists := conf.CategoryRatios[uint(id)]
+			if exists && (!ok || value < override) {
+				override = value
+				ok = true
+			}
+		}
+	}
+	if !ok {
+		override, ok = conf.BrandRatios[product.Brand]
+	}
+	if !ok {
+		return ratio
+	}
+	return ratio * override / conf.Ratio
+}
+
+// CalcDetails calculate the commission of every sub order
+func (conf *OrderCommissionConfig) CalcDetails(subOrders SubOrders, products map[uint]*Product, ratio float64) (details OrderCommissionDetails, amount float64) {
+	details = make(OrderCommissionDetails, 0, len(subOrders))
+	for _, subOrder := range subOrders {
+		lineRatio := conf.GetProductRatio(products[subOrder.Product], ratio)
+		lineAmount := subOrder.ProductPayAmount * lineRatio
+		details = append(details, &OrderCommissionDetail{
+			SubOrder:         subOrder.ID,
+			Product:          subOrder.Product,
+			ProductName:      subOrder.ProductName,
+			ProductPayAmount: subOrder.ProductPayAmount,
+			Ratio:            lineRatio,
+			CommissionAmount: lineAmount,
+		})
+		amount += lineAmount
+	}
+	return
+}
+
+// GetReversal get the reversal rule of config
+func (conf *OrderCommissionConfig) GetReversal() string {
+	if conf == nil || conf.Reversal != OrderCommissionReversalProportional {
+		return OrderCommissionReversalFull
+	}
+	return OrderCommissionReversalProportional
+}
+
+// create create the order commission if not exists, and credit it to the ledger
+func (srv *OrderCommissionSrv) create(orderCommission *OrderCommission) (err error) {
+	return pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		db := tx.FirstOrCreate(orderCommission, OrderCommission{
+			OrderSN:         orderCommission.OrderSN,
+			Recommender:     orderCommission.Recommender,
+			CommissionGroup: orderCommission.CommissionGroup,
+		})
+		err = db.Error
+		if err != nil {
+			return
+		}
+		// 已存在的佣金记录不再重复入账
+		if db.RowsAffected != 1 {
+			return
+		}
+		return commissionLedgerSrv.Credit(tx, orderCommission)
+	})
+}
+
+func (details OrderCommissionDetails) Value() (driver.Value, error) {
+	buf, err := json.Marshal(details)
+	return string(buf), err
+}
+
+func (details *OrderCommissionDetails) Scan(input interface{}) error {
+	switch value := input.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), details)
+	case []byte:
+		return json.Unmarshal(value, details)
+	case nil:
+		return nil
+	default:
+		return hes.New("不支持的佣金明细类型")
+	}
+}
+
+// calcCommissionAmount calculate the commission amount after refunded
+func calcCommissionAmount(payAmount, refundedAmount, commissionAmount float64, reversal string) float64 {
+	if refundedAmount <= 0 {
+		return commissionAmount
+	}
+	// 全额扣回
+	if reversal != OrderCommissionReversalProportional || payAmount <= 0 {
+		return 0
+	}
+	amount := commissionAmount * (payAmount - refundedAmount) / payAmount
+	if amount < 0 {
+		amount = 0
+	}
+	return amount
+}
+
+// calcAmountAfterRefunded calculate the commission amount after refunded,
+// the proportional reversal is calculated by the details of every sub order
+func (orderCommission *OrderCommission) calcAmountAfterRefunded(refundedAmounts map[uint]float64, reversal string) float64 {
+	refundedAmount := sumRefundedAmount(refundedAmounts)
+	if len(orderCommission.Details) == 0 ||
+		refundedAmount <= 0 ||
+		reversal != OrderCommissionReversalProportional {
+		return calcCommissionAmount(orderCommission.PayAmount, refundedAmount, orderCommission.CommissionAmount, reversal)
+	}
+	amount := 0.0
+	for _, detail := range orderCommission.Details {
+		// 各子订单按其自身的佣金比例扣回
+		amount += calcCommissionAmount(detail.ProductPayAmount, refundedAmounts[detail.SubOrder], detail.CommissionAmount, reversal)
+	}
+	return amount
+}
+
+// sumRefundedAmount sum the refunded amount of sub orders
+func sumRefundedAmount(refundedAmounts map[uint]float64) (refundedAmount float64) {
+	for _, amount := range refundedAmounts {
+		refundedAmount += amount
+	}
+	return
+}
+
+// getRefundedAmounts get the refunded(or canceled) amount of every sub order
+func (srv *OrderCommissionSrv) getRefundedAmounts(subOrders SubOrders) (refundedAmounts map[uint]float64, err error) {
+	refundedAmounts = make(map[uint]float64)
+	for _, subOrder := range subOrders {
+		switch {
+		case subOrder.Status == SubOrderStatusCanceled:
+			refundedAmounts[subOrder.ID] = subOrder.ProductPayAmount
+		// 已退款金额（包括部分退款）
+		case subOrder.RefundedAmount != 0:
+			refundedAmounts[subOrder.ID] = subOrder.RefundedAmount
+		// 历史数据未记录子订单的退款金额，以售后记录为准
+		case subOrder.Status == SubOrderStatusRefunded:
+			afterSales, e := afterSaleSrv.List(PGQueryParams{
+				Limit: 10,
+			}, "sub_order = ? AND status = ?", subOrder.ID, AfterSaleStatusRefunded)
+			if e != nil {
+				err = e
+				return
+			}
+			amount := 0.0
+			for _, afterSale := range afterSales {
+				amount += afterSale.RefundAmount
+			}
+			if amount == 0 {
+				amount = subOrder.ProductPayAmount
+			}
+			refundedAmounts[subOrder.ID] = amount
+		}
+	}
+	return
+}
+
+// reverse reverse the commission if the order is refunded
+func (srv *OrderCommissionSrv) reverse(orderCommission *OrderCommission, refundedAmounts map[uint]float64) (err error) {
+	// 使用生成佣金时的配置版本，未记录版本则使用生成佣金时生效的配置
+	conf := defaultOrderCommissionConfigs.GetByVersion(orderCommission.ConfigVersion)
+	if conf == nil && orderCommission.CreatedAt != nil {
+		conf = defaultOrderCommissionConfigs.GetAt(orderCommission.CommissionGroup, *orderCommission.CreatedAt)
+	}
+	reversal := conf.GetReversal()
+	refundedAmount := sumRefundedAmount(refundedAmounts)
+	amount := orderCommission.calcAmountAfterRefunded(refundedAmounts, reversal)
+	delta := orderCommission.CommissionAmount - orderCommission.ReversedAmount - amount
+	if delta <= 0 {
 		return
 	}
-	ratio = conf.Ratio
+	return pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		// 保证冲正金额未被其它流程修改
+		db := tx.Model(orderCommission).
+			Where("reversed_amount = ?", orderCommission.ReversedAmount).
+			Updates(map[string]interface{}{
+				"refunded_amount": refundedAmount,
+				"reversed_amount": orderCommission.ReversedAmount + delta,
+			})
+		err = db.Error
+		if err != nil {
+			return
+		}
+		if db.RowsAffected != 1 {
+			return
+		}
+		orderCommission.RefundedAmount = refundedAmount
+		orderCommission.ReversedAmount += delta
+		return commissionLedgerSrv.Reverse(tx, orderCommission, delta)
+	})
+}
+
+// RequestReversal mark the commissions of order to be reversed,
+// it should be called in the same transaction of refund or cancel
+func (srv *OrderCommissionSrv) RequestReversal(tx *gorm.DB, sn string) error {
+	return tx.Model(&OrderCommission{}).
+		Where("order_sn = ?", sn).
+		Update("reversal_requested_at", time.Now()).Error
+}
+
+// RequestReversalByOrderID mark the commissions of order to be reversed by order id
+func (srv *OrderCommissionSrv) RequestReversalByOrderID(tx *gorm.DB, orderID uint) error {
+	return tx.Model(&OrderCommission{}).
+		Where("order_sn IN (SELECT sn FROM orders WHERE id = ?)", orderID).
+		Update("reversal_requested_at", time.Now()).Error
+}
+
+// RetryReversal reverse the commissions which are requested but not reversed
+func (srv *OrderCommissionSrv) RetryReversal() (err error) {
+	limit := 100
+	// 刚申请的冲正由申请流程处理
+	before := time.Now().Add(-time.Minute)
+	snList := make([]string, 0)
+	err = pgGetClient().Model(&OrderCommission{}).
+		Distinct("order_sn").
+		Where("reversal_requested_at IS NOT NULL AND reversal_requested_at < ?", before).
+		Limit(limit).
+		Pluck("order_sn", &snList).Error
+	if err != nil {
+		return
+	}
+	for _, sn := range snList {
+		e := srv.Reverse(sn)
+		if e != nil {
+			logger.Error("retry reverse order commission fail",
+				zap.String("sn", sn),
+				zap.Error(e),
+			)
+		}
+	}
+	return
+}
+
+// clearReversalRequest clear the reversal request, the request after the
+// commission was loaded is kept
+func (srv *OrderCommissionSrv) clearReversalRequest(orderCommission *OrderCommission) error {
+	if orderCommission.ReversalRequestedAt == nil {
+		return nil
+	}
+	return pgGetClient().Model(orderCommission).
+		Where("reversal_requested_at = ?", orderCommission.ReversalRequestedAt).
+		Update("reversal_requested_at", nil).Error
+}
+
+// Reverse reverse all commissions of order
+func (srv *OrderCommissionSrv) Reverse(sn string) (err error) {
+	orderCommissions, err := srv.List(PGQueryParams{
+		Limit: 10,
+	}, "order_sn = ?", sn)
+	if err != nil || len(orderCommissions) == 0 {
+		return
+	}
+	order, err := orderSrv.FindBySN(sn)
+	if err != nil {
+		return
+	}
+	subOrders, err := orderSrv.FindSubOrdersByOrderID(order.ID)
+	if err != nil {
+		return
+	}
+	refundedAmounts, err := srv.getRefundedAmounts(subOrders)
+	if err != nil {
+		return
+	}
+	for _, orderCommission := range orderCommissions {
+		err = srv.reverse(orderCommission, refundedAmounts)
+		if err != nil {
+			return
+		}
+		err = srv.clearReversalRequest(orderCommission)
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
+// getProducts get the products of sub orders
+func (srv *OrderCommissionSrv) getProducts(subOrders SubOrders) (products map[uint]*Product, err error) {
+	products = make(map[uint]*Product)
+	for _, subOrder := range subOrders {
+		if products[subOrder.Product] != nil {
+			continue
+		}
+		// 产品可能已被删除
+		product, err := productSrv.findByIDUnscoped(subOrder.Product)
+		if err != nil {
+			return nil, err
+		}
+		products[subOrder.Product] = product
+	}
+	return
+}
+
+// newOrderCommission create the order commission with the details of sub orders
+func (srv *OrderCommissionSrv) newOrderCommission(order *Order, conf *OrderCommissionConfig, subOrders SubOrders, products map[uint]*Product, ratio float64) *OrderCommission {
+	orderCommission := &OrderCommission{
+		UserID:        order.UserID,
+		OrderSN:       order.SN,
+		PayAmount:     order.PayAmount,
+		Ratio:         ratio,
+		ConfigVersion: conf.Version,
+	}
+	// 无子订单时按订单支付金额计算
+	if len(subOrders) == 0 {
+		orderCommission.CommissionAmount = order.PayAmount * ratio
+		return orderCommission
+	}
+	details, amount := conf.CalcDetails(subOrders, products, ratio)
+	orderCommission.Details = details
+	orderCommission.CommissionAmount = amount
+	if order.PayAmount != 0 {
+		orderCommission.Ratio = amount / order.PayAmount
+	}
+	return orderCommission
+}
+
 func (srv *OrderCommissionSrv) createOrUpdate(order *Order) (err error) {
 	if order.Recommender == 0 {
 		return
 	}
-	ratio := defaultOrderCommissionConfigs.GetRatio(orderCommissionAllGroup)
-	if ratio == 0 {
+	// 使用订单支付时生效的佣金配置
+	paidAt := time.Now()
+	if order.PaidAt != nil {
+		paidAt = *order.PaidAt
+	}
+	conf := defaultOrderCommissionConfigs.GetAt(orderCommissionAllGroup, paidAt)
+	if conf == nil || conf.Ratio == 0 {
 		return
 	}
-	orderCommission := &OrderCommission{
-		UserID:           order.UserID,
-		OrderSN:          order.SN,
-		Recommender:      order.Recommender,
-		PayAmount:        order.PayAmount,
-		CommissionAmount: order.PayAmount * ratio,
-		CommissionGroup:  orderCommissionAllGroup,
-	}
-	err = pgGetClient().FirstOrCreate(orderCommission, OrderCommission{
-		OrderSN:     order.SN,
-		Recommender: order.Recommender,
-	}).Error
+	subOrders, err := orderSrv.FindSubOrdersByOrderID(order.ID)
+	if err != nil {
+		return
+	}
+	refundedAmounts, err := srv.getRefundedAmounts(subOrders)
+	if err != nil {
+		return
+	}
+	products, err := srv.getProducts(subOrders)
 	if err != nil {
 		return
 	}
+	// 沿推荐链向上生成各级佣金
+	ratios := conf.GetLevelRatios()
+	visited := map[uint]bool{
+		order.UserID: true,
+	}
+	recommender := order.Recommender
+	for index, ratio := range ratios {
+		// 推荐链结束或出现循环
+		if recommender == 0 || visited[recommender] {
+			break
+		}
+		visited[recommender] = true
+		if ratio > 0 {
+			orderCommission := srv.newOrderCommission(order, conf, subOrders, products, ratio)
+			orderCommission.Recommender = recommender
+			orderCommission.CommissionGroup = orderCommissionAllGroup
+			orderCommission.Level = index + 1
+			err = srv.create(orderCommission)
+			if err != nil {
+				return
+			}
+			// 订单有退款或取消的子订单，则冲正佣金
+			err = srv.reverse(orderCommission, refundedAmounts)
+			if err != nil {
+				return
+			}
+		}
+		// 最后一级无需再查询上级推荐人
+		if index == len(ratios)-1 {
+			break
+		}
+		user, err := userSrv.FindByID(recommender)
+		if err != nil {
+			return err
+		}
+		recommender = user.Recommender
+	}
 
 	// 判断推荐人所在的销售分组
 	marketingGroup, err := userSrv.GetMarketingGroupFromCache(order.Recommender)
@@ -144,7 +649,7 @@ func (srv *OrderCommissionSrv) createOrUpdate(order *Order) (err error) {
 	if marketingGroup == "" {
 		return
 	}
-	conf := defaultOrderCommissionConfigs.Get(marketingGroup)
+	conf = defaultOrderCommissionConfigs.GetAt(marketingGroup, paidAt)
 	if conf == nil || conf.Ratio == 0 {
 		return
 	}
@@ -152,21 +657,122 @@ func (srv *OrderCommissionSrv) createOrUpdate(order *Order) (err error) {
 	if owner == 0 {
 		return
 	}
-	orderCommission = &OrderCommission{
-		UserID:           order.UserID,
-		OrderSN:          order.SN,
-		Recommender:      owner,
-		PayAmount:        order.PayAmount,
-		CommissionAmount: order.PayAmount * conf.Ratio,
-		CommissionGroup:  marketingGroup,
-	}
-	err = pgGetClient().FirstOrCreate(orderCommission, OrderCommission{
-		OrderSN:     order.SN,
-		Recommender: owner,
-	}).Error
+	orderCommission := srv.newOrderCommission(order, conf, subOrders, products, conf.Ratio)
+	orderCommission.Recommender = owner
+	orderCommission.CommissionGroup = marketingGroup
+	err = srv.create(orderCommission)
 	if err != nil {
 		return
 	}
+	err = srv.reverse(orderCommission, refundedAmounts)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// GetDownline get the downline tree of user, and the commissions generated at each level
+func (srv *OrderCommissionSrv) GetDownline(userID uint, depth int) (downline *OrderCommissionDownline, err error) {
+	if depth <= 0 || depth > OrderCommissionMaxLevel {
+		depth = OrderCommissionMaxLevel
+	}
+	// 该用户从各下线订单获取的佣金
+	type commissionSummary struct {
+		UserID uint
+		Level  int
+		Amount float64
+	}
+	summaries := make([]*commissionSummary, 0)
+	err = pgGetClient().Model(&OrderCommission{}).
+		Select("user_id, level, SUM(commission_amount - reversed_amount) AS amount").
+		Where("recommender = ? AND level > 0", userID).
+		Group("user_id, level").
+		Scan(&summaries).Error
+	if err != nil {
+		return
+	}
+	userAmounts := make(map[uint]float64)
+	downline = &OrderCommissionDownline{
+		Levels: make([]*OrderCommissionDownlineLevel, depth),
+		Nodes:  make([]*OrderCommissionDownlineNode, 0),
+	}
+	for index := range downline.Levels {
+		downline.Levels[index] = &OrderCommissionDownlineLevel{
+			Level: index + 1,
+		}
+	}
+	for _, item := range summaries {
+		userAmounts[item.UserID] += item.Amount
+		if item.Level <= depth {
+			downline.Levels[item.Level-1].CommissionAmount += item.Amount
+		}
+	}
+
+	visited := map[uint]bool{
+		userID: true,
+	}
+	parents := map[uint]*OrderCommissionDownlineNode{
+		userID: {
+			Children: make([]*OrderCommissionDownlineNode, 0),
+		},
+	}
+	for level := 1; level <= depth && len(parents) != 0; level++ {
+		ids := make([]uint, 0, len(parents))
+		for id := range parents {
+			ids = append(ids, id)
+		}
+		users, err := srv.listDownlineUsers(ids)
+		if err != nil {
+			return nil, err
+		}
+		nextParents := make(map[uint]*OrderCommissionDownlineNode)
+		for _, user := range users {
+			// 避免推荐关系出现循环
+			if visited[user.ID] {
+				continue
+			}
+			visited[user.ID] = true
+			node := &OrderCommissionDownlineNode{
+				ID:               user.ID,
+				Account:          user.Account,
+				Name:             user.Name,
+				Level:            level,
+				CommissionAmount: userAmounts[user.ID],
+			}
+			parent := parents[user.Recommender]
+			parent.Children = append(parent.Children, node)
+			if level == 1 {
+				downline.Nodes = append(downline.Nodes, node)
+			}
+			downline.Levels[level-1].Count++
+			nextParents[user.ID] = node
+		}
+		parents = nextParents
+	}
+	return
+}
+
+// listDownlineUsers list all users recommended by the recommenders
+func (srv *OrderCommissionSrv) listDownlineUsers(recommenders []uint) (users Users, err error) {
+	limit := 1000
+	users = make(Users, 0)
+	// 按id分页获取，避免下线较多时被截断
+	lastID := uint(0)
+	for {
+		result, err := userSrv.List(PGQueryParams{
+			Limit:  limit,
+			Order:  "id",
+			Fields: "id,account,name,recommender",
+		}, "recommender IN ? AND id > ?", recommenders, lastID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, result...)
+		if len(result) < limit {
+			break
+		}
+		lastID = result[len(result)-1].ID
+	}
 	return
 }
 
@@ -178,7 +784,7 @@ func (srv *OrderCommissionSrv) FindBySN(sn string) (orderCommission *OrderCommis
 
 // 生成佣金流水
 func (srv *OrderCommissionSrv) Do() (err error) {
-	done := true
+	done := false
 	limit := 100
 	offset := 0
 	maxCount := 1000
@@ -198,8 +804,9 @@ func (srv *OrderCommissionSrv) Do() (err error) {
 			// TODO 输出异常
 			break
 		}
+		// 仅已支付的订单（未支付而关闭的订单不计算佣金）
 		args := []interface{}{
-			"status = ? AND created_at >= ? AND created_at <= ? AND recommender IS NOT NULL",
+			"status = ? AND paid_at IS NOT NULL AND created_at >= ? AND created_at <= ? AND recommender IS NOT NULL",
 			OrderStatusClosed,
 			util.FormatTime(start),
 			util.FormatTime(end),
@@ -0,0 +1,347 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalcCommissionAmount(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		payAmount        float64
+		refundedAmount   float64
+		commissionAmount float64
+		reversal         string
+		expected         float64
+	}{
+		// 未退款
+		{
+			payAmount:        100,
+			commissionAmount: 10,
+			reversal:         OrderCommissionReversalFull,
+			expected:         10,
+		},
+		// 全额扣回
+		{
+			payAmount:        100,
+			refundedAmount:   20,
+			commissionAmount: 10,
+			reversal:         OrderCommissionReversalFull,
+			expected:         0,
+		},
+		// 未配置的冲正方式按全额扣回
+		{
+			payAmount:        100,
+			refundedAmount:   20,
+			commissionAmount: 10,
+			expected:         0,
+		},
+		// 按退款金额比例扣回
+		{
+			payAmount:        100,
+			refundedAmount:   25,
+			commissionAmount: 10,
+			reversal:         OrderCommissionReversalProportional,
+			expected:         7.5,
+		},
+		{
+			payAmount:        100,
+			refundedAmount:   150,
+			commissionAmount: 10,
+			reversal:         OrderCommissionReversalProportional,
+			expected:         0,
+		},
+		{
+			refundedAmount:   10,
+			commissionAmount: 10,
+			reversal:         OrderCommissionReversalProportional,
+			expected:         0,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, calcCommissionAmount(tt.payAmount, tt.refundedAmount, tt.commissionAmount, tt.reversal))
+	}
+}
+
+func TestGetLevelRatios(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		conf     *OrderCommissionConfig
+		expected []float64
+	}{
+		{
+			conf: &OrderCommissionConfig{
+				Ratio: 0.1,
+			},
+			expected: []float64{0.1},
+		},
+		{
+			conf: &OrderCommissionConfig{
+				Ratio:       0.1,
+				LevelRatios: []float64{0.05, 0.02},
+			},
+			expected: []float64{0.1, 0.05, 0.02},
+		},
+		// 最多5级
+		{
+			conf: &OrderCommissionConfig{
+				Ratio:       0.1,
+				LevelRatios: []float64{0.05, 0.04, 0.03, 0.02, 0.01},
+			},
+			expected: []float64{0.1, 0.05, 0.04, 0.03, 0.02},
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, tt.conf.GetLevelRatios())
+	}
+}
+
+func TestOrderCommissionConfigsGetAt(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	getTime := func(hours int) *time.Time {
+		value := now.Add(time.Duration(hours) * time.Hour)
+		return &value
+	}
+	// 第一版本在第二版本创建后失效
+	v1 := &OrderCommissionConfig{
+		Group:       orderCommissionAllGroup,
+		Ratio:       0.1,
+		Version:     "1",
+		enabled:     true,
+		versionFrom: getTime(0),
+		versionTo:   getTime(10),
+	}
+	v2 := &OrderCommissionConfig{
+		Group:       orderCommissionAllGroup,
+		Ratio:       0.2,
+		Version:     "2",
+		enabled:     true,
+		versionFrom: getTime(10),
+	}
+	// 已禁用的版本
+	v3 := &OrderCommissionConfig{
+		Group:       orderCommissionAllGroup,
+		Ratio:       0.5,
+		Version:     "3",
+		versionFrom: getTime(0),
+	}
+	// 限时活动的配置
+	v4 := &OrderCommissionConfig{
+		Group:       orderCommissionAllGroup,
+		Ratio:       0.3,
+		Version:     "4",
+		enabled:     true,
+		versionFrom: getTime(0),
+		BeginDate:   getTime(20),
+		EndDate:     getTime(30),
+	}
+	confs := &OrderCommissionConfigs{
+		configs: []*OrderCommissionConfig{
+			v1,
+			v2,
+			v3,
+			v4,
+		},
+	}
+
+	tests := []struct {
+		group    string
+		t        *time.Time
+		expected *OrderCommissionConfig
+	}{
+		{
+			group: orderCommissionAllGroup,
+			t:     getTime(-1),
+		},
+		{
+			group:    orderCommissionAllGroup,
+			t:        getTime(5),
+			expected: v1,
+		},
+		{
+			group:    orderCommissionAllGroup,
+			t:        getTime(10),
+			expected: v2,
+		},
+		// 生效时间开始较晚的优先
+		{
+			group:    orderCommissionAllGroup,
+			t:        getTime(25),
+			expected: v4,
+		},
+		{
+			group:    orderCommissionAllGroup,
+			t:        getTime(30),
+			expected: v2,
+		},
+		{
+			group: "sales",
+			t:     getTime(5),
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, confs.GetAt(tt.group, *tt.t))
+	}
+}
+
+func TestGetProductRatio(t *testing.T) {
+	assert := assert.New(t)
+	conf := &OrderCommissionConfig{
+		Ratio: 0.1,
+		ProductRatios: map[uint]float64{
+			1: 0.2,
+		},
+		CategoryRatios: map[uint]float64{
+			10: 0.05,
+			11: 0.08,
+		},
+		BrandRatios: map[uint]float64{
+			5: 0.3,
+		},
+	}
+	newProduct := func(id, brand uint, categories ...int64) *Product {
+		p := &Product{
+			Brand:      brand,
+			Categories: pq.Int64Array(categories),
+		}
+		p.ID = id
+		return p
+	}
+
+	tests := []struct {
+		product  *Product
+		ratio    float64
+		expected float64
+	}{
+		// 产品的比例优先
+		{
+			product:  newProduct(1, 5, 10),
+			ratio:    0.1,
+			expected: 0.2,
+		},
+		// 其它层级按相同比例缩放
+		{
+			product:  newProduct(1, 5, 10),
+			ratio:    0.05,
+			expected: 0.1,
+		},
+		// 属于多个分类时使用最低的比例
+		{
+			product:  newProduct(2, 5, 11, 10),
+			ratio:    0.1,
+			expected: 0.05,
+		},
+		{
+			product:  newProduct(3, 5),
+			ratio:    0.1,
+			expected: 0.3,
+		},
+		{
+			product:  newProduct(4, 6, 12),
+			ratio:    0.1,
+			expected: 0.1,
+		},
+		{
+			ratio:    0.1,
+			expected: 0.1,
+		},
+	}
+	for _, tt := range tests {
+		assert.InDelta(tt.expected, conf.GetProductRatio(tt.product, tt.ratio), 0.000001)
+	}
+}
+
+func TestCalcAmountAfterRefunded(t *testing.T) {
+	assert := assert.New(t)
+	orderCommission := &OrderCommission{
+		PayAmount:        150,
+		CommissionAmount: 12.5,
+		Details: OrderCommissionDetails{
+			{
+				SubOrder:         1,
+				ProductPayAmount: 100,
+				CommissionAmount: 10,
+			},
+			{
+				SubOrder:         2,
+				ProductPayAmount: 50,
+				CommissionAmount: 2.5,
+			},
+		},
+	}
+	// 未记录明细的历史佣金
+	noDetailsOrderCommission := &OrderCommission{
+		PayAmount:        150,
+		CommissionAmount: 15,
+	}
+
+	tests := []struct {
+		orderCommission *OrderCommission
+		refundedAmounts map[uint]float64
+		reversal        string
+		expected        float64
+	}{
+		{
+			orderCommission: orderCommission,
+			reversal:        OrderCommissionReversalProportional,
+			expected:        12.5,
+		},
+		{
+			orderCommission: orderCommission,
+			refundedAmounts: map[uint]float64{
+				1: 50,
+			},
+			reversal: OrderCommissionReversalFull,
+			expected: 0,
+		},
+		// 按子订单各自的佣金比例扣回
+		{
+			orderCommission: orderCommission,
+			refundedAmounts: map[uint]float64{
+				1: 50,
+			},
+			reversal: OrderCommissionReversalProportional,
+			expected: 7.5,
+		},
+		{
+			orderCommission: orderCommission,
+			refundedAmounts: map[uint]float64{
+				1: 100,
+				2: 50,
+			},
+			reversal: OrderCommissionReversalProportional,
+			expected: 0,
+		},
+		{
+			orderCommission: noDetailsOrderCommission,
+			refundedAmounts: map[uint]float64{
+				1: 30,
+			},
+			reversal: OrderCommissionReversalProportional,
+			expected: 12,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, tt.orderCommission.calcAmountAfterRefunded(tt.refundedAmounts, tt.reversal))
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubOrderStatusValidateNext(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		status SubOrderStatus
+		next   SubOrderStatus
+		valid  bool
+	}{
+		{
+			status: SubOrderStatusInited,
+			next:   SubOrderStatusToBeShipped,
+			valid:  true,
+		},
+		{
+			status: SubOrderStatusInited,
+			next:   SubOrderStatusInited,
+			valid:  false,
+		},
+		{
+			status: SubOrderStatusToBeShipped,
+			next:   SubOrderStatusDone,
+			valid:  false,
+		},
+		{
+			status: SubOrderStatusShipped,
+			next:   SubOrderStatusApplyRefunds,
+			valid:  true,
+		},
+		// 售后取消或关闭时恢复申请前的状态
+		{
+			status: SubOrderStatusApplyRefunds,
+			next:   SubOrderStatusShipped,
+			valid:  true,
+		},
+		{
+			status: SubOrderStatusApplyRefunds,
+			next:   SubOrderStatusDone,
+			valid:  true,
+		},
+		{
+			status: SubOrderStatusApplyRefunds,
+			next:   SubOrderStatusRefunding,
+			valid:  true,
+		},
+		// 部分退款恢复为完成，全额退款则为已退款
+		{
+			status: SubOrderStatusRefunding,
+			next:   SubOrderStatusDone,
+			valid:  true,
+		},
+		{
+			status: SubOrderStatusRefunding,
+			next:   SubOrderStatusRefunded,
+			valid:  true,
+		},
+		{
+			status: SubOrderStatusRefunding,
+			next:   SubOrderStatusShipped,
+			valid:  false,
+		},
+		{
+			status: SubOrderStatusDone,
+			next:   SubOrderStatusApplyRefunds,
+			valid:  true,
+		},
+		// 已退款为最终状态
+		{
+			status: SubOrderStatusRefunded,
+			next:   SubOrderStatusDone,
+			valid:  false,
+		},
+	}
+	for _, tt := range tests {
+		err := tt.status.ValidateNext(tt.next)
+		if tt.valid {
+			assert.Nil(err, "%s --> %s", tt.status.String(), tt.next.String())
+		} else {
+			assert.NotNil(err, "%s --> %s", tt.status.String(), tt.next.String())
+		}
+	}
+}
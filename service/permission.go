@@ -0,0 +1,481 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vicanso/hes"
+	lruTTL "github.com/vicanso/lru-ttl"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+type (
+	Roles []*Role
+	// Role 角色权限，名称对应用户的角色或分组
+	Role struct {
+		helper.Model
+
+		Name        string `json:"name,omitempty" gorm:"type:varchar(20);not null;uniqueIndex:idx_role_name"`
+		Description string `json:"description,omitempty"`
+		// 分类：用户角色(role)或用户分组(group)
+		Category string `json:"category,omitempty" gorm:"type:varchar(10)"`
+
+		Status     int    `json:"status,omitempty"`
+		StatusDesc string `json:"statusDesc,omitempty" gorm:"-"`
+
+		// 权限列表，格式为 resource:action，支持 resource:* 与 *
+		Permissions pq.StringArray `json:"permissions,omitempty" gorm:"type:text[]"`
+	}
+	// Permission 权限
+	Permission struct {
+		Name  string `json:"name,omitempty"`
+		Value string `json:"value,omitempty"`
+	}
+	RoleSrv struct{}
+)
+
+const (
+	// 角色权限的版本号，角色或用户权限变更时递增，用于刷新session中缓存的权限
+	rolePermissionVersionKey = "role-permission-version"
+)
+
+var (
+	errRoleNameExists = &hes.Error{
+		Message:    "该角色已存在",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errRoleGrantForbidden = &hes.Error{
+		Message:    "不可授予超出自身权限的角色或分组",
+		StatusCode: http.StatusForbidden,
+		Category:   errUserCategory,
+	}
+	errRoleNotFound = &hes.Error{
+		Message:    "角色(%s)不存在或已禁用",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+var (
+	// 权限描述
+	permissionsMap map[string]string
+	// 已启用角色缓存（以版本号为key）
+	enabledRolesCache *lruTTL.Cache
+	roleSrv           = new(RoleSrv)
+)
+
+func init() {
+	permissionsMap = map[string]string{
+		cs.PermissionUserRead:            "查询用户",
+		cs.PermissionUserUpdate:          "编辑用户",
+		cs.PermissionRoleRead:            "查询角色权限",
+		cs.PermissionRoleUpdate:          "编辑角色权限",
+		cs.PermissionConfigurationRead:   "查询系统配置",
+		cs.PermissionConfigurationUpdate: "编辑系统配置",
+		cs.PermissionProductRead:         "查询产品后台数据",
+		cs.PermissionProductUpdate:       "编辑产品",
+		cs.PermissionProductImport:       "导入产品",
+		cs.PermissionProductExport:       "导出产品",
+		cs.PermissionBrandRead:           "查询品牌后台数据",
+		cs.PermissionBrandUpdate:         "编辑品牌",
+		cs.PermissionSupplierRead:        "查询供应商",
+		cs.PermissionSupplierUpdate:      "编辑供应商",
+		cs.PermissionAdvertisementUpdate: "编辑广告",
+		cs.PermissionFileUpload:          "上传文件",
+		cs.PermissionRegionUpdate:        "编辑地区",
+		cs.PermissionRegionImport:        "导入地区",
+		cs.PermissionOrderRead:           "查询所有订单",
+		cs.PermissionOrderAssign:         "指派配送员",
+		cs.PermissionOrderDeliver:        "订单配送",
+		cs.PermissionAfterSaleRead:       "查询所有售后",
+		cs.PermissionAfterSaleHandle:     "处理售后",
+		cs.PermissionAfterSaleRefund:     "售后退款",
+		cs.PermissionCommissionRead:      "查询佣金提现",
+		cs.PermissionCommissionApprove:   "审核佣金提现",
+	}
+
+	ttl := time.Minute
+	if util.IsDevelopment() {
+		ttl = time.Second
+	}
+	enabledRolesCache = lruTTL.New(2, ttl)
+
+	err := helper.PGAutoMigrate(&Role{})
+	if err != nil {
+		panic(err)
+	}
+	err = roleSrv.initDefaultRoles()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (roles Roles) AfterFind(tx *gorm.DB) (err error) {
+	for _, r := range roles {
+		err = r.AfterFind(tx)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *Role) AfterFind(_ *gorm.DB) (err error) {
+	r.StatusDesc = getStatusDesc(r.Status)
+	return
+}
+
+// initDefaultRoles create the default roles if not exists,
+// the roles which have been modified or deleted will be kept
+func (srv *RoleSrv) initDefaultRoles() (err error) {
+	defaultRoles := []Role{
+		{
+			Name:        cs.UserRoleNormal,
+			Description: "普通用户",
+			Category:    cs.RoleCategoryRole,
+		},
+		{
+			Name:        cs.UserRoleSu,
+			Description: "超级用户",
+			Category:    cs.RoleCategoryRole,
+			Permissions: []string{
+				cs.PermissionAll,
+			},
+		},
+		{
+			Name:        cs.UserRoleAdmin,
+			Description: "管理员",
+			Category:    cs.RoleCategoryRole,
+			Permissions: []string{
+				cs.PermissionUserRead,
+				cs.PermissionUserUpdate,
+			},
+		},
+		{
+			Name:        cs.UserGroupIT,
+			Description: "研发部",
+			Category:    cs.RoleCategoryGroup,
+		},
+		{
+			Name:        cs.UserGroupMarketing,
+			Description: "市场部",
+			Category:    cs.RoleCategoryGroup,
+			Permissions: []string{
+				"product:*",
+				"brand:*",
+				"supplier:*",
+				"advertisement:*",
+				cs.PermissionFileUpload,
+				cs.PermissionRegionUpdate,
+				cs.PermissionOrderRead,
+				cs.PermissionOrderAssign,
+			},
+		},
+		{
+			Name:        cs.UserGroupLogistics,
+			Description: "物流部",
+			Category:    cs.RoleCategoryGroup,
+			Permissions: []string{
+				cs.PermissionOrderDeliver,
+				cs.PermissionAfterSaleRead,
+				cs.PermissionAfterSaleHandle,
+			},
+		},
+		{
+			Name:        cs.UserGroupFinance,
+			Description: "财务部",
+			Category:    cs.RoleCategoryGroup,
+			Permissions: []string{
+				cs.PermissionAfterSaleRead,
+				cs.PermissionAfterSaleRefund,
+				"commission:*",
+			},
+		},
+	}
+	for _, item := range defaultRoles {
+		item.Status = cs.StatusEnabled
+		err = pgGetClient().Unscoped().
+			Where(Role{
+				Name: item.Name,
+			}).
+			Attrs(item).
+			FirstOrCreate(&Role{}).Error
+		if err != nil {
+			return
+		}
+	}
+	// 未设置分类的角色（旧数据），按默认分组名称补充分类
+	err = pgGetClient().Unscoped().
+		Model(&Role{}).
+		Where("category IS NULL OR category = ''").
+		Where("name IN ?", cs.UserGroups).
+		Update("category", cs.RoleCategoryGroup).Error
+	if err != nil {
+		return
+	}
+	err = pgGetClient().Unscoped().
+		Model(&Role{}).
+		Where("category IS NULL OR category = ''").
+		Update("category", cs.RoleCategoryRole).Error
+	return
+}
+
+// ListPermission list all permissions
+func (srv *RoleSrv) ListPermission() []*Permission {
+	permissions := make([]*Permission, 0, len(cs.Permissions))
+	for _, value := range cs.Permissions {
+		permissions = append(permissions, &Permission{
+			Name:  permissionsMap[value],
+			Value: value,
+		})
+	}
+	return permissions
+}
+
+// createByID create a role model by id
+func (srv *RoleSrv) createByID(id uint) *Role {
+	r := &Role{}
+	r.Model.ID = id
+	return r
+}
+
+// Add add role
+func (srv *RoleSrv) Add(data Role) (role *Role, err error) {
+	count, err := pgCount(&Role{}, "name = ?", data.Name)
+	if err != nil {
+		return
+	}
+	if count != 0 {
+		err = errRoleNameExists
+		return
+	}
+	role = &data
+	err = pgCreate(role)
+	if err != nil {
+		return
+	}
+	err = srv.IncreaseVersion()
+	return
+}
+
+// UpdateByID update role by id
+func (srv *RoleSrv) UpdateByID(id uint, role Role) (err error) {
+	err = pgGetClient().Model(srv.createByID(id)).Updates(role).Error
+	if err != nil {
+		return
+	}
+	err = srv.IncreaseVersion()
+	return
+}
+
+// FindByID find role by id
+func (srv *RoleSrv) FindByID(id uint) (role *Role, err error) {
+	role = new(Role)
+	err = pgGetClient().First(role, "id = ?", id).Error
+	return
+}
+
+// List list role
+func (srv *RoleSrv) List(params PGQueryParams, args ...interface{}) (result Roles, err error) {
+	result = make(Roles, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// GetVersion get the version of role permissions
+func (srv *RoleSrv) GetVersion() (version string, err error) {
+	return redisSrv.GetIgnoreNilErr(rolePermissionVersionKey)
+}
+
+// IncreaseVersion increase the version of role permissions,
+// the permissions cached in session will be refreshed
+func (srv *RoleSrv) IncreaseVersion() (err error) {
+	return helper.RedisGetClient().Incr(rolePermissionVersionKey).Err()
+}
+
+// listEnabled list all enabled roles, the result is cached by version
+func (srv *RoleSrv) listEnabled(version string) (roles Roles, err error) {
+	value, ok := enabledRolesCache.Get(version)
+	if ok {
+		return value.(Roles), nil
+	}
+	roles, err = srv.List(PGQueryParams{
+		Order: "id",
+	}, "status = ?", cs.StatusEnabled)
+	if err != nil {
+		return
+	}
+	enabledRolesCache.Add(version, roles)
+	return
+}
+
+// getRolePermissions get the permissions of all enabled roles
+func (srv *RoleSrv) getRolePermissions(version string) (rolePermissions map[string][]string, err error) {
+	roles, err := srv.listEnabled(version)
+	if err != nil {
+		return
+	}
+	rolePermissions = make(map[string][]string)
+	for _, r := range roles {
+		rolePermissions[r.Name] = r.Permissions
+	}
+	return
+}
+
+// ListEnabled list the enabled roles of category(user role or group)
+func (srv *RoleSrv) ListEnabled(category string) (result Roles, err error) {
+	version, err := srv.GetVersion()
+	if err != nil {
+		return
+	}
+	roles, err := srv.listEnabled(version)
+	if err != nil {
+		return
+	}
+	result = make(Roles, 0, len(roles))
+	for _, r := range roles {
+		if r.Category == category {
+			result = append(result, r)
+		}
+	}
+	return
+}
+
+// ValidateNames check the names are all enabled roles of category
+func (srv *RoleSrv) ValidateNames(category string, names []string) (err error) {
+	roles, err := srv.ListEnabled(category)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		found := false
+		for _, r := range roles {
+			if r.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errRoleNotFound.CloneWithMessage(fmt.Sprintf(errRoleNotFound.Message, name))
+		}
+	}
+	return
+}
+
+// canGrantRoles check the operator can grant the roles, the operator
+// with role:update permission can grant all roles, otherwise the operator
+// should have all the permissions of roles
+func canGrantRoles(operatorPermissions []string, rolePermissions map[string][]string, roles []string) bool {
+	if util.HasPermission(operatorPermissions, cs.PermissionRoleUpdate) {
+		return true
+	}
+	for _, role := range roles {
+		if !util.HasAllPermissions(operatorPermissions, rolePermissions[role]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateGrant check the operator can grant the roles(include user roles and groups)
+func (srv *RoleSrv) ValidateGrant(operatorPermissions []string, roles ...string) (err error) {
+	if len(roles) == 0 {
+		return
+	}
+	version, err := srv.GetVersion()
+	if err != nil {
+		return
+	}
+	rolePermissions, err := srv.getRolePermissions(version)
+	if err != nil {
+		return
+	}
+	if !canGrantRoles(operatorPermissions, rolePermissions, roles) {
+		err = errRoleGrantForbidden
+	}
+	return
+}
+
+// GetPermissions get the permissions of roles(include user roles and groups)
+func (srv *RoleSrv) GetPermissions(version string, roles ...string) (permissions []string, err error) {
+	rolePermissions, err := srv.getRolePermissions(version)
+	if err != nil {
+		return
+	}
+	permissions = make([]string, 0)
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if !util.ContainsString(permissions, p) {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return
+}
+
+// GetPermissions get the permissions of user, it will be cached in session
+// until the version of role permissions changed
+func (us *UserSession) GetPermissions() (permissions []string, err error) {
+	info, err := us.GetInfo()
+	if err != nil {
+		return
+	}
+	version, err := roleSrv.GetVersion()
+	if err != nil {
+		return
+	}
+	if info.Permissions != nil && info.PermissionVersion == version {
+		return info.Permissions, nil
+	}
+	// 从数据库中获取用户最新的角色与分组
+	u, err := userSrv.FindByID(info.ID)
+	if err != nil {
+		return
+	}
+	roles := make([]string, 0, len(u.Roles)+len(u.Groups))
+	roles = append(roles, u.Roles...)
+	roles = append(roles, u.Groups...)
+	permissions, err = roleSrv.GetPermissions(version, roles...)
+	if err != nil {
+		return
+	}
+	info.Permissions = permissions
+	info.PermissionVersion = version
+	err = us.saveInfo(info)
+	return
+}
+
+// HasPermission check the user has the permission,
+// the session of api token is also limited by the permissions of token
+func (us *UserSession) HasPermission(permission string) (bool, error) {
+	permissions, err := us.GetPermissions()
+	if err != nil {
+		return false, err
+	}
+	info := us.MustGetInfo()
+	if info.APIToken != 0 &&
+		!util.HasPermission(info.APITokenPermissions, permission) {
+		return false, nil
+	}
+	return util.HasPermission(permissions, permission), nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/origin/cs"
+)
+
+func TestCanGrantRoles(t *testing.T) {
+	assert := assert.New(t)
+	rolePermissions := map[string][]string{
+		cs.UserRoleSu: {
+			cs.PermissionAll,
+		},
+		cs.UserRoleAdmin: {
+			cs.PermissionUserRead,
+			cs.PermissionUserUpdate,
+		},
+		cs.UserGroupFinance: {
+			cs.PermissionAfterSaleRead,
+			"commission:*",
+		},
+		"auditor": {
+			cs.PermissionUserRead,
+		},
+	}
+	adminPermissions := rolePermissions[cs.UserRoleAdmin]
+
+	tests := []struct {
+		permissions []string
+		roles       []string
+		expected    bool
+	}{
+		// 权限范围内的角色可授予
+		{
+			permissions: adminPermissions,
+			roles:       []string{cs.UserRoleAdmin, "auditor"},
+			expected:    true,
+		},
+		// 管理员不可授予超出自身权限的角色或分组
+		{
+			permissions: adminPermissions,
+			roles:       []string{cs.UserRoleSu},
+			expected:    false,
+		},
+		{
+			permissions: adminPermissions,
+			roles:       []string{"auditor", cs.UserGroupFinance},
+			expected:    false,
+		},
+		// 拥有角色编辑权限可授予所有角色
+		{
+			permissions: []string{cs.PermissionRoleUpdate},
+			roles:       []string{cs.UserRoleSu, cs.UserGroupFinance},
+			expected:    true,
+		},
+		{
+			permissions: []string{cs.PermissionAll},
+			roles:       []string{cs.UserRoleSu},
+			expected:    true,
+		},
+		// 不存在的角色无权限
+		{
+			permissions: nil,
+			roles:       []string{"unknown"},
+			expected:    true,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, canGrantRoles(tt.permissions, rolePermissions, tt.roles))
+	}
+}
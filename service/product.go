@@ -77,6 +77,14 @@ type (
 
 		// 是否有效(是否可购买)
 		Available bool `json:"available,omitempty" gorm:"-"`
+
+		// 近一周、近一月销量以及热度（由定时任务计算）
+		WeekSales  int64   `json:"weekSales,omitempty" gorm:"not null;default:0"`
+		MonthSales int64   `json:"monthSales,omitempty" gorm:"not null;default:0"`
+		Popularity float64 `json:"popularity,omitempty" gorm:"not null;default:0;index:idx_product_popularity"`
+
+		// 全文搜索（名称、关键字、品牌、分类以及拼音）
+		SearchVector string `json:"-" gorm:"type:tsvector;index:idx_product_search_vector,type:gin"`
 	}
 	ProductCategories []*ProductCategory
 	// ProductCategory product category
@@ -228,13 +236,48 @@ func (product *Product) CheckAvailable() error {
 // Add add product
 func (srv *ProductSrv) Add(data Product) (product *Product, err error) {
 	product = &data
-	err = pgCreate(product)
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = tx.Create(product).Error
+		if err != nil {
+			return
+		}
+		return srv.addInitialPrice(tx, product.ID, 0, product.Price)
+	})
+	if err != nil {
+		return
+	}
+	err = srv.RefreshSearchVector(product.ID)
 	return
 }
 
-// UpdateByID update product by id
-func (srv *ProductSrv) UpdateByID(id uint, product Product) (err error) {
-	err = pgGetClient().Model(srv.createByID(id)).Updates(product).Error
+// UpdateByID update product by id, the changes are recorded as catalog history
+func (srv *ProductSrv) UpdateByID(id uint, product Product, operator uint) (err error) {
+	// 价格调整记录价格历史
+	if product.Price != 0 {
+		current, e := srv.FindByID(id)
+		if e != nil {
+			err = e
+			return
+		}
+		if current.Price != product.Price {
+			_, err = srv.SchedulePrice(ProductPriceParams{
+				Product: id,
+				Price:   product.Price,
+				Creator: operator,
+			})
+			if err != nil {
+				return
+			}
+		}
+		product.Price = 0
+	}
+	err = catalogHistorySrv.Track(CatalogProduct, id, operator, CatalogActionUpdate, func() error {
+		return pgGetClient().Model(srv.createByID(id)).Updates(product).Error
+	})
+	if err != nil {
+		return
+	}
+	err = srv.RefreshSearchVector(id)
 	return
 }
 
@@ -245,6 +288,14 @@ func (srv *ProductSrv) FindByID(id uint) (product *Product, err error) {
 	return
 }
 
+// findByIDUnscoped find product by id include the deleted one,
+// it is used for the history data such as orders
+func (srv *ProductSrv) findByIDUnscoped(id uint) (product *Product, err error) {
+	product = new(Product)
+	err = pgGetClient().Unscoped().First(product, "id = ?", id).Error
+	return
+}
+
 // List list product
 func (srv *ProductSrv) List(params PGQueryParams, args ...interface{}) (result Products, err error) {
 	result = make(Products, 0)
@@ -265,14 +316,26 @@ func (srv *ProductSrv) createCategoryByID(id uint) *ProductCategory {
 
 // AddCategory add category
 func (srv *ProductSrv) AddCategory(data ProductCategory) (cat *ProductCategory, err error) {
+	err = srv.ValidateCategory(0, data.Level, data.Belongs)
+	if err != nil {
+		return
+	}
 	cat = &data
 	err = pgCreate(cat)
+	if err != nil {
+		return
+	}
+	srv.clearCategoryTreeCache()
 	return
 }
 
 // UpdateCategoryByID update category by id
 func (srv *ProductSrv) UpdateCategoryByID(id uint, value interface{}) (err error) {
 	err = pgGetClient().Model(srv.createCategoryByID(id)).Updates(value).Error
+	if err != nil {
+		return
+	}
+	srv.clearCategoryTreeCache()
 	return
 }
 
@@ -305,7 +368,9 @@ func (srv *ProductSrv) GetCategoryNameFromCache(id uint) (name string, err error
 	if ok {
 		return value.(string), nil
 	}
-	cat, err := srv.FindCategoryByID(id)
+	// 已删除的分类仍展示其名称
+	cat := new(ProductCategory)
+	err = pgGetClient().Unscoped().First(cat, "id = ?", id).Error
 	if err != nil {
 		return
 	}
@@ -0,0 +1,498 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vicanso/hes"
+	lruTTL "github.com/vicanso/lru-ttl"
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+type (
+	// ProductCategoryTreeNode 分类树节点，分类可属于多个上级分类，因此可能出现在多个节点下
+	ProductCategoryTreeNode struct {
+		ID     uint   `json:"id,omitempty"`
+		Name   string `json:"name,omitempty"`
+		Level  int    `json:"level,omitempty"`
+		Rank   int    `json:"rank,omitempty"`
+		Icon   string `json:"icon,omitempty"`
+		Status int    `json:"status,omitempty"`
+		// 直接属于该分类的产品数
+		ProductCount int64 `json:"productCount"`
+		// 属于该分类及其子分类的产品数（已去重）
+		TotalProductCount int64                      `json:"totalProductCount"`
+		Children          []*ProductCategoryTreeNode `json:"children,omitempty"`
+	}
+
+	// productCategoryGraph 分类之间的关系
+	productCategoryGraph struct {
+		categories map[uint]*ProductCategory
+		children   map[uint][]uint
+		// 按排序的所有分类id
+		ids []uint
+	}
+	productCategoryTreeCacheValue struct {
+		graph *productCategoryGraph
+		tree  []*ProductCategoryTreeNode
+	}
+)
+
+const (
+	// 分类最大层级
+	productCategoryMaxLevel = 3
+	productCategoryTreeKey  = "tree"
+	// 分类路径的分隔符，如：食品/零食/坚果
+	productCategoryPathSeparator = "/"
+)
+
+var (
+	errProductCategoryParentInvalid = &hes.Error{
+		Message:    "上级分类(%d)不存在或层级不低于该分类",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductCategory,
+	}
+	errProductCategoryCycle = &hes.Error{
+		Message:    "不能将分类移动至其自身或子分类下",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductCategory,
+	}
+	errProductCategoryLevelInvalid = &hes.Error{
+		Message:    "分类层级不能高于子分类层级且不能超过3级",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductCategory,
+	}
+)
+
+var (
+	productCategoryTreeCache *lruTTL.Cache
+)
+
+func init() {
+	ttl := time.Minute
+	if util.IsDevelopment() {
+		ttl = time.Second
+	}
+	productCategoryTreeCache = lruTTL.New(1, ttl)
+}
+
+func newProductCategoryGraph(cats ProductCategories) *productCategoryGraph {
+	g := &productCategoryGraph{
+		categories: make(map[uint]*ProductCategory),
+		children:   make(map[uint][]uint),
+		ids:        make([]uint, 0, len(cats)),
+	}
+	for _, cat := range cats {
+		g.categories[cat.ID] = cat
+		g.ids = append(g.ids, cat.ID)
+	}
+	for _, id := range g.ids {
+		for _, parent := range g.categories[id].Belongs {
+			g.children[uint(parent)] = append(g.children[uint(parent)], id)
+		}
+	}
+	return g
+}
+
+// isRoot 无上级分类或上级分类均不存在
+func (g *productCategoryGraph) isRoot(id uint) bool {
+	for _, parent := range g.categories[id].Belongs {
+		if _, ok := g.categories[uint(parent)]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// descendants get the id and all descendants' ids
+func (g *productCategoryGraph) descendants(id uint) []uint {
+	result := []uint{
+		id,
+	}
+	visited := map[uint]bool{
+		id: true,
+	}
+	for i := 0; i < len(result); i++ {
+		for _, child := range g.children[result[i]] {
+			if visited[child] {
+				continue
+			}
+			visited[child] = true
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+// isCycle check whether any of the parents is the category itself or its descendant
+func (g *productCategoryGraph) isCycle(id uint, belongs []int64) bool {
+	descendants := make(map[uint]bool)
+	for _, item := range g.descendants(id) {
+		descendants[item] = true
+	}
+	for _, parent := range belongs {
+		if descendants[uint(parent)] {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestors get the id and all ancestors' ids
+func (g *productCategoryGraph) ancestors(id uint) []uint {
+	result := []uint{
+		id,
+	}
+	visited := map[uint]bool{
+		id: true,
+	}
+	for i := 0; i < len(result); i++ {
+		cat, ok := g.categories[result[i]]
+		if !ok {
+			continue
+		}
+		for _, parent := range cat.Belongs {
+			if visited[uint(parent)] {
+				continue
+			}
+			visited[uint(parent)] = true
+			result = append(result, uint(parent))
+		}
+	}
+	return result
+}
+
+// path get the full path of category, the first existing parent is used
+// if it belongs to more than one category, the id is returned if not found
+func (g *productCategoryGraph) path(id uint) string {
+	names := make([]string, 0, productCategoryMaxLevel)
+	visited := make(map[uint]bool)
+	current := id
+	for {
+		cat, ok := g.categories[current]
+		if !ok || visited[current] {
+			break
+		}
+		visited[current] = true
+		names = append([]string{cat.Name}, names...)
+		next := uint(0)
+		for _, parent := range cat.Belongs {
+			if _, ok := g.categories[uint(parent)]; ok {
+				next = uint(parent)
+				break
+			}
+		}
+		if next == 0 {
+			break
+		}
+		current = next
+	}
+	if len(names) == 0 {
+		return strconv.Itoa(int(id))
+	}
+	return strings.Join(names, productCategoryPathSeparator)
+}
+
+// matchPath check the category matches the path(names from ancestor to itself)
+func (g *productCategoryGraph) matchPath(id uint, names []string, visiting map[uint]bool) bool {
+	cat, ok := g.categories[id]
+	if !ok || visiting[id] || cat.Name != names[len(names)-1] {
+		return false
+	}
+	if len(names) == 1 {
+		return true
+	}
+	visiting[id] = true
+	defer delete(visiting, id)
+	for _, parent := range cat.Belongs {
+		if g.matchPath(uint(parent), names[:len(names)-1], visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve get the category id by id or path, the path can be the
+// name if it is unique, otherwise the full path should be used
+func (g *productCategoryGraph) resolve(value string) (ids []uint) {
+	ids = make([]uint, 0)
+	if id, err := strconv.Atoi(value); err == nil {
+		if _, ok := g.categories[uint(id)]; ok {
+			ids = append(ids, uint(id))
+		}
+		return
+	}
+	names := strings.Split(value, productCategoryPathSeparator)
+	for index, name := range names {
+		names[index] = strings.TrimSpace(name)
+	}
+	for _, id := range g.ids {
+		if g.matchPath(id, names, make(map[uint]bool)) {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+func (g *productCategoryGraph) buildNode(id uint, visiting map[uint]bool, counts, totalCounts map[uint]int64) *ProductCategoryTreeNode {
+	cat := g.categories[id]
+	node := &ProductCategoryTreeNode{
+		ID:                cat.ID,
+		Name:              cat.Name,
+		Level:             cat.Level,
+		Rank:              cat.Rank,
+		Icon:              cat.Icon,
+		Status:            cat.Status,
+		ProductCount:      counts[id],
+		TotalProductCount: totalCounts[id],
+	}
+	// 避免脏数据导致死循环
+	visiting[id] = true
+	defer delete(visiting, id)
+	for _, child := range g.children[id] {
+		if visiting[child] {
+			continue
+		}
+		node.Children = append(node.Children, g.buildNode(child, visiting, counts, totalCounts))
+	}
+	return node
+}
+
+func (srv *ProductSrv) clearCategoryTreeCache() {
+	productCategoryTreeCache.Remove(productCategoryTreeKey)
+}
+
+// listAllCategory list all categories order by rank
+func (srv *ProductSrv) listAllCategory() (result ProductCategories, err error) {
+	result = make(ProductCategories, 0)
+	err = pgGetClient().Order("rank, id").Find(&result).Error
+	return
+}
+
+// countProductByCategories count products group by categories
+func (srv *ProductSrv) countProductByCategories(g *productCategoryGraph) (counts, totalCounts map[uint]int64, err error) {
+	items := make([]*struct {
+		Categories pq.Int64Array
+		Count      int64
+	}, 0)
+	// 相同分类组合的产品一起统计，减少数据量
+	err = pgGetClient().Model(&Product{}).
+		Select("categories, COUNT(*) AS count").
+		Group("categories").
+		Scan(&items).Error
+	if err != nil {
+		return
+	}
+	counts = make(map[uint]int64)
+	totalCounts = make(map[uint]int64)
+	for _, item := range items {
+		ancestors := make(map[uint]bool)
+		for _, id := range item.Categories {
+			counts[uint(id)] += item.Count
+			for _, ancestor := range g.ancestors(uint(id)) {
+				ancestors[ancestor] = true
+			}
+		}
+		for id := range ancestors {
+			totalCounts[id] += item.Count
+		}
+	}
+	return
+}
+
+func (srv *ProductSrv) getCategoryTreeCache() (value *productCategoryTreeCacheValue, err error) {
+	cacheValue, ok := productCategoryTreeCache.Get(productCategoryTreeKey)
+	if ok {
+		return cacheValue.(*productCategoryTreeCacheValue), nil
+	}
+	cats, err := srv.listAllCategory()
+	if err != nil {
+		return
+	}
+	g := newProductCategoryGraph(cats)
+	counts, totalCounts, err := srv.countProductByCategories(g)
+	if err != nil {
+		return
+	}
+	tree := make([]*ProductCategoryTreeNode, 0)
+	for _, id := range g.ids {
+		if g.isRoot(id) {
+			tree = append(tree, g.buildNode(id, make(map[uint]bool), counts, totalCounts))
+		}
+	}
+	value = &productCategoryTreeCacheValue{
+		graph: g,
+		tree:  tree,
+	}
+	productCategoryTreeCache.Add(productCategoryTreeKey, value)
+	return
+}
+
+// GetCategoryTree get the category tree(cached)
+func (srv *ProductSrv) GetCategoryTree() (tree []*ProductCategoryTreeNode, err error) {
+	value, err := srv.getCategoryTreeCache()
+	if err != nil {
+		return
+	}
+	tree = value.tree
+	return
+}
+
+// GetCategoryDescendants get the category and all its descendants
+func (srv *ProductSrv) GetCategoryDescendants(id uint) (ids []uint, err error) {
+	value, err := srv.getCategoryTreeCache()
+	if err != nil {
+		return
+	}
+	ids = value.graph.descendants(id)
+	return
+}
+
+// ValidateCategory validate the level and belongs of category,
+// the parents should exist and their levels should be lower than the category,
+// the id is 0 for new category
+func (srv *ProductSrv) ValidateCategory(id uint, level int, belongs []int64) (err error) {
+	cats, err := srv.listAllCategory()
+	if err != nil {
+		return
+	}
+	g := newProductCategoryGraph(cats)
+	if id != 0 {
+		cat, ok := g.categories[id]
+		if !ok {
+			err = gorm.ErrRecordNotFound
+			return
+		}
+		if level == 0 {
+			level = cat.Level
+		}
+		if belongs == nil {
+			belongs = cat.Belongs
+		}
+		// 子分类的层级需要高于该分类
+		for _, child := range g.children[id] {
+			if g.categories[child].Level <= level {
+				err = errProductCategoryLevelInvalid
+				return
+			}
+		}
+	}
+	if id != 0 && g.isCycle(id, belongs) {
+		err = errProductCategoryCycle
+		return
+	}
+	for _, parent := range belongs {
+		cat, ok := g.categories[uint(parent)]
+		if !ok || cat.Level >= level {
+			err = errProductCategoryParentInvalid.CloneWithMessage(fmt.Sprintf(errProductCategoryParentInvalid.Message, parent))
+			return
+		}
+	}
+	return
+}
+
+// MoveCategory move the category to new parents, the level of category and
+// its descendants will be recalculated
+func (srv *ProductSrv) MoveCategory(id uint, belongs []int64) (err error) {
+	cats, err := srv.listAllCategory()
+	if err != nil {
+		return
+	}
+	g := newProductCategoryGraph(cats)
+	cat, ok := g.categories[id]
+	if !ok {
+		err = gorm.ErrRecordNotFound
+		return
+	}
+	if g.isCycle(id, belongs) {
+		err = errProductCategoryCycle
+		return
+	}
+	descendants := g.descendants(id)
+	affected := make(map[uint]bool)
+	for _, item := range descendants {
+		affected[item] = true
+	}
+	for _, parent := range belongs {
+		if _, ok := g.categories[uint(parent)]; !ok {
+			err = errProductCategoryParentInvalid.CloneWithMessage(fmt.Sprintf(errProductCategoryParentInvalid.Message, parent))
+			return
+		}
+	}
+	cat.Belongs = belongs
+	g = newProductCategoryGraph(cats)
+
+	// 重新计算该分类及子分类的层级：上级分类的最大层级+1
+	levels := make(map[uint]int)
+	var calcLevel func(uint) int
+	calcLevel = func(current uint) int {
+		if !affected[current] {
+			return g.categories[current].Level
+		}
+		if value, ok := levels[current]; ok {
+			return value
+		}
+		value := 1
+		for _, parent := range g.categories[current].Belongs {
+			if _, ok := g.categories[uint(parent)]; !ok {
+				continue
+			}
+			parentLevel := calcLevel(uint(parent))
+			if parentLevel+1 > value {
+				value = parentLevel + 1
+			}
+		}
+		levels[current] = value
+		return value
+	}
+	for _, item := range descendants {
+		if calcLevel(item) > productCategoryMaxLevel {
+			err = errProductCategoryLevelInvalid
+			return
+		}
+	}
+
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = tx.Model(srv.createCategoryByID(id)).Updates(map[string]interface{}{
+			"belongs": pq.Int64Array(belongs),
+			"level":   levels[id],
+		}).Error
+		if err != nil {
+			return
+		}
+		for item, level := range levels {
+			if item == id || g.categories[item].Level == level {
+				continue
+			}
+			err = tx.Model(srv.createCategoryByID(item)).Update("level", level).Error
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	srv.clearCategoryTreeCache()
+	return
+}
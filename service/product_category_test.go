@@ -0,0 +1,83 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductCategoryIsCycle(t *testing.T) {
+	assert := assert.New(t)
+	g := newTestProductCategoryGraph()
+
+	assert.Equal([]uint{1, 3, 4, 6}, g.descendants(1))
+
+	tests := []struct {
+		id       uint
+		belongs  []int64
+		expected bool
+	}{
+		// 不能移动至自身下
+		{
+			id:       1,
+			belongs:  []int64{1},
+			expected: true,
+		},
+		// 不能移动至子分类下
+		{
+			id:       1,
+			belongs:  []int64{6},
+			expected: true,
+		},
+		{
+			id:       3,
+			belongs:  []int64{2, 6},
+			expected: true,
+		},
+		{
+			id:       3,
+			belongs:  []int64{2},
+			expected: false,
+		},
+		{
+			id:       6,
+			belongs:  []int64{3, 5},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, g.isCycle(tt.id, tt.belongs))
+	}
+
+	// 脏数据中已存在循环
+	newCategory := func(id uint, belongs ...int64) *ProductCategory {
+		cat := &ProductCategory{
+			Belongs: pq.Int64Array(belongs),
+		}
+		cat.ID = id
+		return cat
+	}
+	g = newProductCategoryGraph(ProductCategories{
+		newCategory(1, 2),
+		newCategory(2, 1),
+		newCategory(3),
+	})
+	assert.Equal([]uint{1, 2}, g.descendants(1))
+	assert.True(g.isCycle(1, []int64{2}))
+	assert.False(g.isCycle(1, []int64{3}))
+}
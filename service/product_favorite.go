@@ -0,0 +1,267 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	ProductFavorites []*ProductFavorite
+	// ProductFavorite 用户收藏的产品
+	ProductFavorite struct {
+		helper.Model
+
+		UserID  uint `json:"userID,omitempty" gorm:"index:idx_product_favorite_user;not null"`
+		Product uint `json:"product,omitempty" gorm:"index:idx_product_favorite_product;not null"`
+	}
+
+	ProductRestockSubscriptions []*ProductRestockSubscription
+	// ProductRestockSubscription 产品到货（可购买）通知订阅
+	ProductRestockSubscription struct {
+		helper.Model
+
+		UserID  uint `json:"userID,omitempty" gorm:"index:idx_product_restock_user;not null"`
+		Product uint `json:"product,omitempty" gorm:"index:idx_product_restock_product;not null"`
+		// 通知时间，为空表示未通知
+		NotifiedAt *time.Time `json:"notifiedAt,omitempty" gorm:"index:idx_product_restock_notified_at"`
+	}
+
+	ProductFavoriteSrv struct{}
+)
+
+const (
+	errProductFavoriteCategory = "product-favorite"
+)
+
+var (
+	errProductRestockNotNeeded = &hes.Error{
+		Message:    "该产品可购买，无需订阅到货通知",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductFavoriteCategory,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(
+		&ProductFavorite{},
+		&ProductRestockSubscription{},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// IsPurchasable check the product is purchasable,
+// the product should be available and has stock if it has skus
+func (srv *ProductSrv) IsPurchasable(p *Product) (purchasable bool, err error) {
+	if !p.IsAvailable() {
+		return
+	}
+	skus, err := srv.ListSKUByProduct(p.ID)
+	if err != nil {
+		return
+	}
+	if len(skus) == 0 {
+		purchasable = true
+		return
+	}
+	for _, sku := range skus {
+		if sku.Status == cs.StatusEnabled && sku.Stock > 0 {
+			purchasable = true
+			return
+		}
+	}
+	return
+}
+
+// Add add product to favorites
+func (srv *ProductFavoriteSrv) Add(userID, productID uint) (favorite *ProductFavorite, err error) {
+	_, err = productSrv.FindByID(productID)
+	if err != nil {
+		return
+	}
+	favorite = &ProductFavorite{}
+	// 重复收藏则返回已有记录
+	err = pgGetClient().
+		Where(ProductFavorite{
+			UserID:  userID,
+			Product: productID,
+		}).
+		FirstOrCreate(favorite).Error
+	return
+}
+
+// Remove remove product from favorites
+func (srv *ProductFavoriteSrv) Remove(userID, productID uint) (err error) {
+	err = pgGetClient().
+		Where("user_id = ? AND product = ?", userID, productID).
+		Delete(&ProductFavorite{}).Error
+	return
+}
+
+// List list favorites
+func (srv *ProductFavoriteSrv) List(params PGQueryParams, args ...interface{}) (result ProductFavorites, err error) {
+	result = make(ProductFavorites, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// Count count favorites
+func (srv *ProductFavoriteSrv) Count(args ...interface{}) (count int64, err error) {
+	return pgCount(&ProductFavorite{}, args...)
+}
+
+// ListProducts list the favorite products of user
+func (srv *ProductFavoriteSrv) ListProducts(userID uint, params PGQueryParams) (products Products, err error) {
+	favorites, err := srv.List(params, "user_id = ?", userID)
+	if err != nil {
+		return
+	}
+	products = make(Products, 0, len(favorites))
+	if len(favorites) == 0 {
+		return
+	}
+	ids := make([]string, len(favorites))
+	for index, item := range favorites {
+		ids[index] = strconv.Itoa(int(item.Product))
+	}
+	result, err := productSrv.List(PGQueryParams{
+		Limit: len(ids),
+	}, "id IN (?)", ids)
+	if err != nil {
+		return
+	}
+	// 按收藏的顺序返回
+	for _, item := range favorites {
+		for _, p := range result {
+			if p.ID == item.Product {
+				products = append(products, p)
+				break
+			}
+		}
+	}
+	return
+}
+
+// Subscribe subscribe the restock notification of product
+func (srv *ProductFavoriteSrv) Subscribe(userID, productID uint) (sub *ProductRestockSubscription, err error) {
+	product, err := productSrv.FindByID(productID)
+	if err != nil {
+		return
+	}
+	purchasable, err := productSrv.IsPurchasable(product)
+	if err != nil {
+		return
+	}
+	if purchasable {
+		err = errProductRestockNotNeeded
+		return
+	}
+	sub = &ProductRestockSubscription{}
+	err = pgGetClient().
+		Where("user_id = ? AND product = ? AND notified_at IS NULL", userID, productID).
+		FirstOrCreate(sub, ProductRestockSubscription{
+			UserID:  userID,
+			Product: productID,
+		}).Error
+	return
+}
+
+// Unsubscribe cancel the restock notification of product
+func (srv *ProductFavoriteSrv) Unsubscribe(userID, productID uint) (err error) {
+	err = pgGetClient().
+		Where("user_id = ? AND product = ? AND notified_at IS NULL", userID, productID).
+		Delete(&ProductRestockSubscription{}).Error
+	return
+}
+
+// ListSubscription list the restock subscriptions
+func (srv *ProductFavoriteSrv) ListSubscription(params PGQueryParams, args ...interface{}) (result ProductRestockSubscriptions, err error) {
+	result = make(ProductRestockSubscriptions, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// NotifyRestock notify the subscribers of products which are purchasable
+func (srv *ProductFavoriteSrv) NotifyRestock() (err error) {
+	productIDs := make([]uint, 0)
+	err = pgGetClient().Model(&ProductRestockSubscription{}).
+		Where("notified_at IS NULL").
+		Distinct().
+		Pluck("product", &productIDs).Error
+	if err != nil {
+		return
+	}
+	notificationSrv := new(NotificationSrv)
+	for _, id := range productIDs {
+		product, e := productSrv.FindByID(id)
+		// 产品已删除，忽略其订阅
+		if e == gorm.ErrRecordNotFound {
+			logger.Info("restock product not found",
+				zap.Uint("product", id),
+			)
+			continue
+		}
+		if e != nil {
+			err = e
+			return
+		}
+		purchasable, e := productSrv.IsPurchasable(product)
+		if e != nil {
+			err = e
+			return
+		}
+		if !purchasable {
+			continue
+		}
+		subs, e := srv.ListSubscription(PGQueryParams{}, "product = ? AND notified_at IS NULL", id)
+		if e != nil {
+			err = e
+			return
+		}
+		for _, sub := range subs {
+			// 先标记已通知，避免多实例重复通知
+			db := pgGetClient().Model(sub).
+				Where("notified_at IS NULL").
+				Update("notified_at", util.Now())
+			if db.Error != nil {
+				err = db.Error
+				return
+			}
+			if db.RowsAffected != 1 {
+				continue
+			}
+			_, e = notificationSrv.Send(sub.UserID, NotificationRestock, "到货通知", product.Name+"已可购买")
+			if e != nil {
+				logger.Error("send restock notification fail",
+					zap.Uint("user", sub.UserID),
+					zap.Uint("product", id),
+					zap.Error(e),
+				)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,368 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+type (
+	ProductPrices []*ProductPrice
+	// ProductPrice 产品价格记录，包括已生效的历史价格与定时调价
+	ProductPrice struct {
+		helper.Model
+
+		Product uint `json:"product,omitempty" gorm:"index:idx_product_price_product;not null"`
+		// 产品规格，为0表示产品的价格
+		ProductSKU uint `json:"productSKU,omitempty" gorm:"index:idx_product_price_product_sku;not null;default:0"`
+		// 调整后价格
+		Price float64 `json:"price,omitempty" gorm:"not null"`
+		// 调整前价格（生效时记录）
+		PreviousPrice float64 `json:"previousPrice,omitempty"`
+		// 生效时间
+		EffectiveAt *time.Time `json:"effectiveAt,omitempty" gorm:"not null;index:idx_product_price_effective_at"`
+		// 状态：待生效、已生效、已取消
+		Status     string `json:"status,omitempty" gorm:"not null;index:idx_product_price_status"`
+		StatusDesc string `json:"statusDesc,omitempty" gorm:"-"`
+
+		// 操作人
+		Creator uint   `json:"creator,omitempty"`
+		Remark  string `json:"remark,omitempty"`
+	}
+
+	// ProductPriceParams 调价参数
+	ProductPriceParams struct {
+		Product uint
+		// 为0则调整产品的价格
+		ProductSKU uint
+		Price      float64
+		// 为空或已过去的时间则立即生效
+		EffectiveAt *time.Time
+		Creator     uint
+		Remark      string
+	}
+)
+
+const (
+	errProductPriceCategory = "product-price"
+
+	// 待生效
+	ProductPriceScheduled = "scheduled"
+	// 已生效
+	ProductPriceApplied = "applied"
+	// 已取消
+	ProductPriceCanceled = "canceled"
+)
+
+var (
+	productPriceStatusDict = map[string]string{
+		ProductPriceScheduled: "待生效",
+		ProductPriceApplied:   "已生效",
+		ProductPriceCanceled:  "已取消",
+	}
+)
+
+var (
+	errProductPriceStatusInvalid = &hes.Error{
+		Message:    "该调价记录非待生效状态",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductPriceCategory,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&ProductPrice{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (pp *ProductPrice) AfterFind(_ *gorm.DB) (err error) {
+	pp.StatusDesc = productPriceStatusDict[pp.Status]
+	return
+}
+
+func (pps ProductPrices) AfterFind(tx *gorm.DB) (err error) {
+	for _, pp := range pps {
+		err = pp.AfterFind(tx)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// getEffectivePrices get the price of every product(or sku) which is in effect at the time,
+// the scheduled price which is effective but not applied is used too,
+// the one effective latest is used if there are more than one
+func (pps ProductPrices) getEffectivePrices(t time.Time, getID func(pp *ProductPrice) uint) map[uint]float64 {
+	latest := make(map[uint]*ProductPrice)
+	for _, pp := range pps {
+		if pp.EffectiveAt == nil || pp.EffectiveAt.After(t) {
+			continue
+		}
+		if pp.Status != ProductPriceScheduled && pp.Status != ProductPriceApplied {
+			continue
+		}
+		id := getID(pp)
+		current, ok := latest[id]
+		if ok && (current.EffectiveAt.After(*pp.EffectiveAt) ||
+			(current.EffectiveAt.Equal(*pp.EffectiveAt) && current.ID > pp.ID)) {
+			continue
+		}
+		latest[id] = pp
+	}
+	prices := make(map[uint]float64, len(latest))
+	for id, pp := range latest {
+		prices[id] = pp.Price
+	}
+	return prices
+}
+
+// applyPrice apply the price to product(or sku) and record the previous price
+func (srv *ProductSrv) applyPrice(tx *gorm.DB, pp *ProductPrice) (err error) {
+	var target interface{}
+	previousPrice := 0.0
+	if pp.ProductSKU != 0 {
+		sku := new(ProductSKU)
+		err = tx.Select("id, price").First(sku, "id = ?", pp.ProductSKU).Error
+		if err != nil {
+			return
+		}
+		target = sku
+		previousPrice = sku.Price
+	} else {
+		product := new(Product)
+		err = tx.Select("id, price").First(product, "id = ?", pp.Product).Error
+		if err != nil {
+			return
+		}
+		target = product
+		previousPrice = product.Price
+	}
+	db := tx.Model(pp).
+		Where("status = ?", ProductPriceScheduled).
+		Updates(map[string]interface{}{
+			"status":         ProductPriceApplied,
+			"previous_price": previousPrice,
+		})
+	err = db.Error
+	if err != nil {
+		return
+	}
+	if db.RowsAffected != 1 {
+		err = errProductPriceStatusInvalid
+		return
+	}
+	err = tx.Model(target).Update("price", pp.Price).Error
+	return
+}
+
+// applyPriceWithHistory apply the price and record the snapshot of product(or sku)
+func (srv *ProductSrv) applyPriceWithHistory(pp *ProductPrice, operator uint) (err error) {
+	category := CatalogProduct
+	id := pp.Product
+	if pp.ProductSKU != 0 {
+		category = CatalogProductSKU
+		id = pp.ProductSKU
+	}
+	return catalogHistorySrv.Track(category, id, operator, CatalogActionUpdate, func() error {
+		return pgGetClient().Transaction(func(tx *gorm.DB) error {
+			return srv.applyPrice(tx, pp)
+		})
+	})
+}
+
+// addInitialPrice record the initial price of product(or sku) as applied price
+func (srv *ProductSrv) addInitialPrice(tx *gorm.DB, product, sku uint, price float64) (err error) {
+	now := util.Now()
+	err = tx.Create(&ProductPrice{
+		Product:     product,
+		ProductSKU:  sku,
+		Price:       price,
+		EffectiveAt: &now,
+		Status:      ProductPriceApplied,
+		Remark:      "初始价格",
+	}).Error
+	return
+}
+
+// SchedulePrice add a price change of product, it will be applied
+// immediately if the effective time is empty or passed
+func (srv *ProductSrv) SchedulePrice(params ProductPriceParams) (pp *ProductPrice, err error) {
+	_, err = srv.FindByID(params.Product)
+	if err != nil {
+		return
+	}
+	if params.ProductSKU != 0 {
+		sku, e := srv.FindSKUByID(params.ProductSKU)
+		if e != nil {
+			err = e
+			return
+		}
+		if sku.Product != params.Product {
+			err = errProductSKUInvalid
+			return
+		}
+	}
+	now := util.Now()
+	effectiveAt := params.EffectiveAt
+	if effectiveAt == nil || effectiveAt.Before(now) {
+		effectiveAt = &now
+	}
+	pp = &ProductPrice{
+		Product:     params.Product,
+		ProductSKU:  params.ProductSKU,
+		Price:       params.Price,
+		EffectiveAt: effectiveAt,
+		Status:      ProductPriceScheduled,
+		Creator:     params.Creator,
+		Remark:      params.Remark,
+	}
+	err = pgCreate(pp)
+	if err != nil {
+		return
+	}
+	if !effectiveAt.After(now) {
+		err = srv.applyPriceWithHistory(pp, params.Creator)
+		if err != nil {
+			return
+		}
+	}
+	pp.StatusDesc = productPriceStatusDict[pp.Status]
+	return
+}
+
+// ApplyScheduledPrices apply the scheduled prices which are effective
+func (srv *ProductSrv) ApplyScheduledPrices() (err error) {
+	result := make(ProductPrices, 0)
+	// 同一产品按生效时间顺序应用，最后生效的为当前价格
+	err = pgGetClient().
+		Where("status = ? AND effective_at <= ?", ProductPriceScheduled, util.Now()).
+		Order("effective_at, id").
+		Find(&result).Error
+	if err != nil {
+		return
+	}
+	for _, pp := range result {
+		err = srv.applyPriceWithHistory(pp, pp.Creator)
+		// 已被取消或其它实例已应用，忽略
+		if err == errProductPriceStatusInvalid {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// CancelPrice cancel the scheduled price
+func (srv *ProductSrv) CancelPrice(id, operator uint) (err error) {
+	db := pgGetClient().Model(&ProductPrice{}).
+		Where("id = ? AND status = ?", id, ProductPriceScheduled).
+		Updates(map[string]interface{}{
+			"status":  ProductPriceCanceled,
+			"creator": operator,
+		})
+	err = db.Error
+	if err != nil {
+		return
+	}
+	if db.RowsAffected != 1 {
+		err = errProductPriceStatusInvalid
+		return
+	}
+	return
+}
+
+// ListPrice list the price timeline of product
+func (srv *ProductSrv) ListPrice(productID uint) (result ProductPrices, err error) {
+	result = make(ProductPrices, 0)
+	err = pgGetClient().
+		Where("product = ?", productID).
+		Order("effective_at DESC, id DESC").
+		Find(&result).Error
+	return
+}
+
+// GetPricesAt get the prices of products at the time,
+// the scheduled price which is effective but not applied will be used too
+func (srv *ProductSrv) GetPricesAt(products Products, t time.Time) (prices map[uint]float64, err error) {
+	prices = make(map[uint]float64)
+	ids := make([]uint, len(products))
+	for index, p := range products {
+		prices[p.ID] = p.Price
+		ids[index] = p.ID
+	}
+	if len(ids) == 0 {
+		return
+	}
+	result := make(ProductPrices, 0)
+	err = pgGetClient().
+		Select("id, product, price, status, effective_at").
+		Where("product IN ? AND product_sku = 0 AND status IN ? AND effective_at <= ?", ids, []string{
+			ProductPriceScheduled,
+			ProductPriceApplied,
+		}, util.FormatTime(t)).
+		Find(&result).Error
+	if err != nil {
+		return
+	}
+	for id, price := range result.getEffectivePrices(t, func(pp *ProductPrice) uint {
+		return pp.Product
+	}) {
+		prices[id] = price
+	}
+	return
+}
+
+// GetSKUPricesAt get the prices of skus at the time,
+// the scheduled price which is effective but not applied will be used too
+func (srv *ProductSrv) GetSKUPricesAt(skus ProductSKUs, t time.Time) (prices map[uint]float64, err error) {
+	prices = make(map[uint]float64)
+	ids := make([]uint, len(skus))
+	for index, sku := range skus {
+		prices[sku.ID] = sku.Price
+		ids[index] = sku.ID
+	}
+	if len(ids) == 0 {
+		return
+	}
+	result := make(ProductPrices, 0)
+	err = pgGetClient().
+		Select("id, product_sku, price, status, effective_at").
+		Where("product_sku IN ? AND status IN ? AND effective_at <= ?", ids, []string{
+			ProductPriceScheduled,
+			ProductPriceApplied,
+		}, util.FormatTime(t)).
+		Find(&result).Error
+	if err != nil {
+		return
+	}
+	for id, price := range result.getEffectivePrices(t, func(pp *ProductPrice) uint {
+		return pp.ProductSKU
+	}) {
+		prices[id] = price
+	}
+	return
+}
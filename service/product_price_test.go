@@ -0,0 +1,71 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEffectivePrices(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	newPrice := func(id, product uint, price float64, status string, d time.Duration) *ProductPrice {
+		effectiveAt := now.Add(d)
+		pp := &ProductPrice{
+			Product:     product,
+			Price:       price,
+			Status:      status,
+			EffectiveAt: &effectiveAt,
+		}
+		pp.ID = id
+		return pp
+	}
+	pps := ProductPrices{
+		newPrice(1, 1, 10, ProductPriceApplied, -10*time.Hour),
+		// 已生效但未应用的定时调价
+		newPrice(2, 1, 12, ProductPriceScheduled, -time.Hour),
+		// 未生效的定时调价
+		newPrice(3, 1, 15, ProductPriceScheduled, time.Hour),
+		// 已取消的调价
+		newPrice(4, 1, 9, ProductPriceCanceled, -time.Minute),
+		// 同时生效的使用后创建的
+		newPrice(6, 2, 21, ProductPriceApplied, -time.Hour),
+		newPrice(5, 2, 20, ProductPriceApplied, -time.Hour),
+		newPrice(7, 3, 30, ProductPriceScheduled, time.Hour),
+	}
+	getProduct := func(pp *ProductPrice) uint {
+		return pp.Product
+	}
+
+	assert.Equal(map[uint]float64{
+		1: 12,
+		2: 21,
+	}, pps.getEffectivePrices(now, getProduct))
+
+	assert.Equal(map[uint]float64{
+		1: 10,
+	}, pps.getEffectivePrices(now.Add(-2*time.Hour), getProduct))
+
+	assert.Equal(map[uint]float64{
+		1: 15,
+		2: 21,
+		3: 30,
+	}, pps.getEffectivePrices(now.Add(2*time.Hour), getProduct))
+
+	assert.Empty(ProductPrices{}.getEffectivePrices(now, getProduct))
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+type (
+	// ProductView 产品浏览记录（用于计算看了又看）
+	ProductView struct {
+		helper.Model
+
+		TrackID string `json:"trackID,omitempty" gorm:"type:varchar(64);not null;index:idx_product_view_track_id"`
+		Product uint   `json:"product,omitempty" gorm:"not null"`
+	}
+
+	ProductRelations []*ProductRelation
+	// ProductRelation 产品关联（由定时任务计算生成）
+	ProductRelation struct {
+		helper.Model
+
+		Product uint `json:"product,omitempty" gorm:"index:idx_product_relation_product;not null"`
+		Related uint `json:"related,omitempty" gorm:"not null"`
+		// 类型：一起购买、看了又看
+		Category string `json:"category,omitempty" gorm:"not null"`
+		// 关联度（共同出现的订单数或浏览人数）
+		Score int64 `json:"score,omitempty"`
+	}
+)
+
+const (
+	// 经常一起购买
+	ProductRelationBought = "bought"
+	// 看了又看
+	ProductRelationViewed = "viewed"
+
+	// 每个产品保留的关联产品数量
+	productRelationMaxCount = 20
+	// 近一周销量的权重
+	productPopularityWeekWeight = 3
+)
+
+const (
+	productWeekSalesPeriod  = 7 * 24 * time.Hour
+	productMonthSalesPeriod = 30 * 24 * time.Hour
+	productBoughtPeriod     = 90 * 24 * time.Hour
+	productViewedPeriod     = 30 * 24 * time.Hour
+	// 同一用户同一产品的浏览记录间隔
+	productViewInterval = 30 * time.Minute
+
+	productViewLockPrefix = "product-view-"
+)
+
+func init() {
+	err := helper.PGAutoMigrate(
+		&ProductView{},
+		&ProductRelation{},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// AddView add view record of product, the views of the same track
+// and product in the interval are counted once
+func (srv *ProductSrv) AddView(trackID string, productID uint) (err error) {
+	if trackID == "" {
+		return
+	}
+	success, err := redisSrv.Lock(productViewLockPrefix+trackID+"-"+strconv.Itoa(int(productID)), productViewInterval)
+	if err != nil || !success {
+		return
+	}
+	err = pgCreate(&ProductView{
+		TrackID: trackID,
+		Product: productID,
+	})
+	return
+}
+
+// refreshSales refresh the sales and popularity of products
+func (srv *ProductSrv) refreshSales(tx *gorm.DB, now time.Time) (err error) {
+	err = tx.Exec(`UPDATE products SET week_sales = 0, month_sales = 0, popularity = 0
+		WHERE week_sales <> 0 OR month_sales <> 0 OR popularity <> 0`).Error
+	if err != nil {
+		return
+	}
+	// 仅统计已支付且未取消、退款的子订单
+	err = tx.Exec(`UPDATE products AS p SET week_sales = t.week_sales, month_sales = t.month_sales,
+		popularity = t.week_sales * ? + t.month_sales
+		FROM (
+			SELECT s.product,
+				COALESCE(SUM(s.product_count) FILTER (WHERE o.paid_at >= ?), 0) AS week_sales,
+				SUM(s.product_count) AS month_sales
+			FROM sub_orders AS s JOIN orders AS o ON o.id = s.main_order
+			WHERE o.paid_at >= ? AND s.status NOT IN ? AND s.deleted_at IS NULL AND o.deleted_at IS NULL
+			GROUP BY s.product
+		) AS t
+		WHERE p.id = t.product`,
+		productPopularityWeekWeight,
+		util.FormatTime(now.Add(-productWeekSalesPeriod)),
+		util.FormatTime(now.Add(-productMonthSalesPeriod)),
+		[]SubOrderStatus{
+			SubOrderStatusCanceled,
+			SubOrderStatusRefunded,
+		},
+	).Error
+	return
+}
+
+// refreshRelations refresh the relations of category, the pairs sql
+// should return product, related and score
+func (srv *ProductSrv) refreshRelations(tx *gorm.DB, category, pairsSQL string, args ...interface{}) (err error) {
+	err = tx.Unscoped().Where("category = ?", category).Delete(&ProductRelation{}).Error
+	if err != nil {
+		return
+	}
+	values := []interface{}{
+		category,
+	}
+	values = append(values, args...)
+	values = append(values, productRelationMaxCount)
+	err = tx.Exec(`INSERT INTO product_relations (created_at, updated_at, product, related, category, score)
+		SELECT NOW(), NOW(), product, related, ?, score FROM (
+			SELECT product, related, score,
+				ROW_NUMBER() OVER (PARTITION BY product ORDER BY score DESC, related) AS n
+			FROM (`+pairsSQL+`) AS pairs
+		) AS t WHERE n <= ?`, values...).Error
+	return
+}
+
+// RefreshRanking refresh the sales ranking and relations of products
+func (srv *ProductSrv) RefreshRanking() (err error) {
+	now := util.Now()
+	return pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = srv.refreshSales(tx, now)
+		if err != nil {
+			return
+		}
+		// 同一订单中一起购买的产品
+		err = srv.refreshRelations(tx, ProductRelationBought, `SELECT a.product, b.product AS related, COUNT(DISTINCT a.main_order) AS score
+			FROM sub_orders AS a
+			JOIN sub_orders AS b ON a.main_order = b.main_order AND a.product <> b.product
+			JOIN orders AS o ON o.id = a.main_order
+			WHERE o.paid_at >= ? AND a.deleted_at IS NULL AND b.deleted_at IS NULL
+			GROUP BY a.product, b.product`,
+			util.FormatTime(now.Add(-productBoughtPeriod)),
+		)
+		if err != nil {
+			return
+		}
+		// 同一用户（track id）浏览过的产品
+		viewedAt := util.FormatTime(now.Add(-productViewedPeriod))
+		err = srv.refreshRelations(tx, ProductRelationViewed, `SELECT a.product, b.product AS related, COUNT(DISTINCT a.track_id) AS score
+			FROM product_views AS a
+			JOIN product_views AS b ON a.track_id = b.track_id AND a.product <> b.product
+			WHERE a.created_at >= ? AND b.created_at >= ?
+			GROUP BY a.product, b.product`,
+			viewedAt,
+			viewedAt,
+		)
+		if err != nil {
+			return
+		}
+		// 删除过期的浏览记录
+		err = tx.Unscoped().Where("created_at < ?", viewedAt).Delete(&ProductView{}).Error
+		return
+	})
+}
+
+// ListRelated list the related products of category
+func (srv *ProductSrv) ListRelated(productID uint, category string, limit int) (products Products, err error) {
+	relations := make(ProductRelations, 0)
+	err = pgGetClient().
+		Where("product = ? AND category = ?", productID, category).
+		Order("score DESC, related").
+		Limit(limit).
+		Find(&relations).Error
+	if err != nil {
+		return
+	}
+	products = make(Products, 0, len(relations))
+	if len(relations) == 0 {
+		return
+	}
+	ids := make([]string, len(relations))
+	for index, item := range relations {
+		ids[index] = strconv.Itoa(int(item.Related))
+	}
+	result, err := srv.List(PGQueryParams{
+		Limit: len(ids),
+	}, "id IN (?) AND status = ?", ids, cs.StatusEnabled)
+	if err != nil {
+		return
+	}
+	// 按关联度排序
+	for _, item := range relations {
+		for _, p := range result {
+			if p.ID == item.Related {
+				products = append(products, p)
+				break
+			}
+		}
+	}
+	return
+}
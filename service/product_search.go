@@ -0,0 +1,161 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strings"
+
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+const (
+	// 使用simple分词（不做词干处理），中文由程序生成n-gram
+	productSearchConfig = "simple"
+	// ProductSearchCondition 产品全文搜索的查询条件
+	ProductSearchCondition = "search_vector @@ to_tsquery('" + productSearchConfig + "', ?)"
+)
+
+// getProductSearchTokens get the search tokens of product,
+// includes name, keywords, brand and categories
+func getProductSearchTokens(p *Product) []string {
+	texts := []string{
+		p.Name,
+		p.Keywords,
+	}
+	// 如果获取失败，忽略出错
+	brand, _ := brandSrv.GetNameFromCache(p.Brand)
+	texts = append(texts, brand)
+	for _, id := range p.Categories {
+		name, _ := productSrv.GetCategoryNameFromCache(uint(id))
+		texts = append(texts, name)
+	}
+
+	tokens := make([]string, 0)
+	exists := make(map[string]bool)
+	add := func(items ...string) {
+		for _, item := range items {
+			if item == "" || exists[item] {
+				continue
+			}
+			exists[item] = true
+			tokens = append(tokens, item)
+		}
+	}
+	for _, text := range texts {
+		for _, word := range util.SplitSearchWords(text) {
+			if !util.IsHan(word) {
+				add(word)
+				continue
+			}
+			// 中文使用单字与二元分词
+			add(word)
+			add(util.NGram(word, 1)...)
+			add(util.NGram(word, 2)...)
+			// 拼音与首字母，生成各后缀以便前缀匹配任意位置
+			runes := []rune(word)
+			for i := range runes {
+				suffix := string(runes[i:])
+				add(util.GetPinyin(suffix), util.GetPinyinInitials(suffix))
+			}
+		}
+	}
+	return tokens
+}
+
+// GetProductSearchQuery get the tsquery of keyword,
+// it returns empty string if there isn't any valid word
+func GetProductSearchQuery(keyword string) string {
+	terms := make([]string, 0)
+	for _, word := range util.SplitSearchWords(keyword) {
+		if util.IsHan(word) {
+			terms = append(terms, util.NGram(word, 2)...)
+			continue
+		}
+		// 字母数字（含拼音、首字母）使用前缀匹配
+		terms = append(terms, word+":*")
+	}
+	return strings.Join(terms, " & ")
+}
+
+// RefreshSearchVector refresh the search vector of product
+func (srv *ProductSrv) RefreshSearchVector(id uint) (err error) {
+	product, err := srv.FindByID(id)
+	if err != nil {
+		return
+	}
+	tokens := getProductSearchTokens(product)
+	err = pgGetClient().Model(srv.createByID(id)).
+		Update("search_vector", gorm.Expr("to_tsvector('"+productSearchConfig+"', ?)", strings.Join(tokens, " "))).
+		Error
+	return
+}
+
+// RefreshAllSearchVector refresh the search vector of all products,
+// brand or category's name may be modified
+func (srv *ProductSrv) RefreshAllSearchVector() (err error) {
+	return srv.refreshSearchVectors("")
+}
+
+// RefreshMissingSearchVector refresh the search vector of products which
+// have not been generated, it is called on startup
+func (srv *ProductSrv) RefreshMissingSearchVector() (err error) {
+	return srv.refreshSearchVectors("search_vector IS NULL")
+}
+
+// refreshSearchVectors refresh the search vector of products match the condition
+func (srv *ProductSrv) refreshSearchVectors(condition string) (err error) {
+	limit := 100
+	var lastID uint
+	query := "id > ?"
+	if condition != "" {
+		query += " AND " + condition
+	}
+	for {
+		var products Products
+		products, err = srv.List(PGQueryParams{
+			Limit:  limit,
+			Order:  "id",
+			Fields: "id",
+		}, query, lastID)
+		if err != nil {
+			return
+		}
+		for _, p := range products {
+			err = srv.RefreshSearchVector(p.ID)
+			if err != nil {
+				return
+			}
+			lastID = p.ID
+		}
+		if len(products) < limit {
+			return
+		}
+	}
+}
+
+// Search search product by tsquery, the result is ordered by relevance combined with rank
+func (srv *ProductSrv) Search(params PGQueryParams, query string, args ...interface{}) (result Products, err error) {
+	result = make(Products, 0)
+	db := pgQuery(params, args...)
+	// 未指定排序则按相关度，排序值越大权重越高
+	if params.Order == "" {
+		// query仅包含字母、数字、汉字与查询符号，单引号转义防止意外
+		query = strings.ReplaceAll(query, "'", "''")
+		db = db.Order("ts_rank(search_vector, to_tsquery('" + productSearchConfig + "', '" + query + "')) * (1 + LN(1 + GREATEST(rank, 0))) DESC, id DESC")
+	}
+	err = db.Find(&result).Error
+	return
+}
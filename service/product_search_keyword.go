@@ -0,0 +1,220 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+)
+
+type (
+	// ProductSearchKeyword 搜索关键字及其热度
+	ProductSearchKeyword struct {
+		Keyword string  `json:"keyword,omitempty"`
+		Score   float64 `json:"score,omitempty"`
+	}
+	ProductSearchKeywords []*ProductSearchKeyword
+
+	// searchKeywordBlocker 搜索关键字屏蔽
+	searchKeywordBlocker struct {
+		mutex    sync.RWMutex
+		keywords []string
+	}
+
+	ProductSearchKeywordSrv struct{}
+)
+
+const (
+	// 低于此热度的关键字删除
+	productSearchKeywordMinScore = 0.1
+	// 联想时从热门关键字中筛选的数量
+	productSearchSuggestHotCount = 200
+)
+
+var (
+	defaultSearchKeywordBlocker = &searchKeywordBlocker{}
+)
+
+// ResetSearchBlockKeywords reset the block keywords of search,
+// each config data is a list of keywords separated by comma or new line
+func ResetSearchBlockKeywords(configs []string) {
+	keywords := make([]string, 0)
+	for _, data := range configs {
+		arr := strings.FieldsFunc(data, func(r rune) bool {
+			return r == ',' || r == '\n'
+		})
+		for _, item := range arr {
+			item = strings.ToLower(strings.TrimSpace(item))
+			if item != "" {
+				keywords = append(keywords, item)
+			}
+		}
+	}
+	defaultSearchKeywordBlocker.mutex.Lock()
+	defer defaultSearchKeywordBlocker.mutex.Unlock()
+	defaultSearchKeywordBlocker.keywords = keywords
+}
+
+// IsBlockSearchKeyword check the keyword contains any block keyword
+func IsBlockSearchKeyword(keyword string) bool {
+	keyword = strings.ToLower(keyword)
+	defaultSearchKeywordBlocker.mutex.RLock()
+	defer defaultSearchKeywordBlocker.mutex.RUnlock()
+	for _, item := range defaultSearchKeywordBlocker.keywords {
+		if strings.Contains(keyword, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeSearchKeyword(keyword string) string {
+	return strings.ToLower(strings.TrimSpace(keyword))
+}
+
+// Record record the search keyword, the keyword without result will be recorded too
+func (srv *ProductSearchKeywordSrv) Record(keyword string, resultCount int64) (err error) {
+	keyword = normalizeSearchKeyword(keyword)
+	if keyword == "" || IsBlockSearchKeyword(keyword) {
+		return
+	}
+	pipe := helper.RedisGetClient().TxPipeline()
+	pipe.ZIncrBy(cs.ProductSearchHotKeywords, 1, keyword)
+	if resultCount == 0 {
+		pipe.ZIncrBy(cs.ProductSearchZeroResultKeywords, 1, keyword)
+	}
+	_, err = pipe.Exec()
+	return
+}
+
+func (srv *ProductSearchKeywordSrv) list(key string, limit int) (result ProductSearchKeywords, err error) {
+	// 多取一些，过滤屏蔽的关键字（屏蔽前已记录）
+	items, err := helper.RedisGetClient().ZRevRangeWithScores(key, 0, int64(2*limit-1)).Result()
+	if err != nil {
+		return
+	}
+	result = make(ProductSearchKeywords, 0, limit)
+	for _, item := range items {
+		keyword, _ := item.Member.(string)
+		if keyword == "" || IsBlockSearchKeyword(keyword) {
+			continue
+		}
+		result = append(result, &ProductSearchKeyword{
+			Keyword: keyword,
+			Score:   item.Score,
+		})
+		if len(result) >= limit {
+			break
+		}
+	}
+	return
+}
+
+// ListHot list the hot keywords
+func (srv *ProductSearchKeywordSrv) ListHot(limit int) (ProductSearchKeywords, error) {
+	return srv.list(cs.ProductSearchHotKeywords, limit)
+}
+
+// ListZeroResult list the keywords without result
+func (srv *ProductSearchKeywordSrv) ListZeroResult(limit int) (ProductSearchKeywords, error) {
+	return srv.list(cs.ProductSearchZeroResultKeywords, limit)
+}
+
+// Suggest get the suggestions of keyword, from hot keywords and product names
+func (srv *ProductSearchKeywordSrv) Suggest(prefix string, limit int) (suggestions []string, err error) {
+	prefix = normalizeSearchKeyword(prefix)
+	suggestions = make([]string, 0, limit)
+	if prefix == "" || IsBlockSearchKeyword(prefix) {
+		return
+	}
+	exists := make(map[string]bool)
+	add := func(value string) {
+		if exists[value] || len(suggestions) >= limit {
+			return
+		}
+		exists[value] = true
+		suggestions = append(suggestions, value)
+	}
+	hotKeywords, err := srv.ListHot(productSearchSuggestHotCount)
+	if err != nil {
+		return
+	}
+	for _, item := range hotKeywords {
+		// 支持拼音与首字母
+		if strings.HasPrefix(item.Keyword, prefix) ||
+			strings.HasPrefix(util.GetPinyin(item.Keyword), prefix) ||
+			strings.HasPrefix(util.GetPinyinInitials(item.Keyword), prefix) {
+			add(item.Keyword)
+		}
+	}
+	if len(suggestions) >= limit {
+		return
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	products, err := productSrv.List(PGQueryParams{
+		Limit:  limit,
+		Fields: "name",
+		Order:  "-rank",
+	}, "status = ? AND name ILIKE ?", cs.StatusEnabled, escaper.Replace(prefix)+"%")
+	if err != nil {
+		return
+	}
+	for _, p := range products {
+		add(p.Name)
+	}
+	return
+}
+
+// calcDecayFactor calculate the factor of score decay after the interval,
+// the score is halved every half life
+func calcDecayFactor(interval, halfLife time.Duration) float64 {
+	if interval <= 0 || halfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(interval)/float64(halfLife))
+}
+
+// Decay decay the score of keywords by half life,
+// it should be called periodically with the same interval
+func (srv *ProductSearchKeywordSrv) Decay(interval time.Duration) (err error) {
+	halfLife := config.GetDurationDefault("productSearch.hotKeywordHalfLife", 72*time.Hour)
+	factor := calcDecayFactor(interval, halfLife)
+	client := helper.RedisGetClient()
+	for _, key := range []string{
+		cs.ProductSearchHotKeywords,
+		cs.ProductSearchZeroResultKeywords,
+	} {
+		pipe := client.TxPipeline()
+		pipe.ZUnionStore(key, &redis.ZStore{
+			Keys:    []string{key},
+			Weights: []float64{factor},
+		})
+		pipe.ZRemRangeByScore(key, "-inf", "("+strconv.FormatFloat(productSearchKeywordMinScore, 'f', -1, 64))
+		_, err = pipe.Exec()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalcDecayFactor(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		interval time.Duration
+		halfLife time.Duration
+		expected float64
+	}{
+		{
+			interval: 72 * time.Hour,
+			halfLife: 72 * time.Hour,
+			expected: 0.5,
+		},
+		{
+			interval: 144 * time.Hour,
+			halfLife: 72 * time.Hour,
+			expected: 0.25,
+		},
+		// 每小时衰减，72小时后为一半
+		{
+			interval: time.Hour,
+			halfLife: 72 * time.Hour,
+			expected: 0.990419,
+		},
+		// 未配置半衰期则不衰减
+		{
+			interval: time.Hour,
+			expected: 1,
+		},
+		{
+			halfLife: 72 * time.Hour,
+			expected: 1,
+		},
+	}
+	for _, tt := range tests {
+		assert.InDelta(tt.expected, calcDecayFactor(tt.interval, tt.halfLife), 0.000001)
+	}
+}
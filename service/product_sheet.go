@@ -0,0 +1,483 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+type (
+	// ProductSheetRow 表格中的一行产品数据，key为表头
+	ProductSheetRow map[string]string
+
+	// ProductSheetResolver 将表格中的品牌、供应商名称以及分类路径转换为id
+	ProductSheetResolver struct {
+		brands     map[string]uint
+		suppliers  map[string]uint
+		categories map[string]uint
+		// 分类关系，用于分类路径与id的转换
+		categoryGraph *productCategoryGraph
+	}
+)
+
+const (
+	errProductSheetCategory = "product-sheet"
+
+	ProductSheetCSV  = "csv"
+	ProductSheetXLSX = "xlsx"
+
+	// 多个值（图片、分类）的分隔符
+	productSheetSeparator = "|"
+	productSheetName      = "Sheet1"
+)
+
+var (
+	// ProductSheetHeaders 导入导出的表头，与添加产品的参数一致，
+	// 品牌、供应商使用名称，分类使用完整路径（或分类ID）
+	ProductSheetHeaders = []string{
+		"name",
+		"price",
+		"specs",
+		"unit",
+		"catalog",
+		"pics",
+		"mainPic",
+		"sn",
+		"status",
+		"keywords",
+		"categories",
+		"startedAt",
+		"endedAt",
+		"origin",
+		"brand",
+		"supplier",
+		"rank",
+	}
+	productSheetIntFields = []string{
+		"specs",
+		"mainPic",
+		"status",
+		"rank",
+	}
+)
+
+var (
+	errProductSheetFormatInvalid = &hes.Error{
+		Message:    "不支持该文件格式，仅支持csv与xlsx",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSheetCategory,
+	}
+	errProductSheetEmpty = &hes.Error{
+		Message:    "文件无产品数据",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSheetCategory,
+	}
+	errProductSheetValueInvalid = &hes.Error{
+		Message:    "%s的值(%s)不合法",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSheetCategory,
+	}
+	errProductSheetNameNotFound = &hes.Error{
+		Message:    "%s(%s)不存在",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSheetCategory,
+	}
+	errProductSheetCategoryAmbiguous = &hes.Error{
+		Message:    "分类(%s)存在多个，请使用完整路径(如：食品/零食)或分类ID",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSheetCategory,
+	}
+)
+
+// GetProductSheetFormat get the sheet format from file name
+func GetProductSheetFormat(filename string) (format string, err error) {
+	arr := strings.Split(filename, ".")
+	format = strings.ToLower(arr[len(arr)-1])
+	if format != ProductSheetCSV && format != ProductSheetXLSX {
+		err = errProductSheetFormatInvalid
+	}
+	return
+}
+
+// ReadProductSheet read the rows of sheet, the first line is header
+func ReadProductSheet(format string, r io.Reader) (rows []ProductSheetRow, err error) {
+	var records [][]string
+	switch format {
+	case ProductSheetCSV:
+		buf, e := ioutil.ReadAll(r)
+		if e != nil {
+			err = e
+			return
+		}
+		// 去除excel导出的BOM
+		buf = bytes.TrimPrefix(buf, []byte("\xEF\xBB\xBF"))
+		reader := csv.NewReader(bytes.NewReader(buf))
+		reader.FieldsPerRecord = -1
+		records, err = reader.ReadAll()
+	case ProductSheetXLSX:
+		f, e := excelize.OpenReader(r)
+		if e != nil {
+			err = e
+			return
+		}
+		records, err = f.GetRows(f.GetSheetName(0))
+	default:
+		err = errProductSheetFormatInvalid
+	}
+	if err != nil {
+		return
+	}
+	if len(records) < 2 {
+		err = errProductSheetEmpty
+		return
+	}
+	headers := records[0]
+	rows = make([]ProductSheetRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(ProductSheetRow)
+		empty := true
+		for index, header := range headers {
+			if index >= len(record) {
+				break
+			}
+			value := strings.TrimSpace(record[index])
+			if value != "" {
+				empty = false
+			}
+			row[strings.TrimSpace(header)] = value
+		}
+		// 忽略空行
+		if !empty {
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// WriteProductSheet write the products to sheet
+func WriteProductSheet(format string, products Products) (buf []byte, err error) {
+	resolver := NewProductSheetResolver()
+	records := make([][]string, 0, len(products)+1)
+	records = append(records, ProductSheetHeaders)
+	for _, p := range products {
+		row, e := resolver.ToRow(p)
+		if e != nil {
+			err = e
+			return
+		}
+		record := make([]string, len(ProductSheetHeaders))
+		for index, header := range ProductSheetHeaders {
+			record[index] = row[header]
+		}
+		records = append(records, record)
+	}
+	switch format {
+	case ProductSheetCSV:
+		b := new(bytes.Buffer)
+		// 添加BOM，避免excel打开时中文乱码
+		b.WriteString("\xEF\xBB\xBF")
+		w := csv.NewWriter(b)
+		err = w.WriteAll(records)
+		if err != nil {
+			return
+		}
+		buf = b.Bytes()
+	case ProductSheetXLSX:
+		f := excelize.NewFile()
+		for index, record := range records {
+			cell, e := excelize.CoordinatesToCellName(1, index+1)
+			if e != nil {
+				err = e
+				return
+			}
+			values := make([]interface{}, len(record))
+			for i, v := range record {
+				values[i] = v
+			}
+			err = f.SetSheetRow(productSheetName, cell, &values)
+			if err != nil {
+				return
+			}
+		}
+		b, e := f.WriteToBuffer()
+		if e != nil {
+			err = e
+			return
+		}
+		buf = b.Bytes()
+	default:
+		err = errProductSheetFormatInvalid
+	}
+	return
+}
+
+// NewProductSheetResolver create a new resolver
+func NewProductSheetResolver() *ProductSheetResolver {
+	return &ProductSheetResolver{
+		brands:     make(map[string]uint),
+		suppliers:  make(map[string]uint),
+		categories: make(map[string]uint),
+	}
+}
+
+func (r *ProductSheetResolver) getBrand(name string) (id uint, err error) {
+	id, ok := r.brands[name]
+	if ok {
+		return
+	}
+	result, err := brandSrv.List(PGQueryParams{
+		Limit:  1,
+		Fields: "id",
+	}, "name = ?", name)
+	if err != nil {
+		return
+	}
+	if len(result) == 0 {
+		err = errProductSheetNameNotFound.CloneWithMessage(fmt.Sprintf(errProductSheetNameNotFound.Message, "品牌", name))
+		return
+	}
+	id = result[0].ID
+	r.brands[name] = id
+	return
+}
+
+func (r *ProductSheetResolver) getSupplier(name string) (id uint, err error) {
+	id, ok := r.suppliers[name]
+	if ok {
+		return
+	}
+	result, err := supplierSrv.List(PGQueryParams{
+		Limit:  1,
+		Fields: "id",
+	}, "name = ?", name)
+	if err != nil {
+		return
+	}
+	if len(result) == 0 {
+		err = errProductSheetNameNotFound.CloneWithMessage(fmt.Sprintf(errProductSheetNameNotFound.Message, "供应商", name))
+		return
+	}
+	id = result[0].ID
+	r.suppliers[name] = id
+	return
+}
+
+func (r *ProductSheetResolver) getCategoryGraph() (g *productCategoryGraph, err error) {
+	if r.categoryGraph != nil {
+		return r.categoryGraph, nil
+	}
+	cats, err := productSrv.listAllCategory()
+	if err != nil {
+		return
+	}
+	r.categoryGraph = newProductCategoryGraph(cats)
+	return r.categoryGraph, nil
+}
+
+// getCategory get the category id by id or path
+func (r *ProductSheetResolver) getCategory(value string) (id uint, err error) {
+	id, ok := r.categories[value]
+	if ok {
+		return
+	}
+	g, err := r.getCategoryGraph()
+	if err != nil {
+		return
+	}
+	ids := g.resolve(value)
+	switch len(ids) {
+	case 0:
+		err = errProductSheetNameNotFound.CloneWithMessage(fmt.Sprintf(errProductSheetNameNotFound.Message, "分类", value))
+		return
+	case 1:
+		id = ids[0]
+	default:
+		err = errProductSheetCategoryAmbiguous.CloneWithMessage(fmt.Sprintf(errProductSheetCategoryAmbiguous.Message, value))
+		return
+	}
+	r.categories[value] = id
+	return
+}
+
+func splitProductSheetValue(value string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(value, productSheetSeparator) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Resolve convert the row to the data of product params,
+// the names of brand, supplier and categories are converted to id
+func (r *ProductSheetResolver) Resolve(row ProductSheetRow) (data map[string]interface{}, err error) {
+	data = make(map[string]interface{})
+	newValueInvalidErr := func(key, value string) error {
+		return errProductSheetValueInvalid.CloneWithMessage(fmt.Sprintf(errProductSheetValueInvalid.Message, key, value))
+	}
+	for key, value := range row {
+		if value == "" {
+			continue
+		}
+		switch {
+		case key == "price":
+			price, e := strconv.ParseFloat(value, 64)
+			if e != nil {
+				err = newValueInvalidErr(key, value)
+				return
+			}
+			data[key] = price
+		case util.ContainsString(productSheetIntFields, key):
+			v, e := strconv.Atoi(value)
+			if e != nil {
+				err = newValueInvalidErr(key, value)
+				return
+			}
+			data[key] = v
+		case key == "pics":
+			data[key] = splitProductSheetValue(value)
+		case key == "categories":
+			ids := make([]uint, 0)
+			for _, name := range splitProductSheetValue(value) {
+				id, e := r.getCategory(name)
+				if e != nil {
+					err = e
+					return
+				}
+				ids = append(ids, id)
+			}
+			data[key] = ids
+		case key == "brand":
+			data[key], err = r.getBrand(value)
+			if err != nil {
+				return
+			}
+		case key == "supplier":
+			data[key], err = r.getSupplier(value)
+			if err != nil {
+				return
+			}
+		case key == "startedAt" || key == "endedAt":
+			t, e := time.Parse(time.RFC3339, value)
+			if e != nil {
+				err = newValueInvalidErr(key, value)
+				return
+			}
+			data[key] = t
+		case util.ContainsString(ProductSheetHeaders, key):
+			data[key] = value
+		}
+	}
+	return
+}
+
+// ToRow convert the product to sheet row
+func (r *ProductSheetResolver) ToRow(p *Product) (row ProductSheetRow, err error) {
+	g, err := r.getCategoryGraph()
+	if err != nil {
+		return
+	}
+	// 分类使用完整路径，避免同名分类导入时无法区分
+	categories := make([]string, 0, len(p.Categories))
+	for _, id := range p.Categories {
+		categories = append(categories, g.path(uint(id)))
+	}
+	brand, err := brandSrv.GetNameFromCache(p.Brand)
+	if err != nil {
+		return
+	}
+	supplier := ""
+	if p.Supplier != 0 {
+		// 供应商可能已被删除
+		s := new(Supplier)
+		err = pgGetClient().Unscoped().First(s, "id = ?", p.Supplier).Error
+		if err != nil {
+			return
+		}
+		supplier = s.Name
+	}
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	row = ProductSheetRow{
+		"name":       p.Name,
+		"price":      strconv.FormatFloat(p.Price, 'f', -1, 64),
+		"specs":      strconv.Itoa(int(p.Specs)),
+		"unit":       p.Unit,
+		"catalog":    p.Catalog,
+		"pics":       strings.Join(p.Pics, productSheetSeparator),
+		"mainPic":    strconv.Itoa(p.MainPic),
+		"sn":         p.SN,
+		"status":     strconv.Itoa(p.Status),
+		"keywords":   p.Keywords,
+		"categories": strings.Join(categories, productSheetSeparator),
+		"startedAt":  formatTime(p.StartedAt),
+		"endedAt":    formatTime(p.EndedAt),
+		"origin":     p.Origin,
+		"brand":      brand,
+		"supplier":   supplier,
+		"rank":       strconv.Itoa(p.Rank),
+	}
+	return
+}
+
+// BatchAdd add products in a transaction
+func (srv *ProductSrv) BatchAdd(products []*Product, operator uint) (err error) {
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = tx.Create(products).Error
+		if err != nil {
+			return
+		}
+		for _, p := range products {
+			err = srv.addInitialPrice(tx, p.ID, 0, p.Price)
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	for _, p := range products {
+		err = srv.RefreshSearchVector(p.ID)
+		if err != nil {
+			return
+		}
+		err = catalogHistorySrv.RecordCreate(CatalogProduct, p.ID, operator)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
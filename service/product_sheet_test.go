@@ -0,0 +1,92 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProductCategoryGraph() *productCategoryGraph {
+	newCategory := func(id uint, name string, belongs ...int64) *ProductCategory {
+		cat := &ProductCategory{
+			Name:    name,
+			Belongs: pq.Int64Array(belongs),
+		}
+		cat.ID = id
+		return cat
+	}
+	return newProductCategoryGraph(ProductCategories{
+		newCategory(1, "食品"),
+		newCategory(2, "日用品"),
+		newCategory(3, "零食", 1),
+		newCategory(4, "其它", 1),
+		newCategory(5, "其它", 2),
+		newCategory(6, "坚果", 3),
+	})
+}
+
+func TestProductCategoryPath(t *testing.T) {
+	assert := assert.New(t)
+	g := newTestProductCategoryGraph()
+
+	assert.Equal("食品/零食/坚果", g.path(6))
+	assert.Equal("日用品/其它", g.path(5))
+	// 不存在的分类使用id
+	assert.Equal("100", g.path(100))
+
+	assert.Equal([]uint{6}, g.resolve("坚果"))
+	assert.Equal([]uint{6}, g.resolve("零食/坚果"))
+	assert.Equal([]uint{6}, g.resolve("食品/零食/坚果"))
+	assert.Equal([]uint{4, 5}, g.resolve("其它"))
+	assert.Equal([]uint{5}, g.resolve("日用品/其它"))
+	assert.Equal([]uint{5}, g.resolve("5"))
+	assert.Empty(g.resolve("日用品/坚果"))
+	assert.Empty(g.resolve("100"))
+}
+
+func TestProductSheetResolve(t *testing.T) {
+	assert := assert.New(t)
+
+	rows, err := ReadProductSheet(ProductSheetCSV, strings.NewReader("\xEF\xBB\xBFname,price,specs,categories\n"+
+		"开心果,12.5,500,食品/零食/坚果|日用品/其它\n"+
+		",,,\n"+
+		"纸巾,abc,1,其它\n"))
+	assert.Nil(err)
+	assert.Equal(2, len(rows))
+
+	r := NewProductSheetResolver()
+	r.categoryGraph = newTestProductCategoryGraph()
+
+	data, err := r.Resolve(rows[0])
+	assert.Nil(err)
+	assert.Equal("开心果", data["name"])
+	assert.Equal(12.5, data["price"])
+	assert.Equal(500, data["specs"])
+	assert.Equal([]uint{6, 5}, data["categories"])
+
+	_, err = r.Resolve(rows[1])
+	assert.NotNil(err)
+
+	// 同名分类需要使用完整路径
+	_, err = r.Resolve(ProductSheetRow{
+		"categories": "其它",
+	})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "存在多个")
+}
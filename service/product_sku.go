@@ -0,0 +1,339 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"gorm.io/gorm"
+)
+
+type (
+	// ProductSKUAttrs sku的属性，如：{"规格": "500g", "口味": "原味"}
+	ProductSKUAttrs map[string]string
+
+	ProductSKUs []*ProductSKU
+	// ProductSKU 产品的规格（变体），价格、编号以及库存独立
+	ProductSKU struct {
+		helper.Model
+
+		Product uint            `json:"product,omitempty" gorm:"index:idx_product_sku_product;not null"`
+		Name    string          `json:"name,omitempty" gorm:"not null"`
+		SN      string          `json:"sn,omitempty" gorm:"uniqueIndex:idx_product_sku_sn,where:sn <> ''"`
+		Attrs   ProductSKUAttrs `json:"attrs,omitempty"`
+		// 单价
+		Price float64 `json:"price,omitempty" gorm:"not null"`
+		// 规格+单位，如500克
+		Specs uint   `json:"specs,omitempty" gorm:"not null"`
+		Unit  string `json:"unit,omitempty" gorm:"not null"`
+		// 库存
+		Stock int `json:"stock,omitempty" gorm:"not null;default:0"`
+		// 图片
+		Pic string `json:"pic,omitempty"`
+		// 排序
+		Rank int `json:"rank,omitempty"`
+
+		Status     int    `json:"status,omitempty" gorm:"index:idx_product_sku_status"`
+		StatusDesc string `json:"statusDesc,omitempty" gorm:"-"`
+	}
+
+	// ProductSKUAttrValues 属性及其可选值
+	ProductSKUAttrValues struct {
+		Name   string   `json:"name,omitempty"`
+		Values []string `json:"values,omitempty"`
+	}
+	// ProductSKUMatrix 产品的规格矩阵
+	ProductSKUMatrix struct {
+		Attrs []*ProductSKUAttrValues `json:"attrs,omitempty"`
+		SKUs  ProductSKUs             `json:"skus,omitempty"`
+	}
+)
+
+const (
+	errProductSKUCategory = "product-sku"
+)
+
+var (
+	errProductSKUInvalid = &hes.Error{
+		Message:    "产品规格异常",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSKUCategory,
+	}
+	errProductSKURequired = &hes.Error{
+		Message:    "%s:请选择产品规格",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSKUCategory,
+	}
+	errProductSKUUnavailable = &hes.Error{
+		Message:    "%s:该规格已下架",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSKUCategory,
+	}
+	errProductSKUStockNotEnough = &hes.Error{
+		Message:    "%s:库存不足",
+		StatusCode: http.StatusBadRequest,
+		Category:   errProductSKUCategory,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&ProductSKU{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (attrs ProductSKUAttrs) Value() (driver.Value, error) {
+	buf, err := json.Marshal(attrs)
+	return string(buf), err
+}
+
+func (attrs *ProductSKUAttrs) Scan(input interface{}) error {
+	switch value := input.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), attrs)
+	case []byte:
+		return json.Unmarshal(value, attrs)
+	case nil:
+		return nil
+	default:
+		return hes.New("不支持的规格属性类型")
+	}
+}
+
+func (sku *ProductSKU) AfterFind(_ *gorm.DB) (err error) {
+	sku.StatusDesc = getStatusDesc(sku.Status)
+	return
+}
+
+func (skus ProductSKUs) AfterFind(tx *gorm.DB) (err error) {
+	for _, sku := range skus {
+		err = sku.AfterFind(tx)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// CheckAvailable check the sku is available
+func (sku *ProductSKU) CheckAvailable(productName string) error {
+	if sku.Status != cs.StatusEnabled {
+		return errProductSKUUnavailable.CloneWithMessage(fmt.Sprintf(errProductSKUUnavailable.Message, productName+sku.Name))
+	}
+	return nil
+}
+
+// Matrix get the attrs and values of skus
+func (skus ProductSKUs) Matrix() *ProductSKUMatrix {
+	attrs := make([]*ProductSKUAttrValues, 0)
+	for _, sku := range skus {
+		// 属性名排序，保证输出顺序一致
+		names := make([]string, 0, len(sku.Attrs))
+		for name := range sku.Attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := sku.Attrs[name]
+			var found *ProductSKUAttrValues
+			for _, item := range attrs {
+				if item.Name == name {
+					found = item
+					break
+				}
+			}
+			if found == nil {
+				found = &ProductSKUAttrValues{
+					Name:   name,
+					Values: make([]string, 0),
+				}
+				attrs = append(attrs, found)
+			}
+			exists := false
+			for _, v := range found.Values {
+				if v == value {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				found.Values = append(found.Values, value)
+			}
+		}
+	}
+	return &ProductSKUMatrix{
+		Attrs: attrs,
+		SKUs:  skus,
+	}
+}
+
+func (srv *ProductSrv) createSKUByID(id uint) *ProductSKU {
+	sku := &ProductSKU{}
+	sku.Model.ID = id
+	return sku
+}
+
+// AddSKU add sku to product
+func (srv *ProductSrv) AddSKU(data ProductSKU, operator uint) (sku *ProductSKU, err error) {
+	_, err = srv.FindByID(data.Product)
+	if err != nil {
+		return
+	}
+	sku = &data
+	err = pgGetClient().Transaction(func(tx *gorm.DB) (err error) {
+		err = tx.Create(sku).Error
+		if err != nil {
+			return
+		}
+		return srv.addInitialPrice(tx, sku.Product, sku.ID, sku.Price)
+	})
+	if err != nil {
+		return
+	}
+	err = catalogHistorySrv.RecordCreate(CatalogProductSKU, sku.ID, operator)
+	return
+}
+
+// UpdateSKU update sku, the price change is recorded as price history,
+// and the changes are recorded as catalog history
+func (srv *ProductSrv) UpdateSKU(id uint, data ProductSKU, stock *int, operator uint) (err error) {
+	if data.Price != 0 {
+		current, e := srv.FindSKUByID(id)
+		if e != nil {
+			err = e
+			return
+		}
+		if current.Price != data.Price {
+			_, err = srv.SchedulePrice(ProductPriceParams{
+				Product:    current.Product,
+				ProductSKU: id,
+				Price:      data.Price,
+				Creator:    operator,
+			})
+			if err != nil {
+				return
+			}
+		}
+		data.Price = 0
+	}
+	return catalogHistorySrv.Track(CatalogProductSKU, id, operator, CatalogActionUpdate, func() error {
+		err := srv.UpdateSKUByID(id, data)
+		if err != nil {
+			return err
+		}
+		// 库存为0时struct的更新会被忽略，单独更新
+		if stock == nil {
+			return nil
+		}
+		return srv.UpdateSKUByID(id, map[string]interface{}{
+			"stock": *stock,
+		})
+	})
+}
+
+// UpdateSKUByID update sku by id
+func (srv *ProductSrv) UpdateSKUByID(id uint, value interface{}) (err error) {
+	err = pgGetClient().Model(srv.createSKUByID(id)).Updates(value).Error
+	return
+}
+
+// FindSKUByID find sku by id
+func (srv *ProductSrv) FindSKUByID(id uint) (sku *ProductSKU, err error) {
+	sku = new(ProductSKU)
+	err = pgGetClient().First(sku, "id = ?", id).Error
+	return
+}
+
+// ListSKU list sku
+func (srv *ProductSrv) ListSKU(params PGQueryParams, args ...interface{}) (result ProductSKUs, err error) {
+	result = make(ProductSKUs, 0)
+	err = pgQuery(params, args...).Find(&result).Error
+	return
+}
+
+// ListSKUByProduct list the skus of product
+func (srv *ProductSrv) ListSKUByProduct(productID uint) (result ProductSKUs, err error) {
+	return srv.ListSKU(PGQueryParams{
+		Limit: 100,
+		Order: "rank,id",
+	}, "product = ?", productID)
+}
+
+// GetSKUMatrix get the sku matrix of product
+func (srv *ProductSrv) GetSKUMatrix(productID uint) (matrix *ProductSKUMatrix, err error) {
+	skus, err := srv.ListSKUByProduct(productID)
+	if err != nil {
+		return
+	}
+	matrix = skus.Matrix()
+	return
+}
+
+// DecreaseSKUStock decrease the stock of sku
+func (srv *ProductSrv) DecreaseSKUStock(tx *gorm.DB, sku *ProductSKU, count uint, productName string) (err error) {
+	db := tx.Model(sku).
+		Where("stock >= ?", count).
+		Update("stock", gorm.Expr("stock - ?", count))
+	err = db.Error
+	if err != nil {
+		return
+	}
+	if db.RowsAffected != 1 {
+		err = errProductSKUStockNotEnough.CloneWithMessage(fmt.Sprintf(errProductSKUStockNotEnough.Message, productName+sku.Name))
+		return
+	}
+	return
+}
+
+// IncreaseSKUStock increase the stock of sku, it is used to restore the stock
+// when the sub order is canceled or the goods are returned
+func (srv *ProductSrv) IncreaseSKUStock(tx *gorm.DB, skuID uint, count uint) (err error) {
+	// 无规格的子订单不需要恢复库存
+	if skuID == 0 || count == 0 {
+		return
+	}
+	err = tx.Model(&ProductSKU{}).
+		Where("id = ?", skuID).
+		Update("stock", gorm.Expr("stock + ?", count)).Error
+	return
+}
+
+// IncreaseSubOrdersSKUStock restore the stock of sub orders, the canceled
+// and refunded ones are ignored as their stock has been restored
+func (srv *ProductSrv) IncreaseSubOrdersSKUStock(tx *gorm.DB, orderID uint) (err error) {
+	subOrders := make(SubOrders, 0)
+	err = tx.Where("main_order = ? AND status NOT IN ?", orderID, []SubOrderStatus{
+		SubOrderStatusCanceled,
+		SubOrderStatusRefunded,
+	}).Find(&subOrders).Error
+	if err != nil {
+		return
+	}
+	for _, subOrder := range subOrders {
+		err = srv.IncreaseSKUStock(tx, subOrder.ProductSKU, subOrder.ProductCount)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductSKUsMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	skus := ProductSKUs{
+		{
+			Name: "原味500g",
+			Attrs: ProductSKUAttrs{
+				"规格": "500g",
+				"口味": "原味",
+			},
+		},
+		{
+			Name: "原味1kg",
+			Attrs: ProductSKUAttrs{
+				"规格": "1kg",
+				"口味": "原味",
+			},
+		},
+		{
+			Name: "盐焗500g",
+			Attrs: ProductSKUAttrs{
+				"口味": "盐焗",
+				"规格": "500g",
+			},
+		},
+	}
+	matrix := skus.Matrix()
+	assert.Equal(skus, matrix.SKUs)
+	// 属性按名称排序，可选值按规格出现的顺序
+	assert.Equal([]*ProductSKUAttrValues{
+		{
+			Name:   "口味",
+			Values: []string{"原味", "盐焗"},
+		},
+		{
+			Name:   "规格",
+			Values: []string{"500g", "1kg"},
+		},
+	}, matrix.Attrs)
+
+	matrix = ProductSKUs{}.Matrix()
+	assert.Empty(matrix.Attrs)
+}
@@ -40,14 +40,18 @@ var (
 
 	logger = log.Default()
 
-	redisSrv           = new(helper.Redis)
-	productSrv         = new(ProductSrv)
-	regionSrv          = new(RegionSrv)
-	brandSrv           = new(BrandSrv)
-	userSrv            = new(UserSrv)
-	fileSrv            = new(FileSrv)
-	orderSrv           = new(OrderSrv)
-	orderCommissionSrv = new(OrderCommissionSrv)
+	redisSrv            = new(helper.Redis)
+	productSrv          = new(ProductSrv)
+	regionSrv           = new(RegionSrv)
+	brandSrv            = new(BrandSrv)
+	userSrv             = new(UserSrv)
+	fileSrv             = new(FileSrv)
+	orderSrv            = new(OrderSrv)
+	orderCommissionSrv  = new(OrderCommissionSrv)
+	commissionLedgerSrv = new(CommissionLedgerSrv)
+	afterSaleSrv        = new(AfterSaleSrv)
+	supplierSrv         = new(SupplierSrv)
+	catalogHistorySrv   = new(CatalogHistorySrv)
 
 	statusInfoList StatusInfoList
 	statusMap      map[int]string
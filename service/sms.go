@@ -0,0 +1,80 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+)
+
+type (
+	// SMSSender 短信发送
+	SMSSender interface {
+		Send(mobile, content string) error
+	}
+	// logSMSSender 仅输出日志（包括验证码），只用于本地开发
+	logSMSSender struct{}
+)
+
+const (
+	errSMSCategory = "sms"
+)
+
+var (
+	// 未接入短信服务商时为空，不可发送短信
+	smsSender SMSSender
+
+	errSMSUnavailable = &hes.Error{
+		Message:    "暂不支持短信发送",
+		StatusCode: http.StatusBadRequest,
+		Category:   errSMSCategory,
+	}
+)
+
+func init() {
+	if util.IsDevelopment() {
+		smsSender = new(logSMSSender)
+	}
+}
+
+// Send log the sms
+func (*logSMSSender) Send(mobile, content string) error {
+	logger.Info("send sms",
+		zap.String("mobile", mobile),
+		zap.String("content", content),
+	)
+	return nil
+}
+
+// SetSMSSender set the sms sender
+func SetSMSSender(sender SMSSender) {
+	smsSender = sender
+}
+
+// IsSMSAvailable check the sms sender is set
+func IsSMSAvailable() bool {
+	return smsSender != nil
+}
+
+// SendSMS send sms by the sender
+func SendSMS(mobile, content string) error {
+	if !IsSMSAvailable() {
+		return errSMSUnavailable
+	}
+	return smsSender.Send(mobile, content)
+}
@@ -51,11 +51,6 @@ var (
 )
 
 var (
-	// 用户角色
-	userRolesMap map[string]string
-	// 用户分组
-	userGroupsMap map[string]string
-
 	// userNameCache 用户名字缓存
 	userNameCache *lruTTL.Cache
 
@@ -71,6 +66,16 @@ type (
 		Roles     []string
 		Groups    []string
 		LoginedAt string
+		// 登录时的session代数，小于当前代数则已被注销
+		SessionGeneration int64
+		// 是否已通过两步验证
+		TwoFactorVerified bool
+		// 缓存的权限列表及其对应的角色权限版本号
+		Permissions       []string
+		PermissionVersion string
+		// 通过API token访问时的token id及其可使用的权限
+		APIToken            uint
+		APITokenPermissions []string
 	}
 	// UserSession user session struct
 	UserSession struct {
@@ -82,7 +87,7 @@ type (
 	User struct {
 		helper.Model
 
-		Account  string `json:"account,omitempty" gorm:"type:varchar(20);not null;unique_index:idx_users_account"`
+		Account  string `json:"account,omitempty" gorm:"type:varchar(20);not null;uniqueIndex:idx_users_account"`
 		Password string `json:"-,omitempty" gorm:"type:varchar(128);not null"`
 		Name     string `json:"name,omitempty"`
 
@@ -107,6 +112,8 @@ type (
 		// 推荐人
 		Recommender     uint   `json:"recommender,omitempty"`
 		RecommenderName string `json:"recommenderName,omitempty" gorm:"-"`
+		// 登录失败次数过多时锁定至该时间
+		LockedUntil *time.Time `json:"lockedUntil,omitempty"`
 	}
 	// UserRole user role
 	UserRole struct {
@@ -166,6 +173,8 @@ type (
 		EnabledAmount float64    `json:"enabledAmount,omitempty"`
 		FrozenAmount  float64    `json:"frozenAmount,omitempty"`
 		Amount        float64    `json:"amount,omitempty"`
+		// 提现中金额
+		WithdrawingAmount float64 `json:"withdrawingAmount,omitempty"`
 	}
 )
 
@@ -179,18 +188,6 @@ func init() {
 		panic(err)
 	}
 
-	userRolesMap = map[string]string{
-		cs.UserRoleNormal: "普通用户",
-		cs.UserRoleAdmin:  "管理员",
-		cs.UserRoleSu:     "超级用户",
-	}
-	userGroupsMap = map[string]string{
-		cs.UserGroupIT:        "研发部",
-		cs.UserGroupMarketing: "市场部",
-		cs.UserGroupFinance:   "财务部",
-		cs.UserGroupLogistics: "物流部",
-	}
-
 	ttl := 300 * time.Second
 	// 本地开发环境，设置缓存为1秒
 	if util.IsDevelopment() {
@@ -228,23 +225,9 @@ func (u *User) BeforeCreate(_ *gorm.DB) (err error) {
 func (u *User) AfterFind(_ *gorm.DB) (err error) {
 	u.StatusDesc = getStatusDesc(u.Status)
 
-	userRolesDesc := make([]string, 0)
-	for _, role := range u.Roles {
-		value, ok := userRolesMap[role]
-		if ok {
-			userRolesDesc = append(userRolesDesc, value)
-		}
-	}
-	u.RolesDesc = userRolesDesc
-
-	userGroupsDesc := make([]string, 0)
-	for _, group := range u.Groups {
-		value, ok := userGroupsMap[group]
-		if ok {
-			userGroupsDesc = append(userGroupsDesc, value)
-		}
-	}
-	u.GroupsDesc = userGroupsDesc
+	// 角色与分组的描述从已启用的角色权限中获取，获取失败时忽略
+	u.RolesDesc = getRolesDesc(cs.RoleCategoryRole, u.Roles)
+	u.GroupsDesc = getRolesDesc(cs.RoleCategoryGroup, u.Groups)
 
 	if u.Recommender != 0 {
 		rcmder, _ := userSrv.FindByID(u.Recommender)
@@ -267,28 +250,54 @@ func (us Users) AfterFind(tx *gorm.DB) (err error) {
 	return
 }
 
-// ListRoles list all user roles
-func (srv *UserSrv) ListRole() []*UserRole {
-	userRoles := make([]*UserRole, 0)
-	for key, value := range userRolesMap {
+// getRolesDesc get the descriptions of roles
+func getRolesDesc(category string, names []string) []string {
+	desc := make([]string, 0)
+	if len(names) == 0 {
+		return desc
+	}
+	roles, _ := roleSrv.ListEnabled(category)
+	for _, name := range names {
+		for _, r := range roles {
+			if r.Name == name {
+				desc = append(desc, r.Description)
+				break
+			}
+		}
+	}
+	return desc
+}
+
+// ListRoles list all enabled user roles
+func (srv *UserSrv) ListRole() (userRoles []*UserRole, err error) {
+	roles, err := roleSrv.ListEnabled(cs.RoleCategoryRole)
+	if err != nil {
+		return
+	}
+	userRoles = make([]*UserRole, 0, len(roles))
+	for _, r := range roles {
 		userRoles = append(userRoles, &UserRole{
-			Name:  value,
-			Value: key,
+			Name:  r.Description,
+			Value: r.Name,
 		})
 	}
-	return userRoles
+	return
 }
 
-// ListGroups list all user group
-func (srv *UserSrv) ListGroup() []*UserGroup {
-	userGroups := make([]*UserGroup, 0)
-	for key, value := range userGroupsMap {
+// ListGroups list all enabled user groups
+func (srv *UserSrv) ListGroup() (userGroups []*UserGroup, err error) {
+	roles, err := roleSrv.ListEnabled(cs.RoleCategoryGroup)
+	if err != nil {
+		return
+	}
+	userGroups = make([]*UserGroup, 0, len(roles))
+	for _, r := range roles {
 		userGroups = append(userGroups, &UserGroup{
-			Name:  value,
-			Value: key,
+			Name:  r.Description,
+			Value: r.Name,
 		})
 	}
-	return userGroups
+	return
 }
 
 // createByID create a user model by id
@@ -311,6 +320,12 @@ func (srv *UserSrv) Add(data User) (u *User, err error) {
 	if u.Status == 0 {
 		u.Status = cs.StatusEnabled
 	}
+	if u.Password != "" {
+		u.Password, err = srv.HashPassword(u.Password)
+		if err != nil {
+			return
+		}
+	}
 
 	err = pgCreate(u)
 	return
@@ -326,21 +341,43 @@ func (srv *UserSrv) Login(account, password, token string) (u *User, err error)
 		}
 		return
 	}
-	pwd := util.Sha256(u.Password + token)
+	if u.IsLocked() {
+		err = errUserLocked
+		return
+	}
 	// 用于自动化测试使用
 	if util.IsDevelopment() && password == "fEqNCco3Yq9h5ZUglD3CZJT4lBsfEqNCco31Yq9h5ZUB" {
-		pwd = password
+		return
 	}
-	if pwd != password {
+	matched, rehash := srv.verifyPassword(u, password, token)
+	if !matched {
 		err = errAccountOrPasswordInvalid
 		return
 	}
+	// 旧数据或hash参数调整，重新hash
+	if rehash {
+		srv.rehashPassword(u, password)
+	}
 	return
 }
 
 // UpdateByID update user by id
 func (srv *UserSrv) UpdateByID(id uint, user User) (err error) {
 	err = pgGetClient().Model(srv.createByID(id)).Updates(user).Error
+	if err != nil {
+		return
+	}
+	// 禁用账户则注销其所有session
+	if user.Status == cs.StatusDisabled {
+		err = srv.RevokeSessions(id)
+		if err != nil {
+			return
+		}
+	}
+	// 角色或分组变更，刷新session中缓存的权限
+	if user.Roles != nil || user.Groups != nil {
+		err = roleSrv.IncreaseVersion()
+	}
 	return
 }
 
@@ -485,29 +522,18 @@ func (srv *UserSrv) GetMarketingGroupFromCache(id uint) (marketingGroup string,
 	return
 }
 
-// GetAmount get user's amount
+// GetAmount get user's amount from commission account
 func (*UserSrv) GetAmount(id uint) (userAmout *UserAmount, err error) {
-	commissions, err := orderCommissionSrv.ListAll(PGQueryParams{
-		Fields: "commissionAmount,updatedAt",
-	}, "recommender = ?", id)
+	account, err := commissionLedgerSrv.FindAccount(id)
 	if err != nil {
 		return
 	}
-	amount := 0.0
-	frozenAmount := 0.0
-	initTime := time.Unix(0, 0)
-	updatedAt := &initTime
-	for _, commission := range commissions {
-		if commission.UpdatedAt.Unix() > updatedAt.Unix() {
-			updatedAt = commission.UpdatedAt
-		}
-		amount += commission.CommissionAmount
-	}
 	userAmout = &UserAmount{
-		UpdatedAt:     updatedAt,
-		EnabledAmount: amount - frozenAmount,
-		Amount:        amount,
-		FrozenAmount:  frozenAmount,
+		UpdatedAt:         account.UpdatedAt,
+		EnabledAmount:     account.EnabledAmount,
+		Amount:            account.Amount,
+		FrozenAmount:      account.FrozenAmount,
+		WithdrawingAmount: account.WithdrawingAmount,
 	}
 	return
 }
@@ -524,6 +550,10 @@ func (us *UserSession) GetInfo() (info *UserSessionInfo, err error) {
 	if err != nil {
 		return
 	}
+	// 已被注销的session视为未登录
+	if userSrv.isSessionRevoked(info) {
+		info = new(UserSessionInfo)
+	}
 	us.info = info
 	return
 }
@@ -551,12 +581,17 @@ func (us *UserSession) IsLogined() bool {
 
 // SetInfo set user session info
 func (us *UserSession) SetInfo(data User) (err error) {
+	generation, err := userSrv.getSessionGeneration(data.ID)
+	if err != nil {
+		return
+	}
 	info := UserSessionInfo{
-		Account:   data.Account,
-		ID:        data.ID,
-		Roles:     data.Roles,
-		Groups:    data.Groups,
-		LoginedAt: util.NowString(),
+		Account:           data.Account,
+		ID:                data.ID,
+		Roles:             data.Roles,
+		Groups:            data.Groups,
+		LoginedAt:         util.NowString(),
+		SessionGeneration: generation,
 	}
 	buf, err := json.Marshal(&info)
 	if err != nil {
@@ -566,6 +601,8 @@ func (us *UserSession) SetInfo(data User) (err error) {
 	if err != nil {
 		return
 	}
+	// 记录用户的session，用于查询与注销
+	err = userSrv.addSession(data.ID, us.se.ID)
 	return
 }
 
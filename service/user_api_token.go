@@ -0,0 +1,256 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vicanso/elton"
+	session "github.com/vicanso/elton-session"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	UserAPITokens []*UserAPIToken
+	// UserAPIToken 用户的API token，用于脚本等机器客户端调用接口
+	UserAPIToken struct {
+		helper.Model
+
+		UserID uint   `json:"userID,omitempty" gorm:"index:idx_user_api_token_user;not null"`
+		Name   string `json:"name,omitempty" gorm:"not null"`
+		// token的前缀，用于展示与识别
+		Prefix string `json:"prefix,omitempty" gorm:"not null"`
+		// token sha256后保存
+		Token string `json:"-" gorm:"uniqueIndex:idx_user_api_token_token;not null"`
+		// 可使用的权限（仍受限于用户自身的权限）
+		Permissions pq.StringArray `json:"permissions,omitempty" gorm:"type:text[]"`
+		ExpiredAt   *time.Time     `json:"expiredAt,omitempty" gorm:"not null"`
+		// 最近一次使用
+		LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+		LastUsedIP string     `json:"lastUsedIP,omitempty"`
+	}
+)
+
+const (
+	// token的格式为 pat_ + 随机字符串
+	userAPITokenPrefix = "pat_"
+	userAPITokenSize   = 20
+	// 展示的前缀长度
+	userAPITokenDisplaySize = 12
+	// 最近使用时间的更新间隔，避免每次请求均更新数据库
+	userAPITokenTouchInterval = time.Minute
+)
+
+var (
+	errUserAPITokenInvalid = &hes.Error{
+		Message:    "API token无效或已过期",
+		StatusCode: http.StatusUnauthorized,
+		Category:   errUserCategory,
+	}
+	errUserAPITokenExpiredAtInvalid = &hes.Error{
+		Message:    "API token的过期时间无效",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errUserAPITokenPermissionDenied = &hes.Error{
+		Message:    "API token的权限不能超出用户自身的权限",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&UserAPIToken{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func getUserAPITokenMaxTTL() time.Duration {
+	return config.GetDurationDefault("apiToken.maxTTL", 365*24*time.Hour)
+}
+
+func hashAPIToken(token string) string {
+	return util.Sha256(token)
+}
+
+// generateAPIToken generate a random api token
+func generateAPIToken() (token string, err error) {
+	buf := make([]byte, userAPITokenSize)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return
+	}
+	token = userAPITokenPrefix + hex.EncodeToString(buf)
+	return
+}
+
+// AddAPIToken add api token for user, the token is only returned once
+func (srv *UserSrv) AddAPIToken(userID uint, data UserAPIToken, userPermissions []string) (token string, result *UserAPIToken, err error) {
+	now := util.Now()
+	if data.ExpiredAt == nil ||
+		data.ExpiredAt.Before(now) ||
+		data.ExpiredAt.After(now.Add(getUserAPITokenMaxTTL())) {
+		err = errUserAPITokenExpiredAtInvalid
+		return
+	}
+	for _, p := range data.Permissions {
+		if !util.HasPermission(userPermissions, p) {
+			err = errUserAPITokenPermissionDenied
+			return
+		}
+	}
+	token, err = generateAPIToken()
+	if err != nil {
+		return
+	}
+	data.UserID = userID
+	data.Prefix = token[:userAPITokenDisplaySize]
+	data.Token = hashAPIToken(token)
+	result = &data
+	err = pgCreate(result)
+	if err != nil {
+		token = ""
+	}
+	return
+}
+
+// ListAPIToken list the api tokens of user
+func (srv *UserSrv) ListAPIToken(userID uint) (result UserAPITokens, err error) {
+	result = make(UserAPITokens, 0)
+	err = pgGetClient().
+		Order("id DESC").
+		Find(&result, "user_id = ?", userID).Error
+	return
+}
+
+// DeleteAPIToken delete the api token of user
+func (srv *UserSrv) DeleteAPIToken(userID, id uint) (err error) {
+	db := pgGetClient().
+		Where("id = ? AND user_id = ?", id, userID).
+		Delete(&UserAPIToken{})
+	if db.Error != nil {
+		err = db.Error
+		return
+	}
+	if db.RowsAffected == 0 {
+		err = gorm.ErrRecordNotFound
+	}
+	return
+}
+
+// deleteAPITokens delete all api tokens of user
+func (srv *UserSrv) deleteAPITokens(userID uint) (err error) {
+	return pgGetClient().
+		Where("user_id = ?", userID).
+		Delete(&UserAPIToken{}).Error
+}
+
+// VerifyAPIToken verify the api token, it returns the token and user
+func (srv *UserSrv) VerifyAPIToken(token, ip string) (t *UserAPIToken, u *User, err error) {
+	if !strings.HasPrefix(token, userAPITokenPrefix) {
+		err = errUserAPITokenInvalid
+		return
+	}
+	t = new(UserAPIToken)
+	err = pgGetClient().First(t, "token = ?", hashAPIToken(token)).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			err = errUserAPITokenInvalid
+		}
+		return
+	}
+	now := util.Now()
+	if t.ExpiredAt == nil || t.ExpiredAt.Before(now) {
+		err = errUserAPITokenInvalid
+		return
+	}
+	u, err = srv.FindByID(t.UserID)
+	if err != nil {
+		return
+	}
+	if u.Status != cs.StatusEnabled {
+		err = errUserAPITokenInvalid
+		return
+	}
+	// 更新最近使用时间，失败不影响使用
+	e := pgGetClient().Model(t).
+		Where("last_used_at IS NULL OR last_used_at < ?", now.Add(-userAPITokenTouchInterval)).
+		Updates(map[string]interface{}{
+			"last_used_at": &now,
+			"last_used_ip": ip,
+		}).Error
+	if e != nil {
+		logger.Error("update api token last used fail",
+			zap.Uint("token", t.ID),
+			zap.Error(e),
+		)
+	}
+	return
+}
+
+// NewAPITokenUserSession create the user session of api token, the session
+// is only kept in memory of the request, so the handlers can use it as
+// the session of cookie
+func NewAPITokenUserSession(c *elton.Context, t *UserAPIToken, u *User) (us *UserSession, err error) {
+	se := &session.Session{
+		Store: userSessionStore,
+	}
+	_, err = se.Fetch()
+	if err != nil {
+		return
+	}
+	c.Set(session.Key, se)
+	us = NewUserSession(c)
+	permissions := t.Permissions
+	if permissions == nil {
+		permissions = make([]string, 0)
+	}
+	generation, err := userSrv.getSessionGeneration(u.ID)
+	if err != nil {
+		return
+	}
+	err = us.saveInfo(&UserSessionInfo{
+		Account:             u.Account,
+		ID:                  u.ID,
+		Roles:               u.Roles,
+		Groups:              u.Groups,
+		LoginedAt:           util.NowString(),
+		SessionGeneration:   generation,
+		APIToken:            t.ID,
+		APITokenPermissions: permissions,
+	})
+	return
+}
+
+// IsAPIToken check the session is created by api token
+func (us *UserSession) IsAPIToken() bool {
+	info, err := us.GetInfo()
+	if err != nil || info == nil {
+		return false
+	}
+	return info.APIToken != 0
+}
@@ -0,0 +1,383 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/cs"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+)
+
+type (
+	// LoginGuardConfig 登录防护配置
+	LoginGuardConfig struct {
+		// 账户在统计周期内允许的最大失败次数，超过则锁定
+		MaxFailures   int
+		FailureWindow time.Duration
+		// 账户锁定时长
+		LockDuration time.Duration
+		// 相同IP在统计周期内登录失败的账户数，超过则屏蔽该IP（0表示不屏蔽）
+		IPMaxAccounts   int
+		IPFailureWindow time.Duration
+		// IP屏蔽时长
+		IPBlockDuration time.Duration
+		// 异地或新设备登录是否需要邮箱验证码校验
+		StepUp bool
+	}
+
+	// LoginRisk 登录风险评估结果
+	LoginRisk struct {
+		// 新的登录设备
+		NewDevice bool `json:"newDevice,omitempty"`
+		// 新的登录地点（国家或城市）
+		NewLocation bool      `json:"newLocation,omitempty"`
+		Location    *Location `json:"location,omitempty"`
+	}
+)
+
+const (
+	loginFailureKeyPrefix    = "login-failure-"
+	loginFailureIPKeyPrefix  = "login-failure-ip-"
+	loginBlockIPLockPrefix   = "login-block-ip-"
+	loginStepUpCodeKeyPrefix = "login-step-up-code-"
+
+	loginStepUpCodeLength = 6
+	// 用于比较的历史登录记录数
+	loginRiskRecordLimit = 50
+	// 自动屏蔽IP的配置创建者
+	loginGuardOwner = "loginGuard"
+)
+
+var (
+	errUserLocked = &hes.Error{
+		Message:    "账户登录失败次数过多已被锁定，请稍后再试或联系管理员",
+		StatusCode: http.StatusForbidden,
+		Category:   errUserCategory,
+	}
+	errLoginStepUpCodeInvalid = &hes.Error{
+		Message:    "验证码错误或已过期",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+// GetLoginGuardConfig get login guard config
+func GetLoginGuardConfig() LoginGuardConfig {
+	prefix := "loginGuard."
+	return LoginGuardConfig{
+		MaxFailures:     config.GetIntDefault(prefix+"maxFailures", 10),
+		FailureWindow:   config.GetDurationDefault(prefix+"failureWindow", 24*time.Hour),
+		LockDuration:    config.GetDurationDefault(prefix+"lockDuration", time.Hour),
+		IPMaxAccounts:   config.GetIntDefault(prefix+"ipMaxAccounts", 0),
+		IPFailureWindow: config.GetDurationDefault(prefix+"ipFailureWindow", 10*time.Minute),
+		IPBlockDuration: config.GetDurationDefault(prefix+"ipBlockDuration", 24*time.Hour),
+		StepUp:          config.GetBool(prefix + "stepUp"),
+	}
+}
+
+// IsSuspicious check the login is from new device or new location
+func (r *LoginRisk) IsSuspicious() bool {
+	return r.NewDevice || r.NewLocation
+}
+
+// IsLocked check the user is locked
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && util.Now().Before(*u.LockedUntil)
+}
+
+// AddLoginFailure add the login failure of account and ip,
+// lock the account or block the ip if it reaches the limit
+func (srv *UserSrv) AddLoginFailure(account, ip string, loginErr error) {
+	// 仅统计账户或密码错误
+	if loginErr != errAccountOrPasswordInvalid {
+		return
+	}
+	conf := GetLoginGuardConfig()
+	err := srv.addAccountLoginFailure(account, conf)
+	if err != nil {
+		logger.Error("add account login failure fail",
+			zap.String("account", account),
+			zap.Error(err),
+		)
+	}
+	err = srv.addIPLoginFailure(account, ip, conf)
+	if err != nil {
+		logger.Error("add ip login failure fail",
+			zap.String("ip", ip),
+			zap.Error(err),
+		)
+	}
+}
+
+// ClearLoginFailure clear the login failure count of account
+func (srv *UserSrv) ClearLoginFailure(account string) error {
+	return redisSrv.Del(loginFailureKeyPrefix + account)
+}
+
+func (srv *UserSrv) addAccountLoginFailure(account string, conf LoginGuardConfig) (err error) {
+	if conf.MaxFailures <= 0 {
+		return
+	}
+	key := loginFailureKeyPrefix + account
+	count, err := redisSrv.IncWithTTL(key, conf.FailureWindow)
+	if err != nil || count < int64(conf.MaxFailures) {
+		return
+	}
+	// 锁定后重新计数
+	err = redisSrv.Del(key)
+	if err != nil {
+		return
+	}
+	return srv.lock(account, conf.LockDuration)
+}
+
+// lock lock the account and notify the user
+func (srv *UserSrv) lock(account string, duration time.Duration) (err error) {
+	u, err := srv.FindOneByAccount(account)
+	// 账户不存在则忽略
+	if err != nil {
+		err = nil
+		return
+	}
+	lockedUntil := util.Now().Add(duration)
+	err = pgGetClient().Model(srv.createByID(u.ID)).Update("locked_until", lockedUntil).Error
+	if err != nil {
+		return
+	}
+	AlarmError(fmt.Sprintf("account %s is locked until %s because of too many login failures", account, util.FormatTime(lockedUntil)))
+	if u.Email != "" {
+		SendMail([]string{
+			u.Email,
+		}, "账户锁定提醒", fmt.Sprintf("您的账户%s因多次登录失败已被锁定至%s，如非本人操作请及时修改密码。", account, util.FormatTime(lockedUntil)))
+	}
+	return
+}
+
+// Unlock unlock the user and reset the login failure count
+func (srv *UserSrv) Unlock(id uint) (err error) {
+	u, err := srv.FindByID(id)
+	if err != nil {
+		return
+	}
+	err = pgGetClient().Model(srv.createByID(id)).Update("locked_until", nil).Error
+	if err != nil {
+		return
+	}
+	return srv.ClearLoginFailure(u.Account)
+}
+
+func (srv *UserSrv) addIPLoginFailure(account, ip string, conf LoginGuardConfig) (err error) {
+	if conf.IPMaxAccounts <= 0 || ip == "" {
+		return
+	}
+	// 可信代理或内网地址不自动屏蔽，避免代理配置错误时所有客户端均被屏蔽
+	if util.IsTrustedProxy(ip) || util.IsIntranetIP(ip) {
+		return
+	}
+	// 记录该IP登录失败的账户（set去重）
+	key := loginFailureIPKeyPrefix + ip
+	pipe := helper.RedisGetClient().TxPipeline()
+	pipe.SAdd(key, account)
+	pipe.Expire(key, conf.IPFailureWindow)
+	countCmd := pipe.SCard(key)
+	_, err = pipe.Exec()
+	if err != nil {
+		return
+	}
+	count := countCmd.Val()
+	if count < int64(conf.IPMaxAccounts) {
+		return
+	}
+	return blockLoginIP(ip, int(count), conf.IPBlockDuration)
+}
+
+// blockLoginIP add the ip to block ip configuration
+func blockLoginIP(ip string, accountCount int, duration time.Duration) (err error) {
+	if IsBlockIP(ip) {
+		return
+	}
+	// 避免并发时重复添加
+	success, err := redisSrv.Lock(loginBlockIPLockPrefix+ip, duration)
+	if err != nil || !success {
+		return
+	}
+	now := util.Now()
+	endDate := now.Add(duration)
+	configSrv := new(ConfigurationSrv)
+	_, err = configSrv.Add(Configuration{
+		Name:      "autoBlockIP-" + strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10),
+		Category:  blockIPCategory,
+		Owner:     loginGuardOwner,
+		Status:    cs.StatusEnabled,
+		Data:      ip,
+		BeginDate: &now,
+		EndDate:   &endDate,
+	})
+	if err != nil {
+		return
+	}
+	AlarmError(fmt.Sprintf("ip %s is blocked until %s because of login failures of %d accounts", ip, util.FormatTime(endDate), accountCount))
+	// 其它实例由定时任务刷新
+	return configSrv.Refresh()
+}
+
+// isNewLoginDevice check the device is not used by the records,
+// compare uuid first and then user agent
+func isNewLoginDevice(records UserLoginRecords, uuid, userAgent string) bool {
+	for _, r := range records {
+		if uuid != "" {
+			if r.UUID == uuid {
+				return false
+			}
+			continue
+		}
+		if r.UserAgent == userAgent {
+			return false
+		}
+	}
+	return true
+}
+
+// isNewLoginLocation check the country or city is not used by the records,
+// the records without location are ignored
+func isNewLoginLocation(records UserLoginRecords, lo *Location) bool {
+	if lo == nil || lo.Country == "" {
+		return false
+	}
+	found := false
+	for _, r := range records {
+		if r.Country == "" {
+			continue
+		}
+		found = true
+		if r.Country == lo.Country && r.City == lo.City {
+			return false
+		}
+	}
+	return found
+}
+
+// AssessLogin assess the login risk of account by the history login records
+func (srv *UserSrv) AssessLogin(account, ip, uuid, userAgent string, c *elton.Context) (risk *LoginRisk, err error) {
+	risk = new(LoginRisk)
+	records, err := srv.ListLoginRecord(PGQueryParams{
+		Limit: loginRiskRecordLimit,
+		Order: "-createdAt",
+	}, "account = ?", account)
+	if err != nil {
+		return
+	}
+	// 首次登录无记录可比较
+	if len(records) == 0 {
+		return
+	}
+	risk.NewDevice = isNewLoginDevice(records, uuid, userAgent)
+	lo, e := GetLocationByIP(ip, c)
+	// 获取地理位置失败不影响登录
+	if e != nil {
+		logger.Error("get location by ip fail",
+			zap.String("ip", ip),
+			zap.Error(e),
+		)
+		return
+	}
+	risk.Location = lo
+	risk.NewLocation = isNewLoginLocation(records, lo)
+	return
+}
+
+func (r *LoginRisk) locationDesc() string {
+	if r.Location == nil {
+		return "未知"
+	}
+	arr := make([]string, 0, 3)
+	for _, item := range []string{
+		r.Location.Country,
+		r.Location.Province,
+		r.Location.City,
+	} {
+		if item != "" && !util.ContainsString(arr, item) {
+			arr = append(arr, item)
+		}
+	}
+	if len(arr) == 0 {
+		return "未知"
+	}
+	return strings.Join(arr, " ")
+}
+
+// NotifySuspiciousLogin notify the user by email that login from new device or location
+func (srv *UserSrv) NotifySuspiciousLogin(u *User, ip, userAgent string, risk *LoginRisk) {
+	if u.Email == "" || !risk.IsSuspicious() {
+		return
+	}
+	SendMail([]string{
+		u.Email,
+	}, "账户登录提醒", fmt.Sprintf("您的账户%s于%s在新的设备或地点登录（IP：%s，地点：%s，设备：%s），如非本人操作请及时修改密码并注销其它登录。",
+		u.Account,
+		util.NowString(),
+		ip,
+		risk.locationDesc(),
+		userAgent,
+	))
+}
+
+func getLoginStepUpCodeKey(id uint) string {
+	return loginStepUpCodeKeyPrefix + strconv.Itoa(int(id))
+}
+
+// SendLoginStepUpCode send the step up verification code to the email of user
+func (srv *UserSrv) SendLoginStepUpCode(u *User, ip string, risk *LoginRisk) (err error) {
+	code := util.SecureRandomDigit(loginStepUpCodeLength)
+	err = redisSrv.Set(getLoginStepUpCodeKey(u.ID), code, userPendingLoginTTL)
+	if err != nil {
+		return
+	}
+	SendMail([]string{
+		u.Email,
+	}, "登录验证", fmt.Sprintf("您的账户%s正在新的设备或地点登录（IP：%s，地点：%s），验证码为：%s，%d分钟内有效，如非本人操作请及时修改密码。",
+		u.Account,
+		ip,
+		risk.locationDesc(),
+		code,
+		int(userPendingLoginTTL.Minutes()),
+	))
+	return
+}
+
+// VerifyLoginStepUpCode verify the step up code, it can only be used once
+func (srv *UserSrv) VerifyLoginStepUpCode(id uint, code string) (err error) {
+	key := getLoginStepUpCodeKey(id)
+	value, err := redisSrv.GetIgnoreNilErr(key)
+	if err != nil {
+		return
+	}
+	if value == "" || subtle.ConstantTimeCompare([]byte(value), []byte(code)) != 1 {
+		err = errLoginStepUpCodeInvalid
+		return
+	}
+	return redisSrv.Del(key)
+}
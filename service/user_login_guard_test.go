@@ -0,0 +1,131 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsNewLoginDevice(t *testing.T) {
+	assert := assert.New(t)
+	records := UserLoginRecords{
+		{
+			UUID:      "uuid-1",
+			UserAgent: "Chrome",
+		},
+		{
+			UserAgent: "Safari",
+		},
+	}
+
+	tests := []struct {
+		uuid      string
+		userAgent string
+		expected  bool
+	}{
+		{
+			uuid:      "uuid-1",
+			userAgent: "Firefox",
+			expected:  false,
+		},
+		// 有uuid时仅对比uuid
+		{
+			uuid:      "uuid-2",
+			userAgent: "Chrome",
+			expected:  true,
+		},
+		{
+			userAgent: "Safari",
+			expected:  false,
+		},
+		{
+			userAgent: "Firefox",
+			expected:  true,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, isNewLoginDevice(records, tt.uuid, tt.userAgent))
+	}
+	// 首次登录
+	assert.True(isNewLoginDevice(nil, "uuid-1", "Chrome"))
+}
+
+func TestIsNewLoginLocation(t *testing.T) {
+	assert := assert.New(t)
+	records := UserLoginRecords{
+		{
+			Country: "中国",
+			City:    "广州",
+		},
+		// 未获取到位置的记录
+		{},
+	}
+
+	tests := []struct {
+		records  UserLoginRecords
+		location *Location
+		expected bool
+	}{
+		{
+			records: records,
+			location: &Location{
+				Country: "中国",
+				City:    "广州",
+			},
+			expected: false,
+		},
+		{
+			records: records,
+			location: &Location{
+				Country: "中国",
+				City:    "深圳",
+			},
+			expected: true,
+		},
+		{
+			records: records,
+			location: &Location{
+				Country: "美国",
+			},
+			expected: true,
+		},
+		// 当前位置未知
+		{
+			records:  records,
+			location: &Location{},
+			expected: false,
+		},
+		{
+			records:  records,
+			expected: false,
+		},
+		// 历史记录均无位置
+		{
+			records: UserLoginRecords{
+				{},
+			},
+			location: &Location{
+				Country: "中国",
+				City:    "广州",
+			},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.expected, isNewLoginLocation(tt.records, tt.location))
+	}
+}
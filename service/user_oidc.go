@@ -0,0 +1,357 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type (
+	UserIdentities []*UserIdentity
+	// UserIdentity 用户关联的第三方（OpenID Connect）账户
+	UserIdentity struct {
+		helper.Model
+
+		UserID   uint   `json:"userID,omitempty" gorm:"index:idx_user_identity_user;not null"`
+		Provider string `json:"provider,omitempty" gorm:"uniqueIndex:idx_user_identity_subject;not null"`
+		// provider中的用户唯一标识
+		Subject string `json:"subject,omitempty" gorm:"uniqueIndex:idx_user_identity_subject;not null"`
+		Email   string `json:"email,omitempty"`
+		Name    string `json:"name,omitempty"`
+	}
+	// UserOIDCAuthState 第三方登录授权时保存在session中的状态
+	UserOIDCAuthState struct {
+		Provider     string `json:"provider,omitempty"`
+		State        string `json:"state,omitempty"`
+		Nonce        string `json:"nonce,omitempty"`
+		CodeVerifier string `json:"codeVerifier,omitempty"`
+		// 自动注册时的推荐人
+		Recommender uint `json:"recommender,omitempty"`
+		// 已登录用户关联第三方账户
+		UserID    uint   `json:"userID,omitempty"`
+		CreatedAt string `json:"createdAt,omitempty"`
+	}
+	oidcProvider struct {
+		config   config.OIDCProviderConfig
+		provider *util.OIDCProvider
+	}
+)
+
+const (
+	userOIDCAuthStateKey = "oidcAuthState"
+	// 授权的有效期
+	userOIDCAuthStateTTL = 10 * time.Minute
+	// 自动注册的账户名（u + 9位数字）
+	userOIDCAccountPrefix = "u"
+	userOIDCAccountDigits = 9
+	// 随机账号冲突时的重试次数
+	userOIDCAccountRetries = 5
+)
+
+var (
+	errOIDCProviderNotFound = &hes.Error{
+		Message:    "不支持该第三方登录",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errOIDCAuthStateInvalid = &hes.Error{
+		Message:    "第三方登录已超时或状态不匹配，请重新登录",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errOIDCVerifyFail = &hes.Error{
+		Message:    "第三方登录校验失败",
+		StatusCode: http.StatusUnauthorized,
+		Category:   errUserCategory,
+	}
+	errUserIdentityLinked = &hes.Error{
+		Message:    "该第三方账户已关联其它用户",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+var (
+	oidcProviders     map[string]*oidcProvider
+	oidcProviderNames []string
+)
+
+func init() {
+	err := helper.PGAutoMigrate(&UserIdentity{})
+	if err != nil {
+		panic(err)
+	}
+	oidcProviders = make(map[string]*oidcProvider)
+	oidcProviderNames = make([]string, 0)
+	for _, item := range config.GetOIDCProviderConfigs() {
+		oidcProviderNames = append(oidcProviderNames, item.Name)
+		oidcProviders[item.Name] = &oidcProvider{
+			config: item,
+			provider: util.NewOIDCProvider(util.OIDCConfig{
+				Issuer:       item.Issuer,
+				ClientID:     item.ClientID,
+				ClientSecret: item.ClientSecret,
+				RedirectURL:  item.RedirectURL,
+				Scopes:       item.Scopes,
+			}, 10*time.Second),
+		}
+	}
+}
+
+// GetOIDCRedirectURL get the front-end url which will be redirected to after login
+func GetOIDCRedirectURL() string {
+	return config.GetStringDefault("oidc.redirectURL", "/")
+}
+
+func getOIDCProvider(name string) (*oidcProvider, error) {
+	p, ok := oidcProviders[name]
+	if !ok {
+		return nil, errOIDCProviderNotFound
+	}
+	return p, nil
+}
+
+// ListOIDCProvider list the names of oidc providers
+func (srv *UserSrv) ListOIDCProvider() []string {
+	return oidcProviderNames
+}
+
+// NewOIDCAuthState create a new auth state of provider
+func (srv *UserSrv) NewOIDCAuthState(provider string) (state *UserOIDCAuthState, err error) {
+	_, err = getOIDCProvider(provider)
+	if err != nil {
+		return
+	}
+	state = &UserOIDCAuthState{
+		Provider:  provider,
+		CreatedAt: util.NowString(),
+	}
+	for _, value := range []*string{
+		&state.State,
+		&state.Nonce,
+		&state.CodeVerifier,
+	} {
+		*value, err = util.GenerateOIDCRandom()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetOIDCAuthURL get the authorization url of provider
+func (srv *UserSrv) GetOIDCAuthURL(state *UserOIDCAuthState) (string, error) {
+	p, err := getOIDCProvider(state.Provider)
+	if err != nil {
+		return "", err
+	}
+	return p.provider.AuthCodeURL(state.State, state.Nonce, state.CodeVerifier)
+}
+
+// verifyOIDCCode exchange the code and verify the id token
+func (srv *UserSrv) verifyOIDCCode(p *oidcProvider, state *UserOIDCAuthState, code string) (claims *util.OIDCClaims, err error) {
+	token, err := p.provider.Exchange(code, state.CodeVerifier)
+	if err == nil {
+		claims, err = p.provider.VerifyIDToken(token.IDToken, state.Nonce)
+	}
+	if err != nil {
+		logger.Error("oidc verify fail",
+			zap.String("provider", state.Provider),
+			zap.Error(err),
+		)
+		err = errOIDCVerifyFail
+	}
+	return
+}
+
+// linkIdentity link the identity to user
+func (srv *UserSrv) linkIdentity(userID uint, provider string, claims *util.OIDCClaims) (err error) {
+	identity, err := srv.findIdentity(provider, claims.Subject)
+	if err == nil {
+		if identity.UserID != userID {
+			err = errUserIdentityLinked
+		}
+		return
+	}
+	if err != gorm.ErrRecordNotFound {
+		return
+	}
+	err = pgCreate(&UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		Name:     claims.Name,
+	})
+	return
+}
+
+// findIdentity find the identity by provider and subject
+func (srv *UserSrv) findIdentity(provider, subject string) (identity *UserIdentity, err error) {
+	identity = new(UserIdentity)
+	err = pgGetClient().First(identity, "provider = ? AND subject = ?", provider, subject).Error
+	return
+}
+
+// registerByOIDC register a new user by the claims of id token
+func (srv *UserSrv) registerByOIDC(claims *util.OIDCClaims, recommender uint) (u *User, err error) {
+	// 随机密码，用户只能通过第三方登录（或重置密码）
+	password, err := util.GenerateOIDCRandom()
+	if err != nil {
+		return
+	}
+	user := User{
+		Password:    password,
+		Name:        claims.Name,
+		Recommender: recommender,
+	}
+	if claims.EmailVerified {
+		user.Email = claims.Email
+	}
+	// 随机生成账号，账号已存在（唯一约束冲突）则重试
+	for i := 0; i < userOIDCAccountRetries; i++ {
+		user.Account = userOIDCAccountPrefix + util.SecureRandomDigit(userOIDCAccountDigits)
+		u, err = srv.Add(user)
+		if err == nil || !helper.PGIsUniqueViolation(err) {
+			return
+		}
+	}
+	return
+}
+
+// OIDCLogin verify the code of provider and get the user,
+// the user will be registered if not exists
+func (srv *UserSrv) OIDCLogin(state *UserOIDCAuthState, code string) (u *User, err error) {
+	p, err := getOIDCProvider(state.Provider)
+	if err != nil {
+		return
+	}
+	claims, err := srv.verifyOIDCCode(p, state, code)
+	if err != nil {
+		return
+	}
+	// 关联已登录的用户
+	if state.UserID != 0 {
+		err = srv.linkIdentity(state.UserID, state.Provider, claims)
+		if err != nil {
+			return
+		}
+		return srv.FindByID(state.UserID)
+	}
+	identity, err := srv.findIdentity(state.Provider, claims.Subject)
+	if err == nil {
+		u, err = srv.FindByID(identity.UserID)
+		if err != nil {
+			return
+		}
+		// 与账户密码登录一致，锁定的账户不可登录
+		if u.IsLocked() {
+			err = errUserLocked
+		}
+		return
+	}
+	if err != gorm.ErrRecordNotFound {
+		return
+	}
+	// 信任provider校验的邮箱，关联同邮箱的账户
+	if p.config.TrustEmail && claims.EmailVerified && claims.Email != "" {
+		users := make(Users, 0)
+		err = pgGetClient().Limit(2).Find(&users, "email = ?", claims.Email).Error
+		if err != nil {
+			return
+		}
+		if len(users) == 1 {
+			u = users[0]
+			if u.IsLocked() {
+				err = errUserLocked
+				return
+			}
+		}
+	}
+	if u == nil {
+		u, err = srv.registerByOIDC(claims, state.Recommender)
+		if err != nil {
+			return
+		}
+	}
+	err = srv.linkIdentity(u.ID, state.Provider, claims)
+	return
+}
+
+// ListIdentity list the identities of user
+func (srv *UserSrv) ListIdentity(userID uint) (result UserIdentities, err error) {
+	result = make(UserIdentities, 0)
+	err = pgGetClient().Find(&result, "user_id = ?", userID).Error
+	return
+}
+
+// DeleteIdentity delete the identity of user
+func (srv *UserSrv) DeleteIdentity(userID, id uint) (err error) {
+	db := pgGetClient().Unscoped().
+		Where("id = ? AND user_id = ?", id, userID).
+		Delete(&UserIdentity{})
+	if db.Error != nil {
+		err = db.Error
+		return
+	}
+	if db.RowsAffected == 0 {
+		err = gorm.ErrRecordNotFound
+	}
+	return
+}
+
+// SetOIDCAuthState set the oidc auth state
+func (us *UserSession) SetOIDCAuthState(state *UserOIDCAuthState) (err error) {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	return us.se.Set(userOIDCAuthStateKey, string(buf))
+}
+
+// TakeOIDCAuthState get and clear the oidc auth state, the state should match
+func (us *UserSession) TakeOIDCAuthState(provider, state string) (data *UserOIDCAuthState, err error) {
+	value := us.se.GetString(userOIDCAuthStateKey)
+	// 只允许使用一次
+	err = us.se.Set(userOIDCAuthStateKey, "")
+	if err != nil {
+		return
+	}
+	data = new(UserOIDCAuthState)
+	if value == "" || json.Unmarshal([]byte(value), data) != nil {
+		data = nil
+		err = errOIDCAuthStateInvalid
+		return
+	}
+	createdAt, e := time.Parse(time.RFC3339, data.CreatedAt)
+	if e != nil ||
+		util.Now().Sub(createdAt) > userOIDCAuthStateTTL ||
+		data.Provider != provider ||
+		data.State != state {
+		data = nil
+		err = errOIDCAuthStateInvalid
+		return
+	}
+	return
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+)
+
+const (
+	PasswordHasherArgon2id = "argon2id"
+	PasswordHasherBcrypt   = "bcrypt"
+)
+
+var (
+	errUserPasswordIncorrect = &hes.Error{
+		Message:    "密码错误",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+var (
+	// 当前使用的密码hash
+	defaultPasswordHasher util.PasswordHasher
+	// 所有支持校验的密码hash
+	passwordHashers []util.PasswordHasher
+)
+
+func init() {
+	argon2idHasher := util.NewArgon2idHasher(
+		uint32(config.GetIntDefault("password.argon2Time", 1)),
+		uint32(config.GetIntDefault("password.argon2Memory", 64*1024)),
+		uint8(config.GetIntDefault("password.argon2Threads", 4)),
+	)
+	bcryptHasher := util.NewBcryptHasher(config.GetIntDefault("password.bcryptCost", 10))
+	passwordHashers = []util.PasswordHasher{
+		argon2idHasher,
+		bcryptHasher,
+	}
+	defaultPasswordHasher = argon2idHasher
+	if config.GetStringDefault("password.hasher", PasswordHasherArgon2id) == PasswordHasherBcrypt {
+		defaultPasswordHasher = bcryptHasher
+	}
+}
+
+// getPasswordHasher get the hasher of hashed password
+func getPasswordHasher(hashed string) util.PasswordHasher {
+	for _, h := range passwordHashers {
+		if h.Match(hashed) {
+			return h
+		}
+	}
+	return nil
+}
+
+// HashPassword hash the password(client derived) by default hasher
+func (srv *UserSrv) HashPassword(password string) (string, error) {
+	return defaultPasswordHasher.Hash(password)
+}
+
+// verifyPassword verify the password of user, the token is used for
+// legacy client which sends sha256(password + token),
+// it returns whether the password should be rehashed
+func (srv *UserSrv) verifyPassword(u *User, password, token string) (matched, rehash bool) {
+	// 旧数据保存的是客户端生成的密码，校验成功后需要hash
+	if !util.IsPasswordHashed(u.Password) {
+		matched = subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
+		if !matched && token != "" {
+			matched = subtle.ConstantTimeCompare([]byte(util.Sha256(u.Password+token)), []byte(password)) == 1
+		}
+		return matched, matched
+	}
+	h := getPasswordHasher(u.Password)
+	if h == nil {
+		return
+	}
+	matched = h.Verify(u.Password, password)
+	rehash = matched && (h != defaultPasswordHasher || h.NeedsRehash(u.Password))
+	return
+}
+
+// rehashPassword rehash the password of user by default hasher
+func (srv *UserSrv) rehashPassword(u *User, password string) {
+	// 旧数据直接使用保存的客户端密码
+	if !util.IsPasswordHashed(u.Password) {
+		password = u.Password
+	}
+	hashed, err := srv.HashPassword(password)
+	if err == nil {
+		err = pgGetClient().Model(&User{}).
+			Where("id = ? AND password = ?", u.ID, u.Password).
+			Update("password", hashed).Error
+	}
+	// 重新hash失败不影响登录
+	if err != nil {
+		logger.Error("rehash password fail",
+			zap.String("account", u.Account),
+			zap.Error(err),
+		)
+		return
+	}
+	u.Password = hashed
+}
+
+// CheckPassword check the password of account
+func (srv *UserSrv) CheckPassword(account, password string) (err error) {
+	u, err := srv.FindOneByAccount(account)
+	if err != nil {
+		return
+	}
+	matched, rehash := srv.verifyPassword(u, password, "")
+	if !matched {
+		err = errUserPasswordIncorrect
+		return
+	}
+	if rehash {
+		srv.rehashPassword(u, password)
+	}
+	return
+}
+
+// UpdatePassword update the password of account
+func (srv *UserSrv) UpdatePassword(account, password string) (err error) {
+	hashed, err := srv.HashPassword(password)
+	if err != nil {
+		return
+	}
+	err = srv.UpdateByAccount(account, map[string]interface{}{
+		"password": hashed,
+	})
+	return
+}
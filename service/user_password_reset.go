@@ -0,0 +1,179 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// 通过邮件发送验证码
+	PasswordResetChannelEmail = "email"
+	// 通过短信发送验证码
+	PasswordResetChannelSMS = "sms"
+
+	passwordResetCodeKeyPrefix     = "user-pwd-reset-code-"
+	passwordResetAttemptsKeyPrefix = "user-pwd-reset-attempts-"
+	passwordResetLockKeyPrefix     = "user-pwd-reset-lock-"
+
+	passwordResetCodeLength = 6
+	// 验证码最多可尝试次数，超过则失效
+	passwordResetMaxAttempts = 5
+	// 两次发送验证码的最小间隔
+	passwordResetSendInterval = time.Minute
+)
+
+var (
+	errPasswordResetTooFrequently = &hes.Error{
+		Message:    "验证码发送过于频繁，请稍候再试",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errPasswordResetCodeInvalid = &hes.Error{
+		Message:    "验证码错误或已失效",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errPasswordResetTooManyAttempts = &hes.Error{
+		Message:    "验证码错误次数过多，请重新获取",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+func getPasswordResetCodeTTL() time.Duration {
+	return config.GetDurationDefault("password.resetCodeTTL", 10*time.Minute)
+}
+
+// SendPasswordResetCode send the reset code to the email or mobile of account,
+// it does nothing if the account is not exists or without email(mobile),
+// so the caller can't detect whether the account exists
+func (srv *UserSrv) SendPasswordResetCode(account, channel string) (err error) {
+	// 未接入短信服务商时不支持短信渠道
+	if channel == PasswordResetChannelSMS && !IsSMSAvailable() {
+		err = errSMSUnavailable
+		return
+	}
+	success, err := redisSrv.Lock(passwordResetLockKeyPrefix+account, passwordResetSendInterval)
+	if err != nil {
+		return
+	}
+	if !success {
+		err = errPasswordResetTooFrequently
+		return
+	}
+	u, err := srv.FindOneByAccount(account)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			err = nil
+		}
+		return
+	}
+	target := u.Email
+	if channel == PasswordResetChannelSMS {
+		target = u.Mobile
+	}
+	if target == "" {
+		logger.Info("the user has no target to receive reset code",
+			zap.String("account", account),
+			zap.String("channel", channel),
+		)
+		return
+	}
+
+	code := util.SecureRandomDigit(passwordResetCodeLength)
+	ttl := getPasswordResetCodeTTL()
+	err = redisSrv.Set(passwordResetCodeKeyPrefix+account, code, ttl)
+	if err != nil {
+		return
+	}
+	// 新的验证码重置尝试次数
+	err = redisSrv.Del(passwordResetAttemptsKeyPrefix + account)
+	if err != nil {
+		return
+	}
+	content := fmt.Sprintf("您的重置密码验证码为：%s，%d分钟内有效，请勿告知他人。", code, int(ttl.Minutes()))
+	if channel == PasswordResetChannelSMS {
+		err = SendSMS(target, content)
+		return
+	}
+	SendMail([]string{
+		target,
+	}, "重置密码", content)
+	return
+}
+
+// ResetPassword reset the password of account by the code,
+// the code can only be used once and all sessions of user will be revoked
+func (srv *UserSrv) ResetPassword(account, code, password string) (err error) {
+	codeKey := passwordResetCodeKeyPrefix + account
+	value, err := redisSrv.GetIgnoreNilErr(codeKey)
+	if err != nil {
+		return
+	}
+	if value == "" {
+		err = errPasswordResetCodeInvalid
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(value), []byte(code)) != 1 {
+		count, e := redisSrv.IncWithTTL(passwordResetAttemptsKeyPrefix+account, getPasswordResetCodeTTL())
+		if e != nil {
+			err = e
+			return
+		}
+		if count < passwordResetMaxAttempts {
+			err = errPasswordResetCodeInvalid
+			return
+		}
+		err = redisSrv.Del(codeKey)
+		if err != nil {
+			return
+		}
+		err = errPasswordResetTooManyAttempts
+		return
+	}
+	// 删除验证码，保证只能使用一次
+	value, err = redisSrv.GetAndDel(codeKey)
+	if err != nil {
+		if helper.IsRedisNilError(err) {
+			err = errPasswordResetCodeInvalid
+		}
+		return
+	}
+	if value != code {
+		err = errPasswordResetCodeInvalid
+		return
+	}
+	u, err := srv.FindOneByAccount(account)
+	if err != nil {
+		return
+	}
+	err = srv.UpdatePassword(account, password)
+	if err != nil {
+		return
+	}
+	err = srv.RevokeSessions(u.ID)
+	return
+}
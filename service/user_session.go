@@ -0,0 +1,329 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"go.uber.org/zap"
+)
+
+type (
+	UserSessionDetails []*UserSessionDetail
+	// UserSessionDetail 用户登录的session信息
+	UserSessionDetail struct {
+		ID        string     `json:"id,omitempty"`
+		LoginedAt *time.Time `json:"loginedAt,omitempty"`
+		// 是否当前session
+		Current bool `json:"current,omitempty"`
+
+		// 登录时的设备信息
+		IP        string `json:"ip,omitempty"`
+		UserAgent string `json:"userAgent,omitempty"`
+		Platform  string `json:"platform,omitempty"`
+		Brand     string `json:"brand,omitempty"`
+		Country   string `json:"country,omitempty"`
+		Province  string `json:"province,omitempty"`
+		City      string `json:"city,omitempty"`
+	}
+)
+
+const (
+	// 用户的session代数，注销所有session时递增
+	userSessionGenerationKeyPrefix = "user-session-generation-"
+	// 用户的session id列表（zset，score为登录时间）
+	userSessionIDsKeyPrefix = "user-session-ids-"
+)
+
+var (
+	errUserSessionNotFound = &hes.Error{
+		Message:    "该session不存在或已失效",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+var userSessionStore = &helper.RedisSessionStore{
+	Prefix: helper.RedisSessionKeyPrefix,
+}
+
+func getUserSessionGenerationKey(id uint) string {
+	return userSessionGenerationKeyPrefix + strconv.Itoa(int(id))
+}
+
+func getUserSessionIDsKey(id uint) string {
+	return userSessionIDsKeyPrefix + strconv.Itoa(int(id))
+}
+
+// addSession add the session id to the index of user
+func (srv *UserSrv) addSession(id uint, sessionID string) (err error) {
+	if id == 0 || sessionID == "" {
+		return
+	}
+	key := getUserSessionIDsKey(id)
+	pipe := helper.RedisGetClient().TxPipeline()
+	pipe.ZAdd(key, &redis.Z{
+		Score:  float64(util.Now().Unix()),
+		Member: sessionID,
+	})
+	pipe.Expire(key, config.GetSessionConfig().TTL)
+	_, err = pipe.Exec()
+	return
+}
+
+// removeSession destroy the session and remove it from the index of user
+func (srv *UserSrv) removeSession(id uint, sessionID string) (err error) {
+	err = userSessionStore.Destroy(sessionID)
+	if err != nil {
+		return
+	}
+	err = helper.RedisGetClient().ZRem(getUserSessionIDsKey(id), sessionID).Err()
+	return
+}
+
+// listSessionIDs list the session ids of user, the expired sessions will be removed
+func (srv *UserSrv) listSessionIDs(id uint) (sessionIDs []string, err error) {
+	key := getUserSessionIDsKey(id)
+	items, err := helper.RedisGetClient().ZRevRange(key, 0, -1).Result()
+	if err != nil {
+		return
+	}
+	sessionIDs = make([]string, 0, len(items))
+	expiredIDs := make([]interface{}, 0)
+	for _, item := range items {
+		data, e := userSessionStore.Get(item)
+		if e != nil {
+			err = e
+			return
+		}
+		if len(data) == 0 {
+			expiredIDs = append(expiredIDs, item)
+			continue
+		}
+		sessionIDs = append(sessionIDs, item)
+	}
+	if len(expiredIDs) != 0 {
+		err = helper.RedisGetClient().ZRem(key, expiredIDs...).Err()
+	}
+	return
+}
+
+// ListSessions list the sessions of user with device info of login record
+func (srv *UserSrv) ListSessions(id uint) (details UserSessionDetails, err error) {
+	sessionIDs, err := srv.listSessionIDs(id)
+	if err != nil {
+		return
+	}
+	details = make(UserSessionDetails, 0, len(sessionIDs))
+	if len(sessionIDs) == 0 {
+		return
+	}
+	records, err := srv.ListLoginRecord(PGQueryParams{
+		Order: "-createdAt",
+	}, "session_id IN (?)", sessionIDs)
+	if err != nil {
+		return
+	}
+	for _, sessionID := range sessionIDs {
+		detail := &UserSessionDetail{
+			ID: sessionID,
+		}
+		for _, r := range records {
+			if r.SessionID != sessionID {
+				continue
+			}
+			detail.LoginedAt = r.CreatedAt
+			detail.IP = r.IP
+			detail.UserAgent = r.UserAgent
+			detail.Platform = r.Platform
+			detail.Brand = r.Brand
+			detail.Country = r.Country
+			detail.Province = r.Province
+			detail.City = r.City
+			break
+		}
+		details = append(details, detail)
+	}
+	return
+}
+
+// RevokeSession revoke the session of user
+func (srv *UserSrv) RevokeSession(id uint, sessionID string) (err error) {
+	err = helper.RedisGetClient().ZScore(getUserSessionIDsKey(id), sessionID).Err()
+	if err != nil {
+		if err == redis.Nil {
+			err = errUserSessionNotFound
+		}
+		return
+	}
+	return srv.removeSession(id, sessionID)
+}
+
+// RevokeOtherSessions revoke all sessions of user except the current session
+func (srv *UserSrv) RevokeOtherSessions(id uint, currentSessionID string) (err error) {
+	sessionIDs, err := srv.listSessionIDs(id)
+	if err != nil {
+		return
+	}
+	for _, sessionID := range sessionIDs {
+		if sessionID == currentSessionID {
+			continue
+		}
+		err = srv.removeSession(id, sessionID)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// RevokeSessions revoke all sessions of user, the sessions
+// logined before now will be invalid, and the api tokens are deleted
+func (srv *UserSrv) RevokeSessions(id uint) (err error) {
+	// API token每次请求均生成新的session，需删除token才可失效
+	err = srv.deleteAPITokens(id)
+	if err != nil {
+		return
+	}
+	// 递增session代数，之前创建的session均失效，
+	// 对于未记录在session列表中的session也生效。
+	// 不设置有效期，避免重置后旧的session重新生效
+	err = helper.RedisGetClient().Incr(getUserSessionGenerationKey(id)).Err()
+	if err != nil {
+		return
+	}
+	return srv.RevokeOtherSessions(id, "")
+}
+
+// getSessionGeneration get the session generation of user
+func (srv *UserSrv) getSessionGeneration(id uint) (generation int64, err error) {
+	value, err := redisSrv.GetIgnoreNilErr(getUserSessionGenerationKey(id))
+	if err != nil || value == "" {
+		return
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// isSessionRevoked check the session is revoked
+func (srv *UserSrv) isSessionRevoked(info *UserSessionInfo) bool {
+	if info.ID == 0 {
+		return false
+	}
+	generation, err := srv.getSessionGeneration(info.ID)
+	// 获取失败时不影响正常使用
+	if err != nil {
+		logger.Error("get session generation fail",
+			zap.Uint("user", info.ID),
+			zap.Error(err),
+		)
+		return false
+	}
+	return info.SessionGeneration < generation
+}
+
+type (
+	// UserPendingLogin 密码校验通过，等待两步验证的登录
+	UserPendingLogin struct {
+		ID        uint   `json:"id,omitempty"`
+		Account   string `json:"account,omitempty"`
+		CreatedAt string `json:"createdAt,omitempty"`
+		// 异地或新设备登录，需要邮箱验证码校验
+		StepUp bool `json:"stepUp,omitempty"`
+		// 登录时的设备信息等
+		Extra json.RawMessage `json:"extra,omitempty"`
+	}
+)
+
+const (
+	userPendingLoginKey = "pendingLogin"
+	// 等待两步验证的有效期
+	userPendingLoginTTL = 5 * time.Minute
+)
+
+// saveInfo save the user session info
+func (us *UserSession) saveInfo(info *UserSessionInfo) (err error) {
+	buf, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	err = us.se.Set(UserSessionInfoKey, string(buf))
+	if err != nil {
+		return
+	}
+	us.info = info
+	return
+}
+
+// SetTwoFactorVerified set the session is verified by two factor
+func (us *UserSession) SetTwoFactorVerified() (err error) {
+	info, err := us.GetInfo()
+	if err != nil {
+		return
+	}
+	info.TwoFactorVerified = true
+	return us.saveInfo(info)
+}
+
+// IsTwoFactorVerified check the session is verified by two factor
+func (us *UserSession) IsTwoFactorVerified() bool {
+	info := us.MustGetInfo()
+	return info.TwoFactorVerified
+}
+
+// SetPendingLogin set the pending login which waits for two factor verification
+func (us *UserSession) SetPendingLogin(data UserPendingLogin) (err error) {
+	data.CreatedAt = util.NowString()
+	buf, err := json.Marshal(&data)
+	if err != nil {
+		return
+	}
+	return us.se.Set(userPendingLoginKey, string(buf))
+}
+
+// GetPendingLogin get the pending login, it returns nil if not exists or expired
+func (us *UserSession) GetPendingLogin() (data *UserPendingLogin) {
+	value := us.se.GetString(userPendingLoginKey)
+	if value == "" {
+		return
+	}
+	data = new(UserPendingLogin)
+	err := json.Unmarshal([]byte(value), data)
+	if err != nil {
+		return nil
+	}
+	createdAt, err := time.Parse(time.RFC3339, data.CreatedAt)
+	if err != nil || util.Now().Sub(createdAt) > userPendingLoginTTL {
+		return nil
+	}
+	return
+}
+
+// ClearPendingLogin clear the pending login
+func (us *UserSession) ClearPendingLogin() error {
+	return us.se.Set(userPendingLoginKey, "")
+}
+
+// GetSessionID get the session id
+func (us *UserSession) GetSessionID() string {
+	return us.se.ID
+}
@@ -0,0 +1,282 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/origin/config"
+	"github.com/vicanso/origin/helper"
+	"github.com/vicanso/origin/util"
+	"gorm.io/gorm"
+)
+
+type (
+	// UserTOTP 用户两步验证（TOTP）
+	UserTOTP struct {
+		helper.Model
+
+		UserID uint   `json:"userID,omitempty" gorm:"uniqueIndex:idx_user_totp_user;not null"`
+		Secret string `json:"-" gorm:"not null"`
+		// 启用时间，为空表示未完成绑定
+		EnabledAt *time.Time `json:"enabledAt,omitempty"`
+		// 恢复码（sha256后保存），每个只能使用一次
+		RecoveryCodes pq.StringArray `json:"-" gorm:"type:text[]"`
+		// 最近一次使用的时间周期，避免验证码重复使用
+		LastStep int64 `json:"-"`
+	}
+	// UserTOTPEnrollment 绑定信息，用于身份验证器App扫码
+	UserTOTPEnrollment struct {
+		Secret string `json:"secret,omitempty"`
+		URI    string `json:"uri,omitempty"`
+		// 二维码图片（png）
+		QRCode []byte `json:"qrCode,omitempty"`
+	}
+
+	// TwoFactorPolicy 强制启用两步验证的角色与分组
+	TwoFactorPolicy struct {
+		Roles  []string `json:"roles,omitempty"`
+		Groups []string `json:"groups,omitempty"`
+	}
+	twoFactorPolicies struct {
+		sync.RWMutex
+		policies []*TwoFactorPolicy
+	}
+)
+
+const (
+	// 恢复码数量
+	userTOTPRecoveryCodeCount = 10
+	userTOTPRecoveryCodeSize  = 10
+	// 允许前后一个周期的时间偏差
+	userTOTPSkew = 1
+	// 二维码尺寸
+	userTOTPQRCodeSize = 256
+)
+
+var (
+	errUserTOTPEnabled = &hes.Error{
+		Message:    "已启用两步验证",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errUserTOTPNotEnabled = &hes.Error{
+		Message:    "未启用两步验证",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+	errUserTOTPCodeInvalid = &hes.Error{
+		Message:    "两步验证码错误或已使用",
+		StatusCode: http.StatusBadRequest,
+		Category:   errUserCategory,
+	}
+)
+
+var defaultTwoFactorPolicies = new(twoFactorPolicies)
+
+func init() {
+	err := helper.PGAutoMigrate(&UserTOTP{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ResetTwoFactorPolicies reset the two factor policies,
+// each config data is json of TwoFactorPolicy
+func ResetTwoFactorPolicies(configs []string) {
+	policies := make([]*TwoFactorPolicy, 0)
+	for _, data := range configs {
+		policy := &TwoFactorPolicy{}
+		err := json.Unmarshal([]byte(data), policy)
+		if err != nil {
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	defaultTwoFactorPolicies.Lock()
+	defer defaultTwoFactorPolicies.Unlock()
+	defaultTwoFactorPolicies.policies = policies
+}
+
+// IsTwoFactorRequired check the roles or groups are forced to use two factor
+func IsTwoFactorRequired(roles, groups []string) bool {
+	defaultTwoFactorPolicies.RLock()
+	defer defaultTwoFactorPolicies.RUnlock()
+	for _, policy := range defaultTwoFactorPolicies.policies {
+		if util.ContainsAny(policy.Roles, roles) ||
+			util.ContainsAny(policy.Groups, groups) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashRecoveryCode(code string) string {
+	return util.Sha256(strings.ToLower(code))
+}
+
+// findTOTP find the totp of user
+func (srv *UserSrv) findTOTP(userID uint) (t *UserTOTP, err error) {
+	t = new(UserTOTP)
+	err = pgGetClient().First(t, "user_id = ?", userID).Error
+	return
+}
+
+// IsTOTPEnabled check the user has enabled totp
+func (srv *UserSrv) IsTOTPEnabled(userID uint) (enabled bool, err error) {
+	t, err := srv.findTOTP(userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			err = nil
+		}
+		return
+	}
+	enabled = t.EnabledAt != nil
+	return
+}
+
+// EnrollTOTP generate a new secret for user, it should be enabled by EnableTOTP
+func (srv *UserSrv) EnrollTOTP(userID uint, account string) (enrollment *UserTOTPEnrollment, err error) {
+	enabled, err := srv.IsTOTPEnabled(userID)
+	if err != nil {
+		return
+	}
+	if enabled {
+		err = errUserTOTPEnabled
+		return
+	}
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return
+	}
+	err = pgGetClient().
+		Where(UserTOTP{
+			UserID: userID,
+		}).
+		Assign(UserTOTP{
+			Secret: secret,
+		}).
+		FirstOrCreate(&UserTOTP{}).Error
+	if err != nil {
+		return
+	}
+	uri := util.GetTOTPURI(config.GetAppName(), account, secret)
+	qrCode, err := GetQRCode(uri, userTOTPQRCodeSize)
+	if err != nil {
+		return
+	}
+	enrollment = &UserTOTPEnrollment{
+		Secret: secret,
+		URI:    uri,
+		QRCode: qrCode.Data,
+	}
+	return
+}
+
+// EnableTOTP enable the totp by code, it returns the recovery codes
+// which are only shown once
+func (srv *UserSrv) EnableTOTP(userID uint, code string) (recoveryCodes []string, err error) {
+	t, err := srv.findTOTP(userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			err = errUserTOTPNotEnabled
+		}
+		return
+	}
+	if t.EnabledAt != nil {
+		err = errUserTOTPEnabled
+		return
+	}
+	step := util.VerifyTOTP(t.Secret, code, util.Now(), userTOTPSkew)
+	if step == 0 {
+		err = errUserTOTPCodeInvalid
+		return
+	}
+	recoveryCodes = make([]string, userTOTPRecoveryCodeCount)
+	hashedCodes := make(pq.StringArray, userTOTPRecoveryCodeCount)
+	for i := range recoveryCodes {
+		recoveryCodes[i] = strings.ToLower(util.SecureRandomString(userTOTPRecoveryCodeSize))
+		hashedCodes[i] = hashRecoveryCode(recoveryCodes[i])
+	}
+	now := util.Now()
+	err = pgGetClient().Model(t).Updates(map[string]interface{}{
+		"enabled_at":     &now,
+		"recovery_codes": hashedCodes,
+		"last_step":      step,
+	}).Error
+	if err != nil {
+		recoveryCodes = nil
+	}
+	return
+}
+
+// VerifyTOTP verify the totp code or recovery code of user,
+// the code can only be used once
+func (srv *UserSrv) VerifyTOTP(userID uint, code string) (err error) {
+	t, err := srv.findTOTP(userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			err = errUserTOTPNotEnabled
+		}
+		return
+	}
+	if t.EnabledAt == nil {
+		err = errUserTOTPNotEnabled
+		return
+	}
+	var db *gorm.DB
+	if len(code) == util.TOTPDigits {
+		step := util.VerifyTOTP(t.Secret, code, util.Now(), userTOTPSkew)
+		if step == 0 {
+			err = errUserTOTPCodeInvalid
+			return
+		}
+		db = pgGetClient().Model(t).
+			Where("last_step < ?", step).
+			Update("last_step", step)
+	} else {
+		// 恢复码
+		hashed := hashRecoveryCode(code)
+		db = pgGetClient().Model(t).
+			Where("? = ANY(recovery_codes)", hashed).
+			Update("recovery_codes", gorm.Expr("array_remove(recovery_codes, ?)", hashed))
+	}
+	if db.Error != nil {
+		err = db.Error
+		return
+	}
+	if db.RowsAffected != 1 {
+		err = errUserTOTPCodeInvalid
+	}
+	return
+}
+
+// DisableTOTP disable the totp of user, it should be verified by code
+func (srv *UserSrv) DisableTOTP(userID uint, code string) (err error) {
+	err = srv.VerifyTOTP(userID, code)
+	if err != nil {
+		return
+	}
+	err = pgGetClient().Unscoped().
+		Where("user_id = ?", userID).
+		Delete(&UserTOTP{}).Error
+	return
+}
@@ -15,6 +15,10 @@
 package util
 
 import (
+	"net"
+	"net/http"
+	"strings"
+
 	"github.com/vicanso/elton"
 
 	"github.com/vicanso/origin/config"
@@ -42,3 +46,89 @@ func GetSessionID(c *elton.Context) string {
 	}
 	return cookie.Value
 }
+
+var (
+	// 内网地址（回环与链路本地地址另外判断）
+	intranetIPNets = mustParseCIDRs(
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"100.64.0.0/10",
+		"fc00::/7",
+	)
+)
+
+func mustParseCIDRs(values ...string) []*net.IPNet {
+	ipNets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			panic(err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets
+}
+
+// containsIP check the ip is in the ip nets
+func containsIP(ipNets []*net.IPNet, ip string) bool {
+	v := net.ParseIP(ip)
+	if v == nil {
+		return false
+	}
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTrustedProxy check the ip is trusted proxy
+func IsTrustedProxy(ip string) bool {
+	return containsIP(config.GetTrustedProxies(), ip)
+}
+
+// IsIntranetIP check the ip is private, loopback or link local address
+func IsIntranetIP(ip string) bool {
+	v := net.ParseIP(ip)
+	if v == nil {
+		return false
+	}
+	if v.IsLoopback() || v.IsLinkLocalUnicast() {
+		return true
+	}
+	return containsIP(intranetIPNets, ip)
+}
+
+// GetClientIP get the client ip of request, the X-Forwarded-For and
+// X-Real-Ip are only used when the request is from trusted proxy,
+// otherwise they can be forged by client
+func GetClientIP(req *http.Request) string {
+	ip := elton.GetRemoteAddr(req)
+	if !IsTrustedProxy(ip) {
+		return ip
+	}
+	h := req.Header
+	xForwardedFor := h.Get(elton.HeaderXForwardedFor)
+	if xForwardedFor != "" {
+		// 从后往前找第一个非可信代理的IP则为客户IP
+		arr := strings.Split(xForwardedFor, ",")
+		for i := len(arr) - 1; i >= 0; i-- {
+			v := strings.TrimSpace(arr[i])
+			if v == "" {
+				continue
+			}
+			ip = v
+			if !IsTrustedProxy(v) {
+				break
+			}
+		}
+		return ip
+	}
+	xRealIP := h.Get(elton.HeaderXRealIP)
+	if xRealIP != "" {
+		return xRealIP
+	}
+	return ip
+}
@@ -52,3 +52,61 @@ func TestGetSessionID(t *testing.T) {
 	req.AddCookie(&cookie)
 	assert.Equal(cookie.Value, GetSessionID(c))
 }
+
+func TestGetClientIP(t *testing.T) {
+	assert := assert.New(t)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.1.1.1:1234"
+	req.Header.Set(elton.HeaderXForwardedFor, "2.2.2.2")
+	// 非可信代理的请求，忽略X-Forwarded-For
+	assert.Equal("1.1.1.1", GetClientIP(req))
+
+	req.RemoteAddr = "127.0.0.1:1234"
+	assert.Equal("2.2.2.2", GetClientIP(req))
+
+	// 客户端伪造的X-Forwarded-For在最左侧
+	req.Header.Set(elton.HeaderXForwardedFor, "3.3.3.3, 2.2.2.2")
+	assert.Equal("2.2.2.2", GetClientIP(req))
+
+	req.Header.Set(elton.HeaderXForwardedFor, "2.2.2.2, 127.0.0.1")
+	assert.Equal("2.2.2.2", GetClientIP(req))
+
+	req.Header.Del(elton.HeaderXForwardedFor)
+	req.Header.Set(elton.HeaderXRealIP, "2.2.2.2")
+	assert.Equal("2.2.2.2", GetClientIP(req))
+
+	req.Header.Del(elton.HeaderXRealIP)
+	assert.Equal("127.0.0.1", GetClientIP(req))
+}
+
+func TestIsIntranetIP(t *testing.T) {
+	assert := assert.New(t)
+	for _, ip := range []string{
+		"127.0.0.1",
+		"::1",
+		"10.1.2.3",
+		"172.20.0.1",
+		"192.168.1.1",
+		"169.254.1.1",
+		"fd00::1",
+	} {
+		assert.True(IsIntranetIP(ip), ip)
+	}
+	for _, ip := range []string{
+		"1.1.1.1",
+		"172.32.0.1",
+		"2001:4860::1",
+		"",
+		"abc",
+	} {
+		assert.False(IsIntranetIP(ip), ip)
+	}
+}
+
+func TestIsTrustedProxy(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsTrustedProxy("127.0.0.1"))
+	assert.True(IsTrustedProxy("::1"))
+	assert.False(IsTrustedProxy("10.0.0.1"))
+	assert.False(IsTrustedProxy("abc"))
+}
@@ -105,6 +105,25 @@ func ChinaYesterday() (time.Time, error) {
 	return now.With(t).BeginningOfDay(), err
 }
 
+// ChinaMonth get the begin and end of the month(2006-01) in china,
+// if month is empty, the current month is used
+func ChinaMonth(month string) (begin, end time.Time, err error) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		return
+	}
+	if month == "" {
+		begin = now.With(Now().In(loc)).BeginningOfMonth()
+	} else {
+		begin, err = time.ParseInLocation("2006-01", month, loc)
+		if err != nil {
+			return
+		}
+	}
+	end = begin.AddDate(0, 1, 0)
+	return
+}
+
 // IsBetween now is between begin and end
 func IsBetween(begin *time.Time, end *time.Time) bool {
 	now := Now().Unix()
@@ -47,4 +47,14 @@ func TestTime(t *testing.T) {
 	chinaYesterday, err := ChinaYesterday()
 	assert.Nil(err)
 	assert.Equal("2020-04-25T00:00:00+08:00", FormatTime(chinaYesterday))
+
+	begin, end, err := ChinaMonth("")
+	assert.Nil(err)
+	assert.Equal("2020-04-01T00:00:00+08:00", FormatTime(begin))
+	assert.Equal("2020-05-01T00:00:00+08:00", FormatTime(end))
+
+	begin, end, err = ChinaMonth("2019-12")
+	assert.Nil(err)
+	assert.Equal("2019-12-01T00:00:00+08:00", FormatTime(begin))
+	assert.Equal("2020-01-01T00:00:00+08:00", FormatTime(end))
 }
@@ -0,0 +1,393 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect 客户端（relying party），使用authorization code + PKCE流程，
+// id token仅支持RS256签名
+
+type (
+	// OIDCConfig oidc客户端配置
+	OIDCConfig struct {
+		Issuer       string
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		Scopes       []string
+	}
+	// OIDCDiscovery 服务发现信息（/.well-known/openid-configuration）
+	OIDCDiscovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	// OIDCToken token endpoint的响应
+	OIDCToken struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		IDToken     string `json:"id_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	// OIDCAudience aud可以为字符串或数组
+	OIDCAudience []string
+	// OIDCClaims id token的claims
+	OIDCClaims struct {
+		Issuer            string       `json:"iss"`
+		Subject           string       `json:"sub"`
+		Audience          OIDCAudience `json:"aud"`
+		ExpiresAt         int64        `json:"exp"`
+		IssuedAt          int64        `json:"iat"`
+		Nonce             string       `json:"nonce"`
+		Email             string       `json:"email"`
+		EmailVerified     bool         `json:"email_verified"`
+		Name              string       `json:"name"`
+		PreferredUsername string       `json:"preferred_username"`
+	}
+	// OIDCProvider oidc provider
+	OIDCProvider struct {
+		config OIDCConfig
+		client *http.Client
+
+		sync.RWMutex
+		discovery     *OIDCDiscovery
+		keys          map[string]*rsa.PublicKey
+		keysFetchedAt time.Time
+	}
+
+	oidcJWKS struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	oidcJWTHeader struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+)
+
+const (
+	// 时间校验允许的偏差
+	oidcLeeway = time.Minute
+	// jwks刷新的最小间隔
+	oidcKeysRefreshInterval = time.Minute
+	oidcRandomSize          = 32
+)
+
+var (
+	ErrOIDCIDTokenInvalid   = errors.New("id token is invalid")
+	ErrOIDCSignatureInvalid = errors.New("signature of id token is invalid")
+	ErrOIDCKeyNotFound      = errors.New("key of id token is not found")
+	ErrOIDCIssuerInvalid    = errors.New("issuer of id token is invalid")
+	ErrOIDCAudienceInvalid  = errors.New("audience of id token is invalid")
+	ErrOIDCTokenExpired     = errors.New("id token is expired")
+	ErrOIDCNonceInvalid     = errors.New("nonce of id token is invalid")
+)
+
+var oidcEncoding = base64.RawURLEncoding
+
+// UnmarshalJSON unmarshal the audience from string or array
+func (aud *OIDCAudience) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*aud = OIDCAudience{
+			value,
+		}
+		return nil
+	}
+	var values []string
+	err := json.Unmarshal(data, &values)
+	if err != nil {
+		return err
+	}
+	*aud = values
+	return nil
+}
+
+// GenerateOIDCRandom generate a random string for state, nonce and code verifier
+func GenerateOIDCRandom() (string, error) {
+	buf := make([]byte, oidcRandomSize)
+	_, err := crand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return oidcEncoding.EncodeToString(buf), nil
+}
+
+// GetPKCEChallenge get the S256 code challenge of code verifier
+func GetPKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return oidcEncoding.EncodeToString(sum[:])
+}
+
+// NewOIDCProvider create a new oidc provider
+func NewOIDCProvider(config OIDCConfig, timeout time.Duration) *OIDCProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{
+			"openid",
+			"email",
+			"profile",
+		}
+	}
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (p *OIDCProvider) getJSON(resp *http.Response, err error, value interface{}) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("oidc request fail, status:%d, %s", resp.StatusCode, string(buf))
+	}
+	return json.Unmarshal(buf, value)
+}
+
+// Discover get the discovery of provider, it will be cached after success
+func (p *OIDCProvider) Discover() (discovery *OIDCDiscovery, err error) {
+	p.RLock()
+	discovery = p.discovery
+	p.RUnlock()
+	if discovery != nil {
+		return
+	}
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	resp, err := p.client.Get(issuer + "/.well-known/openid-configuration")
+	result := &OIDCDiscovery{}
+	err = p.getJSON(resp, err, result)
+	if err != nil {
+		return
+	}
+	if strings.TrimSuffix(result.Issuer, "/") != issuer {
+		err = ErrOIDCIssuerInvalid
+		return
+	}
+	p.Lock()
+	p.discovery = result
+	p.Unlock()
+	discovery = result
+	return
+}
+
+// AuthCodeURL get the url of authorization endpoint
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeVerifier string) (string, error) {
+	discovery, err := p.Discover()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", GetPKCEChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange exchange the code for token
+func (p *OIDCProvider) Exchange(code, codeVerifier string) (token *OIDCToken, err error) {
+	discovery, err := p.Discover()
+	if err != nil {
+		return
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	result := &OIDCToken{}
+	err = p.getJSON(resp, err, result)
+	if err != nil {
+		return
+	}
+	if result.IDToken == "" {
+		err = ErrOIDCIDTokenInvalid
+		return
+	}
+	token = result
+	return
+}
+
+// fetchKeys fetch the rsa public keys of provider
+func (p *OIDCProvider) fetchKeys(jwksURI string) (err error) {
+	resp, err := p.client.Get(jwksURI)
+	jwks := &oidcJWKS{}
+	err = p.getJSON(resp, err, jwks)
+	if err != nil {
+		return
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, item := range jwks.Keys {
+		if item.Kty != "RSA" {
+			continue
+		}
+		n, e := oidcEncoding.DecodeString(item.N)
+		if e != nil {
+			continue
+		}
+		exponent, e := oidcEncoding.DecodeString(item.E)
+		if e != nil {
+			continue
+		}
+		keys[item.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}
+	}
+	p.Lock()
+	p.keys = keys
+	p.keysFetchedAt = Now()
+	p.Unlock()
+	return
+}
+
+// getKey get the public key by kid, the keys will be refreshed
+// if the kid is not found(key rotation)
+func (p *OIDCProvider) getKey(jwksURI, kid string) (key *rsa.PublicKey, err error) {
+	p.RLock()
+	key = p.keys[kid]
+	fetchedAt := p.keysFetchedAt
+	p.RUnlock()
+	if key != nil {
+		return
+	}
+	if !fetchedAt.IsZero() && Now().Sub(fetchedAt) < oidcKeysRefreshInterval {
+		err = ErrOIDCKeyNotFound
+		return
+	}
+	err = p.fetchKeys(jwksURI)
+	if err != nil {
+		return
+	}
+	p.RLock()
+	key = p.keys[kid]
+	p.RUnlock()
+	if key == nil {
+		err = ErrOIDCKeyNotFound
+	}
+	return
+}
+
+// VerifyIDToken verify the signature and claims of id token
+func (p *OIDCProvider) VerifyIDToken(idToken, nonce string) (claims *OIDCClaims, err error) {
+	discovery, err := p.Discover()
+	if err != nil {
+		return
+	}
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		err = ErrOIDCIDTokenInvalid
+		return
+	}
+	headerData, err := oidcEncoding.DecodeString(parts[0])
+	if err != nil {
+		return
+	}
+	header := oidcJWTHeader{}
+	err = json.Unmarshal(headerData, &header)
+	if err != nil {
+		return
+	}
+	if header.Alg != "RS256" {
+		err = ErrOIDCSignatureInvalid
+		return
+	}
+	key, err := p.getKey(discovery.JWKSURI, header.Kid)
+	if err != nil {
+		return
+	}
+	signature, err := oidcEncoding.DecodeString(parts[2])
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) != nil {
+		err = ErrOIDCSignatureInvalid
+		return
+	}
+	payload, err := oidcEncoding.DecodeString(parts[1])
+	if err != nil {
+		return
+	}
+	result := &OIDCClaims{}
+	err = json.Unmarshal(payload, result)
+	if err != nil {
+		return
+	}
+	if result.Issuer != discovery.Issuer {
+		err = ErrOIDCIssuerInvalid
+		return
+	}
+	if !ContainsString(result.Audience, p.config.ClientID) {
+		err = ErrOIDCAudienceInvalid
+		return
+	}
+	if time.Unix(result.ExpiresAt, 0).Add(oidcLeeway).Before(Now()) {
+		err = ErrOIDCTokenExpired
+		return
+	}
+	if result.Nonce != nonce {
+		err = ErrOIDCNonceInvalid
+		return
+	}
+	claims = result
+	return
+}
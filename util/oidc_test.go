@@ -0,0 +1,218 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockOIDCIssuer 本地模拟的oidc issuer
+type mockOIDCIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	// 授权时的code challenge
+	codeChallenge string
+	claims        map[string]interface{}
+}
+
+func newMockOIDCIssuer(t *testing.T) *mockOIDCIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockOIDCIssuer{
+		key: key,
+		kid: "test-key",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": issuer.kid,
+					"n":   oidcEncoding.EncodeToString(key.N.Bytes()),
+					"e":   oidcEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		user, password, _ := r.BasicAuth()
+		if r.Form.Get("code") != "code" ||
+			user != "client" ||
+			password != "secret" ||
+			GetPKCEChallenge(r.Form.Get("code_verifier")) != issuer.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     issuer.sign(t, issuer.claims),
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (issuer *mockOIDCIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"kid": issuer.kid,
+	})
+	payload, _ := json.Marshal(claims)
+	data := oidcEncoding.EncodeToString(header) + "." + oidcEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(data))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, issuer.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data + "." + oidcEncoding.EncodeToString(signature)
+}
+
+func (issuer *mockOIDCIssuer) newClaims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            issuer.server.URL,
+		"sub":            "user-1",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "tree.xie@example.com",
+		"email_verified": true,
+		"name":           "tree.xie",
+	}
+}
+
+func TestOIDCAudience(t *testing.T) {
+	assert := assert.New(t)
+	aud := OIDCAudience{}
+	err := json.Unmarshal([]byte(`"a"`), &aud)
+	assert.Nil(err)
+	assert.Equal(OIDCAudience{"a"}, aud)
+
+	err = json.Unmarshal([]byte(`["a", "b"]`), &aud)
+	assert.Nil(err)
+	assert.Equal(OIDCAudience{"a", "b"}, aud)
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	assert := assert.New(t)
+	// RFC 7636 Appendix B
+	assert.Equal("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", GetPKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+
+	verifier, err := GenerateOIDCRandom()
+	assert.Nil(err)
+	assert.Equal(43, len(verifier))
+}
+
+func TestOIDCProvider(t *testing.T) {
+	assert := assert.New(t)
+	issuer := newMockOIDCIssuer(t)
+	defer issuer.server.Close()
+
+	provider := NewOIDCProvider(OIDCConfig{
+		Issuer:       issuer.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://127.0.0.1/callback",
+	}, 5*time.Second)
+
+	state, _ := GenerateOIDCRandom()
+	nonce, _ := GenerateOIDCRandom()
+	verifier, _ := GenerateOIDCRandom()
+
+	t.Run("auth code url", func(t *testing.T) {
+		authURL, err := provider.AuthCodeURL(state, nonce, verifier)
+		assert.Nil(err)
+		u, err := url.Parse(authURL)
+		assert.Nil(err)
+		assert.Equal("/authorize", u.Path)
+		query := u.Query()
+		assert.Equal("code", query.Get("response_type"))
+		assert.Equal("client", query.Get("client_id"))
+		assert.Equal("openid email profile", query.Get("scope"))
+		assert.Equal(state, query.Get("state"))
+		assert.Equal(nonce, query.Get("nonce"))
+		assert.Equal("S256", query.Get("code_challenge_method"))
+		issuer.codeChallenge = query.Get("code_challenge")
+		assert.Equal(GetPKCEChallenge(verifier), issuer.codeChallenge)
+	})
+
+	t.Run("exchange", func(t *testing.T) {
+		issuer.claims = issuer.newClaims(nonce)
+		_, err := provider.Exchange("code", "invalid-verifier")
+		assert.NotNil(err)
+
+		token, err := provider.Exchange("code", verifier)
+		assert.Nil(err)
+		claims, err := provider.VerifyIDToken(token.IDToken, nonce)
+		assert.Nil(err)
+		assert.Equal("user-1", claims.Subject)
+		assert.Equal("tree.xie@example.com", claims.Email)
+		assert.True(claims.EmailVerified)
+	})
+
+	t.Run("verify id token", func(t *testing.T) {
+		claims := issuer.newClaims(nonce)
+		_, err := provider.VerifyIDToken(issuer.sign(t, claims), "other")
+		assert.Equal(ErrOIDCNonceInvalid, err)
+
+		claims = issuer.newClaims(nonce)
+		claims["aud"] = []string{"other"}
+		_, err = provider.VerifyIDToken(issuer.sign(t, claims), nonce)
+		assert.Equal(ErrOIDCAudienceInvalid, err)
+
+		claims = issuer.newClaims(nonce)
+		claims["iss"] = "https://example.com"
+		_, err = provider.VerifyIDToken(issuer.sign(t, claims), nonce)
+		assert.Equal(ErrOIDCIssuerInvalid, err)
+
+		claims = issuer.newClaims(nonce)
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		_, err = provider.VerifyIDToken(issuer.sign(t, claims), nonce)
+		assert.Equal(ErrOIDCTokenExpired, err)
+
+		// 篡改payload
+		token := issuer.sign(t, issuer.newClaims(nonce))
+		other := issuer.sign(t, issuer.newClaims(nonce+"x"))
+		_, err = provider.VerifyIDToken(token[:len(token)-10]+other[len(other)-10:], nonce)
+		assert.Equal(ErrOIDCSignatureInvalid, err)
+	})
+}
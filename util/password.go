@@ -0,0 +1,198 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// PasswordHasher 密码hash，hash后的数据需包含算法与参数，方便后续升级参数
+	PasswordHasher interface {
+		// Match 判断是否该算法生成的hash
+		Match(hashed string) bool
+		Hash(password string) (string, error)
+		Verify(hashed, password string) bool
+		// NeedsRehash 参数与当前设置不一致，需要重新hash
+		NeedsRehash(hashed string) bool
+	}
+	// BcryptHasher bcrypt
+	BcryptHasher struct {
+		Cost int
+	}
+	// Argon2idHasher argon2id，格式与PHC一致：
+	// $argon2id$v=19$m=65536,t=1,p=4$salt$key
+	Argon2idHasher struct {
+		Time    uint32
+		Memory  uint32
+		Threads uint8
+		KeyLen  uint32
+		SaltLen uint32
+	}
+	argon2idParams struct {
+		version int
+		time    uint32
+		memory  uint32
+		threads uint8
+		salt    []byte
+		key     []byte
+	}
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var errArgon2idHashInvalid = errors.New("argon2id hash is invalid")
+
+// IsPasswordHashed 判断密码是否已服务端hash（以$开头）
+func IsPasswordHashed(hashed string) bool {
+	return strings.HasPrefix(hashed, "$")
+}
+
+// NewBcryptHasher create a bcrypt hasher
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{
+		Cost: cost,
+	}
+}
+
+// Match check the hash is generated by bcrypt
+func (h *BcryptHasher) Match(hashed string) bool {
+	return strings.HasPrefix(hashed, "$2a$") ||
+		strings.HasPrefix(hashed, "$2b$") ||
+		strings.HasPrefix(hashed, "$2y$")
+}
+
+// Hash hash the password
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	buf, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// Verify verify the password
+func (h *BcryptHasher) Verify(hashed, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+}
+
+// NeedsRehash check the cost is changed
+func (h *BcryptHasher) NeedsRehash(hashed string) bool {
+	cost, err := bcrypt.Cost([]byte(hashed))
+	if err != nil {
+		return true
+	}
+	return cost != h.Cost
+}
+
+// NewArgon2idHasher create a argon2id hasher
+func NewArgon2idHasher(time, memory uint32, threads uint8) *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    time,
+		Memory:  memory,
+		Threads: threads,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func decodeArgon2id(hashed string) (params *argon2idParams, err error) {
+	// ["", "argon2id", "v=19", "m=65536,t=1,p=4", salt, key]
+	arr := strings.Split(hashed, "$")
+	if len(arr) != 6 || arr[1] != "argon2id" {
+		err = errArgon2idHashInvalid
+		return
+	}
+	params = &argon2idParams{}
+	_, err = fmt.Sscanf(arr[2], "v=%d", &params.version)
+	if err != nil {
+		return
+	}
+	_, err = fmt.Sscanf(arr[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return
+	}
+	params.salt, err = base64.RawStdEncoding.DecodeString(arr[4])
+	if err != nil {
+		return
+	}
+	params.key, err = base64.RawStdEncoding.DecodeString(arr[5])
+	if err != nil {
+		return
+	}
+	if len(params.key) == 0 {
+		err = errArgon2idHashInvalid
+	}
+	return
+}
+
+// Match check the hash is generated by argon2id
+func (h *Argon2idHasher) Match(hashed string) bool {
+	return strings.HasPrefix(hashed, argon2idPrefix)
+}
+
+// Hash hash the password
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	_, err := crand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.Memory,
+		h.Time,
+		h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify verify the password, it uses the params of hash
+func (h *Argon2idHasher) Verify(hashed, password string) bool {
+	params, err := decodeArgon2id(hashed)
+	if err != nil || params.version != argon2.Version {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+// NeedsRehash check the params are changed
+func (h *Argon2idHasher) NeedsRehash(hashed string) bool {
+	params, err := decodeArgon2id(hashed)
+	if err != nil {
+		return true
+	}
+	return params.version != argon2.Version ||
+		params.time != h.Time ||
+		params.memory != h.Memory ||
+		params.threads != h.Threads ||
+		uint32(len(params.key)) != h.KeyLen ||
+		uint32(len(params.salt)) != h.SaltLen
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	assert := assert.New(t)
+	password := "fEqNCco3Yq9h5ZUglD3CZJT4lBsfEqNCco31Yq9h5ZUB"
+
+	t.Run("bcrypt", func(t *testing.T) {
+		h := NewBcryptHasher(4)
+		hashed, err := h.Hash(password)
+		assert.Nil(err)
+		assert.True(IsPasswordHashed(hashed))
+		assert.True(h.Match(hashed))
+		assert.True(h.Verify(hashed, password))
+		assert.False(h.Verify(hashed, password+"a"))
+		assert.False(h.NeedsRehash(hashed))
+		assert.True(NewBcryptHasher(5).NeedsRehash(hashed))
+	})
+
+	t.Run("argon2id", func(t *testing.T) {
+		h := NewArgon2idHasher(1, 1024, 1)
+		hashed, err := h.Hash(password)
+		assert.Nil(err)
+		assert.True(IsPasswordHashed(hashed))
+		assert.True(h.Match(hashed))
+		assert.False(NewBcryptHasher(4).Match(hashed))
+		assert.True(h.Verify(hashed, password))
+		assert.False(h.Verify(hashed, password+"a"))
+		assert.False(h.NeedsRehash(hashed))
+		// 参数调整后，旧的hash仍可校验但需要重新hash
+		upgraded := NewArgon2idHasher(2, 1024, 1)
+		assert.True(upgraded.Verify(hashed, password))
+		assert.True(upgraded.NeedsRehash(hashed))
+
+		assert.False(h.Verify("$argon2id$v=19$m=1024", password))
+	})
+
+	assert.False(IsPasswordHashed(password))
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "strings"
+
+const permissionWildcard = "*"
+
+// SplitPermission split the permission to resource and action
+func SplitPermission(permission string) (resource, action string) {
+	arr := strings.SplitN(permission, ":", 2)
+	resource = arr[0]
+	if len(arr) == 2 {
+		action = arr[1]
+	}
+	return
+}
+
+// HasPermission check the permissions contain the permission,
+// "*" matches all permissions and "resource:*" matches all actions of the resource
+func HasPermission(permissions []string, permission string) bool {
+	resource, _ := SplitPermission(permission)
+	for _, item := range permissions {
+		if item == permissionWildcard ||
+			item == permission ||
+			item == resource+":"+permissionWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAllPermissions check the permissions contain all the required permissions
+func HasAllPermissions(permissions []string, required []string) bool {
+	for _, permission := range required {
+		if !HasPermission(permissions, permission) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitPermission(t *testing.T) {
+	assert := assert.New(t)
+	resource, action := SplitPermission("order:assign")
+	assert.Equal("order", resource)
+	assert.Equal("assign", action)
+
+	resource, action = SplitPermission("order")
+	assert.Equal("order", resource)
+	assert.Empty(action)
+}
+
+func TestHasPermission(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(HasPermission([]string{
+		"*",
+	}, "order:assign"))
+	assert.True(HasPermission([]string{
+		"product:update",
+		"order:assign",
+	}, "order:assign"))
+	assert.True(HasPermission([]string{
+		"order:*",
+	}, "order:assign"))
+	assert.False(HasPermission([]string{
+		"order:read",
+		"product:*",
+	}, "order:assign"))
+	assert.False(HasPermission(nil, "order:assign"))
+}
+
+func TestHasAllPermissions(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(HasAllPermissions([]string{
+		"order:*",
+		"user:read",
+	}, []string{
+		"order:assign",
+		"order:*",
+		"user:read",
+	}))
+	assert.True(HasAllPermissions([]string{
+		"user:read",
+	}, nil))
+	assert.False(HasAllPermissions([]string{
+		"order:*",
+	}, []string{
+		"*",
+	}))
+	assert.False(HasAllPermissions([]string{
+		"order:assign",
+	}, []string{
+		"order:*",
+	}))
+}
@@ -25,6 +25,7 @@ import (
 	"math/rand"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/mozillazg/go-pinyin"
 	"github.com/oklog/ulid/v2"
@@ -69,6 +70,41 @@ func RandomDigit(n int) string {
 	return randomString(digitBytes, n)
 }
 
+// secureRandomString create a random string by crypto/rand,
+// it is used for the codes such as verification code
+func secureRandomString(baseLetters string, n int) string {
+	b := make([]byte, n)
+	// 丢弃超出范围的值，保证各字符概率一致
+	max := 256 - 256%len(baseLetters)
+	buf := make([]byte, n)
+	for i := 0; i < n; {
+		if _, err := io.ReadFull(crand.Reader, buf); err != nil {
+			panic(err)
+		}
+		for _, v := range buf {
+			if int(v) >= max {
+				continue
+			}
+			b[i] = baseLetters[int(v)%len(baseLetters)]
+			i++
+			if i == n {
+				break
+			}
+		}
+	}
+	return string(b)
+}
+
+// SecureRandomString create a random string by crypto/rand
+func SecureRandomString(n int) string {
+	return secureRandomString(letterBytes, n)
+}
+
+// SecureRandomDigit create a random digit string by crypto/rand
+func SecureRandomDigit(n int) string {
+	return secureRandomString(digitBytes, n)
+}
+
 var entropy = rand.New(rand.NewSource(time.Unix(0, 0).UnixNano()))
 
 // GenUlid generate ulid
@@ -168,3 +204,78 @@ func GetFirstLetter(str string) string {
 	}
 	return strings.ToUpper(arr[0][0:1])
 }
+
+// GetPinyin get the pinyin of str(only han), e.g. 苹果 -> pingguo
+func GetPinyin(str string) string {
+	return strings.Join(pinyin.LazyPinyin(str, pinyin.NewArgs()), "")
+}
+
+// GetPinyinInitials get the initials of pinyin, e.g. 苹果 -> pg
+func GetPinyinInitials(str string) string {
+	arr := pinyin.LazyPinyin(str, pinyin.NewArgs())
+	var b strings.Builder
+	for _, item := range arr {
+		b.WriteString(item[0:1])
+	}
+	return b.String()
+}
+
+// IsHan check the str is all han
+func IsHan(str string) bool {
+	if str == "" {
+		return false
+	}
+	for _, r := range str {
+		if !unicode.Is(unicode.Han, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// SplitSearchWords split the str to words for search,
+// han and letters(digits) are separated and others are ignored,
+// e.g. 红富士Apple 5斤 -> [红富士 apple 5 斤]
+func SplitSearchWords(str string) []string {
+	words := make([]string, 0)
+	var b strings.Builder
+	// 0:无 1:汉字 2:字母数字
+	prevKind := 0
+	flush := func() {
+		if b.Len() != 0 {
+			words = append(words, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(str) {
+		kind := 0
+		if unicode.Is(unicode.Han, r) {
+			kind = 1
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			kind = 2
+		}
+		if kind != prevKind {
+			flush()
+		}
+		prevKind = kind
+		if kind != 0 {
+			b.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// NGram split the str to n-gram, if the length of str is less than n,
+// the str will be returned
+func NGram(str string, n int) []string {
+	runes := []rune(str)
+	if len(runes) <= n {
+		return []string{str}
+	}
+	result := make([]string, 0, len(runes)-n+1)
+	for i := 0; i+n <= len(runes); i++ {
+		result = append(result, string(runes[i:i+n]))
+	}
+	return result
+}
@@ -15,6 +15,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,6 +25,10 @@ func TestRandomString(t *testing.T) {
 	assert := assert.New(t)
 	assert.Equal(8, len(RandomString(8)))
 	assert.Equal(4, len(RandomDigit(4)))
+	assert.Equal(8, len(SecureRandomString(8)))
+	code := SecureRandomDigit(6)
+	assert.Equal(6, len(code))
+	assert.Empty(strings.Trim(code, "0123456789"))
 	assert.Equal(26, len(GenUlid()))
 }
 
@@ -66,3 +71,28 @@ func TestEncrypt(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal(data, result)
 }
+
+func TestGetPinyin(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("pingguo", GetPinyin("苹果"))
+	assert.Equal("pg", GetPinyinInitials("苹果"))
+}
+
+func TestSplitSearchWords(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsHan("苹果"))
+	assert.False(IsHan("苹果a"))
+	assert.Equal([]string{
+		"红富士",
+		"apple",
+		"5",
+		"斤",
+	}, SplitSearchWords("红富士Apple 5斤"))
+	assert.Equal([]string{
+		"红富",
+		"富士",
+	}, NGram("红富士", 2))
+	assert.Equal([]string{
+		"红",
+	}, NGram("红", 2))
+}
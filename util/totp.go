@@ -0,0 +1,105 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP(RFC 6238)的默认参数，与常用的身份验证器App一致
+const (
+	TOTPPeriod = 30
+	TOTPDigits = 6
+
+	totpSecretSize = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generate a random base32 secret
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretSize)
+	_, err := crand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// GetTOTPStep get the time step of time
+func GetTOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// GetTOTPCode get the code of secret at the time step
+func GetTOTPCode(secret string, step int64, digits int) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(buf)
+	sum := mac.Sum(nil)
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
+
+// VerifyTOTP verify the code, the skew steps before and after are allowed,
+// it returns the matched step(0 for not matched)
+func VerifyTOTP(secret, code string, t time.Time, skew int) int64 {
+	current := GetTOTPStep(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		expected, err := GetTOTPCode(secret, step, len(code))
+		if err != nil {
+			return 0
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// GetTOTPURI get the otpauth uri for authenticator app
+func GetTOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	query.Set("period", fmt.Sprintf("%d", TOTPPeriod))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s",
+		url.PathEscape(issuer),
+		url.PathEscape(account),
+		query.Encode(),
+	)
+}
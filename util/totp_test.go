@@ -0,0 +1,50 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTP(t *testing.T) {
+	assert := assert.New(t)
+	// RFC 6238 测试数据（SHA1）
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	code, err := GetTOTPCode(secret, GetTOTPStep(time.Unix(59, 0)), 8)
+	assert.Nil(err)
+	assert.Equal("94287082", code)
+	code, err = GetTOTPCode(secret, GetTOTPStep(time.Unix(1111111109, 0)), 8)
+	assert.Nil(err)
+	assert.Equal("07081804", code)
+	code, err = GetTOTPCode(secret, GetTOTPStep(time.Unix(1111111109, 0)), 6)
+	assert.Nil(err)
+	assert.Equal("081804", code)
+
+	now := time.Unix(1111111109, 0)
+	step := GetTOTPStep(now)
+	assert.Equal(step, VerifyTOTP(secret, "081804", now, 1))
+	// 允许前后一个周期的偏差
+	assert.Equal(step, VerifyTOTP(secret, "081804", now.Add(TOTPPeriod*time.Second), 1))
+	assert.Equal(int64(0), VerifyTOTP(secret, "081804", now.Add(3*TOTPPeriod*time.Second), 1))
+	assert.Equal(int64(0), VerifyTOTP(secret, "123456", now, 1))
+
+	secret, err = GenerateTOTPSecret()
+	assert.Nil(err)
+	assert.Equal(32, len(secret))
+	assert.Equal("otpauth://totp/origin:tree?digits=6&issuer=origin&period=30&secret=ABC", GetTOTPURI("origin", "tree", "ABC"))
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/vicanso/origin/cs"
+)
+
+func init() {
+	// 售后编号
+	AddAlias("xAfterSaleSN", "min=1")
+	// 售后状态：申请中(1)至已关闭(10)
+	AddAlias("xAfterSaleStatus", "number,min=1,max=10")
+	// 申请原因
+	AddAlias("xAfterSaleReason", "min=1,max=200")
+	// 审核意见
+	AddAlias("xAfterSaleRemark", "min=1,max=200")
+	// 退款金额
+	AddAlias("xAfterSaleRefundAmount", "min=0.01")
+	// 售后子订单
+	AddAlias("xAfterSaleSubOrder", "number,min=1")
+	// 售后类型
+	Add("xAfterSaleCategory", func(fl validator.FieldLevel) bool {
+		return isInString(fl, cs.AfterSaleCategories)
+	})
+}
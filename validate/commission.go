@@ -0,0 +1,28 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+func init() {
+	// 提现金额
+	AddAlias("xCommissionWithdrawAmount", "min=0.01")
+	// 收款账户
+	AddAlias("xCommissionWithdrawAccount", "min=1,max=100")
+	// 提现状态
+	AddAlias("xCommissionWithdrawalStatus", "number,min=1,max=3")
+	// 审核意见
+	AddAlias("xCommissionRemark", "min=1,max=200")
+	// 流水类型
+	AddAlias("xCommissionLedgerCategory", "oneof=credit debit reversal")
+}
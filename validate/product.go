@@ -31,4 +31,16 @@ func init() {
 
 	AddAlias("xProductCategoryName", "min=1,max=10")
 	AddAlias("xProductCategoryLevel", "number,min=1,max=3")
+
+	AddAlias("xProductSKUName", "min=1,max=30")
+	AddAlias("xProductSKUStock", "min=0,max=1000000")
+	// 规格属性，如规格、口味等
+	AddAlias("xProductSKUAttrs", "max=5,dive,keys,min=1,max=10,endkeys,min=1,max=20")
+
+	AddAlias("xProductPriceRemark", "min=1,max=100")
+	// 导入导出的文件格式
+	AddAlias("xProductSheetFormat", "oneof=csv xlsx")
+	AddAlias("xProductSort", "oneof=popularity weekSales monthSales")
+	// 关联产品类型：经常一起购买、看了又看
+	AddAlias("xProductRelationCategory", "oneof=bought viewed")
 }
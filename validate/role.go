@@ -0,0 +1,95 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/vicanso/origin/cs"
+)
+
+// isValidPermission check the permission is valid,
+// it supports "*" and "resource:*"
+func isValidPermission(permission string) bool {
+	if permission == cs.PermissionAll ||
+		containsString(cs.Permissions, permission) {
+		return true
+	}
+	if !strings.HasSuffix(permission, ":*") {
+		return false
+	}
+	prefix := strings.TrimSuffix(permission, "*")
+	for _, item := range cs.Permissions {
+		if strings.HasPrefix(item, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var roleNameRegexp = regexp.MustCompile("^[a-z][a-z0-9_-]{0,19}$")
+
+// isValidRoleName check the format of role name,
+// whether the role exists is checked by role service
+func isValidRoleName(name string) bool {
+	return roleNameRegexp.MatchString(name)
+}
+
+// isAllRoleName check all the role names of slice are valid
+func isAllRoleName(fl validator.FieldLevel) bool {
+	if fl.Field().Kind() != reflect.Slice {
+		return false
+	}
+	names, ok := fl.Field().Interface().([]string)
+	if !ok || len(names) == 0 {
+		return false
+	}
+	for _, name := range names {
+		if !isValidRoleName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	Add("xRoleName", func(fl validator.FieldLevel) bool {
+		value, ok := toString(fl)
+		if !ok {
+			return false
+		}
+		return isValidRoleName(value)
+	})
+	AddAlias("xRoleCategory", "oneof=role group")
+	AddAlias("xRoleDescription", "min=1,max=50")
+	Add("xRolePermissions", func(fl validator.FieldLevel) bool {
+		if fl.Field().Kind() != reflect.Slice {
+			return false
+		}
+		permissions, ok := fl.Field().Interface().([]string)
+		if !ok {
+			return false
+		}
+		for _, permission := range permissions {
+			if !isValidPermission(permission) {
+				return false
+			}
+		}
+		return true
+	})
+}
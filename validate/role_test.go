@@ -0,0 +1,60 @@
+// Copyright 2020 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleValidate(t *testing.T) {
+	assert := assert.New(t)
+	t.Run("xRoleName", func(t *testing.T) {
+		type xRoleName struct {
+			Value string `json:"value" validate:"xRoleName"`
+		}
+		x := xRoleName{}
+		err := doValidate(&x, []byte(`{"value": "marketing"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "su"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "abc"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "1abc"}`))
+		assert.Equal(`Key: 'xRoleName.Value' Error:Field validation for 'Value' failed on the 'xRoleName' tag`, err.Error())
+	})
+
+	t.Run("xRolePermissions", func(t *testing.T) {
+		type xRolePermissions struct {
+			Value []string `json:"value" validate:"xRolePermissions"`
+		}
+		x := xRolePermissions{}
+		err := doValidate(&x, []byte(`{"value": ["*", "order:assign", "product:*"]}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": []}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": ["order:abc"]}`))
+		assert.Equal(`Key: 'xRolePermissions.Value' Error:Field validation for 'Value' failed on the 'xRolePermissions' tag`, err.Error())
+
+		err = doValidate(&x, []byte(`{"value": ["abc:*"]}`))
+		assert.Equal(`Key: 'xRolePermissions.Value' Error:Field validation for 'Value' failed on the 'xRolePermissions' tag`, err.Error())
+	})
+}
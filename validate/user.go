@@ -14,12 +14,6 @@
 
 package validate
 
-import (
-	"github.com/go-playground/validator/v10"
-
-	"github.com/vicanso/origin/cs"
-)
-
 func init() {
 	// 账号
 	AddAlias("xUserAccount", "ascii,min=2,max=10")
@@ -32,19 +26,27 @@ func init() {
 
 	AddAlias("xUserMarketingGroup", "ascii,min=1,max=10")
 
-	Add("xUserRole", func(fl validator.FieldLevel) bool {
-		return isInString(fl, cs.UserRoles)
-	})
-	Add("xUserRoles", func(fl validator.FieldLevel) bool {
-		return isAllInString(fl, cs.UserRoles)
-	})
-	Add("xUserGroup", func(fl validator.FieldLevel) bool {
-		return isInString(fl, cs.UserGroups)
-	})
-	Add("xUserGroups", func(fl validator.FieldLevel) bool {
-		return isAllInString(fl, cs.UserGroups)
-	})
+	// 用户角色与分组对应角色权限的名称，是否存在由角色权限校验
+	AddAlias("xUserRole", "xRoleName")
+	Add("xUserRoles", isAllRoleName)
+	AddAlias("xUserGroup", "xRoleName")
+	Add("xUserGroups", isAllRoleName)
 	AddAlias("xUserEmail", "email")
 
 	AddAlias("xUserTrackCategory", "min=1,max=20")
+
+	AddAlias("xUserPasswordResetChannel", "oneof=email sms")
+	AddAlias("xUserPasswordResetCode", "numeric,len=6")
+	// 两步验证码（6位数字）或恢复码（10位）
+	AddAlias("xUserTOTPCode", "alphanum,min=6,max=10")
+
+	// 异地或新设备登录的邮箱验证码
+	AddAlias("xUserLoginStepUpCode", "numeric,len=6")
+
+	AddAlias("xUserAPITokenName", "min=1,max=30")
+
+	// 第三方登录
+	AddAlias("xOIDCProvider", "ascii,min=1,max=20")
+	AddAlias("xOIDCCode", "ascii,min=1,max=2048")
+	AddAlias("xOIDCState", "ascii,min=1,max=100")
 }
@@ -65,6 +65,9 @@ func TestUserValidate(t *testing.T) {
 		assert.Nil(err)
 
 		err = doValidate(&x, []byte(`{"value": "test"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "Test Role"}`))
 		assert.Equal(`Key: 'xUserRole.Value' Error:Field validation for 'Value' failed on the 'xUserRole' tag`, err.Error())
 	})
 
@@ -79,7 +82,46 @@ func TestUserValidate(t *testing.T) {
 		err = doValidate(&x, []byte(`{"value": ["su"]}`))
 		assert.Nil(err)
 
-		err = doValidate(&x, []byte(`{"value": ["test"]}`))
+		err = doValidate(&x, []byte(`{"value": ["su", "test"]}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": ["su", "测试"]}`))
 		assert.Equal(`Key: 'xUserRoles.Value' Error:Field validation for 'Value' failed on the 'xUserRoles' tag`, err.Error())
 	})
+	t.Run("xUserPasswordResetCode", func(t *testing.T) {
+		type xUserPasswordResetCode struct {
+			Value string `json:"value" validate:"xUserPasswordResetCode"`
+		}
+		x := xUserPasswordResetCode{}
+		err := doValidate(&x, []byte(`{"value": "123456"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "12345a"}`))
+		assert.Equal(`Key: 'xUserPasswordResetCode.Value' Error:Field validation for 'Value' failed on the 'xUserPasswordResetCode' tag`, err.Error())
+	})
+	t.Run("xUserTOTPCode", func(t *testing.T) {
+		type xUserTOTPCode struct {
+			Value string `json:"value" validate:"xUserTOTPCode"`
+		}
+		x := xUserTOTPCode{}
+		err := doValidate(&x, []byte(`{"value": "123456"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "abcdefghij"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "12345"}`))
+		assert.Equal(`Key: 'xUserTOTPCode.Value' Error:Field validation for 'Value' failed on the 'xUserTOTPCode' tag`, err.Error())
+	})
+	t.Run("xUserLoginStepUpCode", func(t *testing.T) {
+		type xUserLoginStepUpCode struct {
+			Value string `json:"value" validate:"xUserLoginStepUpCode"`
+		}
+		x := xUserLoginStepUpCode{}
+		err := doValidate(&x, []byte(`{"value": "123456"}`))
+		assert.Nil(err)
+
+		err = doValidate(&x, []byte(`{"value": "1234567"}`))
+		assert.Equal(`Key: 'xUserLoginStepUpCode.Value' Error:Field validation for 'Value' failed on the 'xUserLoginStepUpCode' tag`, err.Error())
+	})
 }
@@ -15,7 +15,6 @@ import {
   queryOmitEmpty,
   addNoCacheQueryParam
 } from "@/helpers/util";
-import { sha256 } from "@/helpers/crypto";
 import {
   listStatus,
   attachStatusDesc,
@@ -281,15 +280,14 @@ export default {
     async login({ commit }, { account, password, captcha }) {
       commit(mutationUserProcessing, true);
       try {
-        // 先获取登录用的token
-        const res = await request.get(USERS_LOGIN);
-        const { token } = res.data;
-        // 根据token与密码生成登录密码
+        // 先获取登录用的token（登录时需要先生成token）
+        await request.get(USERS_LOGIN);
+        // 服务端使用慢hash校验，因此直接提交客户端生成的密码
         const { data } = await request.post(
           USERS_LOGIN,
           {
             account,
-            password: sha256(generatePassword(password) + token)
+            password: generatePassword(password)
           },
           {
             headers: {